@@ -3,6 +3,7 @@ package rethinkdb
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"sort"
 	"strings"
 	"sync"
@@ -17,11 +18,80 @@ import (
 
 var errClusterClosed = errors.New("rethinkdb: cluster is closed")
 
+// clusterDrainInterval is how often Cluster.drain checks the nodes.
+const clusterDrainInterval = 10 * time.Millisecond
+
+// tablePrimariesTTL is how long the primary replicas of a table are cached
+// for queries run with RunOpts.PreferReplica.
+const tablePrimariesTTL = time.Minute
+
 const (
 	clusterWorking = 0
 	clusterClosed  = 1
 )
 
+// BackoffJitter selects how the delays between reconnection attempts are
+// randomised, see ReconnectBackoffOpts.
+type BackoffJitter int
+
+const (
+	// JitterFull waits a random delay between 0 and the exponential delay,
+	// spreading out the reconnections of many clients after a cluster
+	// failure. This is the default.
+	JitterFull BackoffJitter = iota
+	// JitterProportional waits the exponential delay give or take 50%.
+	JitterProportional
+	// JitterNone waits the exponential delay.
+	JitterNone
+)
+
+// ReconnectBackoffOpts configures the exponential backoff used when
+// reconnecting to the cluster, the delay starts at 500ms and grows by half
+// after each attempt up to 60s.
+type ReconnectBackoffOpts struct {
+	Jitter BackoffJitter `json:"jitter,omitempty"`
+}
+
+// jitterBackOff randomises the delays of an exponential backoff using full
+// jitter.
+type jitterBackOff struct {
+	backoff.BackOff
+	rand *rand.Rand
+}
+
+func (b *jitterBackOff) NextBackOff() time.Duration {
+	d := b.BackOff.NextBackOff()
+	if d == backoff.Stop {
+		return d
+	}
+
+	return time.Duration(b.rand.Int63n(int64(d) + 1))
+}
+
+// newReconnectBackOff returns the backoff used to reconnect, starting at
+// initial if it is not 0 and giving up after maxElapsed, or never if it is 0.
+func newReconnectBackOff(opts ReconnectBackoffOpts, initial, maxElapsed time.Duration) backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = maxElapsed
+	if initial != 0 {
+		b.InitialInterval = initial
+		b.Reset()
+	}
+
+	switch opts.Jitter {
+	case JitterProportional:
+		return b
+	case JitterNone:
+		b.RandomizationFactor = 0
+		return b
+	default:
+		b.RandomizationFactor = 0
+		// Each backoff has its own source so that clients started together
+		// don't wait the same delays
+		return &jitterBackOff{BackOff: b, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	}
+}
+
 // A Cluster represents a connection to a RethinkDB cluster, a cluster is created
 // by the Session and should rarely be created manually.
 //
@@ -41,19 +111,52 @@ type Cluster struct {
 	connFactory connFactory
 
 	discoverInterval time.Duration
+
+	primariesMu sync.Mutex
+	primaries   map[tableRef]tablePrimaries
+	replicaNext uint32
+}
+
+// tableRef identifies a table by its database and name.
+type tableRef struct {
+	db    string
+	table string
+}
+
+// tablePrimaries holds the names of the servers which are primary replicas of
+// a table, names is nil if they couldn't be fetched.
+type tablePrimaries struct {
+	names   map[string]bool
+	fetched time.Time
 }
 
 // NewCluster creates a new cluster by connecting to the given hosts.
 func NewCluster(hosts []Host, opts *ConnectOpts) (*Cluster, error) {
+	return newClusterContext(context.Background(), hosts, opts)
+}
+
+// newClusterContext creates a new cluster by connecting to the given hosts, ctx
+// bounds the initial connections only, connections opened afterwards by the
+// pools are not affected by ctx.
+func newClusterContext(ctx context.Context, hosts []Host, opts *ConnectOpts) (*Cluster, error) {
+	connected := make(chan struct{})
 	c := &Cluster{
-		hp:          newHostPool(opts),
-		seeds:       hosts,
-		opts:        opts,
-		closed:      clusterWorking,
-		connFactory: NewConnection,
+		hp:     newHostPool(opts),
+		seeds:  hosts,
+		opts:   opts,
+		closed: clusterWorking,
+		connFactory: func(host string, opts *ConnectOpts) (*Connection, error) {
+			select {
+			case <-connected:
+				return NewConnection(host, opts)
+			default:
+				return newConnectionContext(ctx, host, opts)
+			}
+		},
 	}
 
 	err := c.run()
+	close(connected)
 	if err != nil {
 		return nil, err
 	}
@@ -84,15 +187,24 @@ func (c *Cluster) Query(ctx context.Context, q Query) (cursor *Cursor, err error
 		var node *Node
 		var hpr hostpool.HostPoolResponse
 
-		node, hpr, err = c.GetNextNode()
+		node, hpr, err = c.nextNode(ctx, q)
 		if err != nil {
 			return nil, err
 		}
 
-		cursor, err = node.Query(ctx, q)
-		hpr.Mark(err)
+		var sent bool
+		cursor, sent, err = node.query(ctx, q)
+		if hpr != nil {
+			hpr.Mark(err)
+		}
+		logQueryError(node, q, err)
+		if cursor != nil {
+			cursor.mu.Lock()
+			cursor.servedBy = ServerResponse{ID: node.ID, Name: node.Name}
+			cursor.mu.Unlock()
+		}
 
-		if !shouldRetryQuery(q, err) {
+		if !shouldRetryQuery(q, sent, err) {
 			break
 		}
 	}
@@ -106,15 +218,19 @@ func (c *Cluster) Exec(ctx context.Context, q Query) (err error) {
 		var node *Node
 		var hpr hostpool.HostPoolResponse
 
-		node, hpr, err = c.GetNextNode()
+		node, hpr, err = c.nextNode(ctx, q)
 		if err != nil {
 			return err
 		}
 
-		err = node.Exec(ctx, q)
-		hpr.Mark(err)
+		var sent bool
+		_, sent, err = node.query(ctx, q)
+		if hpr != nil {
+			hpr.Mark(err)
+		}
+		logQueryError(node, q, err)
 
-		if !shouldRetryQuery(q, err) {
+		if !shouldRetryQuery(q, sent, err) {
 			break
 		}
 	}
@@ -122,6 +238,130 @@ func (c *Cluster) Exec(ctx context.Context, q Query) (err error) {
 	return err
 }
 
+// nextNode returns the node used to run q. Queries run with
+// RunOpts.PreferReplica are sent to a node which isn't a primary replica of the
+// tables they read when there is one, hpr is then nil.
+func (c *Cluster) nextNode(ctx context.Context, q Query) (*Node, hostpool.HostPoolResponse, error) {
+	if q.PreferReplica {
+		if node := c.replicaNode(ctx, q); node != nil {
+			return node, nil, nil
+		}
+	}
+
+	return c.GetNextNode()
+}
+
+// replicaNode returns a node which isn't a primary replica of any of the tables
+// read by q, or nil if the tables or their primary replicas aren't known.
+// Nodes are picked in turn so that reads are spread across the replicas.
+func (c *Cluster) replicaNode(ctx context.Context, q Query) *Node {
+	if q.Term == nil || !c.IsConnected() {
+		return nil
+	}
+	tables, ok := readTables(*q.Term, queryDB(q))
+	if !ok || len(tables) == 0 {
+		return nil
+	}
+
+	primaries := map[string]bool{}
+	for _, table := range tables {
+		names := c.tablePrimaries(ctx, table)
+		if names == nil {
+			return nil
+		}
+		for name := range names {
+			primaries[name] = true
+		}
+	}
+
+	var replicas []*Node
+	for _, node := range c.GetNodes() {
+		if !node.Closed() && node.Name != "" && !primaries[node.Name] {
+			replicas = append(replicas, node)
+		}
+	}
+	if len(replicas) == 0 {
+		return nil
+	}
+	sort.Slice(replicas, func(i, j int) bool { return replicas[i].ID < replicas[j].ID })
+
+	next := atomic.AddUint32(&c.replicaNext, 1)
+	return replicas[int(next%uint32(len(replicas)))]
+}
+
+// tablePrimaries returns the names of the primary replicas of table, from the
+// cache when they were fetched less than tablePrimariesTTL ago. It returns nil
+// if they couldn't be fetched.
+func (c *Cluster) tablePrimaries(ctx context.Context, table tableRef) map[string]bool {
+	c.primariesMu.Lock()
+	cached, ok := c.primaries[table]
+	c.primariesMu.Unlock()
+	if ok && time.Since(cached.fetched) < tablePrimariesTTL {
+		return cached.names
+	}
+
+	names, err := c.fetchTablePrimaries(ctx, table)
+	if err != nil {
+		Log.Debugf("Error fetching primary replicas of %s.%s: %s", table.db, table.table, err)
+	}
+
+	c.primariesMu.Lock()
+	if c.primaries == nil {
+		c.primaries = map[tableRef]tablePrimaries{}
+	}
+	c.primaries[table] = tablePrimaries{names: names, fetched: time.Now()}
+	c.primariesMu.Unlock()
+
+	return names
+}
+
+func (c *Cluster) fetchTablePrimaries(ctx context.Context, table tableRef) (map[string]bool, error) {
+	node, hpr, err := c.GetNextNode()
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := newQuery(DB(table.db).Table(table.table).Status(), map[string]interface{}{}, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := node.Query(ctx, q)
+	hpr.Mark(err)
+	if err != nil {
+		return nil, err
+	}
+
+	var status TableStatus
+	if err := cursor.One(&status); err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for _, shard := range status.Shards {
+		for _, name := range shard.Primaries {
+			names[name] = true
+		}
+	}
+	return names, nil
+}
+
+// logQueryError logs a failed query, the query name is included when set so
+// that failures can be grouped by query kind.
+func logQueryError(node *Node, q Query, err error) {
+	if err == nil {
+		return
+	}
+
+	fields := logrus.Fields{
+		"host":  node.Host.String(),
+		"token": q.Token,
+	}
+	if q.Name != "" {
+		fields["query_name"] = q.Name
+	}
+	Log.WithFields(fields).Debugf("Error executing query: %s", err)
+}
+
 // Server returns the server name and server UUID being used by a connection.
 func (c *Cluster) Server() (response ServerResponse, err error) {
 	for i := 0; i < c.numRetries(); i++ {
@@ -186,19 +426,37 @@ func (c *Cluster) Close(optArgs ...CloseOpts) error {
 	return nil
 }
 
+// drain waits until none of the nodes are busy or the timeout expires, it
+// returns false if the timeout expired.
+func (c *Cluster) drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		busy := false
+		for _, node := range c.GetNodes() {
+			if node.busy() {
+				busy = true
+				break
+			}
+		}
+		if !busy {
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(clusterDrainInterval)
+	}
+}
+
 func (c *Cluster) isClosed() bool {
 	return atomic.LoadInt32(&c.closed) == clusterClosed
 }
 
 // discover attempts to find new nodes in the cluster using the current nodes
 func (c *Cluster) discover() {
-	// Keep retrying with exponential backoff.
-	b := backoff.NewExponentialBackOff()
-	// Never finish retrying (max interval is still 60s)
-	b.MaxElapsedTime = 0
-	if c.discoverInterval != 0 {
-		b.InitialInterval = c.discoverInterval
-	}
+	// Keep retrying with exponential backoff, never finish retrying (max
+	// interval is still 60s)
+	b := newReconnectBackOff(c.opts.ReconnectBackoff, c.discoverInterval, 0)
 
 	// Keep trying to discover new nodes
 	for {
@@ -261,8 +519,7 @@ func (c *Cluster) listenForNodeChanges() error {
 			if !c.nodeExists(result.NewVal.ID) {
 				// Connect to node using exponential backoff (give up after waiting 5s)
 				// to give the node time to start-up.
-				b := backoff.NewExponentialBackOff()
-				b.MaxElapsedTime = time.Second * 5
+				b := newReconnectBackOff(c.opts.ReconnectBackoff, 0, time.Second*5)
 
 				err = backoff.Retry(func() error {
 					node, err := c.connectNodeWithStatus(result.NewVal)
@@ -326,6 +583,7 @@ func (c *Cluster) connectCluster() error {
 			Log.Warnf("Error connecting to node: %s", err)
 			continue
 		}
+		node.Name = svrRsp.Name
 
 		if _, ok := nodeSet[node.ID]; !ok {
 			Log.WithFields(logrus.Fields{
@@ -369,7 +627,12 @@ func (c *Cluster) connectNodeWithStatus(s *nodeStatus) (*Node, error) {
 		aliases[i] = NewHost(aliasAddress.Host, int(s.Network.ReqlPort))
 	}
 
-	return c.connectNode(s.ID, aliases)
+	node, err := c.connectNode(s.ID, aliases)
+	if err != nil {
+		return nil, err
+	}
+	node.Name = s.Name
+	return node, nil
 }
 
 func (c *Cluster) connectNode(id string, aliases []Host) (*Node, error) {
@@ -439,6 +702,21 @@ func (c *Cluster) GetNodes() []*Node {
 	return nodes
 }
 
+// nodeByAddress returns the node with the given host or alias, or nil if
+// there is no such node
+func (c *Cluster) nodeByAddress(host Host) *Node {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, node := range c.nodes {
+		for _, alias := range node.aliases {
+			if alias == host {
+				return node
+			}
+		}
+	}
+	return nil
+}
+
 func (c *Cluster) nodeExists(nodeID string) bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
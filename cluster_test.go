@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"github.com/segmentio/encoding/json"
 	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/context"
 	test "gopkg.in/check.v1"
 	"gopkg.in/rethinkdb/rethinkdb-go.v6/encoding"
 	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -569,3 +572,373 @@ func makeContinueQueryRaw(token int64) []byte {
 	binary.LittleEndian.PutUint32(b[8:], uint32(len(b)-respHeaderLen))
 	return b
 }
+
+func (s *ClusterSuite) TestSession_OnNode(c *test.C) {
+	host1 := Host{Name: "host1", Port: 28015}
+	host2 := Host{Name: "host2", Port: 28015}
+
+	opts := &ConnectOpts{}
+	pool, err := newPool(host2, opts, NewConnection)
+	c.Assert(err, test.IsNil)
+	c.Assert(pool.Close(), test.IsNil)
+
+	cluster := &Cluster{
+		hp:     newHostPool(opts),
+		opts:   opts,
+		closed: clusterWorking,
+		nodes: map[string]*Node{
+			host1.String(): newNode("node1", []Host{host1}, nil),
+			host2.String(): newNode("node2", []Host{host2, {Name: "10.0.0.2", Port: 28015}}, pool),
+		},
+	}
+	session := &Session{opts: opts, cluster: cluster}
+
+	// Queries are sent to the pool of the requested node
+	_, err = Expr(1).Run(session.OnNode("host2:28015"))
+	c.Assert(err, test.Equals, errPoolClosed)
+	err = Expr(1).Exec(session.OnNode("10.0.0.2"))
+	c.Assert(err, test.Equals, errPoolClosed)
+
+	_, err = Expr(1).Run(session.OnNode("host3:28015"))
+	c.Assert(err, test.Equals, ErrNodeNotFound)
+}
+
+func (s *ClusterSuite) TestCluster_drain(c *test.C) {
+	host1 := Host{Name: "host1", Port: 28015}
+
+	opts := &ConnectOpts{}
+	conn := newConnection(nil, host1.String(), opts)
+	pool, err := newPool(host1, opts, func(host string, opts *ConnectOpts) (*Connection, error) {
+		return conn, nil
+	})
+	c.Assert(err, test.IsNil)
+	c.Assert(pool.Ping(), test.IsNil)
+
+	cluster := &Cluster{
+		hp:     newHostPool(opts),
+		opts:   opts,
+		closed: clusterWorking,
+		nodes: map[string]*Node{
+			host1.String(): newNode("node1", []Host{host1}, pool),
+		},
+	}
+
+	c.Assert(cluster.drain(time.Second), test.Equals, true)
+
+	atomic.StoreInt32(&conn.openCursors, 1)
+	c.Assert(cluster.drain(20*time.Millisecond), test.Equals, false)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		atomic.StoreInt32(&conn.openCursors, 0)
+	}()
+	c.Assert(cluster.drain(time.Second), test.Equals, true)
+}
+
+func (s *ClusterSuite) TestSession_ReconnectWith_KeepsPoolOnError(c *test.C) {
+	opts := &ConnectOpts{Address: "host1:28015"}
+	cluster := &Cluster{
+		hp:     newHostPool(opts),
+		opts:   opts,
+		closed: clusterWorking,
+		nodes: map[string]*Node{
+			"host1:28015": newNode("node1", []Host{{Name: "host1", Port: 28015}}, nil),
+		},
+	}
+	hosts := []Host{{Name: "host1", Port: 28015}}
+	session := &Session{hosts: hosts, opts: opts, cluster: cluster}
+
+	err := session.ReconnectWith(ReconnectOpts{
+		ConnectOpts: &ConnectOpts{Address: "127.0.0.1:1", Timeout: time.Second},
+	})
+	c.Assert(err, test.NotNil)
+	c.Assert(session.cluster, test.Equals, cluster)
+	c.Assert(session.opts, test.Equals, opts)
+	c.Assert(session.hosts, test.DeepEquals, hosts)
+	c.Assert(cluster.isClosed(), test.Equals, false)
+}
+
+func (s *ClusterSuite) TestSession_MaxConcurrentQueries(c *test.C) {
+	session := &Session{opts: &ConnectOpts{MaxConcurrentQueries: 1}, querySlots: newQuerySlots(1)}
+	c.Assert(session.Stats(), test.Equals, SessionStats{MaxConcurrentQueries: 1})
+
+	release, err := session.acquireQuerySlot(context.Background())
+	c.Assert(err, test.IsNil)
+	c.Assert(session.Stats(), test.Equals, SessionStats{ConcurrentQueries: 1, MaxConcurrentQueries: 1})
+
+	// Queries above the limit wait for a slot or until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = session.acquireQuerySlot(ctx)
+	c.Assert(err, test.Equals, ErrQueryTimeout)
+
+	acquired := make(chan func())
+	go func() {
+		release, err := session.acquireQuerySlot(context.Background())
+		c.Check(err, test.IsNil)
+		acquired <- release
+	}()
+	for session.Stats().QueuedQueries != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	release = <-acquired
+	c.Assert(session.Stats(), test.Equals, SessionStats{ConcurrentQueries: 1, MaxConcurrentQueries: 1})
+	release()
+	c.Assert(session.Stats(), test.Equals, SessionStats{MaxConcurrentQueries: 1})
+
+	// Without a limit queries are only counted
+	session = &Session{opts: &ConnectOpts{}}
+	release, err = session.acquireQuerySlot(nil)
+	c.Assert(err, test.IsNil)
+	c.Assert(session.Stats(), test.Equals, SessionStats{ConcurrentQueries: 1})
+	release()
+}
+
+// writeFailConn is a net.Conn which fails every write, counting them.
+type writeFailConn struct {
+	net.Conn
+	writes *int32
+}
+
+func (c *writeFailConn) Write(b []byte) (int, error) {
+	atomic.AddInt32(c.writes, 1)
+	return 0, io.ErrClosedPipe
+}
+
+func (c *writeFailConn) Close() error {
+	return nil
+}
+
+func (s *ClusterSuite) TestCluster_RetryWrites(c *test.C) {
+	host1 := Host{Name: "host1", Port: 28015}
+
+	var writes int32
+	opts := &ConnectOpts{NumRetries: 3}
+	pool, err := newPool(host1, opts, func(host string, opts *ConnectOpts) (*Connection, error) {
+		return newConnection(&writeFailConn{writes: &writes}, host, opts), nil
+	})
+	c.Assert(err, test.IsNil)
+
+	cluster := &Cluster{
+		hp:     newHostPool(opts),
+		opts:   opts,
+		closed: clusterWorking,
+		nodes: map[string]*Node{
+			host1.String(): newNode("node1", []Host{host1}, pool),
+		},
+	}
+	cluster.hp.SetHosts([]string{host1.String()})
+	session := &Session{opts: opts, cluster: cluster}
+
+	// Reads are retried, each failed connection is replaced
+	_, err = Table("test").Run(session)
+	c.Assert(err, test.FitsTypeOf, RQLConnectionError{})
+	c.Assert(atomic.SwapInt32(&writes, 0), test.Equals, int32(3))
+
+	// Writes are not retried once sent, unless they are idempotent
+	_, err = Table("test").Insert(map[string]interface{}{"id": 1}).Run(session)
+	c.Assert(err, test.FitsTypeOf, RQLConnectionError{})
+	c.Assert(atomic.SwapInt32(&writes, 0), test.Equals, int32(1))
+
+	err = Table("test").Insert(map[string]interface{}{"id": 1}, InsertOpts{Conflict: "replace"}).Exec(session, ExecOpts{Idempotent: true})
+	c.Assert(err, test.FitsTypeOf, RQLConnectionError{})
+	c.Assert(atomic.SwapInt32(&writes, 0), test.Equals, int32(3))
+}
+
+// dropConn answers every query with serializeAtomResponse, or closes once a
+// query is written if drop is set.
+type dropConn struct {
+	net.Conn
+	drop    bool
+	tokens  chan int64
+	closed  chan struct{}
+	closing sync.Once
+	buf     []byte
+}
+
+func newDropConn(drop bool) *dropConn {
+	return &dropConn{drop: drop, tokens: make(chan int64, 16), closed: make(chan struct{})}
+}
+
+func (c *dropConn) Write(b []byte) (int, error) {
+	c.tokens <- int64(binary.LittleEndian.Uint64(b))
+	return len(b), nil
+}
+
+func (c *dropConn) Read(b []byte) (int, error) {
+	if len(c.buf) == 0 {
+		select {
+		case token := <-c.tokens:
+			if c.drop {
+				return 0, io.EOF
+			}
+			body := serializeAtomResponse()
+			c.buf = append(makeResponseHeaderRaw(token, len(body)), body...)
+		case <-c.closed:
+			return 0, io.EOF
+		}
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *dropConn) Close() error {
+	c.closing.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (s *ClusterSuite) TestCluster_RetryReadOnConnClose(c *test.C) {
+	host1 := Host{Name: "host1", Port: 28015}
+
+	var dials int32
+	opts := &ConnectOpts{NumRetries: 3}
+	pool, err := newPool(host1, opts, func(host string, opts *ConnectOpts) (*Connection, error) {
+		// Only the first connection is dropped before responding
+		conn := newConnection(newDropConn(atomic.AddInt32(&dials, 1) == 1), host, opts)
+		go conn.readSocket()
+		go conn.processResponses()
+		return conn, nil
+	})
+	c.Assert(err, test.IsNil)
+
+	cluster := &Cluster{
+		hp:     newHostPool(opts),
+		opts:   opts,
+		closed: clusterWorking,
+		nodes: map[string]*Node{
+			host1.String(): newNode("node1", []Host{host1}, pool),
+		},
+	}
+	cluster.hp.SetHosts([]string{host1.String()})
+	session := &Session{opts: opts, cluster: cluster}
+
+	res, err := Table("test").Run(session)
+	c.Assert(err, test.IsNil)
+
+	var response string
+	c.Assert(res.One(&response), test.IsNil)
+	c.Assert(response, test.Equals, "response")
+	c.Assert(atomic.LoadInt32(&dials), test.Equals, int32(2))
+}
+
+func (s *ClusterSuite) TestShouldRetryQuery(c *test.C) {
+	read := Table("test").Get(1)
+	write := Table("test").Get(1).Update(map[string]interface{}{"n": 1})
+	connErr := RQLConnectionError{rqlError("broken pipe")}
+
+	c.Assert(shouldRetryQuery(Query{Term: &read}, true, nil), test.Equals, false)
+	c.Assert(shouldRetryQuery(Query{Term: &read}, true, connErr), test.Equals, true)
+	c.Assert(shouldRetryQuery(Query{Term: &read}, true, ErrConnectionClosed), test.Equals, true)
+	c.Assert(shouldRetryQuery(Query{Term: &read}, true, RQLRuntimeError{}), test.Equals, false)
+
+	c.Assert(shouldRetryQuery(Query{Term: &write}, false, connErr), test.Equals, true)
+	c.Assert(shouldRetryQuery(Query{Term: &write}, true, connErr), test.Equals, false)
+	c.Assert(shouldRetryQuery(Query{Term: &write}, true, ErrConnectionClosed), test.Equals, false)
+	c.Assert(shouldRetryQuery(Query{Term: &write, Idempotent: true}, true, connErr), test.Equals, true)
+	c.Assert(shouldRetryQuery(Query{Type: p.Query_NOREPLY_WAIT}, true, connErr), test.Equals, true)
+
+	nested := Expr([]int{1, 2}).ForEach(func(n Term) Term {
+		return Table("test").Insert(map[string]interface{}{"n": n})
+	})
+	c.Assert(shouldRetryQuery(Query{Term: &nested}, true, connErr), test.Equals, false)
+}
+
+func (s *ClusterSuite) TestCluster_ReplicaNode(c *test.C) {
+	opts := &ConnectOpts{}
+	nodes := map[string]*Node{}
+	for i := 1; i <= 3; i++ {
+		host := Host{Name: fmt.Sprintf("host%d", i), Port: 28015}
+		node := newNode(fmt.Sprintf("node%d", i), []Host{host}, nil)
+		node.Name = fmt.Sprintf("server%d", i)
+		nodes[host.String()] = node
+	}
+	cluster := &Cluster{
+		hp:     newHostPool(opts),
+		opts:   opts,
+		closed: clusterWorking,
+		nodes:  nodes,
+		primaries: map[tableRef]tablePrimaries{
+			{db: "test", table: "users"}:   {names: map[string]bool{"server1": true}, fetched: time.Now()},
+			{db: "app", table: "orders"}:   {names: map[string]bool{"server2": true}, fetched: time.Now()},
+			{db: "test", table: "unknown"}: {fetched: time.Now()},
+		},
+	}
+
+	q, err := newQuery(Table("users"), map[string]interface{}{}, opts)
+	c.Assert(err, test.IsNil)
+	q.PreferReplica = true
+
+	// Reads alternate between the servers which aren't primary replicas
+	first := cluster.replicaNode(nil, q)
+	second := cluster.replicaNode(nil, q)
+	c.Assert(first, test.NotNil)
+	c.Assert(second, test.NotNil)
+	c.Assert([]string{first.Name, second.Name}, test.DeepEquals, []string{"server3", "server2"})
+	c.Assert(cluster.replicaNode(nil, q).Name, test.Equals, "server3")
+
+	q, err = newQuery(Table("users").Union(DB("app").Table("orders")), map[string]interface{}{}, opts)
+	c.Assert(err, test.IsNil)
+	c.Assert(cluster.replicaNode(nil, q).Name, test.Equals, "server3")
+
+	// The database set for the query is used for tables without one
+	q, err = newQuery(Table("orders"), map[string]interface{}{"db": DB("app")}, opts)
+	c.Assert(err, test.IsNil)
+	c.Assert(cluster.replicaNode(nil, q).Name, test.Not(test.Equals), "server2")
+	q, err = newQuery(Table("orders"), map[string]interface{}{"db": "app"}, opts)
+	c.Assert(err, test.IsNil)
+	c.Assert(cluster.replicaNode(nil, q).Name, test.Not(test.Equals), "server2")
+
+	// Unknown primaries fall back to the host pool
+	q, err = newQuery(Table("unknown"), map[string]interface{}{}, opts)
+	c.Assert(err, test.IsNil)
+	c.Assert(cluster.replicaNode(nil, q), test.IsNil)
+
+	nodes["host3:28015"].Name = "server1"
+	q, err = newQuery(Table("users").Union(DB("app").Table("orders")), map[string]interface{}{}, opts)
+	c.Assert(err, test.IsNil)
+	c.Assert(cluster.replicaNode(nil, q), test.IsNil)
+}
+
+func (s *ClusterSuite) TestReadTables(c *test.C) {
+	tables, ok := readTables(DB("app").Table("orders").EqJoin("user", Table("users")), "test")
+	c.Assert(ok, test.Equals, true)
+	c.Assert(tables, test.DeepEquals, []tableRef{{db: "app", table: "orders"}, {db: "test", table: "users"}})
+
+	tables, ok = readTables(Expr(1).Add(2), "test")
+	c.Assert(ok, test.Equals, true)
+	c.Assert(tables, test.HasLen, 0)
+
+	_, ok = readTables(Table(Table("names").Get(1).Field("table")), "test")
+	c.Assert(ok, test.Equals, false)
+	_, ok = readTables(DB(Expr("app").Add("_v2")).Table("orders"), "test")
+	c.Assert(ok, test.Equals, false)
+}
+
+func (s *ClusterSuite) TestReconnectBackOff_Jitter(c *test.C) {
+	// Without jitter the delays grow by half from 500ms
+	b := newReconnectBackOff(ReconnectBackoffOpts{Jitter: JitterNone}, 0, 0)
+	c.Assert(b.NextBackOff(), test.Equals, 500*time.Millisecond)
+	c.Assert(b.NextBackOff(), test.Equals, 750*time.Millisecond)
+	c.Assert(b.NextBackOff(), test.Equals, 1125*time.Millisecond)
+
+	// With full jitter each delay is between 0 and the delay without jitter
+	b = newReconnectBackOff(ReconnectBackoffOpts{}, 100*time.Millisecond, 0)
+	delays := map[time.Duration]bool{}
+	max := 100 * time.Millisecond
+	for i := 0; i < 20; i++ {
+		d := b.NextBackOff()
+		c.Assert(d >= 0 && d <= max, test.Equals, true, test.Commentf("delay %d is %s, max %s", i, d, max))
+		delays[d] = true
+		if max = max * 3 / 2; max > 60*time.Second {
+			max = 60 * time.Second
+		}
+	}
+	c.Assert(len(delays) > 1, test.Equals, true)
+
+	// Proportional jitter keeps the delay within 50%
+	b = newReconnectBackOff(ReconnectBackoffOpts{Jitter: JitterProportional}, time.Second, 0)
+	d := b.NextBackOff()
+	c.Assert(d >= 500*time.Millisecond && d <= 1500*time.Millisecond, test.Equals, true, test.Commentf("%s", d))
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/segmentio/encoding/json"
 	"io"
+	"io/ioutil"
 	"net"
 	"sync/atomic"
 	"time"
@@ -15,6 +16,7 @@ import (
 	"github.com/opentracing/opentracing-go/ext"
 	"github.com/opentracing/opentracing-go/log"
 	"golang.org/x/net/context"
+	"gopkg.in/rethinkdb/rethinkdb-go.v6/encoding"
 	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 	"sync"
 )
@@ -33,13 +35,76 @@ const (
 // Response represents the raw response from a query, most of the time you
 // should instead use a Cursor when reading from the database.
 type Response struct {
-	Token     int64
+	Token int64
+	// Type is SUCCESS_ATOM for a single value, SUCCESS_PARTIAL for a batch of
+	// a sequence with more batches to fetch, SUCCESS_SEQUENCE for the last
+	// batch of a sequence, WAIT_COMPLETE and SERVER_INFO for the responses to
+	// the NOREPLY_WAIT and SERVER_INFO queries, or CLIENT_ERROR,
+	// COMPILE_ERROR or RUNTIME_ERROR when the query failed, in which case
+	// Responses holds the error message. The constants are defined in the
+	// ql2 package, for example ql2.Response_SUCCESS_ATOM.
 	Type      p.Response_ResponseType   `json:"t"`
 	ErrorType p.Response_ErrorType      `json:"e"`
 	Notes     []p.Response_ResponseNote `json:"n"`
 	Responses []json.RawMessage         `json:"r"`
 	Backtrace []interface{}             `json:"b"`
 	Profile   interface{}               `json:"p"`
+
+	// release returns the buffer Responses were read into to the pool, it is
+	// nil unless ConnectOpts.ReuseBuffers is set
+	release func()
+	// err is returned to the query instead of the response if the response
+	// was not read, such as when it is larger than MaxResponseBytes
+	err error
+}
+
+// NewResponse returns a response of type typ to the query with the given
+// token holding docs, which are encoded in the same way as the documents
+// returned by Mock. This is useful for testing code which reads responses
+// without connecting to a server.
+//
+//	response, err := r.NewResponse(1, ql2.Response_SUCCESS_SEQUENCE,
+//		map[string]interface{}{"id": 1},
+//		map[string]interface{}{"id": 2},
+//	)
+func NewResponse(token int64, typ p.Response_ResponseType, docs ...interface{}) (Response, error) {
+	responses, err := encodeResponses(docs)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Response{
+		Token:     token,
+		Type:      typ,
+		Responses: responses,
+	}, nil
+}
+
+// encodeResponses encodes each of docs as the JSON sent by the server.
+func encodeResponses(docs []interface{}) ([]json.RawMessage, error) {
+	responses := make([]json.RawMessage, len(docs))
+	for i, doc := range docs {
+		encoded, err := encoding.Encode(doc)
+		if err != nil {
+			return nil, err
+		}
+		if responses[i], err = json.Marshal(encoded); err != nil {
+			return nil, err
+		}
+	}
+	return responses, nil
+}
+
+// detach copies Responses out of the reused buffer and releases it.
+func (r *Response) detach() {
+	if r.release == nil {
+		return
+	}
+	for i, raw := range r.Responses {
+		r.Responses[i] = append(json.RawMessage(nil), raw...)
+	}
+	r.release()
+	r.release = nil
 }
 
 // Connection is a connection to a rethinkdb database. Connection is not thread
@@ -52,9 +117,16 @@ type Connection struct {
 
 	_                  [4]byte
 	token              int64
+	lastUsed           int64     // unix time in nanoseconds of the last query, see ConnectOpts.ConnMaxIdleTime
+	createdAt          time.Time // see ConnectOpts.ConnMaxLifetime
 	cursors            map[int64]*Cursor
 	bad                int32 // 0 - not bad, 1 - bad
 	closed             int32 // 0 - working, 1 - closed
+	noreplyPending     int32 // 1 if noreply queries were sent since the last NOREPLY_WAIT
+	pendingQueries     int32 // number of queries waiting for a response
+	openCursors        int32 // number of cursors waiting for more results, len(cursors)
+	users              int32 // number of queries being sent through the pool, see acquire
+	retired            int32 // 1 once the pool has replaced the connection, see Pool.retire
 	stopReadChan       chan bool
 	readRequestsChan   chan tokenAndPromise
 	responseChan       chan responseAndError
@@ -86,6 +158,12 @@ type tokenAndPromise struct {
 
 // NewConnection creates a new connection to the database server
 func NewConnection(address string, opts *ConnectOpts) (*Connection, error) {
+	return newConnectionContext(context.Background(), address, opts)
+}
+
+// newConnectionContext creates a new connection to the database server, ctx
+// bounds dialing and the handshake in addition to the connection timeout.
+func newConnectionContext(ctx context.Context, address string, opts *ConnectOpts) (*Connection, error) {
 	keepAlivePeriod := defaultKeepAlivePeriod
 	if opts.KeepAlivePeriod > 0 {
 		keepAlivePeriod = opts.KeepAlivePeriod
@@ -94,26 +172,56 @@ func NewConnection(address string, opts *ConnectOpts) (*Connection, error) {
 	// Connect to Server
 	var err error
 	var conn net.Conn
-	nd := net.Dialer{Timeout: opts.Timeout, KeepAlive: keepAlivePeriod}
-	if opts.TLSConfig == nil {
-		conn, err = nd.Dial("tcp", address)
-	} else {
-		conn, err = tls.DialWithDialer(&nd, "tcp", address, opts.TLSConfig)
+	network, dialAddr := dialAddress(address)
+	if network == "unix" && (opts.TLSConfig != nil || opts.ClientCertFile != "" || opts.ClientKeyFile != "" || opts.CAFile != "") {
+		return nil, RQLDriverError{rqlError(fmt.Sprintf("TLS can't be used to connect to the unix domain socket %s", address))}
 	}
+	nd := net.Dialer{Timeout: opts.Timeout, KeepAlive: keepAlivePeriod}
+	conn, err = nd.DialContext(ctx, network, dialAddr)
 	if err != nil {
 		return nil, RQLConnectionError{rqlError(err.Error())}
 	}
 
+	noDelay := true
+	if opts.TCPNoDelay != nil {
+		noDelay = *opts.TCPNoDelay
+	}
+	if err = setTCPNoDelay(conn, noDelay); err != nil {
+		conn.Close()
+		return nil, RQLConnectionError{rqlError(err.Error())}
+	}
+
+	// Interrupt the TLS and RethinkDB handshakes if ctx is done
+	stopWatching := watchContext(ctx, conn)
+	defer stopWatching()
+
+	if opts.TLSConfig != nil {
+		if conn, err = tlsHandshake(conn, address, opts); err != nil {
+			return nil, connectContextError(ctx, err)
+		}
+	}
+
 	c := newConnection(conn, address, opts)
 
-	// Send handshake
-	handshake, err := c.handshake(opts.HandshakeVersion)
+	// Send handshake, the handshake must complete within the connection
+	// timeout
+	handshake, err := c.handshake(ctx, opts.HandshakeVersion)
 	if err != nil {
 		return nil, err
 	}
 
+	if opts.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(opts.Timeout))
+	}
 	if err = handshake.Send(); err != nil {
-		return nil, err
+		return nil, connectContextError(ctx, err)
+	}
+	if err = stopWatching(); err != nil {
+		conn.Close()
+		return nil, connectContextError(ctx, err)
+	}
+	if opts.Timeout > 0 {
+		conn.SetDeadline(time.Time{})
 	}
 
 	// NOTE: mock.go: Mock.Query()
@@ -124,6 +232,68 @@ func NewConnection(address string, opts *ConnectOpts) (*Connection, error) {
 	return c, nil
 }
 
+// tlsHandshake performs the TLS handshake on conn within the connection
+// timeout, like tls.DialWithDialer.
+func tlsHandshake(conn net.Conn, address string, opts *ConnectOpts) (net.Conn, error) {
+	config := opts.TLSConfig
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName, _, _ = net.SplitHostPort(address)
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if opts.Timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(opts.Timeout))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, RQLConnectionError{rqlError(err.Error())}
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	return tlsConn, nil
+}
+
+// watchContext interrupts any blocked reads and writes on conn once ctx is
+// done. The returned function stops watching ctx and returns ctx.Err() if conn
+// was interrupted.
+func watchContext(ctx context.Context, conn net.Conn) func() error {
+	if ctx.Done() == nil {
+		return func() error { return nil }
+	}
+
+	stop := make(chan struct{})
+	interrupted := make(chan error, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+			interrupted <- ctx.Err()
+		case <-stop:
+			interrupted <- nil
+		}
+	}()
+
+	var once sync.Once
+	var err error
+	return func() error {
+		once.Do(func() {
+			close(stop)
+			err = <-interrupted
+		})
+		return err
+	}
+}
+
+// connectContextError returns a RQLConnectionError holding the error of ctx if
+// ctx is done, which caused err, otherwise err.
+func connectContextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return RQLConnectionError{rqlError(ctxErr.Error())}
+	}
+	return err
+}
+
 func newConnection(conn net.Conn, address string, opts *ConnectOpts) *Connection {
 	c := &Connection{
 		Conn:               conn,
@@ -137,7 +307,9 @@ func newConnection(conn net.Conn, address string, opts *ConnectOpts) *Connection
 		responseChan:       make(chan responseAndError, 16),
 		stopProcessingChan: make(chan struct{}),
 		buffer:             bytes.NewBuffer(make([]byte, 0, jsonBufferDefaultSize)),
+		createdAt:          time.Now(),
 	}
+	c.lastUsed = c.createdAt.UnixNano()
 	return c
 }
 
@@ -162,12 +334,19 @@ func (c *Connection) Close() error {
 //
 // This function is used internally by Run which should be used for most queries.
 func (c *Connection) Query(ctx context.Context, q Query) (*Response, *Cursor, error) {
+	response, cursor, _, err := c.query(ctx, q)
+	return response, cursor, err
+}
+
+// query sends a Query like Query, sent reports whether the query may have
+// reached the server, it is false if the query failed before being written.
+func (c *Connection) query(ctx context.Context, q Query) (response *Response, cursor *Cursor, sent bool, err error) {
 	if c == nil {
-		return nil, nil, ErrConnectionClosed
+		return nil, nil, false, ErrConnectionClosed
 	}
 	if c.Conn == nil || c.isClosed() {
 		c.setBad()
-		return nil, nil, ErrConnectionClosed
+		return nil, nil, false, ErrConnectionClosed
 	}
 	if ctx == nil {
 		ctx = c.contextFromConnectionOpts()
@@ -182,7 +361,7 @@ func (c *Connection) Query(ctx context.Context, q Query) (*Response, *Cursor, er
 			var err error
 			q.Opts["db"], err = DB(c.opts.Database).Build()
 			if err != nil {
-				return nil, nil, RQLDriverError{rqlError(err.Error())}
+				return nil, nil, false, RQLDriverError{rqlError(err.Error())}
 			}
 		}
 	}
@@ -201,7 +380,10 @@ func (c *Connection) Query(ctx context.Context, q Query) (*Response, *Cursor, er
 		}
 	}
 
-	err := c.sendQuery(q)
+	atomic.StoreInt64(&c.lastUsed, time.Now().UnixNano())
+	defer func() { atomic.StoreInt64(&c.lastUsed, time.Now().UnixNano()) }()
+
+	err = c.sendQuery(q)
 	if err != nil {
 		if fetchingSpan != nil {
 			ext.Error.Set(fetchingSpan, true)
@@ -211,28 +393,90 @@ func (c *Connection) Query(ctx context.Context, q Query) (*Response, *Cursor, er
 				opentracing.SpanFromContext(ctx).Finish()
 			}
 		}
-		return nil, nil, err
+		return nil, nil, true, err
 	}
 
 	if noreply, ok := q.Opts["noreply"]; ok && noreply.(bool) {
-		return nil, nil, nil
+		atomic.StoreInt32(&c.noreplyPending, 1)
+		return nil, nil, true, nil
 	}
 
+	atomic.AddInt32(&c.pendingQueries, 1)
+	defer atomic.AddInt32(&c.pendingQueries, -1)
+
 	promise := make(chan responseAndCursor, 1)
 	select {
 	case c.readRequestsChan <- tokenAndPromise{ctx: ctx, query: &q, span: fetchingSpan, promise: promise}:
 	case <-ctx.Done():
-		return c.stopQuery(&q)
+		response, cursor, err = c.stopQuery(&q)
+		return response, cursor, true, err
 	}
 
 	select {
 	case future := <-promise:
-		return future.response, future.cursor, future.err
+		return future.response, future.cursor, true, future.err
 	case <-ctx.Done():
-		return c.stopQuery(&q)
+		response, cursor, err = c.stopQuery(&q)
+		return response, cursor, true, err
 	case <-c.stopProcessingChan: // connection readRequests processing stopped, promise can be never answered
-		return nil, nil, ErrConnectionClosed
+		return nil, nil, true, ErrConnectionClosed
+	}
+}
+
+// waitNoReply sends a NOREPLY_WAIT query if any noreply queries were sent on
+// the connection since the last NOREPLY_WAIT, ensuring they were processed by
+// the server.
+func (c *Connection) waitNoReply() error {
+	if !atomic.CompareAndSwapInt32(&c.noreplyPending, 1, 0) {
+		return nil
+	}
+
+	_, _, err := c.Query(c.contextFromConnectionOpts(), Query{
+		Type: p.Query_NOREPLY_WAIT,
+		Opts: map[string]interface{}{},
+	})
+	if err != nil {
+		atomic.StoreInt32(&c.noreplyPending, 1)
 	}
+	return err
+}
+
+// expired returns true if the connection has been idle for longer than
+// ConnMaxIdleTime or open for longer than ConnMaxLifetime, and is not busy,
+// so that the pool should replace it.
+func (c *Connection) expired(now time.Time) bool {
+	if c.opts == nil || (c.opts.ConnMaxIdleTime <= 0 && c.opts.ConnMaxLifetime <= 0) {
+		return false
+	}
+
+	lastUsed := time.Unix(0, atomic.LoadInt64(&c.lastUsed))
+	idle := c.opts.ConnMaxIdleTime > 0 && now.Sub(lastUsed) > c.opts.ConnMaxIdleTime
+	old := c.opts.ConnMaxLifetime > 0 && now.Sub(c.createdAt) > c.opts.ConnMaxLifetime
+
+	return (idle || old) && !c.busy()
+}
+
+// busy returns true if queries sent on the connection are waiting for a
+// response or cursors on the connection have more results to fetch.
+func (c *Connection) busy() bool {
+	return atomic.LoadInt32(&c.pendingQueries) > 0 || atomic.LoadInt32(&c.openCursors) > 0
+}
+
+// acquire marks the connection as used by a query sent through the pool until
+// release is called, so that it isn't closed if the pool replaces it. It
+// returns false if the connection has already been replaced and must not be
+// used.
+func (c *Connection) acquire() bool {
+	atomic.AddInt32(&c.users, 1)
+	if atomic.LoadInt32(&c.retired) == 1 {
+		c.release()
+		return false
+	}
+	return true
+}
+
+func (c *Connection) release() {
+	atomic.AddInt32(&c.users, -1)
 }
 
 func (c *Connection) stopQuery(q *Query) (*Response, *Cursor, error) {
@@ -255,6 +499,9 @@ func (c *Connection) startTracingSpan(parentSpan opentracing.Span, q *Query) ope
 	if q.Type == p.Query_START {
 		span.LogFields(log.String("query", q.Term.String()))
 	}
+	if q.Name != "" {
+		span.SetTag("db.query_name", q.Name)
+	}
 
 	return span
 }
@@ -299,6 +546,7 @@ func (c *Connection) processResponses() {
 				close(c.stopProcessingChan)
 				broadcastError(readRequests, ErrConnectionClosed)
 				c.cursors = nil
+				atomic.StoreInt32(&c.openCursors, 0)
 
 				return
 			}
@@ -426,18 +674,37 @@ func (c *Connection) readResponse() (*Response, error) {
 	responseToken := int64(binary.LittleEndian.Uint64(headerBuf[:8]))
 	messageLength := int(binary.LittleEndian.Uint32(headerBuf[8:]))
 
+	if c.opts.MaxResponseBytes > 0 && messageLength > c.opts.MaxResponseBytes {
+		// Skip the response so that the connection can still be used
+		if _, err := io.CopyN(ioutil.Discard, c.Conn, int64(messageLength)); err != nil {
+			c.setBad()
+			return nil, RQLConnectionError{rqlError(err.Error())}
+		}
+		return &Response{
+			Token: responseToken,
+			err:   ResponseTooLargeError{Size: messageLength, Limit: c.opts.MaxResponseBytes},
+		}, nil
+	}
+
 	if messageLength > jsonBufferDefaultSize {
 		c.lastResponseTime = time.Now()
 		c.lastResponseSize = messageLength
 	}
 
-	c.buffer.Reset()
+	buffer := c.buffer
+	if c.opts.ReuseBuffers {
+		buffer = responseBufferPool.Get().(*bytes.Buffer)
+	}
+	buffer.Reset()
 
-	if c.buffer.Cap() < messageLength {
-		oldCap := c.buffer.Cap()
-		c.buffer.Grow(messageLength - oldCap)
+	if buffer.Cap() < messageLength {
+		oldCap := buffer.Cap()
+		buffer.Grow(messageLength - oldCap)
 	}
-	if _, err := c.buffer.ReadFrom(io.LimitReader(c.Conn, int64(messageLength))); err != nil {
+	if _, err := buffer.ReadFrom(io.LimitReader(c.Conn, int64(messageLength))); err != nil {
+		if c.opts.ReuseBuffers {
+			releaseResponseBuffer(buffer)
+		}
 		c.setBad()
 		return nil, RQLConnectionError{rqlError(err.Error())}
 	}
@@ -445,15 +712,59 @@ func (c *Connection) readResponse() (*Response, error) {
 	// Decode the response
 	response := new(Response)
 
-	if err := json.Unmarshal(c.buffer.Bytes(), response); err != nil {
-		c.setBad()
-		return nil, RQLDriverError{rqlError(err.Error())}
+	if err := c.decodeResponse(buffer, response); err != nil {
+		// The response may contain non-finite numbers which are not valid JSON
+		b, ok := replaceNonFiniteNumbers(buffer.Bytes())
+		if c.opts.ReuseBuffers {
+			releaseResponseBuffer(buffer)
+		}
+		if !ok || json.Unmarshal(b, response) != nil {
+			c.setBad()
+			return nil, RQLDriverError{rqlError(err.Error())}
+		}
 	}
 	response.Token = responseToken
 
 	return response, nil
 }
 
+// maxReusedBufferSize is the capacity above which response buffers are not
+// returned to responseBufferPool.
+const maxReusedBufferSize = 4 * 1024 * 1024
+
+// responseBufferPool holds the buffers responses are read into when
+// ConnectOpts.ReuseBuffers is set.
+var responseBufferPool = sync.Pool{
+	New: func() interface{} {
+		return bytes.NewBuffer(make([]byte, 0, jsonBufferDefaultSize))
+	},
+}
+
+func releaseResponseBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() > maxReusedBufferSize {
+		return
+	}
+	responseBufferPool.Put(buffer)
+}
+
+// decodeResponse decodes the response read into buffer, if buffers are reused
+// the documents in response.Responses are not copied and buffer must not be
+// reused before response.release is called.
+func (c *Connection) decodeResponse(buffer *bytes.Buffer, response *Response) error {
+	if !c.opts.ReuseBuffers {
+		return json.Unmarshal(buffer.Bytes(), response)
+	}
+
+	if _, err := json.Parse(buffer.Bytes(), response, json.DontCopyRawMessage); err != nil {
+		return err
+	}
+	response.release = func() {
+		releaseResponseBuffer(buffer)
+	}
+
+	return nil
+}
+
 // Called to fill response for the query
 func (c *Connection) processResponse(ctx context.Context, q Query, response *Response, span opentracing.Span) (r *Response, cur *Cursor, err error) {
 	if span != nil {
@@ -466,13 +777,33 @@ func (c *Connection) processResponse(ctx context.Context, q Query, response *Res
 		}()
 	}
 
+	if response.err != nil {
+		err = response.err
+		if _, ok := err.(ResponseTooLargeError); ok {
+			// The discarded response may be a partial one, stop the query
+			// so that the server doesn't keep the rest of the results
+			go c.stopQuery(&q)
+		}
+		return response, c.processErrorResponse(response, err), err
+	}
+
+	// Only cursors keep the reused buffer until the documents have been read
+	switch response.Type {
+	case p.Response_SUCCESS_ATOM, p.Response_SERVER_INFO, p.Response_SUCCESS_PARTIAL, p.Response_SUCCESS_SEQUENCE:
+	default:
+		response.detach()
+	}
+
 	switch response.Type {
 	case p.Response_CLIENT_ERROR:
-		return response, c.processErrorResponse(response), createClientError(response, q.Term)
+		err = createClientError(response, q.Term)
+		return response, c.processErrorResponse(response, err), err
 	case p.Response_COMPILE_ERROR:
-		return response, c.processErrorResponse(response), createCompileError(response, q.Term)
+		err = createCompileError(response, q.Term)
+		return response, c.processErrorResponse(response, err), err
 	case p.Response_RUNTIME_ERROR:
-		return response, c.processErrorResponse(response), createRuntimeError(response.ErrorType, response, q.Term)
+		err = createRuntimeError(response.ErrorType, response, q.Term)
+		return response, c.processErrorResponse(response, err), err
 	case p.Response_SUCCESS_ATOM, p.Response_SERVER_INFO:
 		return c.processAtomResponse(ctx, q, response)
 	case p.Response_SUCCESS_PARTIAL:
@@ -486,9 +817,15 @@ func (c *Connection) processResponse(ctx context.Context, q Query, response *Res
 	}
 }
 
-func (c *Connection) processErrorResponse(response *Response) *Cursor {
+// processErrorResponse removes the cursor associated with the response (if
+// any) and stores the error on it so it is returned by Cursor.Err straight away.
+func (c *Connection) processErrorResponse(response *Response, err error) *Cursor {
 	cursor := c.cursors[response.Token]
 	delete(c.cursors, response.Token)
+	atomic.StoreInt32(&c.openCursors, int32(len(c.cursors)))
+	if cursor != nil {
+		cursor.handleError(err)
+	}
 	return cursor
 }
 
@@ -524,6 +861,7 @@ func (c *Connection) processPartialResponse(ctx context.Context, q Query, respon
 		cursor.profile = response.Profile
 
 		c.cursors[response.Token] = cursor
+		atomic.StoreInt32(&c.openCursors, int32(len(c.cursors)))
 	}
 
 	cursor.extend(response)
@@ -539,6 +877,7 @@ func (c *Connection) processSequenceResponse(ctx context.Context, q Query, respo
 		cursor.profile = response.Profile
 	}
 	delete(c.cursors, response.Token)
+	atomic.StoreInt32(&c.openCursors, int32(len(c.cursors)))
 
 	cursor.extend(response)
 
@@ -547,6 +886,7 @@ func (c *Connection) processSequenceResponse(ctx context.Context, q Query, respo
 
 func (c *Connection) processWaitResponse(response *Response) (*Response, *Cursor, error) {
 	delete(c.cursors, response.Token)
+	atomic.StoreInt32(&c.openCursors, int32(len(c.cursors)))
 	return response, nil, nil
 }
 
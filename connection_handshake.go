@@ -11,10 +11,12 @@ import (
 	"github.com/segmentio/encoding/json"
 	"hash"
 	"io"
+	"net"
 	"strconv"
 	"strings"
 
 	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/net/context"
 
 	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 )
@@ -30,12 +32,12 @@ type connectionHandshake interface {
 	Send() error
 }
 
-func (c *Connection) handshake(version HandshakeVersion) (connectionHandshake, error) {
+func (c *Connection) handshake(ctx context.Context, version HandshakeVersion) (connectionHandshake, error) {
 	switch version {
 	case HandshakeV0_4:
 		return &connectionHandshakeV0_4{conn: c}, nil
 	case HandshakeV1_0:
-		return &connectionHandshakeV1_0{conn: c}, nil
+		return &connectionHandshakeV1_0{conn: c, ctx: ctx}, nil
 	default:
 		return nil, fmt.Errorf("Unrecognised handshake version")
 	}
@@ -49,12 +51,16 @@ func (c *connectionHandshakeV0_4) Send() error {
 	// Send handshake request
 	if err := c.writeHandshakeReq(); err != nil {
 		c.conn.Close()
-		return RQLConnectionError{rqlError(err.Error())}
+		return c.conn.handshakeConnError(err)
 	}
 	// Read handshake response
 	if err := c.readHandshakeSuccess(); err != nil {
 		c.conn.Close()
-		return RQLConnectionError{rqlError(err.Error())}
+		switch err.(type) {
+		case RQLHandshakeError, RQLAuthError:
+			return err
+		}
+		return c.conn.handshakeConnError(err)
 	}
 
 	return nil
@@ -98,6 +104,9 @@ func (c *connectionHandshakeV0_4) readHandshakeSuccess() error {
 	response := string(line[:len(line)-1])
 	if response != "SUCCESS" {
 		response = strings.TrimSpace(response)
+		if strings.Contains(response, "authorization key") {
+			return c.conn.authError(fmt.Sprintf("Server dropped connection with message: \"%s\"", response))
+		}
 		// we failed authorization or something else terrible happened
 		return RQLDriverError{rqlError(fmt.Sprintf("Server dropped connection with message: \"%s\"", response))}
 	}
@@ -112,14 +121,24 @@ const (
 
 type connectionHandshakeV1_0 struct {
 	conn   *Connection
+	ctx    context.Context // passed to the CredentialProvider, if nil context.Background is used
 	reader *bufio.Reader
 
+	username string
+	password string
+
 	authMsg string
 }
 
 func (c *connectionHandshakeV1_0) Send() error {
 	c.reader = bufio.NewReader(c.conn.Conn)
 
+	// Load credentials
+	if err := c.loadCredentials(); err != nil {
+		c.conn.Close()
+		return err
+	}
+
 	// Generate client nonce
 	clientNonce, err := c.generateNonce()
 	if err != nil {
@@ -146,7 +165,7 @@ func (c *connectionHandshakeV1_0) Send() error {
 
 	// Check server nonce
 	if !strings.HasPrefix(serverNonce, clientNonce) {
-		return RQLAuthError{RQLDriverError{rqlError("Invalid nonce from server")}}
+		return c.conn.authError("Invalid nonce from server")
 	}
 
 	// Generate proof
@@ -168,11 +187,40 @@ func (c *connectionHandshakeV1_0) Send() error {
 	return nil
 }
 
+// loadCredentials sets the username and password used by the handshake, if a
+// CredentialProvider is configured it is called instead of using the static
+// Username and Password.
+func (c *connectionHandshakeV1_0) loadCredentials() error {
+	opts := c.conn.opts
+	if opts.CredentialProvider == nil {
+		c.username, c.password = opts.Username, opts.Password
+		return nil
+	}
+
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	username, password, err := opts.CredentialProvider(ctx)
+	if err != nil {
+		return c.conn.authError(fmt.Sprintf("Failed to load credentials: %s", err))
+	}
+	c.username, c.password = username, password
+
+	return nil
+}
+
 func (c *connectionHandshakeV1_0) writeFirstMessage(clientNonce string) error {
 	// Default username to admin if not set
 	username := "admin"
-	if c.conn.opts.Username != "" {
-		username = c.conn.opts.Username
+	if c.username != "" {
+		username = c.username
 	}
 
 	c.authMsg = fmt.Sprintf("n=%s,r=%s", username, clientNonce)
@@ -230,14 +278,12 @@ func (c *connectionHandshakeV1_0) checkServerVersions() error {
 	}
 	if rsp.MinProtocolVersion > handshakeV1_0_protocolVersionNumber ||
 		rsp.MaxProtocolVersion < handshakeV1_0_protocolVersionNumber {
-		return RQLDriverError{rqlError(
-			fmt.Sprintf(
-				"Unsupported protocol version %d, expected between %d and %d.",
-				handshakeV1_0_protocolVersionNumber,
-				rsp.MinProtocolVersion,
-				rsp.MaxProtocolVersion,
-			),
-		)}
+		return c.conn.handshakeError(ErrUnsupportedProtocol, fmt.Sprintf(
+			"Unsupported protocol version %d, expected between %d and %d.",
+			handshakeV1_0_protocolVersionNumber,
+			rsp.MinProtocolVersion,
+			rsp.MaxProtocolVersion,
+		))
 	}
 
 	return nil
@@ -356,7 +402,7 @@ func (c *connectionHandshakeV1_0) readFinalMessage(serverSignature string) error
 
 	// Validate server response
 	if serverSignature != auth["v"] {
-		return RQLAuthError{RQLDriverError{rqlError("Invalid server signature")}}
+		return c.conn.authError("Invalid server signature")
 	}
 
 	return nil
@@ -365,7 +411,7 @@ func (c *connectionHandshakeV1_0) readFinalMessage(serverSignature string) error
 func (c *connectionHandshakeV1_0) writeData(data []byte) error {
 
 	if err := c.conn.writeData(data); err != nil {
-		return RQLConnectionError{rqlError(err.Error())}
+		return c.conn.handshakeConnError(err)
 	}
 
 	return nil
@@ -377,7 +423,7 @@ func (c *connectionHandshakeV1_0) readResponse() ([]byte, error) {
 		if err == io.EOF {
 			return nil, RQLConnectionError{rqlError(fmt.Sprintf("Unexpected EOF: %s", string(line)))}
 		}
-		return nil, RQLConnectionError{rqlError(err.Error())}
+		return nil, c.conn.handshakeConnError(err)
 	}
 
 	// Strip null byte and return
@@ -397,7 +443,7 @@ func (c *connectionHandshakeV1_0) generateNonce() (string, error) {
 }
 
 func (c *connectionHandshakeV1_0) saltPassword(iter int64, salt []byte) []byte {
-	pass := []byte(c.conn.opts.Password)
+	pass := []byte(c.password)
 
 	return pbkdf2.Key(pass, salt, int(iter), sha256.Size, sha256.New)
 }
@@ -438,8 +484,8 @@ func (c *connectionHandshakeV1_0) serverSignature(saltedPass []byte) string {
 }
 
 func (c *connectionHandshakeV1_0) handshakeError(code int, message string) error {
-	if code >= 10 || code <= 20 {
-		return RQLAuthError{RQLDriverError{rqlError(message)}}
+	if code >= 10 && code <= 20 {
+		return c.conn.authError(message)
 	}
 
 	return RQLDriverError{rqlError(message)}
@@ -448,3 +494,21 @@ func (c *connectionHandshakeV1_0) handshakeError(code int, message string) error
 func (c *connectionHandshakeV1_0) hashFunc() func() hash.Hash {
 	return sha256.New
 }
+
+func (c *Connection) authError(message string) error {
+	return RQLAuthError{RQLDriverError: RQLDriverError{rqlError(message)}, Address: c.address}
+}
+
+func (c *Connection) handshakeError(kind error, message string) error {
+	return RQLHandshakeError{Kind: kind, Address: c.address, Message: message}
+}
+
+// handshakeConnError converts an error from the underlying connection, timeouts
+// are returned as a RQLHandshakeError.
+func (c *Connection) handshakeConnError(err error) error {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return c.handshakeError(ErrHandshakeTimeout, err.Error())
+	}
+
+	return RQLConnectionError{rqlError(err.Error())}
+}
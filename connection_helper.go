@@ -3,6 +3,7 @@ package rethinkdb
 import (
 	"golang.org/x/net/context"
 	"io"
+	"net"
 )
 
 // Write 'data' to conn
@@ -28,3 +29,14 @@ func (c *Connection) contextFromConnectionOpts() context.Context {
 	ctx, _ := context.WithTimeout(context.Background(), min)
 	return ctx
 }
+
+// setTCPNoDelay sets TCP_NODELAY on conn if it is a TCP connection, other
+// connections are left unchanged. It is called on the dialed connection before
+// the TLS handshake wraps it.
+func setTCPNoDelay(conn net.Conn, noDelay bool) error {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		return tcpConn.SetNoDelay(noDelay)
+	}
+
+	return nil
+}
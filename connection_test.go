@@ -1,7 +1,17 @@
 package rethinkdb
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/binary"
+	"encoding/pem"
+	"fmt"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/segmentio/encoding/json"
@@ -10,7 +20,14 @@ import (
 	test "gopkg.in/check.v1"
 	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
 	"sync"
+	"testing"
 	"time"
 )
 
@@ -209,6 +226,37 @@ func (s *ConnectionSuite) TestConnection_Query_TimeoutRead(c *test.C) {
 	conn.AssertExpectations(c)
 }
 
+func (s *ConnectionSuite) TestConnection_Query_CancelStopsQuery(c *test.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	token := int64(1)
+	q := testQuery(DB("db").Table("table").Get("id"))
+	writeData := serializeQuery(token, q)
+	stopData := serializeQuery(token, newStopQuery(token))
+	stopped := make(chan struct{})
+
+	conn := &connMock{}
+	conn.On("Write", writeData).Return(len(writeData), nil, nil)
+	conn.On("Write", stopData).Return(len(stopData), nil, nil).Run(func(args mock.Arguments) {
+		close(stopped)
+	})
+
+	connection := newConnection(conn, "addr", &ConnectOpts{ReadTimeout: 10 * time.Millisecond, WriteTimeout: 10 * time.Millisecond})
+
+	// The context is cancelled while waiting for the response
+	time.AfterFunc(10*time.Millisecond, cancel)
+	response, cursor, err := connection.Query(ctx, q)
+
+	c.Assert(response, test.IsNil)
+	c.Assert(cursor, test.IsNil)
+	c.Assert(err, test.Equals, ErrQueryTimeout)
+	select {
+	case <-stopped:
+	default:
+		c.Fatal("expected a STOP query to be sent for the token")
+	}
+	conn.AssertExpectations(c)
+}
+
 func (s *ConnectionSuite) TestConnection_Query_SendFailTracing(c *test.C) {
 	tracer := mocktracer.New()
 	rootSpan := tracer.StartSpan("root")
@@ -368,6 +416,44 @@ func (s *ConnectionSuite) TestConnection_readResponse_BodySocketErr(c *test.C) {
 	conn.AssertExpectations(c)
 }
 
+func (s *ConnectionSuite) TestConnection_readResponse_TooLarge(c *test.C) {
+	token := int64(5)
+	respData := make([]byte, 100)
+	header := respHeader(token, respData)
+
+	stopData := serializeQuery(token, newStopQuery(token))
+	stopped := make(chan struct{})
+
+	conn := &connMock{}
+	conn.On("Read", respHeaderLen).Return(header, len(header), nil, nil)
+	conn.On("Read", len(respData)).Return(respData, len(respData), nil, nil)
+	conn.On("Write", stopData).Return(len(stopData), nil, nil).Run(func(args mock.Arguments) {
+		close(stopped)
+	})
+
+	connection := newConnection(conn, "addr", &ConnectOpts{MaxResponseBytes: 10})
+	defer close(connection.stopProcessingChan)
+
+	// The response is skipped without closing the connection
+	response, err := connection.readResponse()
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Token, test.Equals, token)
+	c.Assert(connection.isBad(), test.Equals, false)
+
+	_, cursor, err := connection.processResponse(context.Background(), Query{Token: token}, response, nil)
+	c.Assert(cursor, test.IsNil)
+	c.Assert(err, test.Equals, ResponseTooLargeError{Size: 100, Limit: 10})
+	c.Assert(err, test.ErrorMatches, "rethinkdb: response of 100 bytes exceeds the maximum response size of 10 bytes")
+
+	// The query is stopped as the discarded response may be a partial one
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		c.Fatal("expected a STOP query to be sent")
+	}
+	conn.AssertExpectations(c)
+}
+
 func (s *ConnectionSuite) TestConnection_readResponse_BodyUnmarshalErr(c *test.C) {
 	token := int64(5)
 	respData := serializeAtomResponse()
@@ -387,6 +473,93 @@ func (s *ConnectionSuite) TestConnection_readResponse_BodyUnmarshalErr(c *test.C
 	conn.AssertExpectations(c)
 }
 
+func (s *ConnectionSuite) TestConnection_readResponse_NonFiniteNumbers(c *test.C) {
+	token := int64(5)
+	respData := []byte(`{"t":1,"r":[[Infinity,-Infinity,NaN,"NaN"]]}`)
+	header := respHeader(token, respData)
+
+	conn := &connMock{}
+	conn.On("Read", respHeaderLen).Return(header, len(header), nil, nil)
+	conn.On("Read", len(respData)).Return(respData, len(respData), nil, nil)
+
+	connection := newConnection(conn, "addr", &ConnectOpts{})
+
+	response, err := connection.readResponse()
+	c.Assert(err, test.IsNil)
+	c.Assert(connection.isBad(), test.Equals, false)
+
+	var values []interface{}
+	cursor := newCursor(context.Background(), connection, "Cursor", token, nil, map[string]interface{}{})
+	cursor.extend(response)
+	c.Assert(cursor.All(&values), test.IsNil)
+	c.Assert(values, test.HasLen, 4)
+	c.Assert(math.IsInf(values[0].(float64), 1), test.Equals, true)
+	c.Assert(math.IsInf(values[1].(float64), -1), test.Equals, true)
+	c.Assert(math.IsNaN(values[2].(float64)), test.Equals, true)
+	c.Assert(values[3], test.Equals, "NaN")
+	conn.AssertExpectations(c)
+}
+
+func (s *ConnectionSuite) TestConnection_readResponse_RejectNonFinite(c *test.C) {
+	token := int64(5)
+	respData := []byte(`{"t":1,"r":[Infinity]}`)
+	header := respHeader(token, respData)
+
+	conn := &connMock{}
+	conn.On("Read", respHeaderLen).Return(header, len(header), nil, nil)
+	conn.On("Read", len(respData)).Return(respData, len(respData), nil, nil)
+
+	connection := newConnection(conn, "addr", &ConnectOpts{})
+
+	response, err := connection.readResponse()
+	c.Assert(err, test.IsNil)
+
+	var value float64
+	cursor := newCursor(context.Background(), connection, "Cursor", token, nil, map[string]interface{}{"reject_non_finite": true})
+	cursor.extend(response)
+	c.Assert(cursor.One(&value), test.NotNil)
+	conn.AssertExpectations(c)
+}
+
+func (s *ConnectionSuite) TestConnection_readResponse_ReuseBuffers(c *test.C) {
+	respData1 := []byte(`{"t":2,"r":[{"id":1},{"id":2}]}`)
+	respData2 := []byte(`{"t":18,"e":3000000,"r":["Table not found"]}`)
+	header1 := respHeader(5, respData1)
+	header2 := respHeader(6, respData2)
+
+	conn := &connMock{}
+	conn.On("Read", respHeaderLen).Return(header1, len(header1), nil, nil).Once()
+	conn.On("Read", len(respData1)).Return(respData1, len(respData1), nil, nil).Once()
+	conn.On("Read", respHeaderLen).Return(header2, len(header2), nil, nil).Once()
+	conn.On("Read", len(respData2)).Return(respData2, len(respData2), nil, nil).Once()
+
+	connection := newConnection(conn, "addr", &ConnectOpts{ReuseBuffers: true})
+
+	response, err := connection.readResponse()
+	c.Assert(err, test.IsNil)
+	_, cursor, err := connection.processResponse(context.Background(), Query{Token: 5}, response, nil)
+	c.Assert(err, test.IsNil)
+	c.Assert(cursor.releases, test.HasLen, 1)
+
+	// The buffer of the first response is not reused until it has been read
+	response, err = connection.readResponse()
+	c.Assert(err, test.IsNil)
+	_, _, err = connection.processResponse(context.Background(), Query{Token: 6}, response, nil)
+	c.Assert(err, test.ErrorMatches, "(?s).*Table not found.*")
+	c.Assert(response.release, test.IsNil)
+
+	b, ok := cursor.NextResponse()
+	c.Assert(ok, test.Equals, true)
+	c.Assert(string(b), test.Equals, `{"id":1}`)
+	var doc map[string]interface{}
+	c.Assert(cursor.Next(&doc), test.Equals, true)
+	c.Assert(doc, test.DeepEquals, map[string]interface{}{"id": float64(2)})
+	c.Assert(cursor.Next(&doc), test.Equals, false)
+	c.Assert(cursor.Err(), test.IsNil)
+	c.Assert(cursor.releases, test.HasLen, 0)
+	conn.AssertExpectations(c)
+}
+
 func (s *ConnectionSuite) TestConnection_processResponse_ClientErrOk(c *test.C) {
 	ctx := context.Background()
 	token := int64(3)
@@ -489,6 +662,27 @@ func (s *ConnectionSuite) TestConnection_processResponse_PartialOk(c *test.C) {
 	c.Assert(connection.cursors[token], test.Equals, cursor)
 }
 
+func (s *ConnectionSuite) TestConnection_processResponse_PartialThenErrSetsCursorErr(c *test.C) {
+	ctx := context.Background()
+	token := int64(3)
+	term := Table("test")
+	q := Query{Token: token, Term: &term}
+	partial := &Response{Token: token, Type: p.Response_SUCCESS_PARTIAL, Responses: []json.RawMessage{{'1'}}}
+	runtimeErr := &Response{Token: token, Type: p.Response_RUNTIME_ERROR, Responses: []json.RawMessage{{'"', 'e', '"'}}}
+
+	connection := newConnection(nil, "addr", &ConnectOpts{})
+
+	_, cursor, err := connection.processResponse(ctx, q, partial, nil)
+	c.Assert(err, test.IsNil)
+	c.Assert(cursor.Err(), test.IsNil)
+
+	_, errCursor, err := connection.processResponse(ctx, q, runtimeErr, nil)
+	c.Assert(err, test.FitsTypeOf, RQLRuntimeError{})
+	c.Assert(errCursor, test.Equals, cursor)
+	c.Assert(cursor.Err(), test.Equals, err)
+	c.Assert(connection.cursors, test.HasLen, 0)
+}
+
 func (s *ConnectionSuite) TestConnection_processResponse_SequenceOk(c *test.C) {
 	tracer := mocktracer.New()
 	rootSpan := tracer.StartSpan("root")
@@ -584,3 +778,345 @@ func serializeAtomResponse() []byte {
 	})
 	return b
 }
+
+func (s *ConnectionSuite) TestConnection_handshakeV1_CredentialProvider(c *test.C) {
+	calls := 0
+	opts := &ConnectOpts{
+		Username: "static",
+		Password: "static",
+		CredentialProvider: func(ctx context.Context) (string, string, error) {
+			calls++
+			return "rotated", fmt.Sprintf("secret-%d", calls), nil
+		},
+	}
+	connection := newConnection(nil, "addr", opts)
+
+	h1 := &connectionHandshakeV1_0{conn: connection}
+	c.Assert(h1.loadCredentials(), test.IsNil)
+	h2 := &connectionHandshakeV1_0{conn: connection}
+	c.Assert(h2.loadCredentials(), test.IsNil)
+
+	c.Assert(h1.username, test.Equals, "rotated")
+	c.Assert(h1.password, test.Equals, "secret-1")
+	c.Assert(h2.password, test.Equals, "secret-2")
+}
+
+func (s *ConnectionSuite) TestConnection_handshakeV1_CredentialProviderErr(c *test.C) {
+	opts := &ConnectOpts{
+		CredentialProvider: func(ctx context.Context) (string, string, error) {
+			return "", "", io.ErrUnexpectedEOF
+		},
+	}
+	connection := newConnection(nil, "addr", opts)
+
+	err := (&connectionHandshakeV1_0{conn: connection}).loadCredentials()
+	c.Assert(err, test.FitsTypeOf, RQLAuthError{})
+	c.Assert(err.(RQLAuthError).Address, test.Equals, "addr")
+}
+
+func (s *ConnectionSuite) TestConnection_handshakeV1_UnsupportedProtocol(c *test.C) {
+	rsp := []byte(`{"success":true,"min_protocol_version":1,"max_protocol_version":1}` + "\x00")
+
+	conn := &connMock{}
+	conn.On("Read", 4096).Return(rsp, len(rsp), nil, nil)
+
+	connection := newConnection(conn, "addr:28015", &ConnectOpts{})
+	h := &connectionHandshakeV1_0{conn: connection, reader: bufio.NewReader(conn)}
+
+	err := h.checkServerVersions()
+	c.Assert(err, test.FitsTypeOf, RQLHandshakeError{})
+	c.Assert(err.(RQLHandshakeError).Kind, test.Equals, ErrUnsupportedProtocol)
+	c.Assert(err.(RQLHandshakeError).Address, test.Equals, "addr:28015")
+	conn.AssertExpectations(c)
+}
+
+func (s *ConnectionSuite) TestConnection_handshakeV1_AuthFailed(c *test.C) {
+	connection := newConnection(nil, "addr:28015", &ConnectOpts{})
+	h := &connectionHandshakeV1_0{conn: connection}
+
+	err := h.handshakeError(12, "Wrong password")
+	c.Assert(err, test.FitsTypeOf, RQLAuthError{})
+	c.Assert(err.(RQLAuthError).Address, test.Equals, "addr:28015")
+	c.Assert(err.(RQLAuthError).Unwrap(), test.Equals, ErrAuthFailed)
+	c.Assert(err.Error(), test.Equals, "rethinkdb: Wrong password (addr:28015)")
+
+	err = h.handshakeError(1, "Other error")
+	c.Assert(err, test.FitsTypeOf, RQLDriverError{})
+}
+
+func (s *ConnectionSuite) TestConnection_handshake_Timeout(c *test.C) {
+	connection := newConnection(nil, "addr:28015", &ConnectOpts{})
+
+	err := connection.handshakeConnError(timeoutError{})
+	c.Assert(err, test.FitsTypeOf, RQLHandshakeError{})
+	c.Assert(err.(RQLHandshakeError).Kind, test.Equals, ErrHandshakeTimeout)
+
+	err = connection.handshakeConnError(io.ErrUnexpectedEOF)
+	c.Assert(err, test.FitsTypeOf, RQLConnectionError{})
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func (s *ConnectionSuite) TestConnection_setTCPNoDelay(c *test.C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, test.IsNil)
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, test.IsNil)
+	defer conn.Close()
+
+	c.Assert(setTCPNoDelay(conn, false), test.IsNil)
+
+	// Non-TCP connections are skipped
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c.Assert(setTCPNoDelay(client, false), test.IsNil)
+}
+
+func (s *ConnectionSuite) TestConnection_UnixSocket(c *test.C) {
+	path := filepath.Join(c.MkDir(), "rethinkdb.sock")
+	c.Assert(hostFromAddress("unix://"+path), test.Equals, NewUnixHost(path))
+	c.Assert(hostFromAddress("unix://"+path).String(), test.Equals, "unix://"+path)
+	c.Assert(hostFromAddress("host1:28016"), test.Equals, NewHost("host1", 28016))
+
+	l, err := net.Listen("unix", path)
+	c.Assert(err, test.IsNil)
+	defer l.Close()
+	magic := make(chan uint32, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(conn, b); err == nil {
+			magic <- binary.LittleEndian.Uint32(b)
+		}
+	}()
+
+	// TLS can't be used over a unix domain socket
+	for _, opts := range []*ConnectOpts{{TLSConfig: &tls.Config{}}, {ClientCertFile: "cert.pem", ClientKeyFile: "key.pem"}} {
+		_, err = NewConnection("unix://"+path, opts)
+		c.Assert(err, test.FitsTypeOf, RQLDriverError{})
+		c.Assert(err, test.ErrorMatches, "rethinkdb: TLS can't be used to connect to the unix domain socket unix://.*")
+	}
+
+	// The handshake is sent over the socket
+	_, err = NewConnection("unix://"+path, &ConnectOpts{})
+	c.Assert(err, test.NotNil)
+	c.Assert(<-magic, test.Equals, uint32(p.VersionDummy_V1_0))
+}
+
+// writeTestCertificate writes a self-signed certificate and its key to dir.
+func writeTestCertificate(c *test.C, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, test.IsNil)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rethinkdb"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	c.Assert(err, test.IsNil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	c.Assert(err, test.IsNil)
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	c.Assert(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), test.IsNil)
+	c.Assert(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600), test.IsNil)
+	return certFile, keyFile
+}
+
+func (s *ConnectionSuite) TestConnection_tlsConfigFromOpts(c *test.C) {
+	certFile, keyFile := writeTestCertificate(c, c.MkDir())
+
+	config, err := tlsConfigFromOpts(ConnectOpts{})
+	c.Assert(err, test.IsNil)
+	c.Assert(config, test.IsNil)
+
+	base := &tls.Config{ServerName: "db.example.com"}
+	config, err = tlsConfigFromOpts(ConnectOpts{TLSConfig: base, ClientCertFile: certFile, ClientKeyFile: keyFile, CAFile: certFile})
+	c.Assert(err, test.IsNil)
+	c.Assert(config.ServerName, test.Equals, "db.example.com")
+	c.Assert(config.Certificates, test.HasLen, 1)
+	c.Assert(config.RootCAs, test.NotNil)
+	c.Assert(base.Certificates, test.HasLen, 0)
+	c.Assert(base.RootCAs, test.IsNil)
+
+	_, err = tlsConfigFromOpts(ConnectOpts{ClientCertFile: certFile})
+	c.Assert(err, test.ErrorMatches, "rethinkdb: ClientCertFile and ClientKeyFile must be set together")
+	_, err = tlsConfigFromOpts(ConnectOpts{ClientCertFile: certFile, ClientKeyFile: certFile})
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Failed to load client certificate: .*")
+	_, err = tlsConfigFromOpts(ConnectOpts{CAFile: keyFile})
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Failed to load CA certificates: no certificates found in .*")
+	_, err = tlsConfigFromOpts(ConnectOpts{CAFile: certFile, TLSConfig: &tls.Config{RootCAs: x509.NewCertPool()}})
+	c.Assert(err, test.ErrorMatches, "rethinkdb: CAFile can't be set when TLSConfig.RootCAs is set")
+
+	_, err = Connect(ConnectOpts{Address: "localhost:28015", CAFile: filepath.Join(c.MkDir(), "missing.pem")})
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Failed to load CA certificates: .*")
+}
+
+func (s *ConnectionSuite) TestConnection_processResponse_CompileErrBacktrace(c *test.C) {
+	ctx := context.Background()
+	token := int64(3)
+	term := DB("db").Table("table").Get("id").Field("a").Add(DB("db").Table("other").Count())
+	q := Query{Token: token, Term: &term}
+	response := &Response{
+		Token:     token,
+		Type:      p.Response_COMPILE_ERROR,
+		Responses: []json.RawMessage{json.RawMessage(`"Expected 2 arguments but found 1."`)},
+		Backtrace: []interface{}{float64(1), float64(0)},
+	}
+
+	connection := newConnection(nil, "addr", &ConnectOpts{})
+
+	_, _, err := connection.processResponse(ctx, q, response, nil)
+
+	compileErr, ok := err.(RQLCompileError)
+	c.Assert(ok, test.Equals, true)
+	c.Assert(compileErr.Message, test.Equals, "Expected 2 arguments but found 1.")
+	c.Assert(compileErr.Backtrace, test.DeepEquals, []Frame{{Pos: 1}, {Pos: 0}})
+	c.Assert(err.Error(), test.Equals, "rethinkdb: Expected 2 arguments but found 1. in:\n"+
+		term.String()+"\n"+
+		strings.Repeat(" ", len(`r.DB("db").Table("table").Get("id").Field("a").Add(`))+strings.Repeat("^", len(`r.DB("db").Table("other")`)))
+
+	// Unknown frames fall back to the query without carets
+	response.Backtrace = []interface{}{"default"}
+	_, _, err = connection.processResponse(ctx, q, response, nil)
+	c.Assert(err.(RQLCompileError).Backtrace, test.DeepEquals, []Frame{{Opt: "default"}})
+	c.Assert(err.Error(), test.Equals, "rethinkdb: Expected 2 arguments but found 1. in:\n"+term.String())
+}
+
+func (s *ConnectionSuite) TestConnectContext_HandshakeDeadline(c *test.C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, test.IsNil)
+	defer l.Close()
+	go func() {
+		// Accept connections but never reply to the handshake
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = newConnectionContext(ctx, l.Addr().String(), &ConnectOpts{})
+	c.Assert(err, test.FitsTypeOf, RQLConnectionError{})
+	c.Assert(err, test.ErrorMatches, "rethinkdb: context deadline exceeded")
+	c.Assert(time.Since(start) < time.Second, test.Equals, true)
+
+	_, err = ConnectContext(ctx, ConnectOpts{Address: l.Addr().String()})
+	c.Assert(err, test.NotNil)
+}
+
+func (s *ConnectionSuite) TestConnectContext_Canceled(c *test.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := newConnectionContext(ctx, "127.0.0.1:1", &ConnectOpts{})
+	c.Assert(err, test.FitsTypeOf, RQLConnectionError{})
+	c.Assert(err, test.ErrorMatches, ".*operation was canceled")
+}
+
+// repeatConn is a net.Conn which reads data over and over again.
+type repeatConn struct {
+	net.Conn
+	data   []byte
+	reader *bytes.Reader
+}
+
+func (c *repeatConn) Read(b []byte) (int, error) {
+	if c.reader.Len() == 0 {
+		c.reader.Reset(c.data)
+	}
+	return c.reader.Read(b)
+}
+
+func benchmarkConnectionReadResponse(b *testing.B, opts *ConnectOpts) {
+	docs := make([]interface{}, 100)
+	for i := range docs {
+		docs[i] = map[string]interface{}{"id": i, "name": fmt.Sprintf("document %d", i)}
+	}
+	respData, _ := json.Marshal(map[string]interface{}{"t": p.Response_SUCCESS_SEQUENCE, "r": docs})
+	data := append(respHeader(1, respData), respData...)
+
+	connection := newConnection(&repeatConn{data: data, reader: bytes.NewReader(data)}, "addr", opts)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		response, err := connection.readResponse()
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, cursor, err := connection.processResponse(context.Background(), Query{Token: 1}, response, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var doc struct {
+			ID   int    `rethinkdb:"id"`
+			Name string `rethinkdb:"name"`
+		}
+		for cursor.Next(&doc) {
+		}
+		if err := cursor.Err(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConnection_readResponse(b *testing.B) {
+	benchmarkConnectionReadResponse(b, &ConnectOpts{})
+}
+
+func BenchmarkConnection_readResponse_ReuseBuffers(b *testing.B) {
+	benchmarkConnectionReadResponse(b, &ConnectOpts{ReuseBuffers: true})
+}
+
+func (s *ConnectionSuite) TestNewResponse(c *test.C) {
+	type doc struct {
+		ID   int       `rethinkdb:"id"`
+		Skip string    `rethinkdb:"-"`
+		At   time.Time `rethinkdb:"at"`
+	}
+
+	response, err := NewResponse(5, p.Response_SUCCESS_SEQUENCE, doc{ID: 1, Skip: "x", At: time.Unix(10, 0)}, 2)
+	c.Assert(err, test.IsNil)
+	c.Assert(response.Token, test.Equals, int64(5))
+	c.Assert(response.Type, test.Equals, p.Response_SUCCESS_SEQUENCE)
+	c.Assert(response.Responses, test.HasLen, 2)
+	c.Assert(string(response.Responses[0]), test.Equals, `{"at":{"$reql_type$":"TIME","epoch_time":10,"timezone":"+00:00"},"id":1}`)
+	c.Assert(string(response.Responses[1]), test.Equals, `2`)
+
+	// The response is read by a cursor like a response from the server
+	cursor := newCursor(context.Background(), nil, "Cursor", 5, nil, map[string]interface{}{})
+	cursor.extend(&response)
+	var row doc
+	c.Assert(cursor.Next(&row), test.Equals, true)
+	c.Assert(row.ID, test.Equals, 1)
+	c.Assert(row.At.Equal(time.Unix(10, 0)), test.Equals, true)
+	var n int
+	c.Assert(cursor.Next(&n), test.Equals, true)
+	c.Assert(n, test.Equals, 2)
+	c.Assert(cursor.Next(&n), test.Equals, false)
+	c.Assert(cursor.Err(), test.IsNil)
+
+	_, err = NewResponse(5, p.Response_SUCCESS_ATOM, func() {})
+	c.Assert(err, test.NotNil)
+}
@@ -2,10 +2,19 @@ package rethinkdb
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/csv"
 	"errors"
+	"fmt"
 	"github.com/segmentio/encoding/json"
+	"io"
 	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 	"golang.org/x/net/context"
@@ -38,11 +47,29 @@ func newCursor(ctx context.Context, conn *Connection, cursorType string, token i
 		buffer:     make([]interface{}, 0),
 		responses:  make([]json.RawMessage, 0),
 		ctx:        ctx,
+		closing:    make(chan struct{}),
+
+		useJSONNumber: connOpts.UseJSONNumber,
+	}
+	if connOpts.DebugTrackCursors {
+		cursor.createdAt = debug.Stack()
+		runtime.SetFinalizer(cursor, reportLeakedCursor)
 	}
 
 	return cursor
 }
 
+// reportLeakedCursor logs a warning if c was not closed, it is set as the
+// finalizer of cursors when ConnectOpts.DebugTrackCursors is set.
+func reportLeakedCursor(c *Cursor) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.closed {
+		Log.Warnf("Cursor garbage collected without being closed, created at:\n%s", c.createdAt)
+	}
+}
+
 // Cursor is the result of a query. Its cursor starts before the first row
 // of the result set. A Cursor is not thread safe and should only be accessed
 // by a single goroutine at any given time. Use Next to advance through the
@@ -77,12 +104,47 @@ type Cursor struct {
 	isAtom        bool
 	isSingleValue bool
 	pendingSkips  int
+	stale         bool
+	servedBy      ServerResponse
+	lastRow       interface{}
+	hasLastRow    bool
 	buffer        []interface{}
 	responses     []json.RawMessage
 	profile       interface{}
+
+	// lastRawRow holds the JSON of the last row instead of lastRow when it was
+	// read or skipped without being decoded, see ResumeToken
+	lastRawRow json.RawMessage
+	// closedExplicitly is true if Close was called before the end of the
+	// results, reading from the cursor then fails with ErrCursorClosed
+	closedExplicitly bool
+	// releases return the buffers the responses were read into once all of
+	// the responses have been read, see ConnectOpts.ReuseBuffers
+	releases []func()
+	// resolveType picks the type each row is decoded into by Next, see
+	// RunOpts.ResolveType
+	resolveType func(raw json.RawMessage) (interface{}, error)
+	// useJSONNumber is ConnectOpts.UseJSONNumber unless overridden by
+	// RunOpts.UseJSONNumber
+	useJSONNumber bool
+	// rate is the number of rows per second set by SetRate, rateRows is the
+	// number of rows fetched since rateStart
+	rate      int
+	rateStart time.Time
+	rateRows  int
+	// closing is closed when the cursor is closed to interrupt waiting for
+	// the rate set by SetRate
+	closing chan struct{}
+	// cancel releases the context created for Term.WithTimeout, it is
+	// called when the cursor is closed
+	cancel func()
+	// createdAt is the stack trace recorded when the cursor was created, see
+	// ConnectOpts.DebugTrackCursors
+	createdAt []byte
 }
 
-// Profile returns the information returned from the query profiler.
+// Profile returns the information returned from the query profiler, this is
+// only set when the query was run with RunOpts.Profile set to true.
 func (c *Cursor) Profile() interface{} {
 	if c == nil {
 		return nil
@@ -94,6 +156,89 @@ func (c *Cursor) Profile() interface{} {
 	return c.profile
 }
 
+// Profile is the output of the query profiler, returned by Cursor.ProfileInfo
+// when the query was run with RunOpts.Profile set to true.
+type Profile struct {
+	// Tasks are the tasks performed by the server to run the query.
+	Tasks []ProfileTask
+}
+
+// ProfileTask is a task performed by the server while running a query. Most
+// tasks evaluate a term of the query and are described as "Evaluating " and
+// the name of the term, for example "Evaluating table." or "Evaluating
+// filter.", the tasks performed to evaluate the arguments of the term are its
+// SubTasks. Other tasks describe the work done by the server, for example
+// "Perform read.", "Perform read on shard." or "Do range scan on primary
+// index.".
+//
+// Tasks run in parallel, such as reads on several shards, are grouped into a
+// task without a description or duration, ParallelTasks then holds the tasks
+// run in each group.
+type ProfileTask struct {
+	Description   string
+	Duration      time.Duration
+	SubTasks      []ProfileTask
+	ParallelTasks [][]ProfileTask
+}
+
+// ProfileInfo decodes the information returned from the query profiler, see
+// Profile. It returns nil if the query was not run with RunOpts.Profile set
+// to true.
+func (c *Cursor) ProfileInfo() (*Profile, error) {
+	raw := c.Profile()
+	if raw == nil {
+		return nil, nil
+	}
+
+	tasks, err := decodeProfileTasks(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Profile{Tasks: tasks}, nil
+}
+
+func decodeProfileTasks(v interface{}) ([]ProfileTask, error) {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, RQLDriverError{rqlError(fmt.Sprintf("Unexpected profile tasks: %v", v))}
+	}
+
+	tasks := make([]ProfileTask, len(items))
+	for i, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, RQLDriverError{rqlError(fmt.Sprintf("Unexpected profile task: %v", item))}
+		}
+
+		var err error
+		task := &tasks[i]
+		task.Description, _ = m["description"].(string)
+		if ms, ok := m["duration(ms)"].(float64); ok {
+			task.Duration = time.Duration(ms * float64(time.Millisecond))
+		}
+		if subTasks, ok := m["sub_tasks"]; ok {
+			if task.SubTasks, err = decodeProfileTasks(subTasks); err != nil {
+				return nil, err
+			}
+		}
+		if parallel, ok := m["parallel_tasks"]; ok {
+			groups, ok := parallel.([]interface{})
+			if !ok {
+				return nil, RQLDriverError{rqlError(fmt.Sprintf("Unexpected profile parallel tasks: %v", parallel))}
+			}
+			task.ParallelTasks = make([][]ProfileTask, len(groups))
+			for j, group := range groups {
+				if task.ParallelTasks[j], err = decodeProfileTasks(group); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return tasks, nil
+}
+
 // Type returns the cursor type (by default "Cursor")
 func (c *Cursor) Type() string {
 	if c == nil {
@@ -106,6 +251,33 @@ func (c *Cursor) Type() string {
 	return c.cursorType
 }
 
+// IsStale returns true if the query was run using the outdated read mode after
+// failing with the read mode it was run with, see RunOpts.StaleFallbackAfter.
+func (c *Cursor) IsStale() bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.stale
+}
+
+// ServedBy returns the ID and name of the server which ran the query, see
+// RunOpts.PreferReplica. Both are empty if the server isn't known, such as for
+// cursors returned by Mock.
+func (c *Cursor) ServedBy() ServerResponse {
+	if c == nil {
+		return ServerResponse{}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.servedBy
+}
+
 // Err returns nil if no errors happened during iteration, or the actual
 // error otherwise.
 func (c *Cursor) Err() error {
@@ -119,15 +291,99 @@ func (c *Cursor) Err() error {
 	return c.lastErr
 }
 
+// ResumeToken returns a token recording the position of the cursor after the
+// last row read using Next, NextN, NextPath, RawNext, NextResponse or Skip.
+// Term.ResumeFrom uses the token to
+// restart the query from that position, see it for the constraints on the
+// query. The token is JSON and holds the key of the last row, it should be
+// treated as opaque.
+func (c *Cursor) ResumeToken() ([]byte, error) {
+	if c == nil {
+		return nil, errNilCursor
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.term == nil {
+		return nil, RQLDriverError{rqlError("ResumeToken: cursor has no query")}
+	}
+	if !c.hasLastRow {
+		return nil, RQLDriverError{rqlError("ResumeToken: no rows have been read")}
+	}
+
+	row := c.lastRow
+	if c.lastRawRow != nil {
+		var err error
+		if row, err = c.decodeResponse(c.lastRawRow); err != nil {
+			return nil, err
+		}
+	}
+
+	return newResumeToken(*c.term, row)
+}
+
+// Offset returns the new_offset of the last row read from a changefeed run
+// with ChangesOpts.IncludeOffsets, rows are recorded as for ResumeToken. ok is
+// false if no rows have been read or the last row has no new_offset, for
+// example when the document left the results. The offset is an index into the
+// ordered results of the changefeed, it can't be used to resume the changefeed
+// after a restart, see ChangesOpts.IncludeOffsets.
+func (c *Cursor) Offset() (offset int, ok bool) {
+	if c == nil {
+		return 0, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.hasLastRow {
+		return 0, false
+	}
+
+	row := c.lastRow
+	if c.lastRawRow != nil {
+		var err error
+		if row, err = c.decodeResponse(c.lastRawRow); err != nil {
+			return 0, false
+		}
+	}
+
+	doc, isObject := row.(map[string]interface{})
+	if !isObject {
+		return 0, false
+	}
+	switch v := doc["new_offset"].(type) {
+	case float64:
+		return int(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return int(n), err == nil
+	default:
+		return 0, false
+	}
+}
+
 // Close closes the cursor, preventing further enumeration. If the end is
 // encountered, the cursor is connClosed automatically. Close is idempotent.
+//
+// Close may be called while another goroutine is iterating over the cursor,
+// the iteration then stops and Err returns ErrCursorClosed. Closing the
+// cursor after the end of the results has been reached has no effect.
 func (c *Cursor) Close() error {
+	return c.close(true)
+}
+
+// close closes the cursor, explicit is true if Close was called and false if
+// the cursor is closed at the end of the results or after an error.
+func (c *Cursor) close(explicit bool) error {
 	if c == nil {
 		return errNilCursor
 	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	defer c.cancelLocked()
 
 	var err error
 
@@ -163,9 +419,14 @@ func (c *Cursor) Close() error {
 	}
 
 	c.closed = true
+	c.closedExplicitly = explicit
+	if c.closing != nil {
+		close(c.closing)
+	}
 	c.conn = nil
 	c.buffer = nil
 	c.responses = nil
+	c.releaseBuffersLocked()
 
 	return err
 }
@@ -189,25 +450,87 @@ func (c *Cursor) Next(dest interface{}) bool {
 
 	c.mu.Lock()
 	if c.closed {
+		c.handleErrorLocked(c.closedErrLocked())
 		c.mu.Unlock()
 		return false
 	}
 
-	hasMore, err := c.nextLocked(dest, true)
+	var hasMore bool
+	var err error
+	if c.resolveType != nil {
+		hasMore, err = c.nextResolvedLocked(dest)
+	} else {
+		hasMore, err = c.nextLocked(dest, true)
+	}
 	if c.handleErrorLocked(err) != nil {
 		c.mu.Unlock()
-		c.Close()
+		c.close(false)
 		return false
 	}
 	c.mu.Unlock()
 
 	if !hasMore {
-		c.Close()
+		c.close(false)
 	}
 
 	return hasMore
 }
 
+// nextResolvedLocked reads the next row like nextLocked but decodes it into
+// the value returned by resolveType before storing it in dest.
+func (c *Cursor) nextResolvedLocked(dest interface{}) (bool, error) {
+	var row interface{}
+	hasMore, err := c.nextLocked(&row, true)
+	if err != nil || !hasMore {
+		return hasMore, err
+	}
+
+	raw, err := marshalRow(row)
+	if err != nil {
+		return false, err
+	}
+	v, err := c.resolveType(raw)
+	if err != nil {
+		return false, err
+	}
+	if v == nil {
+		return true, encoding.Decode(dest, row)
+	}
+
+	resolved := reflect.ValueOf(v)
+	if resolved.Kind() != reflect.Ptr || resolved.IsNil() {
+		return false, RQLDriverError{rqlError(fmt.Sprintf("ResolveType must return a non-nil pointer, got %T", v))}
+	}
+	if err := encoding.Decode(v, row); err != nil {
+		return false, err
+	}
+
+	destv := reflect.ValueOf(dest)
+	if destv.Kind() != reflect.Ptr || destv.IsNil() {
+		return false, RQLDriverError{rqlError(fmt.Sprintf("Next: dest must be a non-nil pointer, got %T", dest))}
+	}
+	destv = destv.Elem()
+	switch {
+	case resolved.Type().AssignableTo(destv.Type()):
+		destv.Set(resolved)
+	case resolved.Elem().Type().AssignableTo(destv.Type()):
+		destv.Set(resolved.Elem())
+	default:
+		return false, RQLDriverError{rqlError(fmt.Sprintf("ResolveType returned %T which can't be stored in %s", v, destv.Type()))}
+	}
+
+	return true, nil
+}
+
+// marshalRow encodes a decoded row back into JSON.
+func marshalRow(row interface{}) (json.RawMessage, error) {
+	encoded, err := encoding.Encode(row)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encoded)
+}
+
 func (c *Cursor) nextLocked(dest interface{}, progressCursor bool) (bool, error) {
 	for {
 		if err := c.seekCursor(true); err != nil {
@@ -215,7 +538,7 @@ func (c *Cursor) nextLocked(dest interface{}, progressCursor bool) (bool, error)
 		}
 
 		if c.closed {
-			return false, nil
+			return false, c.closedErrLocked()
 		}
 
 		if len(c.buffer) == 0 && c.finished {
@@ -226,6 +549,7 @@ func (c *Cursor) nextLocked(dest interface{}, progressCursor bool) (bool, error)
 			data := c.buffer[0]
 			if progressCursor {
 				c.buffer = c.buffer[1:]
+				c.setLastRowLocked(data, nil)
 			}
 			err := encoding.Decode(dest, data)
 			if err != nil {
@@ -237,6 +561,224 @@ func (c *Cursor) nextLocked(dest interface{}, progressCursor bool) (bool, error)
 	}
 }
 
+// NextPath retrieves the next document from the result set like Next but only
+// decodes the value at path, a dotted list of object keys or array indexes
+// such as "author.name" or "tags.0", into dest. The rest of the document is
+// not decoded, making NextPath cheaper than Next when only a small part of
+// large documents is needed. If the path does not exist in the document dest
+// is decoded from null.
+//
+//	var name string
+//	for cursor.NextPath("author.name", &name) {
+//	    ...
+//	}
+//
+// NextPath returns false at the end of the result set or if an error happened,
+// use Err to tell these apart.
+func (c *Cursor) NextPath(path string, dest interface{}) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.handleErrorLocked(c.closedErrLocked())
+		c.mu.Unlock()
+		return false
+	}
+
+	hasMore, err := c.nextPathLocked(strings.Split(path, "."), dest)
+	if c.handleErrorLocked(err) != nil {
+		c.mu.Unlock()
+		c.close(false)
+		return false
+	}
+	c.mu.Unlock()
+
+	if !hasMore {
+		c.close(false)
+	}
+
+	return hasMore
+}
+
+func (c *Cursor) nextPathLocked(path []string, dest interface{}) (bool, error) {
+	// Atom responses hold all of the documents in a single response and must be
+	// decoded, as must documents already in the buffer
+	if c.isAtom || len(c.buffer) > 0 {
+		var doc interface{}
+		hasMore, err := c.nextLocked(&doc, true)
+		if err != nil || !hasMore {
+			return hasMore, err
+		}
+		return true, encoding.Decode(dest, valueAtPath(doc, path))
+	}
+
+	for {
+		if err := c.seekCursor(false); err != nil {
+			return false, err
+		}
+
+		if len(c.buffer) > 0 || c.isAtom {
+			return c.nextPathLocked(path, dest)
+		}
+		if len(c.responses) == 0 && c.finished {
+			return false, nil
+		}
+
+		if len(c.responses) > 0 {
+			var response json.RawMessage
+			response, c.responses = c.responses[0], c.responses[1:]
+
+			c.setLastRowLocked(nil, response)
+
+			raw, err := rawValueAtPath(response, path)
+			if err != nil {
+				return false, err
+			}
+
+			var value interface{}
+			if raw != nil {
+				if value, err = c.decodeResponse(raw); err != nil {
+					return false, err
+				}
+			}
+
+			return true, encoding.Decode(dest, value)
+		}
+	}
+}
+
+// pathIndex returns the array index in a path segment
+func pathIndex(segment string) (int, bool) {
+	i, err := strconv.Atoi(segment)
+	return i, err == nil && i >= 0
+}
+
+// rawValueAtPath returns the raw JSON value at path in the JSON document raw,
+// or nil if the path does not exist.
+func rawValueAtPath(raw []byte, path []string) ([]byte, error) {
+	for _, segment := range path {
+		raw = bytes.TrimLeft(raw, " \t\r\n")
+		if len(raw) == 0 {
+			return nil, nil
+		}
+
+		switch raw[0] {
+		case '{':
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &obj); err != nil {
+				return nil, err
+			}
+			value, ok := obj[segment]
+			if !ok {
+				return nil, nil
+			}
+			raw = value
+		case '[':
+			i, ok := pathIndex(segment)
+			if !ok {
+				return nil, nil
+			}
+			var arr []json.RawMessage
+			if err := json.Unmarshal(raw, &arr); err != nil {
+				return nil, err
+			}
+			if i >= len(arr) {
+				return nil, nil
+			}
+			raw = arr[i]
+		default:
+			return nil, nil
+		}
+	}
+
+	return raw, nil
+}
+
+// valueAtPath returns the value at path in the decoded document doc, or nil if
+// the path does not exist.
+func valueAtPath(doc interface{}, path []string) interface{} {
+	for _, segment := range path {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			doc = v[segment]
+		case []interface{}:
+			i, ok := pathIndex(segment)
+			if !ok || i >= len(v) {
+				return nil
+			}
+			doc = v[i]
+		default:
+			return nil
+		}
+	}
+
+	return doc
+}
+
+// NextN retrieves up to n documents from the result set and decodes them into
+// the first n elements of dest, which must be a slice (or a pointer to a slice)
+// with a length of at least n. The number of documents read is returned, this
+// is less than n at the end of the result set or if an error happened.
+//
+// NextN is useful when reading large result sets as the cursor is only locked
+// once per batch, while the memory used stays bounded by n unlike All.
+//
+//	rows := make([]Row, 100)
+//	for {
+//	    n, err := cursor.NextN(rows, len(rows))
+//	    process(rows[:n])
+//	    if err != nil || n < len(rows) {
+//	        break
+//	    }
+//	}
+func (c *Cursor) NextN(dest interface{}, n int) (int, error) {
+	if c == nil {
+		return 0, errNilCursor
+	}
+
+	slicev := reflect.ValueOf(dest)
+	if slicev.Kind() == reflect.Ptr {
+		slicev = slicev.Elem()
+	}
+	if slicev.Kind() != reflect.Slice {
+		panic("dest argument must be a slice or slice address")
+	}
+	if n > slicev.Len() {
+		n = slicev.Len()
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		err := c.handleErrorLocked(c.closedErrLocked())
+		c.mu.Unlock()
+		return 0, err
+	}
+
+	i := 0
+	hasMore := true
+	var err error
+	for ; i < n; i++ {
+		hasMore, err = c.nextLocked(slicev.Index(i).Addr().Interface(), true)
+		if err != nil || !hasMore {
+			break
+		}
+	}
+	if err = c.handleErrorLocked(err); err != nil {
+		c.mu.Unlock()
+		c.close(false)
+		return i, err
+	}
+	c.mu.Unlock()
+
+	if !hasMore {
+		c.close(false)
+	}
+
+	return i, nil
+}
+
 // Peek behaves similarly to Next, retreiving the next document from the result set
 // and blocking if necessary. Peek, however, does not progress the position of the cursor.
 // This can be useful for expressions which can return different types to attempt to
@@ -262,19 +804,20 @@ func (c *Cursor) Peek(dest interface{}) (bool, error) {
 
 	c.mu.Lock()
 	if c.closed {
+		err := c.closedErrLocked()
 		c.mu.Unlock()
-		return false, nil
+		return false, err
 	}
 
 	hasMore, err := c.nextLocked(dest, false)
-	if _, isDecodeErr := err.(*encoding.DecodeTypeError); isDecodeErr {
+	if isDecodeError(err) {
 		c.mu.Unlock()
 		return false, err
 	}
 
 	if c.handleErrorLocked(err) != nil {
 		c.mu.Unlock()
-		c.Close()
+		c.close(false)
 		return false, err
 	}
 	c.mu.Unlock()
@@ -294,6 +837,163 @@ func (c *Cursor) Skip() {
 	c.pendingSkips++
 }
 
+// Drain discards the rows which have not been read yet and closes the cursor,
+// returning the number of rows discarded, or the number of rows discarded
+// before an error happened. The rows are counted without being
+// decoded, remaining batches are fetched from the server so that the query is
+// run to completion. Feeds never finish so only the rows which have already
+// been received are counted before the feed is stopped.
+func (c *Cursor) Drain() (int, error) {
+	if c == nil {
+		return 0, errNilCursor
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		err := c.closedErrLocked()
+		c.mu.Unlock()
+		return 0, err
+	}
+
+	n, err := c.drainLocked()
+	finished := c.finished
+	c.handleErrorLocked(err)
+	c.mu.Unlock()
+
+	if closeErr := c.close(!finished); err == nil {
+		err = closeErr
+	}
+
+	return n, err
+}
+
+func (c *Cursor) drainLocked() (int, error) {
+	n := 0
+	for {
+		if c.lastErr != nil {
+			return n, c.lastErr
+		}
+
+		n += len(c.buffer)
+		c.buffer = c.buffer[:0]
+		for _, response := range c.responses {
+			count, err := c.countRows(response)
+			if err != nil {
+				return n, err
+			}
+			n += count
+		}
+		c.responses = c.responses[:0]
+		c.releaseBuffersLocked()
+
+		if c.finished || c.closed || c.cursorType != "Cursor" {
+			break
+		}
+		if err := c.fetchMore(); err != nil {
+			return n, err
+		}
+	}
+
+	if c.pendingSkips >= n {
+		n = 0
+	} else {
+		n -= c.pendingSkips
+	}
+	c.pendingSkips = 0
+
+	return n, nil
+}
+
+// countRows returns the number of rows in response, which is one unless the
+// response is an atom holding an array of rows.
+func (c *Cursor) countRows(response json.RawMessage) (int, error) {
+	response = bytes.TrimSpace(response)
+	if !c.isAtom || len(response) == 0 || response[0] != '[' {
+		return 1, nil
+	}
+
+	var rows []json.RawMessage
+	if _, err := json.Parse(response, &rows, json.DontCopyRawMessage); err != nil {
+		return 0, err
+	}
+	return len(rows), nil
+}
+
+// ToCSV writes the remaining documents of the result set to w as CSV and closes
+// the cursor. The first row holds the column names, each following row holds
+// the values of the columns in a document. Columns may be dotted paths such as
+// "author.name" or "tags.0" in the same way as NextPath, missing values are
+// written as empty fields and objects or arrays are written as JSON. Batches
+// are fetched from the server as rows are written so the result set does not
+// need to fit in memory.
+//
+//	err := cursor.ToCSV(os.Stdout, []string{"id", "author.name"})
+func (c *Cursor) ToCSV(w io.Writer, columns []string) error {
+	if c == nil {
+		return errNilCursor
+	}
+
+	paths := make([][]string, len(columns))
+	for i, column := range columns {
+		paths[i] = strings.Split(column, ".")
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		_ = c.close(false)
+		return err
+	}
+
+	var doc interface{}
+	record := make([]string, len(columns))
+	for c.Next(&doc) {
+		for i, path := range paths {
+			field, err := csvField(valueAtPath(doc, path))
+			if err != nil {
+				_ = c.close(false)
+				return err
+			}
+			record[i] = field
+		}
+		if err := cw.Write(record); err != nil {
+			_ = c.close(false)
+			return err
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	return c.Err()
+}
+
+// csvField formats a decoded value as a CSV field.
+func csvField(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(v)
+		return string(b), err
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
 // NextResponse retrieves the next raw response from the result set, blocking if necessary.
 // Unlike Next the returned response is the raw JSON document returned from the
 // database.
@@ -307,6 +1007,7 @@ func (c *Cursor) NextResponse() ([]byte, bool) {
 
 	c.mu.Lock()
 	if c.closed {
+		c.handleErrorLocked(c.closedErrLocked())
 		c.mu.Unlock()
 		return nil, false
 	}
@@ -314,13 +1015,13 @@ func (c *Cursor) NextResponse() ([]byte, bool) {
 	b, hasMore, err := c.nextResponseLocked()
 	if c.handleErrorLocked(err) != nil {
 		c.mu.Unlock()
-		c.Close()
+		c.close(false)
 		return nil, false
 	}
 	c.mu.Unlock()
 
 	if !hasMore {
-		c.Close()
+		c.close(false)
 	}
 
 	return b, hasMore
@@ -339,12 +1040,79 @@ func (c *Cursor) nextResponseLocked() ([]byte, bool, error) {
 		if len(c.responses) > 0 {
 			var response json.RawMessage
 			response, c.responses = c.responses[0], c.responses[1:]
+			if c.connOpts.ReuseBuffers {
+				response = append(json.RawMessage(nil), response...)
+			}
+			if !c.isAtom {
+				c.setLastRowLocked(nil, response)
+			}
 
 			return []byte(response), true, nil
 		}
 	}
 }
 
+// RawNext retrieves the raw JSON of the next document from the result set,
+// blocking if necessary, which allows documents to be decoded by a custom
+// decoder or forwarded without being decoded. Unlike NextResponse the
+// documents of an atom response, such as an array, are returned one by one
+// like Next does. These documents, and documents already read by Peek, have
+// to be decoded and are encoded again, the other documents are returned as
+// they were received.
+//
+// RawNext returns false at the end of the result set or if an error happened,
+// use Err to tell these apart.
+func (c *Cursor) RawNext() (json.RawMessage, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.handleErrorLocked(c.closedErrLocked())
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	b, hasMore, err := c.rawNextLocked()
+	if c.handleErrorLocked(err) != nil {
+		c.mu.Unlock()
+		c.close(false)
+		return nil, false
+	}
+	c.mu.Unlock()
+
+	if !hasMore {
+		c.close(false)
+	}
+
+	return b, hasMore
+}
+
+func (c *Cursor) rawNextLocked() (json.RawMessage, bool, error) {
+	if err := c.seekCursor(false); err != nil {
+		return nil, false, err
+	}
+
+	// Atom responses hold all of the documents in a single response and must be
+	// decoded, as must documents already in the buffer
+	if !c.isAtom && len(c.buffer) == 0 {
+		return c.nextResponseLocked()
+	}
+
+	var doc interface{}
+	hasMore, err := c.nextLocked(&doc, true)
+	if err != nil || !hasMore {
+		return nil, hasMore, err
+	}
+	b, err := marshalRow(doc)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return b, true, nil
+}
+
 // All retrieves all documents from the result set into the provided slice
 // and closes the cursor.
 //
@@ -355,6 +1123,10 @@ func (c *Cursor) nextResponseLocked() ([]byte, bool, error) {
 // `All` zeroes the value before scanning in the result. It also attempts
 // to reuse the existing slice without allocating any more space by either
 // resizing or returning a selection of the slice if necessary.
+//
+// If a document can't be decoded the error is an *encoding.DecodeError holding
+// the index of the document in the result set and the path of the field which
+// could not be decoded.
 func (c *Cursor) All(result interface{}) error {
 	if c == nil {
 		return errNilCursor
@@ -386,17 +1158,39 @@ func (c *Cursor) All(result interface{}) error {
 	resultv.Elem().Set(slicev.Slice(0, i))
 
 	if err := c.Err(); err != nil {
-		_ = c.Close()
-		return err
+		_ = c.close(false)
+		return decodeErrorAt(err, i)
 	}
 
-	if err := c.Close(); err != nil {
+	if err := c.close(false); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// isDecodeError returns true if err was returned because a document could not
+// be decoded into the destination.
+func isDecodeError(err error) bool {
+	switch err.(type) {
+	case *encoding.DecodeError, *encoding.DecodeTypeError:
+		return true
+	}
+	return false
+}
+
+// decodeErrorAt returns err as an encoding.DecodeError with the index i of the
+// document which could not be decoded, other errors are returned unchanged.
+func decodeErrorAt(err error, i int) error {
+	switch e := err.(type) {
+	case *encoding.DecodeError:
+		return &encoding.DecodeError{DocIndex: i, FieldPath: e.FieldPath, Cause: e.Cause}
+	case *encoding.DecodeTypeError:
+		return &encoding.DecodeError{DocIndex: i, Cause: e}
+	}
+	return err
+}
+
 // One retrieves a single document from the result set into the provided
 // slice and closes the cursor.
 //
@@ -407,19 +1201,27 @@ func (c *Cursor) One(result interface{}) error {
 		return errNilCursor
 	}
 
+	c.mu.Lock()
+	closedErr := c.closedErrLocked()
+	c.handleErrorLocked(closedErr)
+	c.mu.Unlock()
+	if closedErr != nil {
+		return closedErr
+	}
+
 	if c.IsNil() {
-		c.Close()
+		c.close(false)
 		return ErrEmptyResult
 	}
 
 	hasResult := c.Next(result)
 
 	if err := c.Err(); err != nil {
-		c.Close()
+		c.close(false)
 		return err
 	}
 
-	if err := c.Close(); err != nil {
+	if err := c.close(false); err != nil {
 		return err
 	}
 
@@ -496,7 +1298,7 @@ func (c *Cursor) Listen(channel interface{}) {
 			channelv.Send(elemp.Elem())
 		}
 
-		c.Close()
+		c.close(false)
 		channelv.Close()
 	}()
 }
@@ -549,14 +1351,86 @@ func (c *Cursor) fetchMore() error {
 			Token: c.token,
 		}
 
+		// The lock is released while waiting and querying so the connection
+		// and context are read before, Close may reset them in the meantime
+		conn, ctx := c.conn, c.ctx
+		wait := c.rateWaitLocked()
 		c.mu.Unlock()
-		_, _, err = c.conn.Query(c.ctx, q)
+		if err = c.waitFor(ctx, wait); err == nil {
+			_, _, err = conn.Query(ctx, q)
+		}
 		c.mu.Lock()
+
+		if closedErr := c.closedErrLocked(); closedErr != nil {
+			c.fetching = false
+			return closedErr
+		}
+		if err != nil {
+			c.fetching = false
+			c.handleErrorLocked(err)
+		}
 	}
 
 	return err
 }
 
+// SetRate limits the rate at which rows are fetched from the server to about
+// rowsPerSecond, for example to avoid overloading the destination of a bulk
+// copy. The rows are still fetched in batches, the request for the next batch
+// is delayed until the rows fetched since SetRate was called would have taken
+// that long at the given rate, so Next returns the rows of a batch straight
+// away then blocks before the next batch. A rate of 0 or less removes the
+// limit.
+//
+// Waiting is interrupted when the context the query was run with is done,
+// Next then returns false and Err returns ErrQueryTimeout, or when the cursor
+// is closed, Err then returns ErrCursorClosed.
+func (c *Cursor) SetRate(rowsPerSecond int) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rate = rowsPerSecond
+	c.rateStart = time.Now()
+	c.rateRows = 0
+}
+
+// rateWaitLocked returns how long to wait before fetching the next batch to
+// keep to the rate set by SetRate.
+func (c *Cursor) rateWaitLocked() time.Duration {
+	if c.rate <= 0 {
+		return 0
+	}
+
+	due := c.rateStart.Add(time.Duration(c.rateRows) * time.Second / time.Duration(c.rate))
+	return time.Until(due)
+}
+
+// waitFor waits for d or until ctx is done or the cursor is closed.
+func (c *Cursor) waitFor(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ErrQueryTimeout
+	case <-c.closing:
+		return errCursorClosed
+	}
+}
+
 // handleError sets the value of lastErr to err if lastErr is not yet set.
 func (c *Cursor) handleError(err error) error {
 	c.mu.Lock()
@@ -565,6 +1439,23 @@ func (c *Cursor) handleError(err error) error {
 	return c.handleErrorLocked(err)
 }
 
+// cancelLocked releases the context created for Term.WithTimeout, if any.
+func (c *Cursor) cancelLocked() {
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+}
+
+// closedErrLocked returns ErrCursorClosed if the cursor was closed by calling
+// Close, otherwise nil.
+func (c *Cursor) closedErrLocked() error {
+	if c.closedExplicitly {
+		return ErrCursorClosed
+	}
+	return nil
+}
+
 func (c *Cursor) handleErrorLocked(err error) error {
 	if c.lastErr == nil {
 		c.lastErr = err
@@ -582,12 +1473,29 @@ func (c *Cursor) extend(response *Response) {
 }
 
 func (c *Cursor) extendLocked(response *Response) {
+	if response.release != nil {
+		c.releases = append(c.releases, response.release)
+		response.release = nil
+	}
 	c.responses = append(c.responses, response.Responses...)
+	c.rateRows += len(response.Responses)
 	c.finished = response.Type != p.Response_SUCCESS_PARTIAL
 	c.fetching = false
 	c.isAtom = response.Type == p.Response_SUCCESS_ATOM
 }
 
+// releaseBuffersLocked releases the buffers the responses were read into if
+// all of the responses have been read.
+func (c *Cursor) releaseBuffersLocked() {
+	if len(c.responses) > 0 {
+		return
+	}
+	for _, release := range c.releases {
+		release()
+	}
+	c.releases = nil
+}
+
 // seekCursor takes care of loading more data if needed and applying pending skips
 //
 // bufferResponse determines whether the response will be parsed into the buffer
@@ -597,6 +1505,9 @@ func (c *Cursor) seekCursor(bufferResponse bool) error {
 	}
 
 	if len(c.buffer) == 0 && len(c.responses) == 0 && c.closed {
+		if err := c.closedErrLocked(); err != nil {
+			return err
+		}
 		return errCursorClosed
 	}
 
@@ -613,11 +1524,12 @@ func (c *Cursor) seekCursor(bufferResponse bool) error {
 			continue // go around the loop again to re-apply pending skips
 		} else if len(c.buffer) == 0 && len(c.responses) == 0 && !c.finished {
 			//  We skipped all of our data, load some more
+			c.releaseBuffersLocked()
 			if err := c.fetchMore(); err != nil {
 				return err
 			}
 			if c.closed {
-				return nil
+				return c.closedErrLocked()
 			}
 			continue // go around the loop again to re-apply pending skips
 		}
@@ -636,6 +1548,13 @@ func (c *Cursor) applyPendingSkips(drainFromBuffer bool) (stillPending bool) {
 	}
 
 	if drainFromBuffer {
+		if len(c.buffer) > 0 {
+			skipped := c.pendingSkips
+			if skipped > len(c.buffer) {
+				skipped = len(c.buffer)
+			}
+			c.setLastRowLocked(c.buffer[skipped-1], nil)
+		}
 		if len(c.buffer) > c.pendingSkips {
 			c.buffer = c.buffer[c.pendingSkips:]
 			c.pendingSkips = 0
@@ -647,6 +1566,13 @@ func (c *Cursor) applyPendingSkips(drainFromBuffer bool) (stillPending bool) {
 		return c.pendingSkips > 0
 	}
 
+	if len(c.responses) > 0 && !c.isAtom {
+		skipped := c.pendingSkips
+		if skipped > len(c.responses) {
+			skipped = len(c.responses)
+		}
+		c.setLastRowLocked(nil, c.responses[skipped-1])
+	}
 	if len(c.responses) > c.pendingSkips {
 		c.responses = c.responses[c.pendingSkips:]
 		c.pendingSkips = 0
@@ -658,6 +1584,30 @@ func (c *Cursor) applyPendingSkips(drainFromBuffer bool) (stillPending bool) {
 	return c.pendingSkips > 0
 }
 
+// setLastRowLocked records the last row read or skipped for ResumeToken, raw
+// is the JSON of the row if it was not decoded. raw is copied if the response
+// buffers are reused.
+func (c *Cursor) setLastRowLocked(row interface{}, raw json.RawMessage) {
+	if raw != nil && c.connOpts.ReuseBuffers {
+		raw = append(json.RawMessage(nil), raw...)
+	}
+	c.lastRow, c.lastRawRow, c.hasLastRow = row, raw, true
+}
+
+// decodeResponse decodes the JSON of a response and converts its pseudo-types.
+func (c *Cursor) decodeResponse(response json.RawMessage) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewBuffer(response))
+	if c.useJSONNumber {
+		decoder.UseNumber()
+	}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return recursivelyConvertPseudotype(value, c.opts)
+}
+
 // bufferResponse reads a single response and stores the result into the buffer
 // if the response is from an atomic response, it will check if the
 // response contains multiple records and store them all into the buffer
@@ -673,17 +1623,7 @@ func (c *Cursor) bufferNextResponse() error {
 	response := c.responses[0]
 	c.responses = c.responses[1:]
 
-	var value interface{}
-	decoder := json.NewDecoder(bytes.NewBuffer(response))
-	if c.connOpts.UseJSONNumber {
-		decoder.UseNumber()
-	}
-	err := decoder.Decode(&value)
-	if err != nil {
-		return err
-	}
-
-	value, err = recursivelyConvertPseudotype(value, c.opts)
+	value, err := c.decodeResponse(response)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,48 @@
+//go:build go1.18
+// +build go1.18
+
+package rethinkdb
+
+// ListenTyped is a typed version of Cursor.Listen, it decodes each document
+// into a value of type T and sends it on the returned values channel.
+//
+// The values channel is closed once the result set is exhausted, the cursor is
+// closed or a document could not be decoded, the cursor is always closed
+// afterwards. If iteration stopped because of an error it is sent on the
+// errors channel before it is closed. The values channel must be drained for
+// the cursor to be closed.
+//
+//	values, errs := r.ListenTyped[User](cursor)
+//	for user := range values {
+//	    fmt.Println(user.Name)
+//	}
+//	if err := <-errs; err != nil {
+//	    panic(err)
+//	}
+func ListenTyped[T any](c *Cursor) (<-chan T, <-chan error) {
+	values := make(chan T)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+
+		for {
+			var value T
+			if !c.Next(&value) {
+				break
+			}
+			values <- value
+		}
+		close(values)
+
+		err := c.Err()
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			errs <- err
+		}
+	}()
+
+	return values, errs
+}
@@ -0,0 +1,48 @@
+//go:build go1.18
+// +build go1.18
+
+package rethinkdb
+
+import (
+	test "gopkg.in/check.v1"
+)
+
+func (s *CursorSuite) TestListenTyped(c *test.C) {
+	type row struct {
+		ID   int    `rethinkdb:"id"`
+		Name string `rethinkdb:"name"`
+	}
+
+	mock := NewMock()
+	mock.On(DB("test").Table("test")).Return([]interface{}{
+		map[string]interface{}{"id": 1, "name": "a"},
+		map[string]interface{}{"id": 2, "name": "b"},
+	}, nil)
+	res, err := DB("test").Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+
+	values, errs := ListenTyped[row](res)
+	var rows []row
+	for value := range values {
+		rows = append(rows, value)
+	}
+	c.Assert(<-errs, test.IsNil)
+	c.Assert(rows, test.DeepEquals, []row{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}})
+	mock.AssertExpectations(c)
+}
+
+func (s *CursorSuite) TestListenTyped_DecodeErr(c *test.C) {
+	mock := NewMock()
+	mock.On(DB("test").Table("test")).Return([]interface{}{1, "a", 3}, nil)
+	res, err := DB("test").Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+
+	values, errs := ListenTyped[int](res)
+	var rows []int
+	for value := range values {
+		rows = append(rows, value)
+	}
+	c.Assert(<-errs, test.NotNil)
+	c.Assert(rows, test.DeepEquals, []int{1})
+	mock.AssertExpectations(c)
+}
@@ -1,8 +1,19 @@
 package rethinkdb
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"golang.org/x/net/context"
 	test "gopkg.in/check.v1"
 	"gopkg.in/rethinkdb/rethinkdb-go.v6/internal/integration/tests"
+	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 )
 
 type CursorSuite struct{}
@@ -32,3 +43,636 @@ func (s *CursorSuite) TestCursor_One_Ok(c *test.C) {
 	c.Assert(response, tests.JsonEquals, data)
 	mock.AssertExpectations(c)
 }
+
+func (s *CursorSuite) TestCursor_NextN(c *test.C) {
+	mock := NewMock()
+	mock.On(DB("test").Table("test")).Return([]interface{}{1, 2, 3, 4, 5}, nil)
+
+	res, err := DB("test").Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+
+	rows := make([]int, 2)
+	n, err := res.NextN(rows, len(rows))
+	c.Assert(err, test.IsNil)
+	c.Assert(n, test.Equals, 2)
+	c.Assert(rows, test.DeepEquals, []int{1, 2})
+
+	rows = make([]int, 10)
+	n, err = res.NextN(&rows, 5)
+	c.Assert(err, test.IsNil)
+	c.Assert(n, test.Equals, 3)
+	c.Assert(rows[:n], test.DeepEquals, []int{3, 4, 5})
+
+	n, err = res.NextN(rows, len(rows))
+	c.Assert(err, test.IsNil)
+	c.Assert(n, test.Equals, 0)
+	mock.AssertExpectations(c)
+}
+
+func (s *CursorSuite) TestCursor_All_UseJSONNumber(c *test.C) {
+	connection := newConnection(nil, "addr", &ConnectOpts{UseJSONNumber: true})
+	cursor := newCursor(context.Background(), connection, "Cursor", 1, nil, map[string]interface{}{})
+	cursor.extend(&Response{
+		Type:      p.Response_SUCCESS_ATOM,
+		Responses: []json.RawMessage{json.RawMessage(`[{"id":1152921504606846977,"nested":{"n":[1152921504606846977]}}]`)},
+	})
+
+	var rows []map[string]interface{}
+	c.Assert(cursor.All(&rows), test.IsNil)
+	c.Assert(rows, test.HasLen, 1)
+	c.Assert(rows[0]["id"], test.Equals, json.Number("1152921504606846977"))
+	c.Assert(rows[0]["nested"], test.DeepEquals, map[string]interface{}{"n": []interface{}{json.Number("1152921504606846977")}})
+
+	cursor = newCursor(context.Background(), connection, "Cursor", 1, nil, map[string]interface{}{})
+	cursor.extend(&Response{
+		Type:      p.Response_SUCCESS_ATOM,
+		Responses: []json.RawMessage{json.RawMessage(`[1152921504606846977]`)},
+	})
+
+	var values []interface{}
+	c.Assert(cursor.All(&values), test.IsNil)
+	c.Assert(values, test.DeepEquals, []interface{}{json.Number("1152921504606846977")})
+}
+
+func (s *CursorSuite) TestCursor_ResumeToken(c *test.C) {
+	query := DB("test").Table("test").OrderBy(OrderByOpts{Index: "id"}).Limit(2)
+
+	mock := NewMock()
+	mock.On(query).Return([]interface{}{
+		map[string]interface{}{"id": "a"},
+		map[string]interface{}{"id": "b"},
+	}, nil)
+	res, err := query.Run(mock)
+	c.Assert(err, test.IsNil)
+
+	_, err = res.ResumeToken()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: ResumeToken: no rows have been read")
+
+	var row map[string]interface{}
+	c.Assert(res.Next(&row), test.Equals, true)
+	c.Assert(res.Next(&row), test.Equals, true)
+	token, err := res.ResumeToken()
+	c.Assert(err, test.IsNil)
+	c.Assert(string(token), test.Equals, `{"index":"id","key":"b"}`)
+
+	resumed := query.ResumeFrom(token)
+	expected := DB("test").Table("test").Between("b", MaxVal, BetweenOpts{Index: "id", LeftBound: "open"}).OrderBy(OrderByOpts{Index: "id"}).Limit(2)
+	c.Assert(TermsEqual(resumed, expected), test.Equals, true, test.Commentf("%s", resumed))
+	mock.AssertExpectations(c)
+}
+
+func (s *CursorSuite) TestCursor_ResumeToken_Raw(c *test.C) {
+	query := DB("test").Table("test").OrderBy(OrderByOpts{Index: "id"})
+
+	mock := NewMock()
+	mock.On(query).Return([]interface{}{
+		map[string]interface{}{"id": "a"},
+		map[string]interface{}{"id": "b"},
+		map[string]interface{}{"id": "c"},
+	}, nil)
+	res, err := query.Run(mock)
+	c.Assert(err, test.IsNil)
+
+	raw, ok := res.RawNext()
+	c.Assert(ok, test.Equals, true)
+	c.Assert(string(raw), test.Equals, `{"id":"a"}`)
+	token, err := res.ResumeToken()
+	c.Assert(err, test.IsNil)
+	c.Assert(string(token), test.Equals, `{"index":"id","key":"a"}`)
+
+	// Rows skipped without being decoded are recorded too
+	res.Skip()
+	res.Skip()
+	_, ok = res.RawNext()
+	c.Assert(ok, test.Equals, false)
+	c.Assert(res.Err(), test.IsNil)
+	token, err = res.ResumeToken()
+	c.Assert(err, test.IsNil)
+	c.Assert(string(token), test.Equals, `{"index":"id","key":"c"}`)
+
+	// Rows read with NextPath are recorded too
+	res, err = query.Run(mock)
+	c.Assert(err, test.IsNil)
+	var id string
+	c.Assert(res.NextPath("id", &id), test.Equals, true)
+	c.Assert(id, test.Equals, "a")
+	token, err = res.ResumeToken()
+	c.Assert(err, test.IsNil)
+	c.Assert(string(token), test.Equals, `{"index":"id","key":"a"}`)
+}
+
+func (s *CursorSuite) TestCursor_Offset(c *test.C) {
+	query := DB("test").Table("test").OrderBy(OrderByOpts{Index: "id"}).Limit(2).Changes(ChangesOpts{IncludeOffsets: true})
+
+	mock := NewMock()
+	mock.On(query).Return([]interface{}{
+		map[string]interface{}{"new_val": map[string]interface{}{"id": "a"}, "new_offset": 0},
+		map[string]interface{}{"new_val": map[string]interface{}{"id": "b"}, "new_offset": 1, "old_offset": 0},
+		map[string]interface{}{"old_val": map[string]interface{}{"id": "a"}, "old_offset": 0},
+	}, nil)
+	res, err := query.Run(mock)
+	c.Assert(err, test.IsNil)
+
+	_, ok := res.Offset()
+	c.Assert(ok, test.Equals, false)
+
+	var change ChangeResponse
+	c.Assert(res.Next(&change), test.Equals, true)
+	offset, ok := res.Offset()
+	c.Assert(ok, test.Equals, true)
+	c.Assert(offset, test.Equals, 0)
+
+	// Rows read without being decoded are recorded too
+	_, ok = res.RawNext()
+	c.Assert(ok, test.Equals, true)
+	offset, ok = res.Offset()
+	c.Assert(ok, test.Equals, true)
+	c.Assert(offset, test.Equals, 1)
+
+	// A document leaving the results has no new_offset
+	c.Assert(res.Next(&change), test.Equals, true)
+	_, ok = res.Offset()
+	c.Assert(ok, test.Equals, false)
+}
+
+func (s *CursorSuite) TestCursor_ResumeToken_Desc(c *test.C) {
+	query := DB("test").Table("test").OrderBy(OrderByOpts{Index: Desc("n")}).Filter(Row.Field("x").Eq(1))
+
+	mock := NewMock()
+	mock.On(query).Return([]interface{}{
+		map[string]interface{}{"n": 3},
+		map[string]interface{}{"n": 2},
+	}, nil)
+	res, err := query.Run(mock)
+	c.Assert(err, test.IsNil)
+
+	var row map[string]interface{}
+	_, err = res.Peek(&row)
+	c.Assert(err, test.IsNil)
+	res.Skip()
+	c.Assert(res.Next(&row), test.Equals, true)
+	token, err := res.ResumeToken()
+	c.Assert(err, test.IsNil)
+	c.Assert(string(token), test.Equals, `{"index":"n","desc":true,"key":2}`)
+
+	resumed := query.ResumeFrom(token)
+	expected := DB("test").Table("test").Between(MinVal, 2.0, BetweenOpts{Index: "n", RightBound: "open"}).OrderBy(OrderByOpts{Index: Desc("n")}).Filter(Row.Field("x").Eq(1))
+	c.Assert(TermsEqual(resumed, expected), test.Equals, true, test.Commentf("%s", resumed))
+}
+
+func (s *CursorSuite) TestCursor_ResumeToken_Invalid(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("test").OrderBy("id")).Return([]interface{}{map[string]interface{}{"id": 1}}, nil)
+	res, err := Table("test").OrderBy("id").Run(mock)
+	c.Assert(err, test.IsNil)
+
+	var row map[string]interface{}
+	c.Assert(res.Next(&row), test.Equals, true)
+	_, err = res.ResumeToken()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: ResumeToken: query must be ordered using OrderBy with an index")
+
+	_, err = Table("test").OrderBy("id").ResumeFrom([]byte(`{"index":"id","key":1}`)).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: ResumeFrom: query must be ordered using OrderBy with an index")
+	_, err = Table("test").OrderBy(OrderByOpts{Index: "name"}).ResumeFrom([]byte(`{"index":"id","key":1}`)).Build()
+	c.Assert(err, test.ErrorMatches, `rethinkdb: ResumeFrom: token was created for the index "id", not "name"`)
+	_, err = Table("test").OrderBy(OrderByOpts{Index: "id"}).ResumeFrom([]byte(`nope`)).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: ResumeFrom: invalid token: .*")
+}
+
+func (s *CursorSuite) TestCursor_NextPath(c *test.C) {
+	connection := newConnection(nil, "addr", &ConnectOpts{})
+	cursor := newCursor(context.Background(), connection, "Cursor", 1, nil, map[string]interface{}{})
+	cursor.extend(&Response{
+		Type: p.Response_SUCCESS_SEQUENCE,
+		Responses: []json.RawMessage{
+			json.RawMessage(`{"id":1,"author":{"name":"alice","tags":["a","b"]},"body":"..."}`),
+			json.RawMessage(`{"id":2,"author":{"name":"bob","joined":{"$reql_type$":"TIME","epoch_time":0,"timezone":"+00:00"}}}`),
+			json.RawMessage(`{"id":3}`),
+		},
+	})
+
+	var name string
+	c.Assert(cursor.NextPath("author.name", &name), test.Equals, true)
+	c.Assert(name, test.Equals, "alice")
+
+	var joined time.Time
+	c.Assert(cursor.NextPath("author.joined", &joined), test.Equals, true)
+	c.Assert(joined.Unix(), test.Equals, int64(0))
+
+	var tag *string
+	c.Assert(cursor.NextPath("author.tags.1", &tag), test.Equals, true)
+	c.Assert(tag, test.IsNil)
+
+	c.Assert(cursor.NextPath("author.name", &name), test.Equals, false)
+	c.Assert(cursor.Err(), test.IsNil)
+}
+
+func (s *CursorSuite) TestCursor_NextPath_Atom(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("posts")).Return([]interface{}{
+		map[string]interface{}{"author": map[string]interface{}{"tags": []interface{}{"a", "b"}}},
+		map[string]interface{}{"author": "bob"},
+	}, nil)
+	res, err := Table("posts").Run(mock)
+	c.Assert(err, test.IsNil)
+
+	var tag string
+	c.Assert(res.NextPath("author.tags.1", &tag), test.Equals, true)
+	c.Assert(tag, test.Equals, "b")
+
+	var missing *string
+	c.Assert(res.NextPath("author.tags.1", &missing), test.Equals, true)
+	c.Assert(missing, test.IsNil)
+
+	c.Assert(res.NextPath("author", &tag), test.Equals, false)
+	c.Assert(res.Err(), test.IsNil)
+}
+
+func (s *CursorSuite) TestCursor_ClosedDuringIteration(c *test.C) {
+	changes := make(chan interface{})
+	mock := NewMock()
+	mock.On(Table("test").Changes()).ReturnChangefeed(changes)
+	res, err := Table("test").Changes().Run(mock)
+	c.Assert(err, test.IsNil)
+
+	go func() { changes <- 1 }()
+	var n int
+	c.Assert(res.Next(&n), test.Equals, true)
+	c.Assert(n, test.Equals, 1)
+
+	// Next blocks waiting for the next change until the cursor is closed
+	done := make(chan bool)
+	go func() {
+		var n int
+		done <- res.Next(&n)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	c.Assert(res.Close(), test.IsNil)
+
+	c.Assert(<-done, test.Equals, false)
+	c.Assert(res.Err(), test.Equals, ErrCursorClosed)
+	c.Assert(res.Next(&n), test.Equals, false)
+	c.Assert(res.One(&n), test.Equals, ErrCursorClosed)
+}
+
+func (s *CursorSuite) TestCursor_SetRate(c *test.C) {
+	changes := make(chan interface{}, 5)
+	for i := 1; i <= 5; i++ {
+		changes <- i
+	}
+	mock := NewMock()
+	mock.On(Table("test").Changes()).ReturnChangefeed(changes)
+	res, err := Table("test").Changes().Run(mock)
+	c.Assert(err, test.IsNil)
+
+	// Each change is fetched separately, the 5th is fetched 4 rows after the
+	// rate was set
+	res.SetRate(100)
+	start := time.Now()
+	var n int
+	for i := 1; i <= 5; i++ {
+		c.Assert(res.Next(&n), test.Equals, true)
+		c.Assert(n, test.Equals, i)
+	}
+	c.Assert(time.Since(start) >= 40*time.Millisecond, test.Equals, true)
+	c.Assert(res.Close(), test.IsNil)
+}
+
+func (s *CursorSuite) TestCursor_SetRate_ContextDone(c *test.C) {
+	changes := make(chan interface{}, 2)
+	changes <- 1
+	changes <- 2
+	mock := NewMock()
+	mock.On(Table("test").Changes()).ReturnChangefeed(changes)
+	ctx, cancel := context.WithCancel(context.Background())
+	res, err := Table("test").Changes().Run(mock, RunOpts{Context: ctx})
+	c.Assert(err, test.IsNil)
+
+	conn := res.conn.Conn.(*mockConn)
+
+	res.SetRate(1)
+	var n int
+	c.Assert(res.Next(&n), test.Equals, true)
+
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	c.Assert(res.Next(&n), test.Equals, false)
+	c.Assert(time.Since(start) < 500*time.Millisecond, test.Equals, true)
+	c.Assert(res.Err(), test.Equals, ErrQueryTimeout)
+
+	// The server is told to stop the feed
+	select {
+	case <-conn.stopped:
+	case <-time.After(time.Second):
+		c.Fatal("expected a STOP query to be sent")
+	}
+}
+
+func (s *CursorSuite) TestCursor_SetRate_Close(c *test.C) {
+	changes := make(chan interface{}, 2)
+	changes <- 1
+	changes <- 2
+	mock := NewMock()
+	mock.On(Table("test").Changes()).ReturnChangefeed(changes)
+	res, err := Table("test").Changes().Run(mock)
+	c.Assert(err, test.IsNil)
+
+	res.SetRate(1)
+	var n int
+	c.Assert(res.Next(&n), test.Equals, true)
+
+	// Closing the cursor interrupts waiting for the next batch
+	time.AfterFunc(20*time.Millisecond, func() { res.Close() })
+	start := time.Now()
+	c.Assert(res.Next(&n), test.Equals, false)
+	c.Assert(time.Since(start) < 500*time.Millisecond, test.Equals, true)
+	c.Assert(res.Err(), test.Equals, ErrCursorClosed)
+}
+
+func (s *CursorSuite) TestCursor_DebugTrackCursors(c *test.C) {
+	var logs bytes.Buffer
+	var mu sync.Mutex
+	Log.SetOutput(writerFunc(func(b []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return logs.Write(b)
+	}))
+	defer Log.SetOutput(ioutil.Discard)
+	connection := newConnection(nil, "addr", &ConnectOpts{DebugTrackCursors: true})
+
+	func() {
+		closed := newCursor(context.Background(), connection, "Cursor", 1, nil, map[string]interface{}{})
+		closed.mu.Lock()
+		closed.closed = true
+		closed.mu.Unlock()
+		newLeakedCursor(connection)
+	}()
+
+	// Finalizers run in the background after the cursors are collected
+	var out string
+	for i := 0; i < 100 && out == ""; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		out = logs.String()
+		mu.Unlock()
+	}
+	c.Assert(out, test.Matches, "(?s).*Cursor garbage collected without being closed, created at:.*newLeakedCursor.*")
+	c.Assert(strings.Count(out, "Cursor garbage collected"), test.Equals, 1)
+}
+
+func newLeakedCursor(connection *Connection) {
+	newCursor(context.Background(), connection, "Cursor", 2, nil, map[string]interface{}{})
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
+
+func (s *CursorSuite) TestCursor_ClosedAfterEnd(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("test")).Return([]interface{}{1}, nil)
+	res, err := Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+
+	var n int
+	c.Assert(res.Next(&n), test.Equals, true)
+	c.Assert(res.Next(&n), test.Equals, false)
+	c.Assert(res.Close(), test.IsNil)
+	c.Assert(res.Next(&n), test.Equals, false)
+	c.Assert(res.Err(), test.IsNil)
+
+	res, err = Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+	c.Assert(res.Close(), test.IsNil)
+	c.Assert(res.Next(&n), test.Equals, false)
+	c.Assert(res.Err(), test.Equals, ErrCursorClosed)
+}
+
+func (s *CursorSuite) TestCursor_ToCSV(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("users")).Return([]interface{}{
+		map[string]interface{}{"id": 1, "author": map[string]interface{}{"name": "alice", "tags": []interface{}{"a", "b"}}, "score": 1.5},
+		map[string]interface{}{"id": 2, "author": map[string]interface{}{"name": "bob, jr"}, "active": true},
+		map[string]interface{}{"id": 3, "author": "carol"},
+	}, nil)
+	res, err := Table("users").Run(mock)
+	c.Assert(err, test.IsNil)
+
+	buf := &bytes.Buffer{}
+	c.Assert(res.ToCSV(buf, []string{"id", "author.name", "author.tags", "score", "active"}), test.IsNil)
+	c.Assert(buf.String(), test.Equals, `id,author.name,author.tags,score,active
+1,alice,"[""a"",""b""]",1.5,
+2,"bob, jr",,,true
+3,,,,
+`)
+
+	var doc interface{}
+	c.Assert(res.Next(&doc), test.Equals, false)
+}
+
+func (s *CursorSuite) TestCursor_All_Range(c *test.C) {
+	for _, useJSONNumber := range []bool{false, true} {
+		connection := newConnection(nil, "addr", &ConnectOpts{UseJSONNumber: useJSONNumber})
+		cursor := newCursor(context.Background(), connection, "Cursor", 1, nil, map[string]interface{}{})
+		cursor.extend(&Response{
+			Type:      p.Response_SUCCESS_ATOM,
+			Responses: []json.RawMessage{json.RawMessage(`[0,1,2,3.0,4e0]`)},
+		})
+
+		var ints []int
+		c.Assert(cursor.All(&ints), test.IsNil)
+		c.Assert(ints, test.DeepEquals, []int{0, 1, 2, 3, 4})
+	}
+}
+
+func (s *CursorSuite) TestCursor_Drain(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("test")).Return([]interface{}{1, 2, 3, 4}, nil)
+	res, err := Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+
+	var n int
+	c.Assert(res.Next(&n), test.Equals, true)
+	res.Skip()
+	count, err := res.Drain()
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 2)
+	c.Assert(res.Next(&n), test.Equals, false)
+	c.Assert(res.Err(), test.IsNil)
+
+	count, err = res.Drain()
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 0)
+}
+
+func (s *CursorSuite) TestCursor_Drain_Atom(c *test.C) {
+	cursor := newCursor(context.Background(), nil, "Cursor", 1, nil, map[string]interface{}{})
+	cursor.extend(&Response{Type: p.Response_SUCCESS_ATOM, Responses: []json.RawMessage{json.RawMessage(`[1,2,3]`)}})
+	count, err := cursor.Drain()
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 3)
+
+	cursor = newCursor(context.Background(), nil, "Cursor", 1, nil, map[string]interface{}{})
+	cursor.extend(&Response{Type: p.Response_SUCCESS_ATOM, Responses: []json.RawMessage{json.RawMessage(`{"a":[1,2]}`)}})
+	count, err = cursor.Drain()
+	c.Assert(err, test.IsNil)
+	c.Assert(count, test.Equals, 1)
+}
+
+func (s *CursorSuite) TestCursor_ProfileInfo(c *test.C) {
+	var raw interface{}
+	c.Assert(json.Unmarshal([]byte(`[
+		{"description": "Evaluating table.", "duration(ms)": 1.5, "sub_tasks": [
+			{"description": "Evaluating datum.", "duration(ms)": 0.001, "sub_tasks": []}
+		]},
+		{"parallel_tasks": [
+			[{"description": "Perform read on shard.", "duration(ms)": 2, "sub_tasks": []}],
+			[{"description": "Perform read on shard.", "duration(ms)": 3, "sub_tasks": []}]
+		]}
+	]`), &raw), test.IsNil)
+
+	cursor := newCursor(context.Background(), nil, "Cursor", 1, nil, map[string]interface{}{})
+	profile, err := cursor.ProfileInfo()
+	c.Assert(err, test.IsNil)
+	c.Assert(profile, test.IsNil)
+
+	cursor.profile = raw
+	profile, err = cursor.ProfileInfo()
+	c.Assert(err, test.IsNil)
+	c.Assert(profile, test.DeepEquals, &Profile{Tasks: []ProfileTask{
+		{Description: "Evaluating table.", Duration: 1500 * time.Microsecond, SubTasks: []ProfileTask{
+			{Description: "Evaluating datum.", Duration: time.Microsecond, SubTasks: []ProfileTask{}},
+		}},
+		{ParallelTasks: [][]ProfileTask{
+			{{Description: "Perform read on shard.", Duration: 2 * time.Millisecond, SubTasks: []ProfileTask{}}},
+			{{Description: "Perform read on shard.", Duration: 3 * time.Millisecond, SubTasks: []ProfileTask{}}},
+		}},
+	}})
+
+	cursor.profile = map[string]interface{}{}
+	_, err = cursor.ProfileInfo()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Unexpected profile tasks: .*")
+}
+
+func (s *CursorSuite) TestCursor_RawNext(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("test")).Return([]interface{}{map[string]interface{}{"id": 1}, 2}, nil)
+	res, err := Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+
+	var raw []string
+	for b, ok := res.RawNext(); ok; b, ok = res.RawNext() {
+		raw = append(raw, string(b))
+	}
+	c.Assert(res.Err(), test.IsNil)
+	c.Assert(raw, test.DeepEquals, []string{`{"id":1}`, `2`})
+	_, ok := res.RawNext()
+	c.Assert(ok, test.Equals, false)
+}
+
+type orderCreated struct {
+	Kind  string `rethinkdb:"kind"`
+	Total int    `rethinkdb:"total"`
+}
+
+type orderShipped struct {
+	Kind    string `rethinkdb:"kind"`
+	Carrier string `rethinkdb:"carrier"`
+}
+
+func resolveOrderKind(raw json.RawMessage) (interface{}, error) {
+	var row struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.Unmarshal(raw, &row); err != nil {
+		return nil, err
+	}
+	switch row.Kind {
+	case "created":
+		return &orderCreated{}, nil
+	case "shipped":
+		return &orderShipped{}, nil
+	case "":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown kind %q", row.Kind)
+}
+
+func (s *CursorSuite) TestCursor_ResolveType(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("orders")).Return([]interface{}{
+		map[string]interface{}{"kind": "created", "total": 10},
+		map[string]interface{}{"kind": "shipped", "carrier": "ups"},
+		map[string]interface{}{"id": 3},
+	}, nil)
+
+	res, err := Table("orders").Run(mock, RunOpts{ResolveType: resolveOrderKind})
+	c.Assert(err, test.IsNil)
+
+	var rows []interface{}
+	c.Assert(res.All(&rows), test.IsNil)
+	c.Assert(rows, test.DeepEquals, []interface{}{
+		&orderCreated{Kind: "created", Total: 10},
+		&orderShipped{Kind: "shipped", Carrier: "ups"},
+		map[string]interface{}{"id": float64(3)},
+	})
+}
+
+func (s *CursorSuite) TestCursor_ResolveType_Value(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("orders")).Return([]interface{}{
+		map[string]interface{}{"kind": "created", "total": 10},
+	}, nil)
+
+	res, err := Table("orders").Run(mock, RunOpts{ResolveType: resolveOrderKind})
+	c.Assert(err, test.IsNil)
+
+	var row orderCreated
+	c.Assert(res.One(&row), test.IsNil)
+	c.Assert(row, test.Equals, orderCreated{Kind: "created", Total: 10})
+}
+
+func (s *CursorSuite) TestCursor_ResolveType_Error(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("orders")).Return([]interface{}{
+		map[string]interface{}{"kind": "created", "total": 10},
+		map[string]interface{}{"kind": "cancelled"},
+	}, nil)
+
+	res, err := Table("orders").Run(mock, RunOpts{ResolveType: resolveOrderKind})
+	c.Assert(err, test.IsNil)
+
+	var rows []interface{}
+	c.Assert(res.All(&rows), test.ErrorMatches, `unknown kind "cancelled"`)
+
+	res, err = Table("orders").Run(mock, RunOpts{ResolveType: resolveOrderKind})
+	c.Assert(err, test.IsNil)
+
+	var shipped orderShipped
+	c.Assert(res.One(&shipped), test.ErrorMatches, `rethinkdb: ResolveType returned \*rethinkdb.orderCreated which can't be stored in rethinkdb.orderShipped`)
+}
+
+func (s *CursorSuite) TestCursor_RawNext_Atom(c *test.C) {
+	cursor := newCursor(context.Background(), nil, "Cursor", 1, nil, map[string]interface{}{})
+	cursor.extend(&Response{Type: p.Response_SUCCESS_ATOM, Responses: []json.RawMessage{
+		json.RawMessage(`[{"id":1},{"$reql_type$":"TIME","epoch_time":0,"timezone":"+00:00"},null]`),
+	}})
+
+	var first map[string]interface{}
+	found, err := cursor.Peek(&first)
+	c.Assert(err, test.IsNil)
+	c.Assert(found, test.Equals, true)
+
+	var raw []string
+	for b, ok := cursor.RawNext(); ok; b, ok = cursor.RawNext() {
+		raw = append(raw, string(b))
+	}
+	c.Assert(cursor.Err(), test.IsNil)
+	c.Assert(raw, test.HasLen, 3)
+	c.Assert(raw[0], test.Equals, `{"id":1}`)
+	c.Assert(raw[1], test.Equals, `{"$reql_type$":"TIME","epoch_time":0,"timezone":"+00:00"}`)
+	c.Assert(raw[2], test.Equals, `null`)
+}
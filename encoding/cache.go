@@ -22,8 +22,12 @@ type field struct {
 	quoted        bool
 	reference     bool
 	refName       string
+	primary       bool
 	compound      bool
 	compoundIndex int
+	nanoseconds   bool
+	text          bool
+	any           bool
 }
 
 func fillField(f field) field {
@@ -77,7 +81,7 @@ func (x byIndex) Less(i, j int) bool {
 // typeFields returns a list of fields that should be recognized for the given type.
 // The algorithm is breadth-first search over the set of structs to include - the top struct
 // and then any reachable anonymous structs.
-func typeFields(t reflect.Type) []field {
+func typeFields(t reflect.Type, tags []string) []field {
 	// Anonymous fields to explore at the current level and the next.
 	current := []field{}
 	next := []field{{typ: t}}
@@ -109,7 +113,7 @@ func typeFields(t reflect.Type) []field {
 					continue
 				}
 				// Extract field name from tag
-				tag := getTag(sf)
+				tag := getTag(sf, tags)
 				if tag == "-" {
 					continue
 				}
@@ -149,8 +153,12 @@ func typeFields(t reflect.Type) []field {
 						omitEmpty:     opts.Contains("omitempty"),
 						reference:     opts.Contains("reference"),
 						refName:       ref,
+						primary:       opts.Contains("primary"),
 						compound:      isCompound,
 						compoundIndex: compoundIndex,
+						nanoseconds:   opts.Contains("nanoseconds") && ft == durationType,
+						text:          opts.Contains("text"),
+						any:           opts.Contains("any") && ft.Kind() == reflect.Interface,
 					}))
 					if count[f.typ] > 1 {
 						// If there were multiple instances, add a second,
@@ -255,12 +263,16 @@ func dominantField(fields []field) (field, bool) {
 var fieldCache struct {
 	sync.RWMutex
 	m map[reflect.Type][]field
+	// gen is incremented by SetTags, fields computed using the previous tags
+	// are not cached
+	gen int
 }
 
 // cachedTypeFields is like typeFields but uses a cache to avoid repeated work.
 func cachedTypeFields(t reflect.Type) []field {
 	fieldCache.RLock()
 	f := fieldCache.m[t]
+	tags, gen := Tags, fieldCache.gen
 	fieldCache.RUnlock()
 	if f != nil {
 		return f
@@ -268,7 +280,7 @@ func cachedTypeFields(t reflect.Type) []field {
 
 	// Compute fields without lock.
 	// Might duplicate effort but won't hold other computations back.
-	f = typeFields(t)
+	f = typeFields(t, tags)
 	if f == nil {
 		f = []field{}
 	}
@@ -277,7 +289,9 @@ func cachedTypeFields(t reflect.Type) []field {
 	if fieldCache.m == nil {
 		fieldCache.m = map[reflect.Type][]field{}
 	}
-	fieldCache.m[t] = f
+	if fieldCache.gen == gen {
+		fieldCache.m[t] = f
+	}
 	fieldCache.Unlock()
 	return f
 }
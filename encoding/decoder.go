@@ -13,10 +13,101 @@ type decoderFunc func(dv reflect.Value, sv reflect.Value) error
 
 // Decode decodes map[string]interface{} into a struct. The first parameter
 // must be a pointer.
+//
+// Keys are matched to struct fields by the name of the field or its tag, or
+// case-insensitively if no field matches exactly, so the key "Name" is decoded
+// into a field tagged "name". If a document holds both a key matching a field
+// exactly and keys which only differ by case, such as "name" and "Name", the
+// exact match is decoded and the others are ignored. Which of several keys
+// differing only by case is decoded is undefined when none matches exactly.
+//
+// A null value sets pointer fields to nil, types implementing sql.Scanner (such
+// as sql.NullString) are decoded by calling Scan, which is passed nil for null.
+// Other fields which are null or missing from src are set to their zero value,
+// use DecodeMissing to find out which fields these were.
+//
+// time.Duration values are decoded from a number of seconds, or a string
+// accepted by time.ParseDuration, a DecodeTypeError is returned if the value
+// overflows a time.Duration. Struct fields with the "nanoseconds" tag option
+// are decoded from a number of nanoseconds instead.
+//
+// Interface fields with the "any" tag option, for example
+// `rethinkdb:"value,any"`, are set to the source value as is, a string, number,
+// bool, []interface{} or map[string]interface{}, so a field can hold values of
+// different types in different documents. Without the option a value already
+// held by the field, such as a pointer to a struct, is decoded into, which
+// fails when the document holds a value of another type. Numbers are stored as
+// they are in the source, float64 or json.Number values.
+//
+// json.RawMessage values are set to the JSON encoding of the source value,
+// which allows a part of a document to be decoded later.
+//
+// big.Int and big.Rat values are decoded from numbers or from strings holding
+// an integer, a decimal or a fraction such as "1/3".
+//
+// Struct fields with the "text" tag option whose type implements
+// encoding.TextUnmarshaler are decoded from strings by calling UnmarshalText,
+// errors returned by UnmarshalText are returned as a DecodeTypeError. Other
+// values are decoded into them as usual.
+//
+// Errors returned when decoding a struct field, map value or array element
+// are returned as a DecodeError holding the path of the value, for example
+// "items.2.price" when the price field of the third element of items could not
+// be decoded.
 func Decode(dst interface{}, src interface{}) (err error) {
 	return decode(dst, src, true)
 }
 
+// DecodeMissing decodes src into dst like Decode and returns the names of the
+// fields of the dst struct which were missing from src or null. Decode treats
+// a missing field and a null field in the same way, both are set to the zero
+// value of the field type (or nil for pointers), DecodeMissing can be used to
+// tell these zero values apart from zero values stored in the document, for
+// example when a field is optional or has a Default in the query.
+//
+// Only the top level fields of dst are reported and dst must be a pointer to a
+// struct.
+func DecodeMissing(dst interface{}, src interface{}) (missing []string, err error) {
+	if err = Decode(dst, src); err != nil {
+		return nil, err
+	}
+
+	dt := reflect.TypeOf(dst).Elem()
+	if dt.Kind() != reflect.Struct {
+		return nil, &DecodeTypeError{
+			DestType: dt,
+			SrcType:  reflect.TypeOf(src),
+			Reason:   "must be a pointer to a struct",
+		}
+	}
+	sm, _ := src.(map[string]interface{})
+
+	for _, f := range cachedTypeFields(dt) {
+		if f.compound {
+			continue
+		}
+		if !hasFieldValue(sm, f) {
+			missing = append(missing, f.name)
+		}
+	}
+
+	return missing, nil
+}
+
+// hasFieldValue returns true if src contains a non-null value for f, keys are
+// matched in the same way as when decoding.
+func hasFieldValue(src map[string]interface{}, f field) bool {
+	if v, ok := src[f.name]; ok {
+		return v != nil
+	}
+	for k, v := range src {
+		if f.equalFold(f.nameBytes, []byte(k)) {
+			return v != nil
+		}
+	}
+	return false
+}
+
 func Merge(dst interface{}, src interface{}) (err error) {
 	return decode(dst, src, false)
 }
@@ -69,6 +160,9 @@ type decoderCacheKey struct {
 var decoderCache struct {
 	sync.RWMutex
 	m map[decoderCacheKey]decoderFunc
+	// gen is incremented when the cache is reset, decoders built before the
+	// reset are not cached
+	gen int
 }
 
 func valueDecoder(dv, sv reflect.Value, blank bool) decoderFunc {
@@ -108,6 +202,7 @@ func typeDecoder(dt, st reflect.Type, blank bool) decoderFunc {
 		wg.Wait()
 		return f(dv, sv)
 	}
+	gen := decoderCache.gen
 	decoderCache.Unlock()
 
 	// Compute fields without lock.
@@ -115,7 +210,9 @@ func typeDecoder(dt, st reflect.Type, blank bool) decoderFunc {
 	f = newTypeDecoder(dt, st, blank)
 	wg.Done()
 	decoderCache.Lock()
-	decoderCache.m[decoderCacheKey{dt, st}] = f
+	if decoderCache.gen == gen {
+		decoderCache.m[decoderCacheKey{dt, st}] = f
+	}
 	decoderCache.Unlock()
 	return f
 }
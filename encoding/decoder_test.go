@@ -2,11 +2,17 @@ package encoding
 
 import (
 	"bytes"
+	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/segmentio/encoding/json"
 	"image"
+	"math"
+	"math/big"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type T struct {
@@ -168,7 +174,7 @@ var decodeTests = []decodeTest{
 	{in: string("2"), ptr: new(interface{}), out: string("2")},
 	{in: "a\u1234", ptr: new(string), out: "a\u1234"},
 	{in: []interface{}{}, ptr: new([]string), out: []string{}},
-	{in: map[string]interface{}{"X": []interface{}{1, 2, 3}, "Y": 4}, ptr: new(T), out: T{}, err: &DecodeTypeError{reflect.TypeOf(""), reflect.TypeOf([]interface{}{}), ""}},
+	{in: map[string]interface{}{"X": []interface{}{1, 2, 3}, "Y": 4}, ptr: new(T), out: T{}, err: &DecodeError{DocIndex: -1, FieldPath: "X", Cause: &DecodeTypeError{reflect.TypeOf(""), reflect.TypeOf([]interface{}{}), ""}}},
 	{in: map[string]interface{}{"x": 1}, ptr: new(tx), out: tx{}},
 	{in: map[string]interface{}{"F1": float64(1), "F2": 2, "F3": 3}, ptr: new(V), out: V{F1: float64(1), F2: int32(2), F3: string("3")}},
 	{in: map[string]interface{}{"F1": string("1"), "F2": 2, "F3": 3}, ptr: new(V), out: V{F1: string("1"), F2: int32(2), F3: string("3")}},
@@ -479,6 +485,84 @@ func TestDecodeNilSlice(t *testing.T) {
 	}
 }
 
+type NullableStruct struct {
+	Name   *string
+	Count  *int
+	Str    sql.NullString
+	Int    sql.NullInt64
+	Float  sql.NullFloat64
+	Bool   sql.NullBool
+	NilStr sql.NullString
+	NilInt sql.NullInt64
+}
+
+func TestDecodeNullable(t *testing.T) {
+	input := map[string]interface{}{
+		"Name":   nil,
+		"Count":  float64(0),
+		"Str":    "a",
+		"Int":    float64(2),
+		"Float":  1.5,
+		"Bool":   false,
+		"NilStr": nil,
+		"NilInt": nil,
+	}
+	zero := 0
+	want := NullableStruct{
+		Count:  &zero,
+		Str:    sql.NullString{String: "a", Valid: true},
+		Int:    sql.NullInt64{Int64: 2, Valid: true},
+		Float:  sql.NullFloat64{Float64: 1.5, Valid: true},
+		Bool:   sql.NullBool{Bool: false, Valid: true},
+		NilStr: sql.NullString{},
+		NilInt: sql.NullInt64{},
+	}
+
+	out := NullableStruct{}
+	err := Decode(&out, input)
+	if err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %+v, want %+v", out, want)
+	}
+}
+
+func TestMergeNullable(t *testing.T) {
+	name := "change me"
+	dst := NullableStruct{
+		Name:   &name,
+		NilStr: sql.NullString{String: "change me", Valid: true},
+	}
+
+	err := Merge(&dst, map[string]interface{}{"Name": nil, "NilStr": nil})
+	if err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+	if dst.Name != nil {
+		t.Errorf("got %v, want nil pointer", *dst.Name)
+	}
+	if dst.NilStr.Valid {
+		t.Errorf("got %+v, want invalid NullString", dst.NilStr)
+	}
+}
+
+func TestDecodeNullableTypeError(t *testing.T) {
+	out := NullableStruct{}
+	err := Decode(&out, map[string]interface{}{"Int": "not a number"})
+	if _, ok := decodeErrorCause(err).(*DecodeTypeError); !ok {
+		t.Errorf("got error %v, expected DecodeTypeError", err)
+	}
+}
+
+// decodeErrorCause returns the cause of err if it is a DecodeError.
+func decodeErrorCause(err error) error {
+	if e, ok := err.(*DecodeError); ok {
+		return e.Cause
+	}
+	return err
+}
+
 func jsonEqual(a, b interface{}) bool {
 	// First check using reflect.DeepEqual
 	if reflect.DeepEqual(a, b) {
@@ -669,3 +753,463 @@ func TestDecodeCustomTypeEncodingError(t *testing.T) {
 		t.Errorf("got %v, want %v", err, cerr)
 	}
 }
+
+type Date struct {
+	time.Time
+}
+
+func TestDecodeCustomTypeEncodingTime(t *testing.T) {
+	SetTypeEncoding(reflect.TypeOf(Date{}),
+		func(v interface{}) (interface{}, error) {
+			return v.(Date).Time, nil
+		},
+		func(enc interface{}, val reflect.Value) error {
+			tm, ok := enc.(time.Time)
+			if !ok {
+				return fmt.Errorf("cannot decode %T into Date", enc)
+			}
+			val.Set(reflect.ValueOf(Date{tm.UTC().Truncate(24 * time.Hour)}))
+			return nil
+		})
+
+	in := time.Date(2020, 5, 17, 23, 30, 0, 0, time.FixedZone("", -2*60*60))
+	want := Date{time.Date(2020, 5, 18, 0, 0, 0, 0, time.UTC)}
+
+	var out Date
+	if err := Decode(&out, in); err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+	if !out.Equal(want.Time) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+
+	var outStruct struct {
+		Dates []Date
+		Ptr   *Date
+	}
+	if err := Decode(&outStruct, map[string]interface{}{"Dates": []interface{}{in}, "Ptr": in}); err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+	if len(outStruct.Dates) != 1 || !outStruct.Dates[0].Equal(want.Time) || outStruct.Ptr == nil || !outStruct.Ptr.Equal(want.Time) {
+		t.Errorf("got %+v, want %v", outStruct, want)
+	}
+
+	if err := Decode(&out, "2020-05-17"); err == nil || err.Error() != "cannot decode string into Date" {
+		t.Errorf("got error %v, expected cannot decode string into Date", err)
+	}
+}
+
+type SimpleT struct {
+	A string
+	B int
+}
+
+func TestDecodeMissing(t *testing.T) {
+	tests := []struct {
+		src     map[string]interface{}
+		want    SimpleT
+		missing []string
+	}{
+		{map[string]interface{}{"A": "a", "B": 1}, SimpleT{A: "a", B: 1}, nil},
+		{map[string]interface{}{"A": "a", "B": 0}, SimpleT{A: "a"}, nil},
+		{map[string]interface{}{"A": "a"}, SimpleT{A: "a"}, []string{"B"}},
+		{map[string]interface{}{"A": "a", "B": nil}, SimpleT{A: "a"}, []string{"B"}},
+		{map[string]interface{}{"a": nil, "b": 2}, SimpleT{B: 2}, []string{"A"}},
+		{map[string]interface{}{}, SimpleT{}, []string{"A", "B"}},
+	}
+
+	for _, tt := range tests {
+		// Fields are reset even if the destination is reused
+		got := SimpleT{A: "old", B: 5}
+		missing, err := DecodeMissing(&got, tt.src)
+		if err != nil {
+			t.Fatalf("DecodeMissing(%v): %s", tt.src, err)
+		}
+		if got != tt.want {
+			t.Errorf("DecodeMissing(%v): got %+v, want %+v", tt.src, got, tt.want)
+		}
+		if !reflect.DeepEqual(missing, tt.missing) {
+			t.Errorf("DecodeMissing(%v): got missing %v, want %v", tt.src, missing, tt.missing)
+		}
+	}
+
+	var i int
+	if _, err := DecodeMissing(&i, 1); err == nil {
+		t.Error("DecodeMissing into an int should fail")
+	}
+}
+
+type DurationT struct {
+	Seconds     time.Duration  `rethinkdb:"seconds"`
+	Nanoseconds time.Duration  `rethinkdb:"nanoseconds,nanoseconds"`
+	Ptr         *time.Duration `rethinkdb:"ptr,nanoseconds"`
+}
+
+func TestDecodeDuration(t *testing.T) {
+	ns := 1500 * time.Nanosecond
+	tests := []struct {
+		src  map[string]interface{}
+		want DurationT
+	}{
+		{map[string]interface{}{"seconds": 2}, DurationT{Seconds: 2 * time.Second}},
+		{map[string]interface{}{"seconds": 1.5}, DurationT{Seconds: 1500 * time.Millisecond}},
+		{map[string]interface{}{"seconds": 0.001}, DurationT{Seconds: time.Millisecond}},
+		{map[string]interface{}{"seconds": json.Number("2.5")}, DurationT{Seconds: 2500 * time.Millisecond}},
+		{map[string]interface{}{"seconds": "1m30s"}, DurationT{Seconds: 90 * time.Second}},
+		{map[string]interface{}{"nanoseconds": 1500, "ptr": 1500.0}, DurationT{Nanoseconds: ns, Ptr: &ns}},
+		{map[string]interface{}{"ptr": nil}, DurationT{}},
+	}
+
+	for _, tt := range tests {
+		var got DurationT
+		if err := Decode(&got, tt.src); err != nil {
+			t.Fatalf("Decode(%v): %s", tt.src, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Decode(%v): got %+v, want %+v", tt.src, got, tt.want)
+		}
+	}
+
+	var d time.Duration
+	if err := Decode(&d, 3); err != nil || d != 3*time.Second {
+		t.Errorf("Decode(3): got %v, %v, want 3s", d, err)
+	}
+
+	for _, src := range []interface{}{"soon", int64(math.MaxInt64/int64(time.Second) + 1), uint64(1) << 40, 1e10, math.NaN()} {
+		var got DurationT
+		err := Decode(&got, map[string]interface{}{"seconds": src})
+		if _, ok := decodeErrorCause(err).(*DecodeTypeError); !ok {
+			t.Errorf("Decode(%v) into a time.Duration field: expected a DecodeTypeError, got %v", src, err)
+		}
+	}
+}
+
+type Color string
+
+func (c Color) MarshalText() ([]byte, error) {
+	if c != "red" && c != "green" {
+		return nil, fmt.Errorf("invalid color %q", string(c))
+	}
+	return []byte(strings.ToUpper(string(c))), nil
+}
+
+func (c *Color) UnmarshalText(b []byte) error {
+	switch s := strings.ToLower(string(b)); s {
+	case "red", "green":
+		*c = Color(s)
+		return nil
+	default:
+		return fmt.Errorf("invalid color %q", string(b))
+	}
+}
+
+type ColorT struct {
+	Color Color  `rethinkdb:"color,text"`
+	Ptr   *Color `rethinkdb:"ptr,text"`
+	Plain Color  `rethinkdb:"plain,omitempty"`
+}
+
+func TestDecodeTextUnmarshaler(t *testing.T) {
+	var got ColorT
+	if err := Decode(&got, map[string]interface{}{"color": "RED", "ptr": "GREEN", "plain": "BLUE"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Color != "red" || got.Ptr == nil || *got.Ptr != "green" || got.Plain != "BLUE" {
+		t.Errorf("got %+v", got)
+	}
+
+	err := Decode(&got, map[string]interface{}{"color": "BLUE"})
+	if _, ok := decodeErrorCause(err).(*DecodeTypeError); !ok {
+		t.Fatalf("expected DecodeTypeError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `invalid color "BLUE"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+type RawT struct {
+	ID   int              `rethinkdb:"id"`
+	Body json.RawMessage  `rethinkdb:"body"`
+	Ptr  *json.RawMessage `rethinkdb:"ptr,omitempty"`
+}
+
+func TestDecodeRawMessage(t *testing.T) {
+	var got RawT
+	err := Decode(&got, map[string]interface{}{
+		"id": 1,
+		"body": map[string]interface{}{
+			"tags": []interface{}{"a", 2.5},
+			"at":   time.Unix(0, 0).UTC(),
+		},
+		"ptr": "s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"at":{"$reql_type$":"TIME","epoch_time":0,"timezone":"+00:00"},"tags":["a",2.5]}`
+	if string(got.Body) != want {
+		t.Errorf("got %s, want %s", got.Body, want)
+	}
+	if got.Ptr == nil || string(*got.Ptr) != `"s"` {
+		t.Errorf("got ptr %v", got.Ptr)
+	}
+
+	if err := Decode(&got, map[string]interface{}{"id": 1, "body": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Body != nil {
+		t.Errorf("expected nil body, got %s", got.Body)
+	}
+}
+
+func TestDecodeBigNumbers(t *testing.T) {
+	var got BigT
+	err := Decode(&got, map[string]interface{}{
+		"int":     float64(1 << 53),
+		"int_ptr": "123456789012345678901234567890",
+		"rat":     0.1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int.String() != "9007199254740992" || got.IntPtr.String() != "123456789012345678901234567890" ||
+		got.Rat.RatString() != "1/10" {
+		t.Errorf("got %v, %v, %v", &got.Int, got.IntPtr, got.Rat)
+	}
+
+	// Numbers read with UseJSONNumber keep the digits sent by the server
+	err = Decode(&got, map[string]interface{}{
+		"int":     json.Number("9007199254740993"),
+		"int_ptr": json.Number("1e20"),
+		"rat":     "-2/6",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int.String() != "9007199254740993" || got.IntPtr.String() != "100000000000000000000" ||
+		got.Rat.RatString() != "-1/3" {
+		t.Errorf("got %v, %v, %v", &got.Int, got.IntPtr, got.Rat)
+	}
+
+	for _, src := range []interface{}{1.5, "1/3", "abc"} {
+		err = Decode(&got, map[string]interface{}{"int": src})
+		if _, ok := decodeErrorCause(err).(*DecodeTypeError); !ok {
+			t.Errorf("expected DecodeTypeError for %v, got %v", src, err)
+		}
+	}
+}
+
+func TestBigNumbersRoundTrip(t *testing.T) {
+	large, _ := new(big.Int).SetString("-98765432109876543210", 10)
+	rat, _ := new(big.Rat).SetString("12345678901234567890/7")
+	for _, format := range []BigNumberFormat{BigNumberAuto, BigNumberString} {
+		SetBigNumberFormat(format)
+		encoded, err := Encode(BigT{Int: *large, IntPtr: big.NewInt(42), Rat: rat})
+		SetBigNumberFormat(BigNumberAuto)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got BigT
+		if err := Decode(&got, encoded); err != nil {
+			t.Fatal(err)
+		}
+		if got.Int.Cmp(large) != 0 || got.IntPtr.Int64() != 42 || got.Rat.Cmp(rat) != 0 {
+			t.Errorf("format %d: got %v, %v, %v", format, &got.Int, got.IntPtr, got.Rat)
+		}
+	}
+}
+
+func TestDecodeArrayLength(t *testing.T) {
+	type place struct {
+		Name   string     `rethinkdb:"name"`
+		Coords [2]float64 `rethinkdb:"coords"`
+	}
+	defer SetArrayLengthMode(ArrayLengthAny)
+
+	tests := []struct {
+		mode   ArrayLengthMode
+		coords []interface{}
+		want   [2]float64
+		err    bool
+	}{
+		{mode: ArrayLengthAny, coords: []interface{}{1.5, 2.5}, want: [2]float64{1.5, 2.5}},
+		{mode: ArrayLengthAny, coords: []interface{}{1.5}, want: [2]float64{1.5, 0}},
+		{mode: ArrayLengthAny, coords: []interface{}{1.5, 2.5, 3.5}, want: [2]float64{1.5, 2.5}},
+		{mode: ArrayLengthAtMost, coords: []interface{}{1.5}, want: [2]float64{1.5, 0}},
+		{mode: ArrayLengthAtMost, coords: []interface{}{1.5, 2.5, 3.5}, err: true},
+		{mode: ArrayLengthExact, coords: []interface{}{1.5, 2.5}, want: [2]float64{1.5, 2.5}},
+		{mode: ArrayLengthExact, coords: []interface{}{1.5}, err: true},
+		{mode: ArrayLengthExact, coords: []interface{}{1.5, 2.5, 3.5}, err: true},
+	}
+	for _, tt := range tests {
+		SetArrayLengthMode(tt.mode)
+
+		var got place
+		err := Decode(&got, map[string]interface{}{"name": "home", "coords": tt.coords})
+		if tt.err {
+			if _, ok := decodeErrorCause(err).(*DecodeTypeError); !ok {
+				t.Errorf("mode %d, coords %v: expected a DecodeTypeError, got %v", tt.mode, tt.coords, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("mode %d, coords %v: %v", tt.mode, tt.coords, err)
+		} else if got.Coords != tt.want || got.Name != "home" {
+			t.Errorf("mode %d, coords %v: got %v", tt.mode, tt.coords, got)
+		}
+	}
+
+	SetArrayLengthMode(ArrayLengthExact)
+	var coords [2]float64
+	err := Decode(&coords, []interface{}{1.0})
+	if err == nil || err.Error() != "rethinkdb: could not decode type []interface {} into Go value of type [2]float64: got an array of length 1" {
+		t.Errorf("got %v", err)
+	}
+	// Slices are not affected
+	var slice []float64
+	if err := Decode(&slice, []interface{}{1.0}); err != nil || len(slice) != 1 {
+		t.Errorf("got %v, %v", slice, err)
+	}
+}
+
+func TestDecodeAnyField(t *testing.T) {
+	type setting struct {
+		Name  string      `rethinkdb:"name"`
+		Value interface{} `rethinkdb:"value,any"`
+		Count int         `rethinkdb:"count"`
+	}
+
+	tests := []struct {
+		value interface{}
+		want  interface{}
+	}{
+		{value: "dark", want: "dark"},
+		{value: json.Number("12"), want: json.Number("12")},
+		{value: map[string]interface{}{"width": 80.0}, want: map[string]interface{}{"width": 80.0}},
+		{value: []interface{}{"a", true}, want: []interface{}{"a", true}},
+		{value: nil, want: nil},
+	}
+	for _, tt := range tests {
+		// The value held by the field is replaced rather than decoded into
+		got := setting{Value: &setting{}}
+		err := Merge(&got, map[string]interface{}{"name": "theme", "value": tt.value, "count": 1.0})
+		if err != nil {
+			t.Errorf("value %v: %v", tt.value, err)
+		} else if !reflect.DeepEqual(got.Value, tt.want) || got.Name != "theme" || got.Count != 1 {
+			t.Errorf("value %v: got %#v", tt.value, got)
+		}
+	}
+
+	// Sibling fields are still decoded strictly
+	var got setting
+	err := Decode(&got, map[string]interface{}{"value": "dark", "count": "many"})
+	if _, ok := decodeErrorCause(err).(*DecodeTypeError); !ok {
+		t.Errorf("expected a DecodeTypeError, got %v", err)
+	}
+}
+
+func TestDecodeErrorPath(t *testing.T) {
+	type item struct {
+		Price float64 `rethinkdb:"price"`
+	}
+	type order struct {
+		Items  []item            `rethinkdb:"items"`
+		Totals map[string]int    `rethinkdb:"totals"`
+		Owner  *struct{ ID int } `rethinkdb:"owner"`
+	}
+
+	tests := []struct {
+		src  map[string]interface{}
+		path string
+	}{
+		{src: map[string]interface{}{"items": []interface{}{
+			map[string]interface{}{"price": 1.0},
+			map[string]interface{}{"price": "free"},
+		}}, path: "items.1.price"},
+		{src: map[string]interface{}{"totals": map[string]interface{}{"eur": "ten"}}, path: "totals.eur"},
+		{src: map[string]interface{}{"owner": map[string]interface{}{"ID": []interface{}{}}}, path: "owner.ID"},
+	}
+	for _, tt := range tests {
+		var got order
+		err := Decode(&got, tt.src)
+		e, ok := err.(*DecodeError)
+		if !ok {
+			t.Errorf("%s: expected a DecodeError, got %v", tt.path, err)
+			continue
+		}
+		if e.FieldPath != tt.path || e.DocIndex != -1 {
+			t.Errorf("%s: got path %q and index %d", tt.path, e.FieldPath, e.DocIndex)
+		}
+		if _, ok := e.Cause.(*DecodeTypeError); !ok {
+			t.Errorf("%s: expected a DecodeTypeError cause, got %v", tt.path, e.Cause)
+		}
+	}
+
+	err := Decode(&order{}, tests[0].src)
+	want := `rethinkdb: field items.1.price: could not decode type string into Go value of type float64: strconv.ParseFloat: parsing "free": invalid syntax`
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
+
+func TestDecodeCaseInsensitiveFields(t *testing.T) {
+	type user struct {
+		Name  string `rethinkdb:"name"`
+		Email string
+	}
+
+	var got user
+	if err := Decode(&got, map[string]interface{}{"NAME": "alice", "email": "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if got != (user{Name: "alice", Email: "a@example.com"}) {
+		t.Errorf("got %+v", got)
+	}
+
+	// Exact matches win regardless of the order keys are iterated in
+	for i := 0; i < 20; i++ {
+		var got user
+		err := Decode(&got, map[string]interface{}{"Name": "legacy", "name": "bob", "email": "old", "Email": "b@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != (user{Name: "bob", Email: "b@example.com"}) {
+			t.Fatalf("got %+v", got)
+		}
+	}
+}
+
+func TestDecodeJSONNumberIntegers(t *testing.T) {
+	src := []interface{}{json.Number("0"), json.Number("2.0"), json.Number("1e2"), json.Number("-3E+0")}
+	var ints []int
+	if err := Decode(&ints, src); err != nil || !reflect.DeepEqual(ints, []int{0, 2, 100, -3}) {
+		t.Errorf("got %v, %v", ints, err)
+	}
+	var uints []uint16
+	if err := Decode(&uints, src[:3]); err != nil || !reflect.DeepEqual(uints, []uint16{0, 2, 100}) {
+		t.Errorf("got %v, %v", uints, err)
+	}
+	var big int64
+	if err := Decode(&big, json.Number("9223372036854775807")); err != nil || big != 1<<63-1 {
+		t.Errorf("got %v, %v", big, err)
+	}
+
+	for _, tt := range []struct {
+		dst interface{}
+		n   json.Number
+	}{
+		{new(int), "2.5"},
+		{new(int), "1e-2"},
+		{new(int8), "1e3"},
+		{new(uint), "-1"},
+		{new(int64), "1e19"},
+		{new(int), "1.0000000000000000000000000000000000000000000001"},
+	} {
+		if err := Decode(tt.dst, tt.n); err == nil {
+			t.Errorf("%s into %T: expected an error", tt.n, tt.dst)
+		} else if _, ok := err.(*DecodeTypeError); !ok {
+			t.Errorf("%s into %T: expected a DecodeTypeError, got %v", tt.n, tt.dst, err)
+		}
+	}
+}
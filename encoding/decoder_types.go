@@ -2,13 +2,26 @@ package encoding
 
 import (
 	"bytes"
+	"database/sql"
+	"encoding"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
 	"strconv"
+	"time"
+
+	"github.com/segmentio/encoding/json"
 )
 
 // newTypeDecoder constructs an decoderFunc for a type.
 func newTypeDecoder(dt, st reflect.Type, blank bool) decoderFunc {
+	// Types with a decoder set by SetTypeEncoding are decoded from any value,
+	// such as the time.Time values of TIME pseudo-types.
+	if f := customTypeDecoder(dt); f != nil {
+		return f
+	}
+
 	if reflect.PtrTo(dt).Implements(unmarshalerType) ||
 		dt.Implements(unmarshalerType) {
 		return unmarshalerDecoder
@@ -18,6 +31,49 @@ func newTypeDecoder(dt, st reflect.Type, blank bool) decoderFunc {
 		return newInterfaceAsTypeDecoder(blank)
 	}
 
+	// Types such as sql.NullString are decoded using their Scan method, maps
+	// are excluded so that structs implementing sql.Scanner are still decoded
+	// from objects.
+	if reflect.PtrTo(dt).Implements(scannerType) && st.Kind() != reflect.Map {
+		return scannerDecoder
+	}
+
+	if dt == rawMessageType {
+		return rawMessageDecoder
+	}
+
+	if dt == bigIntType || dt == bigRatType {
+		switch st.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+			reflect.Float32, reflect.Float64, reflect.String:
+			if dt == bigIntType {
+				return bigIntDecoder
+			}
+			return bigRatDecoder
+		}
+	}
+
+	if dt == durationType {
+		switch st.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+			reflect.Float32, reflect.Float64, reflect.String:
+			return secondsAsDurationDecoder
+		}
+	}
+
+	// Numbers decoded with UseJSONNumber may hold integers written with a
+	// fraction or an exponent, such as 2.0 or 1e+21
+	if st == numberType {
+		switch dt.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return numberAsIntDecoder
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return numberAsUintDecoder
+		}
+	}
+
 	switch dt.Kind() {
 	case reflect.Bool:
 		switch st.Kind() {
@@ -195,8 +251,23 @@ func newInterfaceAsTypeDecoder(blank bool) decoderFunc {
 			}
 			return decodeValue(dv, sv.Elem(), blank)
 		}
-		return nil
+		return nullDecoder(dv)
+	}
+}
+
+// nullDecoder decodes a null value, pointers are set to nil and types
+// implementing sql.Scanner are passed nil. Other values are left unchanged.
+func nullDecoder(dv reflect.Value) error {
+	switch {
+	case dv.Kind() == reflect.Ptr:
+		if dv.CanSet() {
+			dv.Set(reflect.Zero(dv.Type()))
+		}
+	case dv.CanAddr() && reflect.PtrTo(dv.Type()).Implements(scannerType):
+		return scanValue(dv.Addr(), nil, emptyInterfaceType)
 	}
+
+	return nil
 }
 
 type ptrDecoder struct {
@@ -234,6 +305,68 @@ func unmarshalerDecoder(dv, sv reflect.Value) error {
 	return nil
 }
 
+// newTextDecoder returns a decoder for fields of type dt with the "text" tag
+// option, strings are decoded using UnmarshalText and other values as usual.
+func newTextDecoder(dt reflect.Type, blank bool) decoderFunc {
+	return func(dv, sv reflect.Value) error {
+		if sv.Kind() == reflect.Interface && !sv.IsNil() {
+			sv = sv.Elem()
+		}
+		if sv.Kind() == reflect.String {
+			return textUnmarshalerDecoder(dv, sv)
+		}
+		return typeDecoder(dt, sv.Type(), blank)(dv, sv)
+	}
+}
+
+func textUnmarshalerDecoder(dv, sv reflect.Value) error {
+	if dv.Kind() != reflect.Ptr && dv.CanAddr() {
+		dv = dv.Addr()
+	}
+
+	if dv.IsNil() {
+		dv.Set(reflect.New(dv.Type().Elem()))
+	}
+
+	u := dv.Interface().(encoding.TextUnmarshaler)
+	if err := u.UnmarshalText([]byte(sv.String())); err != nil {
+		return &DecodeTypeError{dv.Type(), sv.Type(), err.Error()}
+	}
+	return nil
+}
+
+// rawMessageDecoder stores the JSON of the source value, values such as times
+// are encoded as pseudo-types again.
+func rawMessageDecoder(dv, sv reflect.Value) error {
+	v, err := Encode(sv.Interface())
+	if err != nil {
+		return &DecodeTypeError{dv.Type(), sv.Type(), err.Error()}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return &DecodeTypeError{dv.Type(), sv.Type(), err.Error()}
+	}
+
+	dv.SetBytes(b)
+	return nil
+}
+
+func scannerDecoder(dv, sv reflect.Value) error {
+	if dv.Kind() != reflect.Ptr && dv.CanAddr() {
+		dv = dv.Addr()
+	}
+
+	return scanValue(dv, sv.Interface(), sv.Type())
+}
+
+func scanValue(dv reflect.Value, src interface{}, st reflect.Type) error {
+	s := dv.Interface().(sql.Scanner)
+	if err := s.Scan(src); err != nil {
+		return &DecodeTypeError{dv.Type(), st, err.Error()}
+	}
+	return nil
+}
+
 // Boolean decoders
 
 func boolAsBoolDecoder(dv, sv reflect.Value) error {
@@ -387,6 +520,54 @@ func stringAsStringDecoder(dv, sv reflect.Value) error {
 	return nil
 }
 
+// Number decoders
+
+// numberAsIntDecoder decodes a json.Number holding an integer, which may be
+// written with a fraction or an exponent, into an int.
+func numberAsIntDecoder(dv, sv reflect.Value) error {
+	if i, err := strconv.ParseInt(sv.String(), 10, dv.Type().Bits()); err == nil {
+		dv.SetInt(i)
+		return nil
+	}
+
+	f, ok := parseIntegralNumber(sv.String())
+	if ok {
+		if i, acc := f.Int64(); acc == big.Exact && !dv.OverflowInt(i) {
+			dv.SetInt(i)
+			return nil
+		}
+	}
+	return &DecodeTypeError{dv.Type(), sv.Type(), fmt.Sprintf("%s is not an integer of type %s", sv.String(), dv.Type())}
+}
+
+// numberAsUintDecoder decodes a json.Number holding an integer, which may be
+// written with a fraction or an exponent, into a uint.
+func numberAsUintDecoder(dv, sv reflect.Value) error {
+	if i, err := strconv.ParseUint(sv.String(), 10, dv.Type().Bits()); err == nil {
+		dv.SetUint(i)
+		return nil
+	}
+
+	f, ok := parseIntegralNumber(sv.String())
+	if ok {
+		if i, acc := f.Uint64(); acc == big.Exact && !dv.OverflowUint(i) {
+			dv.SetUint(i)
+			return nil
+		}
+	}
+	return &DecodeTypeError{dv.Type(), sv.Type(), fmt.Sprintf("%s is not an integer of type %s", sv.String(), dv.Type())}
+}
+
+// parseIntegralNumber parses the number s, ok is false if s is not a number or
+// its value is not an integer.
+func parseIntegralNumber(s string) (f *big.Float, ok bool) {
+	f, _, err := new(big.Float).SetPrec(128).Parse(s, 10)
+	if err != nil || f.Acc() != big.Exact || !f.IsInt() {
+		return nil, false
+	}
+	return f, true
+}
+
 // Slice/Array decoder
 
 type sliceDecoder struct {
@@ -417,6 +598,12 @@ type arrayDecoder struct {
 }
 
 func (d *arrayDecoder) decode(dv, sv reflect.Value) error {
+	if dv.Kind() == reflect.Array {
+		if err := checkArrayLength(dv, sv); err != nil {
+			return err
+		}
+	}
+
 	// Iterate through the slice/array and decode each element before adding it
 	// to the dest slice/array
 	i := 0
@@ -441,7 +628,7 @@ func (d *arrayDecoder) decode(dv, sv reflect.Value) error {
 			// Decode into element.
 			err := d.elemDec(dv.Index(i), sv.Index(i))
 			if err != nil {
-				return err
+				return withFieldPath(err, strconv.Itoa(i))
 			}
 		}
 
@@ -463,6 +650,20 @@ func (d *arrayDecoder) decode(dv, sv reflect.Value) error {
 	return nil
 }
 
+// checkArrayLength returns an error if sv can't be decoded into the array dv
+// because of its length, see SetArrayLengthMode.
+func checkArrayLength(dv, sv reflect.Value) error {
+	mode := currentArrayLengthMode()
+	if sv.Len() > dv.Len() && mode != ArrayLengthAny || sv.Len() < dv.Len() && mode == ArrayLengthExact {
+		return &DecodeTypeError{
+			DestType: dv.Type(),
+			SrcType:  sv.Type(),
+			Reason:   fmt.Sprintf("got an array of length %d", sv.Len()),
+		}
+	}
+	return nil
+}
+
 func newArrayDecoder(dt, st reflect.Type) decoderFunc {
 	dec := &arrayDecoder{typeDecoder(dt.Elem(), st.Elem(), true)}
 	return dec.decode
@@ -507,11 +708,11 @@ func (d *mapAsMapDecoder) decode(dv, sv reflect.Value) error {
 
 		err := d.keyDec(dElemKey, sElemKey)
 		if err != nil {
-			return err
+			return withFieldPath(err, fmt.Sprint(sElemKey.Interface()))
 		}
 		err = d.elemDec(dElemVal, sv.MapIndex(sElemKey))
 		if err != nil {
-			return err
+			return withFieldPath(err, fmt.Sprint(sElemKey.Interface()))
 		}
 
 		dv.SetMapIndex(dElemKey, dElemVal)
@@ -573,10 +774,16 @@ func (d *mapAsStructDecoder) decode(dv, sv reflect.Value) error {
 
 				err := fieldDec(dElemVal, sElemVal)
 				if err != nil {
-					return err
+					return withFieldPath(err, kv.String()+"."+strconv.Itoa(compoundField.compoundIndex))
 				}
 			}
 		} else if f != nil {
+			// Keys matching a field exactly take precedence over keys which
+			// only differ by case
+			if !bytes.Equal(f.nameBytes, key) && hasMapKey(sv, f.name) {
+				continue
+			}
+
 			dElemVal := fieldByIndex(dv, f.index)
 			sElemVal := sv.MapIndex(kv)
 
@@ -586,13 +793,18 @@ func (d *mapAsStructDecoder) decode(dv, sv reflect.Value) error {
 
 			err := fieldDec(dElemVal, sElemVal)
 			if err != nil {
-				return err
+				return withFieldPath(err, kv.String())
 			}
 		}
 	}
 	return nil
 }
 
+// hasMapKey returns true if the map sv holds the key name.
+func hasMapKey(sv reflect.Value, name string) bool {
+	return sv.MapIndex(reflect.ValueOf(name).Convert(sv.Type().Key())).IsValid()
+}
+
 func newMapAsStructDecoder(dt, st reflect.Type, blank bool) decoderFunc {
 	fields := cachedTypeFields(dt)
 	se := &mapAsStructDecoder{
@@ -601,7 +813,185 @@ func newMapAsStructDecoder(dt, st reflect.Type, blank bool) decoderFunc {
 		blank:     blank,
 	}
 	for i, f := range fields {
-		se.fieldDecs[i] = typeDecoder(typeByIndex(dt, f.index), st.Elem(), blank)
+		if f.nanoseconds {
+			se.fieldDecs[i] = newNanosecondsDecoder(blank)
+			continue
+		}
+		if f.any {
+			se.fieldDecs[i] = anyDecoder
+			continue
+		}
+		ft := typeByIndex(dt, f.index)
+		if f.text && (ft.Implements(textUnmarshalerType) || reflect.PtrTo(ft).Implements(textUnmarshalerType)) {
+			se.fieldDecs[i] = newTextDecoder(ft, blank)
+			continue
+		}
+		se.fieldDecs[i] = typeDecoder(ft, st.Elem(), blank)
 	}
 	return se.decode
 }
+
+// secondsAsDurationDecoder decodes a number of seconds, or a string holding
+// either a number of seconds or a duration accepted by time.ParseDuration,
+// into a time.Duration
+func secondsAsDurationDecoder(dv, sv reflect.Value) error {
+	var seconds float64
+	switch sv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if sv.Int() > math.MaxInt64/int64(time.Second) || sv.Int() < math.MinInt64/int64(time.Second) {
+			return durationOverflowError(dv, sv)
+		}
+		dv.SetInt(sv.Int() * int64(time.Second))
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if sv.Uint() > math.MaxInt64/uint64(time.Second) {
+			return durationOverflowError(dv, sv)
+		}
+		dv.SetInt(int64(sv.Uint()) * int64(time.Second))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		seconds = sv.Float()
+	case reflect.String:
+		var err error
+		seconds, err = strconv.ParseFloat(sv.String(), 64)
+		if err != nil {
+			d, perr := time.ParseDuration(sv.String())
+			if perr != nil {
+				return &DecodeTypeError{
+					DestType: dv.Type(),
+					SrcType:  sv.Type(),
+					Reason:   err.Error(),
+				}
+			}
+			dv.SetInt(int64(d))
+			return nil
+		}
+	default:
+		return decodeTypeError(dv, sv)
+	}
+
+	// float64(math.MaxInt64) rounds up to 2^63 which doesn't fit in an int64
+	ns := math.Round(seconds * float64(time.Second))
+	if math.IsNaN(ns) || ns >= math.MaxInt64 || ns < math.MinInt64 {
+		return durationOverflowError(dv, sv)
+	}
+	dv.SetInt(int64(ns))
+	return nil
+}
+
+func durationOverflowError(dv, sv reflect.Value) error {
+	return &DecodeTypeError{
+		DestType: dv.Type(),
+		SrcType:  sv.Type(),
+		Reason:   fmt.Sprintf("%v seconds overflows %s", sv.Interface(), dv.Type()),
+	}
+}
+
+// isBigNumberType returns true for big.Int, big.Rat and pointers to them, which
+// are decoded from strings by decodeBigRat rather than UnmarshalText.
+func isBigNumberType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == bigIntType || t == bigRatType
+}
+
+// bigIntDecoder decodes a number, or a string holding a number, into a
+// big.Int. Decimals and fractions must have an integer value.
+func bigIntDecoder(dv, sv reflect.Value) error {
+	r, err := decodeBigRat(sv)
+	if err == nil && !r.IsInt() {
+		err = fmt.Errorf("%s is not an integer", r.RatString())
+	}
+	if err != nil {
+		return &DecodeTypeError{dv.Type(), sv.Type(), err.Error()}
+	}
+
+	dv.Set(reflect.ValueOf(r.Num()).Elem())
+	return nil
+}
+
+// bigRatDecoder decodes a number, or a string holding a decimal or a fraction
+// such as "1/3", into a big.Rat.
+func bigRatDecoder(dv, sv reflect.Value) error {
+	r, err := decodeBigRat(sv)
+	if err != nil {
+		return &DecodeTypeError{dv.Type(), sv.Type(), err.Error()}
+	}
+
+	dv.Set(reflect.ValueOf(r).Elem())
+	return nil
+}
+
+// decodeBigRat converts sv into a big.Rat. Floats are converted from their
+// shortest decimal representation, so 0.1 is decoded as 1/10 rather than the
+// exact value of the float64 closest to 0.1.
+func decodeBigRat(sv reflect.Value) (*big.Rat, error) {
+	var s string
+	switch sv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(sv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(sv.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		f := sv.Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("%v is not a finite number", f)
+		}
+		s = strconv.FormatFloat(f, 'g', -1, sv.Type().Bits())
+	default:
+		s = sv.String()
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	return r, nil
+}
+
+// newNanosecondsDecoder returns a decoder for time.Duration fields, or
+// pointers to them, with the "nanoseconds" tag option which decodes the value
+// like an int64.
+func newNanosecondsDecoder(blank bool) decoderFunc {
+	return func(dv, sv reflect.Value) error {
+		if sv.Kind() == reflect.Interface {
+			if sv.IsNil() {
+				return nullDecoder(dv)
+			}
+			sv = sv.Elem()
+		}
+		if dv.Kind() == reflect.Ptr {
+			if dv.IsNil() {
+				dv.Set(reflect.New(dv.Type().Elem()))
+			}
+			dv = dv.Elem()
+		}
+
+		nv := reflect.New(int64Type).Elem()
+		if err := typeDecoder(int64Type, sv.Type(), blank)(nv, sv); err != nil {
+			return err
+		}
+		dv.SetInt(nv.Int())
+		return nil
+	}
+}
+
+// anyDecoder decodes interface fields with the "any" tag option, the source
+// value is stored as is rather than decoded into the value already held by the
+// field, so the field can hold values of different types.
+func anyDecoder(dv, sv reflect.Value) error {
+	if sv.Kind() == reflect.Interface {
+		sv = sv.Elem()
+	}
+	if !sv.IsValid() {
+		dv.Set(reflect.Zero(dv.Type()))
+		return nil
+	}
+	if !sv.Type().AssignableTo(dv.Type()) {
+		return decodeTypeError(dv, sv)
+	}
+
+	dv.Set(sv)
+	return nil
+}
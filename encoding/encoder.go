@@ -16,6 +16,21 @@ type encoderFunc func(v reflect.Value) (interface{}, error)
 // Encode  traverses the value v recursively and looks for structs. If a struct
 // is found then it is checked for tagged fields and convert to
 // map[string]interface{}
+//
+// time.Duration values are encoded as a number of seconds, struct fields with
+// the "nanoseconds" tag option are encoded as an integer number of nanoseconds
+// instead.
+//
+// json.RawMessage values are parsed and encoded as the JSON value they hold.
+//
+// json.Number values are encoded as numbers, an empty json.Number is encoded
+// as 0 and other values which aren't valid numbers return an error.
+//
+// big.Int and big.Rat values are encoded as numbers or strings, see
+// SetBigNumberFormat.
+//
+// Struct fields with the "text" tag option whose type implements
+// encoding.TextMarshaler are encoded as the string returned by MarshalText.
 func Encode(v interface{}) (ev interface{}, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -33,6 +48,48 @@ func Encode(v interface{}) (ev interface{}, err error) {
 	return encode(reflect.ValueOf(v))
 }
 
+// EncodeOmitEmpty behaves like Encode except that when v is a struct (or a
+// pointer to a struct) every top-level field is treated as if its tag had the
+// "omitempty" option. This is useful when encoding partial documents, for
+// example in an update.
+//
+// The same zero-value heuristic as "omitempty" is used so a field that was
+// explicitly set to its zero value cannot be distinguished from an unset field,
+// use a pointer field if a zero value must be written.
+func EncodeOmitEmpty(v interface{}) (interface{}, error) {
+	ev, err := Encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || rv.Type() == timeType ||
+		rv.Type().Implements(marshalerType) || reflect.PtrTo(rv.Type()).Implements(marshalerType) {
+		return ev, nil
+	}
+
+	m, ok := ev.(map[string]interface{})
+	if !ok {
+		return ev, nil
+	}
+
+	for _, f := range cachedTypeFields(rv.Type()) {
+		if f.compound {
+			continue
+		}
+
+		fv, ok := lookupFieldByIndex(rv, f.index)
+		if !ok || isEmptyFieldValue(fv) {
+			delete(m, f.name)
+		}
+	}
+
+	return m, nil
+}
+
 func encode(v reflect.Value) (interface{}, error) {
 	return valueEncoder(v)(v)
 }
@@ -40,6 +97,9 @@ func encode(v reflect.Value) (interface{}, error) {
 var encoderCache struct {
 	sync.RWMutex
 	m map[reflect.Type]encoderFunc
+	// gen is incremented when the cache is reset, encoders built before the
+	// reset are not cached
+	gen int
 }
 
 func valueEncoder(v reflect.Value) encoderFunc {
@@ -69,6 +129,7 @@ func typeEncoder(t reflect.Type) encoderFunc {
 		wg.Wait()
 		return f(v)
 	}
+	gen := encoderCache.gen
 	encoderCache.Unlock()
 
 	// Compute fields without lock.
@@ -76,7 +137,9 @@ func typeEncoder(t reflect.Type) encoderFunc {
 	f = newTypeEncoder(t, true)
 	wg.Done()
 	encoderCache.Lock()
-	encoderCache.m[t] = f
+	if encoderCache.gen == gen {
+		encoderCache.m[t] = f
+	}
 	encoderCache.Unlock()
 	return f
 }
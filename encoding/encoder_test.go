@@ -2,8 +2,13 @@ package encoding
 
 import (
 	"errors"
+	"github.com/segmentio/encoding/json"
 	"image"
+	"math/big"
+	"net"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -110,6 +115,36 @@ func TestOmitEmpty(t *testing.T) {
 	}
 }
 
+func TestEncodeOmitEmpty(t *testing.T) {
+	type partial struct {
+		ID    string    `rethinkdb:"id"`
+		Name  string    `rethinkdb:"name"`
+		Count int       `rethinkdb:"count"`
+		Tags  []string  `rethinkdb:"tags"`
+		At    time.Time `rethinkdb:"at"`
+		Ptr   *int      `rethinkdb:"ptr"`
+	}
+	zero := 0
+	input := &partial{ID: "1", Ptr: &zero}
+	want := map[string]interface{}{"id": "1", "ptr": int64(0)}
+
+	out, err := EncodeOmitEmpty(input)
+	if err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %#v, want %#v", out, want)
+	}
+
+	out, err = EncodeOmitEmpty([]int{0})
+	if err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{int64(0)}) {
+		t.Errorf("got %#v, want non-struct values unchanged", out)
+	}
+}
+
 type IntType int
 
 type MyStruct struct {
@@ -326,6 +361,107 @@ func TestReferenceFieldInvalid(t *testing.T) {
 	}
 }
 
+type RefG struct {
+	ID   string   `rethinkdb:"id,omitempty"`
+	User *RefUser `rethinkdb:"user_email,reference"`
+}
+
+type RefUser struct {
+	Email string `rethinkdb:"email,omitempty,primary"`
+	Name  string `rethinkdb:"name"`
+}
+
+func TestReferenceFieldPrimaryKey(t *testing.T) {
+	input := RefG{"1", &RefUser{"a@example.com", "Name"}}
+	want := map[string]interface{}{"id": "1", "user_email": "a@example.com"}
+
+	out, err := Encode(input)
+	if err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+	if !jsonEqual(out, want) {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestPrimaryKey(t *testing.T) {
+	if got := PrimaryKey(reflect.TypeOf(&RefUser{})); got != "email" {
+		t.Errorf("got %q, want %q", got, "email")
+	}
+	if got := PrimaryKey(reflect.TypeOf(RefB{})); got != DefaultPrimaryKey {
+		t.Errorf("got %q, want %q", got, DefaultPrimaryKey)
+	}
+}
+
+func TestAssignGeneratedKeys(t *testing.T) {
+	docs := []*RefB{{Name: "a"}, {ID: "set", Name: "b"}, {Name: "c"}}
+	if err := AssignGeneratedKeys(docs, []string{"k1", "k2"}); err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+	if docs[0].ID != "k1" || docs[1].ID != "set" || docs[2].ID != "k2" {
+		t.Errorf("got ids %q, %q, %q", docs[0].ID, docs[1].ID, docs[2].ID)
+	}
+
+	users := []RefUser{{Name: "a"}}
+	if err := AssignGeneratedKeys(&users, []string{"k1"}); err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+	if users[0].Email != "k1" {
+		t.Errorf("got %q, want %q", users[0].Email, "k1")
+	}
+
+	if err := AssignGeneratedKeys(RefB{}, []string{"k1"}); err == nil {
+		t.Errorf("expected non-nil error but got nil")
+	}
+}
+
+func TestDocumentKeys(t *testing.T) {
+	docs := []*RefB{{Name: "a"}, {ID: "set", Name: "b"}, {Name: "c"}}
+	keys, err := DocumentKeys(docs, []string{"k1", "k2"})
+	if err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+	if want := []interface{}{"k1", "set", "k2"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got %v, want %v", keys, want)
+	}
+	if docs[0].ID != "" {
+		t.Errorf("got id %q, expected docs to be left unchanged", docs[0].ID)
+	}
+
+	users := []RefUser{{Name: "a", Email: "a@example.com"}}
+	keys, err = DocumentKeys(&users, nil)
+	if err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+	if want := []interface{}{"a@example.com"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got %v, want %v", keys, want)
+	}
+
+	// A zero key without omitempty is sent to the server and is a real key
+	counters := []RefCounter{{ID: 0}, {ID: 7}}
+	keys, err = DocumentKeys(counters, nil)
+	if err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+	if want := []interface{}{0, 7}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got %v, want %v", keys, want)
+	}
+
+	if _, err := DocumentKeys(docs, []string{"k1"}); err == nil {
+		t.Errorf("expected non-nil error but got nil")
+	}
+	if _, err := DocumentKeys(docs, []string{"k1", "k2", "k3"}); err == nil {
+		t.Errorf("expected non-nil error but got nil")
+	}
+	if _, err := DocumentKeys(RefB{}, nil); err == nil {
+		t.Errorf("expected non-nil error but got nil")
+	}
+}
+
+type RefCounter struct {
+	ID int `rethinkdb:"id"`
+}
+
 type RefE struct {
 	ID   string  `rethinkdb:"id,omitempty"`
 	FIDs *[]RefF `rethinkdb:"f_ids,reference" rethinkdb_ref:"id"`
@@ -547,3 +683,311 @@ func TestEncodeCustomRootTypeEncodingError(t *testing.T) {
 		t.Errorf("got %q, want %q", err, cerr)
 	}
 }
+
+type TagsTest struct {
+	A string `rethinkdb:"a"`
+	B string `json:"b"`
+	C string `rethinkdb:"c1" json:"c2"`
+}
+
+func TestEncodeTagPriority(t *testing.T) {
+	defer SetTags(nil)
+
+	tests := []struct {
+		tags []string
+		want map[string]interface{}
+	}{
+		{nil, map[string]interface{}{"a": "1", "B": "2", "c1": "3"}},
+		{[]string{TagName, OldTagName}, map[string]interface{}{"a": "1", "B": "2", "c1": "3"}},
+		{[]string{TagName, JSONTagName}, map[string]interface{}{"a": "1", "b": "2", "c1": "3"}},
+		{[]string{JSONTagName, TagName}, map[string]interface{}{"a": "1", "b": "2", "c2": "3"}},
+		{[]string{JSONTagName}, map[string]interface{}{"A": "1", "b": "2", "c2": "3"}},
+	}
+
+	for _, tt := range tests {
+		SetTags(tt.tags)
+
+		v, err := Encode(TagsTest{"1", "2", "3"})
+		if err != nil {
+			t.Fatalf("Encode with tags %v: %s", tt.tags, err)
+		}
+		if !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("Encode with tags %v: got %v, want %v", tt.tags, v, tt.want)
+		}
+
+		var got TagsTest
+		if err := Decode(&got, tt.want); err != nil {
+			t.Fatalf("Decode with tags %v: %s", tt.tags, err)
+		}
+		if got != (TagsTest{"1", "2", "3"}) {
+			t.Errorf("Decode with tags %v: got %+v", tt.tags, got)
+		}
+	}
+}
+
+func TestSetTagsConcurrent(t *testing.T) {
+	defer SetTags(nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if (i+j)%2 == 0 {
+					SetTags([]string{JSONTagName, TagName})
+				} else {
+					SetTags([]string{TagName, JSONTagName})
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				v, err := Encode(TagsTest{"1", "2", "3"})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if m := v.(map[string]interface{}); m["c1"] == nil && m["c2"] == nil {
+					t.Errorf("got %v", m)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSetTagsNoStaleEncoders(t *testing.T) {
+	defer SetTags(nil)
+
+	// Every type is new so encoders and decoders are being built when the
+	// tags are changed, none built with the previous tags may be cached. The
+	// types have many fields so that they take a while to build.
+	types := make([]reflect.Type, 400)
+	for i := range types {
+		fields := make([]reflect.StructField, 50)
+		for j := range fields {
+			fields[j] = reflect.StructField{
+				Name: "F" + strconv.Itoa(i) + "_" + strconv.Itoa(j),
+				Type: stringType,
+				Tag:  reflect.StructTag(`json:"j` + strconv.Itoa(j) + `" rethinkdb:"r` + strconv.Itoa(j) + `"`),
+			}
+		}
+		types[i] = reflect.StructOf(fields)
+	}
+
+	SetTags(nil)
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < len(types); i += 4 {
+				if i == len(types)/4 {
+					close(started)
+				}
+				if _, err := Encode(reflect.New(types[i]).Elem().Interface()); err != nil {
+					t.Error(err)
+				}
+				if err := Decode(reflect.New(types[i]).Interface(), map[string]interface{}{}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(g)
+	}
+	<-started
+	SetTags([]string{JSONTagName})
+	wg.Wait()
+
+	for _, typ := range types {
+		v, err := Encode(reflect.New(typ).Elem().Interface())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := v.(map[string]interface{})["j0"]; !ok {
+			t.Fatalf("Encode %v: got %v, want key j0", typ, v)
+		}
+
+		dv := reflect.New(typ)
+		if err := Decode(dv.Interface(), map[string]interface{}{"j0": "1"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := dv.Elem().Field(0).String(); got != "1" {
+			t.Fatalf("Decode %v: got %q, want %q", typ, got, "1")
+		}
+	}
+}
+
+func TestEncodeDuration(t *testing.T) {
+	ns := 1500 * time.Nanosecond
+	got, err := Encode(DurationT{Seconds: 1500 * time.Millisecond, Nanoseconds: ns, Ptr: &ns})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"seconds": 1.5, "nanoseconds": int64(1500), "ptr": int64(1500)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	got, err = Encode(DurationT{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]interface{}{"seconds": 0.0, "nanoseconds": int64(0), "ptr": nil}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	var decoded DurationT
+	if err := Decode(&decoded, map[string]interface{}{"seconds": 1.5, "nanoseconds": int64(1500), "ptr": int64(1500)}); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Seconds != 1500*time.Millisecond || decoded.Nanoseconds != ns || *decoded.Ptr != ns {
+		t.Errorf("round trip: got %+v", decoded)
+	}
+}
+
+func TestEncodeTextMarshaler(t *testing.T) {
+	green := Color("green")
+	got, err := Encode(ColorT{Color: "red", Ptr: &green, Plain: "blue"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"color": "RED", "ptr": "GREEN", "plain": "blue"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	if _, err := Encode(ColorT{Color: "blue"}); err == nil {
+		t.Error("expected error for invalid color")
+	}
+
+	// Types implementing encoding.TextMarshaler without the "text" tag option
+	// are encoded as before
+	deleted := time.Unix(1577934245, 0).UTC()
+	got, err = Encode(struct {
+		Deleted *time.Time `rethinkdb:"deleted"`
+		IP      net.IP     `rethinkdb:"ip"`
+	}{&deleted, net.IPv4(10, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]interface{}{
+		"deleted": map[string]interface{}{"$reql_type$": "TIME", "epoch_time": 1577934245.0, "timezone": "+00:00"},
+		"ip":      map[string]interface{}{"$reql_type$": "BINARY", "data": "AAAAAAAAAAAAAP//CgAAAQ=="},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestEncodeRawMessage(t *testing.T) {
+	got, err := Encode(RawT{ID: 1, Body: json.RawMessage(`{"tags":["a",2.5],"n":null}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"id":   int64(1),
+		"body": map[string]interface{}{"tags": []interface{}{"a", 2.5}, "n": nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	got, err = Encode(RawT{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]interface{}{"id": int64(1), "body": nil}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	if _, err := Encode(RawT{Body: json.RawMessage(`{`)}); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
+
+type BigT struct {
+	Int    big.Int  `rethinkdb:"int"`
+	IntPtr *big.Int `rethinkdb:"int_ptr"`
+	Rat    *big.Rat `rethinkdb:"rat"`
+}
+
+func TestEncodeBigNumbers(t *testing.T) {
+	large, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	exact := new(big.Int).Lsh(big.NewInt(1), 53)
+
+	got, err := Encode(BigT{Int: *exact, IntPtr: large, Rat: big.NewRat(1, 3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"int":     int64(1) << 53,
+		"int_ptr": "123456789012345678901234567890",
+		"rat":     "1/3",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	got, err = Encode(BigT{Int: *new(big.Int).Add(exact, big.NewInt(1)), Rat: big.NewRat(3, 4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]interface{}{"int": "9007199254740993", "int_ptr": nil, "rat": 0.75}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	defer SetBigNumberFormat(BigNumberAuto)
+
+	SetBigNumberFormat(BigNumberString)
+	got, err = Encode(BigT{Int: *big.NewInt(1), Rat: big.NewRat(3, 4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]interface{}{"int": "1", "int_ptr": nil, "rat": "3/4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	SetBigNumberFormat(BigNumberFloat)
+	got, err = Encode(BigT{Int: *large, Rat: big.NewRat(1, 3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]interface{}{"int": 1.2345678901234568e+29, "int_ptr": nil, "rat": 1.0 / 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestEncodeJSONNumber(t *testing.T) {
+	type doc struct {
+		N json.Number `rethinkdb:"n"`
+	}
+
+	got, err := Encode(doc{N: "12345678901234567890"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, map[string]interface{}{"n": json.Number("12345678901234567890")}) {
+		t.Errorf("got %#v", got)
+	}
+
+	for n, want := range map[json.Number]json.Number{"": "0", "-1.5e3": "-1.5e3", "1e999": "1e999"} {
+		got, err := Encode(n)
+		if err != nil || got != want {
+			t.Errorf("%q: got %#v, %v", n, got, err)
+		}
+	}
+
+	for _, n := range []json.Number{"abc", "true", "1 2", "0x10"} {
+		if _, err := Encode(n); err == nil {
+			t.Errorf("%q: expected an error", n)
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package encoding
 
 import (
+	"encoding"
 	"encoding/base64"
 	"fmt"
 	"math"
+	"math/big"
 	"reflect"
 	"time"
+
+	"github.com/segmentio/encoding/json"
 )
 
 // newTypeEncoder constructs an encoderFunc for a type.
@@ -24,6 +28,16 @@ func newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
 	switch t {
 	case timeType:
 		return timePseudoTypeEncoder
+	case durationType:
+		return durationEncoder
+	case rawMessageType:
+		return rawMessageEncoder
+	case numberType:
+		return numberEncoder
+	case bigIntType, reflect.PtrTo(bigIntType):
+		return bigIntEncoder
+	case bigRatType, reflect.PtrTo(bigRatType):
+		return bigRatEncoder
 	}
 
 	switch t.Kind() {
@@ -97,6 +111,63 @@ func addrMarshalerEncoder(v reflect.Value) (interface{}, error) {
 	return ev, nil
 }
 
+func textMarshalerEncoder(v reflect.Value) (interface{}, error) {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, nil
+	}
+	m := v.Interface().(encoding.TextMarshaler)
+	b, err := m.MarshalText()
+	if err != nil {
+		return nil, &MarshalerError{v.Type(), err}
+	}
+
+	return string(b), nil
+}
+
+func addrTextMarshalerEncoder(v reflect.Value) (interface{}, error) {
+	va := v.Addr()
+	if va.IsNil() {
+		return nil, nil
+	}
+	m := va.Interface().(encoding.TextMarshaler)
+	b, err := m.MarshalText()
+	if err != nil {
+		return nil, &MarshalerError{v.Type(), err}
+	}
+
+	return string(b), nil
+}
+
+// numberEncoder encodes a json.Number so that it is sent to the server as a
+// number, an empty json.Number is sent as 0.
+func numberEncoder(v reflect.Value) (interface{}, error) {
+	n := json.Number(v.String())
+	if n == "" {
+		return json.Number("0"), nil
+	}
+	if c := n[0]; c != '-' && (c < '0' || c > '9') || !json.Valid([]byte(n)) {
+		return nil, &UnsupportedValueError{v, fmt.Sprintf("invalid number %q", n)}
+	}
+
+	return n, nil
+}
+
+// rawMessageEncoder parses the JSON of a json.RawMessage so that it is sent
+// to the server as is, pseudo-types are left unchanged.
+func rawMessageEncoder(v reflect.Value) (interface{}, error) {
+	raw := v.Interface().(json.RawMessage)
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var ev interface{}
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		return nil, &MarshalerError{v.Type(), err}
+	}
+
+	return ev, nil
+}
+
 func boolEncoder(v reflect.Value) (interface{}, error) {
 	if v.Bool() {
 		return true, nil
@@ -109,6 +180,94 @@ func intEncoder(v reflect.Value) (interface{}, error) {
 	return v.Int(), nil
 }
 
+// durationEncoder encodes a time.Duration as a number of seconds
+func durationEncoder(v reflect.Value) (interface{}, error) {
+	return time.Duration(v.Int()).Seconds(), nil
+}
+
+// nanosecondsEncoder encodes a time.Duration field, or a pointer to one, as a
+// number of nanoseconds
+func nanosecondsEncoder(v reflect.Value) (interface{}, error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	return v.Int(), nil
+}
+
+// maxExactFloatInt is the largest integer, 2^53, below which every integer can
+// be stored exactly as a float64
+var maxExactFloatInt = big.NewInt(1 << 53)
+
+// bigIntEncoder encodes a big.Int, or a pointer to one, using the format set by
+// SetBigNumberFormat
+func bigIntEncoder(v reflect.Value) (interface{}, error) {
+	x, ok := bigValue(v).(*big.Int)
+	if !ok {
+		return nil, nil
+	}
+	return encodeBigInt(x), nil
+}
+
+func encodeBigInt(x *big.Int) interface{} {
+	switch currentBigNumberFormat() {
+	case BigNumberString:
+		return x.String()
+	case BigNumberFloat:
+		f, _ := new(big.Float).SetInt(x).Float64()
+		return f
+	}
+
+	if x.CmpAbs(maxExactFloatInt) <= 0 {
+		return x.Int64()
+	}
+	return x.String()
+}
+
+// bigRatEncoder encodes a big.Rat, or a pointer to one, using the format set by
+// SetBigNumberFormat
+func bigRatEncoder(v reflect.Value) (interface{}, error) {
+	x, ok := bigValue(v).(*big.Rat)
+	if !ok {
+		return nil, nil
+	}
+
+	switch currentBigNumberFormat() {
+	case BigNumberString:
+		return x.RatString(), nil
+	case BigNumberFloat:
+		f, _ := x.Float64()
+		return f, nil
+	}
+
+	if x.IsInt() {
+		return encodeBigInt(x.Num()), nil
+	}
+	if f, exact := x.Float64(); exact {
+		return f, nil
+	}
+	return x.RatString(), nil
+}
+
+// bigValue returns a pointer to the big.Int or big.Rat held by v, or nil if v
+// is a nil pointer.
+func bigValue(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		return v.Interface()
+	}
+	if v.CanAddr() {
+		return v.Addr().Interface()
+	}
+	pv := reflect.New(v.Type())
+	pv.Elem().Set(v)
+	return pv.Interface()
+}
+
 func uintEncoder(v reflect.Value) (interface{}, error) {
 	return v.Uint(), nil
 }
@@ -195,6 +354,8 @@ func getReferenceField(f field, v reflect.Value, encField interface{}) interface
 	refName := f.name
 	if f.refName != "" {
 		refName = f.refName
+	} else if pk, ok := primaryKeyField(referencedType(f.typ)); ok {
+		refName = pk.name
 	}
 
 	encFields, isArray := encField.([]interface{})
@@ -226,6 +387,11 @@ func extractValue(encField interface{}, v reflect.Value, name string, refName st
 }
 
 func (se *structEncoder) isEmptyValue(v reflect.Value) bool {
+	return isEmptyFieldValue(v)
+}
+
+// isEmptyFieldValue extends isEmptyValue by treating the zero time as empty.
+func isEmptyFieldValue(v reflect.Value) bool {
 	if v.Type() == timeType {
 		return v.Interface().(time.Time) == time.Time{}
 	}
@@ -240,7 +406,22 @@ func newStructEncoder(t reflect.Type) encoderFunc {
 		fieldEncs: make([]encoderFunc, len(fields)),
 	}
 	for i, f := range fields {
-		se.fieldEncs[i] = typeEncoder(typeByIndex(t, f.index))
+		if f.nanoseconds {
+			se.fieldEncs[i] = nanosecondsEncoder
+			continue
+		}
+		ft := typeByIndex(t, f.index)
+		if f.text {
+			if ft.Implements(textMarshalerType) {
+				se.fieldEncs[i] = textMarshalerEncoder
+				continue
+			}
+			if ft.Kind() != reflect.Ptr && reflect.PtrTo(ft).Implements(textMarshalerType) {
+				se.fieldEncs[i] = newCondAddrEncoder(addrTextMarshalerEncoder, typeEncoder(ft))
+				continue
+			}
+		}
+		se.fieldEncs[i] = typeEncoder(ft)
 	}
 	return se.encode
 }
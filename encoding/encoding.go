@@ -1,17 +1,41 @@
 package encoding
 
 import (
+	"database/sql"
+	"encoding"
+	"math/big"
 	"reflect"
+	"sync/atomic"
 	"time"
+
+	"github.com/segmentio/encoding/json"
 )
 
 var (
 	// type constants
 	stringType = reflect.TypeOf("")
 	timeType   = reflect.TypeOf(new(time.Time)).Elem()
+	// durationType values are stored as a number of seconds, or nanoseconds
+	// for struct fields with the "nanoseconds" tag option
+	durationType = reflect.TypeOf(time.Duration(0))
+	int64Type    = reflect.TypeOf(int64(0))
+	// rawMessageType values hold the JSON of a value, they are encoded by
+	// parsing the JSON and decoded by re-encoding the value as JSON
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+	// numberType values are stored as numbers rather than strings
+	numberType = reflect.TypeOf(json.Number(""))
+	// bigIntType and bigRatType values, and pointers to them, are stored as
+	// numbers or strings, see SetBigNumberFormat
+	bigIntType = reflect.TypeOf(big.Int{})
+	bigRatType = reflect.TypeOf(big.Rat{})
 
 	marshalerType   = reflect.TypeOf(new(Marshaler)).Elem()
 	unmarshalerType = reflect.TypeOf(new(Unmarshaler)).Elem()
+	scannerType     = reflect.TypeOf(new(sql.Scanner)).Elem()
+	// textMarshalerType and textUnmarshalerType values are stored as strings
+	// in struct fields with the "text" tag option
+	textMarshalerType   = reflect.TypeOf(new(encoding.TextMarshaler)).Elem()
+	textUnmarshalerType = reflect.TypeOf(new(encoding.TextUnmarshaler)).Elem()
 
 	emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
 	mapInterfaceType   = reflect.TypeOf((map[string]interface{})(nil))
@@ -29,41 +53,192 @@ type Unmarshaler interface {
 	UnmarshalRQL(interface{}) error
 }
 
+// customEncoders and customDecoders hold the encoders and decoders set by
+// IgnoreType and SetTypeEncoding, these are kept when the caches are reset.
+// They are protected by the encoderCache and decoderCache locks.
+var (
+	customEncoders = map[reflect.Type]encoderFunc{}
+	customDecoders = map[decoderCacheKey]decoderFunc{}
+)
+
 func init() {
 	encoderCache.m = make(map[reflect.Type]encoderFunc)
 	decoderCache.m = make(map[decoderCacheKey]decoderFunc)
 }
 
+// resetCaches clears the cached encoders and decoders, except for the ones
+// set by IgnoreType and SetTypeEncoding. Encoders and decoders which were
+// being built when the caches were reset are not cached, as they may have
+// been built using the previous struct fields.
+func resetCaches() {
+	encoderCache.Lock()
+	encoderCache.gen++
+	encoderCache.m = make(map[reflect.Type]encoderFunc, len(customEncoders))
+	for t, f := range customEncoders {
+		encoderCache.m[t] = f
+	}
+	encoderCache.Unlock()
+
+	decoderCache.Lock()
+	decoderCache.gen++
+	decoderCache.m = make(map[decoderCacheKey]decoderFunc, len(customDecoders))
+	for k, f := range customDecoders {
+		decoderCache.m[k] = f
+	}
+	decoderCache.Unlock()
+}
+
+// customTypeDecoder returns the decoder set by SetTypeEncoding for dt, or nil.
+func customTypeDecoder(dt reflect.Type) decoderFunc {
+	decoderCache.RLock()
+	defer decoderCache.RUnlock()
+
+	return customDecoders[decoderCacheKey{dt: dt, st: emptyInterfaceType}]
+}
+
+// BigNumberFormat controls how big.Int and big.Rat values are encoded, see
+// SetBigNumberFormat.
+type BigNumberFormat int32
+
+const (
+	// BigNumberAuto encodes values which can be stored exactly as a float64
+	// as numbers and other values as strings. This is the default.
+	BigNumberAuto BigNumberFormat = iota
+	// BigNumberString always encodes values as strings, big.Int values as
+	// decimal integers and big.Rat values as fractions such as "1/3".
+	BigNumberString
+	// BigNumberFloat always encodes values as numbers, values which can't be
+	// stored exactly as a float64 are rounded.
+	BigNumberFloat
+)
+
+var bigNumberFormat int32
+
+// SetBigNumberFormat sets how big.Int and big.Rat values, and pointers to them,
+// are encoded. RethinkDB stores numbers as 64-bit floats, so integers beyond
+// 2^53 and most fractions can't be stored exactly as numbers. The default,
+// BigNumberAuto, keeps every value exact but stores large values as strings,
+// a field may then hold both numbers and strings which compare and sort
+// differently in queries. BigNumberString keeps a single type at the cost of
+// arithmetic in queries, BigNumberFloat keeps numbers at the cost of
+// precision.
+//
+// Values are decoded from numbers or from strings holding an integer, a
+// decimal or a fraction, whatever the format. Numbers are decoded from a
+// float64 unless the connection uses UseJSONNumber, in which case the digits
+// sent by the server are used, but these never hold more precision than the
+// server stored.
+func SetBigNumberFormat(format BigNumberFormat) {
+	atomic.StoreInt32(&bigNumberFormat, int32(format))
+}
+
+func currentBigNumberFormat() BigNumberFormat {
+	return BigNumberFormat(atomic.LoadInt32(&bigNumberFormat))
+}
+
+// ArrayLengthMode controls how arrays whose length differs from the length of
+// a Go array destination, such as [2]float64, are decoded, see
+// SetArrayLengthMode.
+type ArrayLengthMode int32
+
+const (
+	// ArrayLengthAny zero-fills the destination when the array is shorter and
+	// ignores the extra values when it is longer, like encoding/json. This is
+	// the default.
+	ArrayLengthAny ArrayLengthMode = iota
+	// ArrayLengthAtMost zero-fills the destination when the array is shorter
+	// and returns an error when it is longer.
+	ArrayLengthAtMost
+	// ArrayLengthExact returns an error unless the array has the length of
+	// the destination.
+	ArrayLengthExact
+)
+
+var arrayLengthMode int32
+
+// SetArrayLengthMode sets how arrays are decoded into Go arrays of a
+// different length. Decoding fails with a DecodeTypeError when the length
+// isn't allowed by mode, which is useful for fixed-shape data such as
+// coordinates where a missing or extra value is a mistake.
+func SetArrayLengthMode(mode ArrayLengthMode) {
+	atomic.StoreInt32(&arrayLengthMode, int32(mode))
+}
+
+func currentArrayLengthMode() ArrayLengthMode {
+	return ArrayLengthMode(atomic.LoadInt32(&arrayLengthMode))
+}
+
 // IgnoreType causes the encoder to ignore a type when encoding
 func IgnoreType(t reflect.Type) {
 	encoderCache.Lock()
 	encoderCache.m[t] = doNothingEncoder
+	customEncoders[t] = doNothingEncoder
 	encoderCache.Unlock()
 }
 
+// SetTypeEncoding sets the functions used to encode and decode values of type
+// t, replacing the default encoding. encode is passed the value and returns
+// the value sent to the database. decode is passed the value read from the
+// database, after pseudo-types have been converted (so a TIME is passed as a
+// time.Time), and must set value, which has type t. Either function may be
+// nil if only one direction is customised. For example a Date type stored as
+// a RethinkDB time can be truncated to the day in UTC:
+//
+//	type Date struct{ time.Time }
+//
+//	encoding.SetTypeEncoding(reflect.TypeOf(Date{}),
+//		func(v interface{}) (interface{}, error) {
+//			return v.(Date).Time, nil
+//		},
+//		func(encoded interface{}, value reflect.Value) error {
+//			t, ok := encoded.(time.Time)
+//			if !ok {
+//				return fmt.Errorf("cannot decode %T into Date", encoded)
+//			}
+//			value.Set(reflect.ValueOf(Date{t.UTC().Truncate(24 * time.Hour)}))
+//			return nil
+//		},
+//	)
+//
+// Type encodings are configured for the whole process and are kept when the
+// struct tags are changed.
 func SetTypeEncoding(
 	t reflect.Type,
 	encode func(value interface{}) (interface{}, error),
 	decode func(encoded interface{}, value reflect.Value) error,
 ) {
-	encoderCache.Lock()
-	encoderCache.m[t] = func(v reflect.Value) (interface{}, error) {
-		return encode(v.Interface())
+	if encode != nil {
+		enc := func(v reflect.Value) (interface{}, error) {
+			return encode(v.Interface())
+		}
+		encoderCache.Lock()
+		encoderCache.m[t] = enc
+		customEncoders[t] = enc
+		encoderCache.Unlock()
+	}
+	if decode == nil {
+		return
 	}
-	encoderCache.Unlock()
 
 	dec := func(dv reflect.Value, sv reflect.Value) error {
 		return decode(sv.Interface(), dv)
 	}
-	decoderCache.Lock()
-	// decode as pointer
-	decoderCache.m[decoderCacheKey{dt: t, st: emptyInterfaceType}] = dec
-	// decode as value
-	decoderCache.m[decoderCacheKey{dt: t, st: mapInterfaceType}] = dec
-
+	keys := []decoderCacheKey{
+		// decode as pointer
+		{dt: t, st: emptyInterfaceType},
+		// decode as value
+		{dt: t, st: mapInterfaceType},
+	}
 	if t.Kind() == reflect.Ptr {
-		decoderCache.m[decoderCacheKey{dt: t.Elem(), st: emptyInterfaceType}] = dec
-		decoderCache.m[decoderCacheKey{dt: t.Elem(), st: mapInterfaceType}] = dec
+		keys = append(keys,
+			decoderCacheKey{dt: t.Elem(), st: emptyInterfaceType},
+			decoderCacheKey{dt: t.Elem(), st: mapInterfaceType},
+		)
+	}
+	decoderCache.Lock()
+	for _, k := range keys {
+		decoderCache.m[k] = dec
+		customDecoders[k] = dec
 	}
 	decoderCache.Unlock()
 }
@@ -45,6 +45,41 @@ func (e *DecodeTypeError) Error() string {
 	}
 }
 
+// A DecodeError is returned by Decode when a value nested in the source, such
+// as a struct field or an array element, could not be decoded. FieldPath is
+// the dotted path of the value in the source, such as "author.tags.1", and
+// Cause is the error returned when decoding it. DocIndex is the index of the
+// document in the result set when returned by Cursor.All, or -1.
+type DecodeError struct {
+	DocIndex  int
+	FieldPath string
+	Cause     error
+}
+
+func (e *DecodeError) Error() string {
+	msg := "rethinkdb: "
+	if e.DocIndex >= 0 {
+		msg += fmt.Sprintf("document %d: ", e.DocIndex)
+	}
+	if e.FieldPath != "" {
+		msg += "field " + e.FieldPath + ": "
+	}
+	return msg + strings.TrimPrefix(e.Cause.Error(), "rethinkdb: ")
+}
+
+// Unwrap returns the error which caused the value to not be decoded.
+func (e *DecodeError) Unwrap() error {
+	return e.Cause
+}
+
+// withFieldPath returns err as a DecodeError with name prepended to its path.
+func withFieldPath(err error, name string) error {
+	if e, ok := err.(*DecodeError); ok {
+		return &DecodeError{DocIndex: e.DocIndex, FieldPath: name + "." + e.FieldPath, Cause: e.Cause}
+	}
+	return &DecodeError{DocIndex: -1, FieldPath: name, Cause: err}
+}
+
 // An UnsupportedTypeError is returned by Marshal when attempting
 // to encode an unsupported value type.
 type UnsupportedTypeError struct {
@@ -0,0 +1,179 @@
+package encoding
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// DefaultPrimaryKey is the name of the primary key used by RethinkDB when a
+// table is created without the primary_key option.
+const DefaultPrimaryKey = "id"
+
+// PrimaryKey returns the name of the primary key field of the struct type t.
+// The primary key is the field tagged with the "primary" option, for example
+// `rethinkdb:"email,primary"`, if no field is tagged then DefaultPrimaryKey is
+// returned.
+//
+// A primary key field should normally use omitempty, for example
+// `rethinkdb:"id,omitempty,primary"`, so that RethinkDB generates a key when it
+// is not set, see AssignGeneratedKeys.
+func PrimaryKey(t reflect.Type) string {
+	if t == nil {
+		return DefaultPrimaryKey
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if pk, ok := primaryKeyField(t); ok {
+		return pk.name
+	}
+
+	return DefaultPrimaryKey
+}
+
+// AssignGeneratedKeys sets the primary key field of each document in docs
+// (a slice of structs or pointers to structs) whose primary key was left out
+// when inserting, see keyOmitted, to the next key from keys. Keys are assigned in order which matches the
+// order of the generated keys returned by RethinkDB for an insert.
+func AssignGeneratedKeys(docs interface{}, keys []string) error {
+	dv, f, err := primaryKeyDocs(docs, "assign generated keys to")
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < dv.Len() && len(keys) > 0; i++ {
+		v := dv.Index(i)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				continue
+			}
+			v = v.Elem()
+		}
+
+		fv, ok := lookupFieldByIndex(v, f.index)
+		if !ok || !fv.CanSet() || !keyOmitted(f, fv) {
+			continue
+		}
+		if fv.Kind() != reflect.String {
+			return fmt.Errorf("rethinkdb: cannot assign generated key to field %s of type %s", f.name, fv.Type())
+		}
+
+		fv.SetString(keys[0])
+		keys = keys[1:]
+	}
+
+	return nil
+}
+
+// DocumentKeys returns the primary key of each document in docs (a slice of
+// structs or pointers to structs), in order. Documents whose primary key was
+// left out when inserting, see keyOmitted, take the next key from keys, which
+// should be the keys generated by RethinkDB for the insert of docs. An error
+// is returned if the number of documents without a primary key does not match
+// len(keys).
+func DocumentKeys(docs interface{}, keys []string) ([]interface{}, error) {
+	dv, f, err := primaryKeyDocs(docs, "read keys of")
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]interface{}, dv.Len())
+	for i := range res {
+		v := dv.Index(i)
+		if v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+
+		fv, ok := reflect.Value{}, false
+		if v.Kind() == reflect.Struct {
+			fv, ok = lookupFieldByIndex(v, f.index)
+		}
+		if ok && !keyOmitted(f, fv) {
+			res[i] = fv.Interface()
+			continue
+		}
+
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("rethinkdb: cannot read keys of %s, not enough generated keys", dv.Type())
+		}
+		res[i] = keys[0]
+		keys = keys[1:]
+	}
+	if len(keys) > 0 {
+		return nil, fmt.Errorf("rethinkdb: cannot read keys of %s, %d unused generated keys", dv.Type(), len(keys))
+	}
+
+	return res, nil
+}
+
+// keyOmitted returns true if the primary key field f with value v is left out
+// of the encoded document, so that RethinkDB generates a key. Like the encoder
+// it only leaves out empty fields tagged with omitempty, a zero key such as 0
+// is sent to the server and used as the key otherwise.
+func keyOmitted(f field, v reflect.Value) bool {
+	return f.omitEmpty && isEmptyFieldValue(v)
+}
+
+// primaryKeyDocs returns the slice value of docs and the primary key field of
+// its element type, op describes the caller for error messages.
+func primaryKeyDocs(docs interface{}, op string) (reflect.Value, field, error) {
+	dv := reflect.ValueOf(docs)
+	if dv.Kind() == reflect.Ptr {
+		dv = dv.Elem()
+	}
+	if dv.Kind() != reflect.Slice && dv.Kind() != reflect.Array {
+		return dv, field{}, fmt.Errorf("rethinkdb: cannot %s %s, expected a slice", op, dv.Type())
+	}
+
+	t := referencedType(dv.Type())
+	if t.Kind() != reflect.Struct {
+		return dv, field{}, fmt.Errorf("rethinkdb: cannot %s %s, expected a slice of structs", op, dv.Type())
+	}
+
+	f, ok := primaryKeyField(t)
+	if !ok {
+		if f, ok = fieldByName(t, DefaultPrimaryKey); !ok {
+			return dv, field{}, fmt.Errorf("rethinkdb: cannot %s %s, no primary key field", op, t)
+		}
+	}
+
+	return dv, f, nil
+}
+
+func primaryKeyField(t reflect.Type) (field, bool) {
+	if t.Kind() != reflect.Struct {
+		return field{}, false
+	}
+
+	for _, f := range cachedTypeFields(t) {
+		if f.primary {
+			return f, true
+		}
+	}
+
+	return field{}, false
+}
+
+func fieldByName(t reflect.Type, name string) (field, bool) {
+	for _, f := range cachedTypeFields(t) {
+		if f.name == name && !f.compound {
+			return f, true
+		}
+	}
+
+	return field{}, false
+}
+
+// referencedType returns the struct type referenced by t following pointers,
+// slices and arrays.
+func referencedType(t reflect.Type) reflect.Type {
+	for {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array:
+			t = t.Elem()
+		default:
+			return t
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 )
 
 var (
+	// Tags holds the tags set by SetTags, it must not be modified directly.
 	Tags []string
 )
 
@@ -25,8 +26,30 @@ const (
 // tag, or the empty string. It does not include the leading comma.
 type tagOptions string
 
-func getTag(sf reflect.StructField) string {
-	if Tags == nil {
+// SetTags sets the tags checked when encoding or decoding struct fields, in
+// order of priority. Passing nil restores the default of checking the
+// rethinkdb and gorethink tags. Cached struct fields, encoders and decoders
+// are cleared so the new tags take effect immediately.
+//
+// The tags are used by the whole process, SetTags should be called once
+// before encoding or decoding values, such as in an init function. Values
+// encoded or decoded while SetTags is running may use either the previous or
+// the new tags.
+func SetTags(tags []string) {
+	fieldCache.Lock()
+	Tags = append([]string(nil), tags...)
+	if tags == nil {
+		Tags = nil
+	}
+	fieldCache.m = nil
+	fieldCache.gen++
+	fieldCache.Unlock()
+
+	resetCaches()
+}
+
+func getTag(sf reflect.StructField, tags []string) string {
+	if tags == nil {
 		value := sf.Tag.Get(TagName)
 		if value == "" {
 			return sf.Tag.Get(OldTagName)
@@ -34,7 +57,7 @@ func getTag(sf reflect.StructField) string {
 		return value
 	}
 
-	for _, tagName := range Tags {
+	for _, tagName := range tags {
 		if tag := sf.Tag.Get(tagName); tag != "" {
 			return tag
 		}
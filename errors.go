@@ -1,11 +1,12 @@
 package rethinkdb
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"github.com/segmentio/encoding/json"
+	"regexp"
 	"strings"
+	"unicode/utf8"
 
 	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 )
@@ -25,46 +26,108 @@ var (
 	// ErrConnectionClosed is returned when trying to send a query with a connClosed
 	// connection.
 	ErrConnectionClosed = errors.New("rethinkdb: the connection is closed")
+	// ErrNodeNotFound is returned when running a query with the executor
+	// returned by Session.OnNode if the node is not in the cluster.
+	ErrNodeNotFound = errors.New("rethinkdb: node not found in the cluster")
+	// ErrCursorClosed is returned by Cursor.Err when the cursor was closed
+	// using Close before all of the results were read, for example by another
+	// goroutine while iterating.
+	ErrCursorClosed = errors.New("rethinkdb: the cursor is closed")
 	// ErrQueryTimeout is returned when query context deadline exceeded.
 	ErrQueryTimeout = errors.New("rethinkdb: query timeout")
+	// ErrAuthFailed is returned by the Unwrap method of RQLAuthError.
+	ErrAuthFailed = errors.New("rethinkdb: authentication failed")
+	// ErrUnsupportedProtocol is the Kind of a RQLHandshakeError returned when
+	// the server does not support the protocol version used by the driver.
+	ErrUnsupportedProtocol = errors.New("rethinkdb: unsupported protocol version")
+	// ErrHandshakeTimeout is the Kind of a RQLHandshakeError returned when the
+	// handshake does not complete within ConnectOpts.Timeout.
+	ErrHandshakeTimeout = errors.New("rethinkdb: handshake timeout")
 )
 
-func printCarrots(t Term, frames []*p.Frame) string {
-	var frame *p.Frame
-	if len(frames) > 1 {
-		frame, frames = frames[0], frames[1:]
-	} else if len(frames) == 1 {
-		frame, frames = frames[0], []*p.Frame{}
-	}
+// Frame is a single step in the path from the root of a query to the term
+// which caused an error. Opt is the name of an optional argument, if it is
+// empty then Pos is the position of an argument.
+type Frame struct {
+	Pos int
+	Opt string
+}
 
-	for i, arg := range t.args {
-		if frame.GetPos() == int64(i) {
-			t.args[i] = Term{
-				termType: p.Term_DATUM,
-				data:     printCarrots(arg, frames),
-			}
+func parseBacktrace(backtrace []interface{}) []Frame {
+	frames := make([]Frame, 0, len(backtrace))
+	for _, f := range backtrace {
+		switch f := f.(type) {
+		case float64:
+			frames = append(frames, Frame{Pos: int(f)})
+		case string:
+			frames = append(frames, Frame{Opt: f})
 		}
 	}
 
-	for k, arg := range t.optArgs {
-		if frame.GetOpt() == k {
-			t.optArgs[k] = Term{
-				termType: p.Term_DATUM,
-				data:     printCarrots(arg, frames),
-			}
-		}
+	return frames
+}
+
+// printCarrots returns the string representation of t and a line of carets
+// below it pointing at the term referenced by frames. The returned line is
+// empty if frames does not reference a term in t.
+func printCarrots(t Term, frames []Frame) (string, string) {
+	marker := Term{termType: p.Term_DATUM, data: "\x00"}
+	marked, target, ok := replaceFrameTerm(t, frames, marker)
+	if !ok {
+		return t.String(), ""
+	}
+
+	s, m := marked.String(), marker.String()
+	i := strings.Index(s, m)
+	if i < 0 {
+		return t.String(), ""
+	}
+
+	ts := target.String()
+	return s[:i] + ts + s[i+len(m):], strings.Repeat(" ", utf8.RuneCountInString(s[:i])) + strings.Repeat("^", utf8.RuneCountInString(ts))
+}
+
+// replaceFrameTerm returns a copy of t with the term referenced by frames
+// replaced by marker, and the replaced term.
+func replaceFrameTerm(t Term, frames []Frame, marker Term) (Term, Term, bool) {
+	if len(frames) == 0 {
+		return marker, t, true
 	}
 
-	b := &bytes.Buffer{}
-	for _, c := range t.String() {
-		if c != '^' {
-			b.WriteString(" ")
-		} else {
-			b.WriteString("^")
+	frame := frames[0]
+	if frame.Opt != "" {
+		arg, ok := t.optArgs[frame.Opt]
+		if !ok {
+			return t, Term{}, false
+		}
+		arg, target, ok := replaceFrameTerm(arg, frames[1:], marker)
+		if !ok {
+			return t, Term{}, false
+		}
+
+		optArgs := make(map[string]Term, len(t.optArgs))
+		for k, v := range t.optArgs {
+			optArgs[k] = v
 		}
+		optArgs[frame.Opt] = arg
+		t.optArgs = optArgs
+
+		return t, target, true
+	}
+
+	if frame.Pos < 0 || frame.Pos >= len(t.args) {
+		return t, Term{}, false
+	}
+	arg, target, ok := replaceFrameTerm(t.args[frame.Pos], frames[1:], marker)
+	if !ok {
+		return t, Term{}, false
 	}
 
-	return b.String()
+	args := append(termsList{}, t.args...)
+	args[frame.Pos] = arg
+	t.args = args
+
+	return t, target, true
 }
 
 // Error constants
@@ -110,10 +173,8 @@ func (e rqlError) String() string {
 // Exported Error "Implementations"
 
 type RQLClientError struct{ rqlServerError }
-type RQLCompileError struct{ rqlServerError }
 type RQLDriverCompileError struct{ RQLCompileError }
 type RQLServerCompileError struct{ RQLCompileError }
-type RQLAuthError struct{ RQLDriverError }
 type RQLRuntimeError struct{ rqlServerError }
 
 type RQLQueryLogicError struct{ RQLRuntimeError }
@@ -126,6 +187,33 @@ type RQLAvailabilityError struct{ RQLRuntimeError }
 type RQLOpFailedError struct{ RQLAvailabilityError }
 type RQLOpIndeterminateError struct{ RQLAvailabilityError }
 
+// RQLCompileError is returned when the server fails to compile a query.
+// Message holds the error returned by the server and Backtrace the path from
+// the root of the query to the term which caused the error, the error string
+// points at this term.
+type RQLCompileError struct {
+	rqlServerError
+	Message   string
+	Backtrace []Frame
+}
+
+func (e RQLCompileError) Error() string {
+	if e.term == nil || len(e.Backtrace) == 0 {
+		return e.rqlServerError.Error()
+	}
+
+	query, carets := printCarrots(*e.term, e.Backtrace)
+	if carets == "" {
+		return e.rqlServerError.Error()
+	}
+
+	return fmt.Sprintf("rethinkdb: %s in:\n%s\n%s", e.Message, query, carets)
+}
+
+func (e RQLCompileError) String() string {
+	return e.Error()
+}
+
 // RQLDriverError represents an unexpected error with the driver, if this error
 // persists please create an issue.
 type RQLDriverError struct {
@@ -138,12 +226,81 @@ type RQLConnectionError struct {
 	rqlError
 }
 
+// RQLAuthError is returned when the server rejects the credentials used to
+// connect, or when they can't be loaded or the server can't be authenticated.
+// Address is the address of the server, it is included in the error message.
+//
+// Adding the Address field breaks unkeyed literals such as
+// RQLAuthError{RQLDriverError{...}}, use keyed fields instead.
+type RQLAuthError struct {
+	RQLDriverError
+	Address string
+}
+
+func (e RQLAuthError) Error() string {
+	if e.Address == "" {
+		return e.RQLDriverError.Error()
+	}
+	return fmt.Sprintf("%s (%s)", e.RQLDriverError.Error(), e.Address)
+}
+
+func (e RQLAuthError) String() string {
+	return e.Error()
+}
+
+// Unwrap returns ErrAuthFailed.
+func (e RQLAuthError) Unwrap() error {
+	return ErrAuthFailed
+}
+
+// RQLHandshakeError is returned when the handshake with a server fails for
+// another reason than authentication. Kind is either ErrUnsupportedProtocol
+// or ErrHandshakeTimeout and can be used to tell the cause of the failure
+// apart, Message holds the error returned by the server.
+type RQLHandshakeError struct {
+	Kind    error
+	Address string
+	Message string
+}
+
+func (e RQLHandshakeError) Error() string {
+	return fmt.Sprintf("%s (%s): %s", e.Kind, e.Address, e.Message)
+}
+
+func (e RQLHandshakeError) String() string {
+	return e.Error()
+}
+
+// Unwrap returns the Kind of the error.
+func (e RQLHandshakeError) Unwrap() error {
+	return e.Kind
+}
+
+// ResponseTooLargeError is returned when a response is larger than
+// ConnectOpts.MaxResponseBytes, Size is the size of the response in bytes.
+type ResponseTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("rethinkdb: response of %d bytes exceeds the maximum response size of %d bytes", e.Size, e.Limit)
+}
+
 func createClientError(response *Response, term *Term) error {
 	return RQLClientError{rqlServerError{response, term}}
 }
 
 func createCompileError(response *Response, term *Term) error {
-	return RQLCompileError{rqlServerError{response, term}}
+	err := RQLCompileError{rqlServerError: rqlServerError{response, term}}
+	if response != nil {
+		if len(response.Responses) > 0 {
+			json.Unmarshal(response.Responses[0], &err.Message)
+		}
+		err.Backtrace = parseBacktrace(response.Backtrace)
+	}
+
+	return err
 }
 
 func createRuntimeError(errorType p.Response_ErrorType, response *Response, term *Term) error {
@@ -181,6 +338,16 @@ func IsConflictErr(err error) bool {
 	return strings.HasPrefix(err.Error(), "Duplicate primary key")
 }
 
+// isAvailabilityErr returns true if err is an RQLAvailabilityError or one of
+// its sub-types.
+func isAvailabilityErr(err error) bool {
+	switch err.(type) {
+	case RQLAvailabilityError, RQLOpFailedError, RQLOpIndeterminateError:
+		return true
+	}
+	return false
+}
+
 // IsTypeErr returns true if the error is non-nil and the query failed due
 // to a type error.
 func IsTypeErr(err error) bool {
@@ -190,3 +357,24 @@ func IsTypeErr(err error) bool {
 
 	return strings.HasPrefix(err.Error(), "Expected type")
 }
+
+var alreadyExistsErrRegexp = regexp.MustCompile("^rethinkdb: (Database|Table|Index) `[^`]+` already exists")
+
+// IsAlreadyExistsErr returns true if the error is non-nil and the query failed
+// because the database, table or index being created already exists.
+func IsAlreadyExistsErr(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return alreadyExistsErrRegexp.MatchString(err.Error())
+}
+
+// ensure executes a query creating a database, table or index, ignoring the
+// error returned if it already exists.
+func ensure(s QueryExecutor, t Term) error {
+	if err := t.Exec(s); err != nil && !IsAlreadyExistsErr(err) {
+		return err
+	}
+	return nil
+}
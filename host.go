@@ -2,9 +2,16 @@ package rethinkdb
 
 import (
 	"fmt"
+	"strings"
 )
 
-// Host name and port of server
+// unixAddressPrefix is the prefix of the addresses of unix domain sockets, for
+// example unix:///var/run/rethinkdb.sock
+const unixAddressPrefix = "unix://"
+
+// Host name and port of server. Hosts connecting to a unix domain socket are
+// created with NewUnixHost, their Name is the address of the socket such as
+// unix:///var/run/rethinkdb.sock and their Port is unused.
 type Host struct {
 	Name string
 	Port int
@@ -18,7 +25,35 @@ func NewHost(name string, port int) Host {
 	}
 }
 
-// Returns host address (name:port)
+// NewUnixHost creates a new Host connecting to the unix domain socket at path.
+func NewUnixHost(path string) Host {
+	return Host{Name: unixAddressPrefix + path}
+}
+
+// Returns host address (name:port), or unix://path for unix domain sockets
 func (h Host) String() string {
+	if strings.HasPrefix(h.Name, unixAddressPrefix) {
+		return h.Name
+	}
 	return fmt.Sprintf("%s:%d", h.Name, h.Port)
 }
+
+// hostFromAddress returns the Host of an address such as localhost:28015 or
+// unix:///var/run/rethinkdb.sock
+func hostFromAddress(address string) Host {
+	if strings.HasPrefix(address, unixAddressPrefix) {
+		return NewUnixHost(strings.TrimPrefix(address, unixAddressPrefix))
+	}
+
+	hostname, port := splitAddress(address)
+	return NewHost(hostname, port)
+}
+
+// dialAddress returns the network and address to dial for an address returned
+// by Host.String.
+func dialAddress(address string) (network, addr string) {
+	if strings.HasPrefix(address, unixAddressPrefix) {
+		return "unix", strings.TrimPrefix(address, unixAddressPrefix)
+	}
+	return "tcp", address
+}
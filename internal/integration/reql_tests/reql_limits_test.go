@@ -118,7 +118,8 @@ func (suite *LimitsSuite) TestCases() {
 	{
 		// limits.yaml line #22
 		/* err("ReqlQueryLogicError", "Illegal array size limit `-1`.  (Must be >= 1.)", []) */
-		var expected_ Err = err("ReqlQueryLogicError", "Illegal array size limit `-1`.  (Must be >= 1.)")
+		// The driver rejects invalid array limits before sending the query
+		var expected_ Err = err("ReqlDriverError", "ArrayLimit must be an integer between 1 and 9007199254740992, got -1")
 		/* r.expr([1,2,3,4,5,6,7,8]) */
 
 		suite.T().Log("About to run line #22: r.Expr([]interface{}{1, 2, 3, 4, 5, 6, 7, 8})")
@@ -134,7 +135,8 @@ func (suite *LimitsSuite) TestCases() {
 	{
 		// limits.yaml line #27
 		/* err("ReqlQueryLogicError", "Illegal array size limit `0`.  (Must be >= 1.)", []) */
-		var expected_ Err = err("ReqlQueryLogicError", "Illegal array size limit `0`.  (Must be >= 1.)")
+		// The driver rejects invalid array limits before sending the query
+		var expected_ Err = err("ReqlDriverError", "ArrayLimit must be an integer between 1 and 9007199254740992, got 0")
 		/* r.expr([1,2,3,4,5,6,7,8]) */
 
 		suite.T().Log("About to run line #27: r.Expr([]interface{}{1, 2, 3, 4, 5, 6, 7, 8})")
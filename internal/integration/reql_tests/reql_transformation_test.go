@@ -1016,7 +1016,8 @@ func (suite *TransformationSuite) TestCases() {
 	{
 		// transformation.yaml line #283
 		/* err('ReqlQueryLogicError', 'Cannot use a negative left index on a stream.', [0]) */
-		var expected_ Err = err("ReqlQueryLogicError", "Cannot use a negative left index on a stream.")
+		// The driver rejects negative indexes on streams before sending the query
+		var expected_ Err = err("ReqlDriverError", "Slice: can't use a negative start index on a stream, got -1")
 		/* tbl.slice(-1, -3).count() */
 
 		suite.T().Log("About to run line #283: tbl.Slice(-1, -3).Count()")
@@ -1031,7 +1032,8 @@ func (suite *TransformationSuite) TestCases() {
 	{
 		// transformation.yaml line #285
 		/* err('ReqlQueryLogicError', 'Cannot use a right index < -1 on a stream.', [0]) */
-		var expected_ Err = err("ReqlQueryLogicError", "Cannot use a right index < -1 on a stream.")
+		// The driver rejects negative indexes on streams before sending the query
+		var expected_ Err = err("ReqlDriverError", "Slice: can't use an end index less than -1 on a stream, got -3")
 		/* tbl.slice(0, -3).count() */
 
 		suite.T().Log("About to run line #285: tbl.Slice(0, -3).Count()")
@@ -1196,7 +1198,8 @@ func (suite *TransformationSuite) TestCases() {
 	{
 		// transformation.yaml line #318
 		/* err("ReqlQueryLogicError", "Cannot use a right index < -1 on a stream.", []) */
-		var expected_ Err = err("ReqlQueryLogicError", "Cannot use a right index < -1 on a stream.")
+		// The driver rejects negative indexes on streams before sending the query
+		var expected_ Err = err("ReqlDriverError", "Slice: can't use an end index less than -1 on a stream, got -2")
 		/* tbl.slice(12, -2).count() */
 
 		suite.T().Log("About to run line #318: tbl.Slice(12, -2).Count()")
@@ -1211,7 +1214,8 @@ func (suite *TransformationSuite) TestCases() {
 	{
 		// transformation.yaml line #321
 		/* err("ReqlQueryLogicError", "Cannot use a right index < -1 on a stream.", []) */
-		var expected_ Err = err("ReqlQueryLogicError", "Cannot use a right index < -1 on a stream.")
+		// The driver rejects negative indexes on streams before sending the query
+		var expected_ Err = err("ReqlDriverError", "Slice: can't use an end index less than -1 on a stream, got -2")
 		/* tbl.slice(12, -2, right_bound='closed').count() */
 
 		suite.T().Log("About to run line #321: tbl.Slice(12, -2).OptArgs(r.SliceOpts{RightBound: 'closed', }).Count()")
@@ -1226,7 +1230,8 @@ func (suite *TransformationSuite) TestCases() {
 	{
 		// transformation.yaml line #325
 		/* err("ReqlQueryLogicError", "Cannot use a negative left index on a stream.", []) */
-		var expected_ Err = err("ReqlQueryLogicError", "Cannot use a negative left index on a stream.")
+		// The driver rejects negative indexes on streams before sending the query
+		var expected_ Err = err("ReqlDriverError", "Slice: can't use a negative start index on a stream, got -12")
 		/* tbl.slice(-12, -2).count() */
 
 		suite.T().Log("About to run line #325: tbl.Slice(-12, -2).Count()")
@@ -1241,7 +1246,8 @@ func (suite *TransformationSuite) TestCases() {
 	{
 		// transformation.yaml line #328
 		/* err("ReqlQueryLogicError", "Cannot use a negative left index on a stream.", []) */
-		var expected_ Err = err("ReqlQueryLogicError", "Cannot use a negative left index on a stream.")
+		// The driver rejects negative indexes on streams before sending the query
+		var expected_ Err = err("ReqlDriverError", "Slice: can't use a negative start index on a stream, got -12")
 		/* tbl.slice(-12, -2, right_bound='closed').count() */
 
 		suite.T().Log("About to run line #328: tbl.Slice(-12, -2).OptArgs(r.SliceOpts{RightBound: 'closed', }).Count()")
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -530,3 +531,63 @@ func ExampleCursor_Peek() {
 	res.Skip()        // progress the cursor, skipping 2
 	res.Peek(&result) // result is now 3
 }
+
+type eventCreated struct {
+	Kind string `rethinkdb:"kind"`
+	Name string `rethinkdb:"name"`
+}
+
+type eventRenamed struct {
+	Kind string `rethinkdb:"kind"`
+	From string `rethinkdb:"from"`
+	To   string `rethinkdb:"to"`
+}
+
+// Decode rows of different shapes into their own types by dispatching on a
+// kind field.
+func ExampleRunOpts_resolveType() {
+	events := []interface{}{
+		map[string]interface{}{"kind": "created", "name": "a"},
+		map[string]interface{}{"kind": "renamed", "from": "a", "to": "b"},
+	}
+
+	res, err := r.Expr(events).Run(session, r.RunOpts{
+		ResolveType: func(raw json.RawMessage) (interface{}, error) {
+			var row struct {
+				Kind string `json:"kind"`
+			}
+			if err := json.Unmarshal(raw, &row); err != nil {
+				return nil, err
+			}
+			switch row.Kind {
+			case "created":
+				return &eventCreated{}, nil
+			case "renamed":
+				return &eventRenamed{}, nil
+			}
+			return nil, fmt.Errorf("unknown kind %q", row.Kind)
+		},
+	})
+	if err != nil {
+		fmt.Print(err)
+		return
+	}
+
+	var rows []interface{}
+	if err := res.All(&rows); err != nil {
+		fmt.Print(err)
+		return
+	}
+	for _, row := range rows {
+		switch event := row.(type) {
+		case *eventCreated:
+			fmt.Printf("created %s\n", event.Name)
+		case *eventRenamed:
+			fmt.Printf("renamed %s to %s\n", event.From, event.To)
+		}
+	}
+
+	// Output:
+	// created a
+	// renamed a to b
+}
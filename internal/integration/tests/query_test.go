@@ -64,6 +64,45 @@ func (s *RethinkSuite) TestQueryRunWrite(c *test.C) {
 	c.Assert(len(res.Changes), test.Equals, 2)
 }
 
+func (s *RethinkSuite) TestQueryUpdateIfVersion(c *test.C) {
+	r.DBDrop("test_versioned").Exec(session)
+	err := r.DBCreate("test_versioned").Exec(session)
+	c.Assert(err, test.IsNil)
+	err = r.DB("test_versioned").TableCreate("accounts").Exec(session)
+	c.Assert(err, test.IsNil)
+	err = r.DB("test_versioned").Table("accounts").Wait().Exec(session)
+	c.Assert(err, test.IsNil)
+
+	table := r.DB("test_versioned").Table("accounts")
+	err = table.Insert(map[string]interface{}{"id": 1, "balance": 100, "version": 1}).Exec(session)
+	c.Assert(err, test.IsNil)
+
+	res, err := table.Get(1).UpdateIfVersion(map[string]interface{}{"balance": 90}, "version", 1).RunWrite(session)
+	c.Assert(err, test.IsNil)
+	outcome, err := res.VersionedUpdate()
+	c.Assert(err, test.IsNil)
+	c.Assert(outcome, test.Equals, r.VersionedUpdateApplied)
+
+	// The version is now 2, an update based on version 1 is stale
+	res, err = table.Get(1).UpdateIfVersion(map[string]interface{}{"balance": 80}, "version", 1).RunWrite(session)
+	c.Assert(err, test.IsNil)
+	outcome, err = res.VersionedUpdate()
+	c.Assert(err, test.IsNil)
+	c.Assert(outcome, test.Equals, r.VersionedUpdateStale)
+
+	var account map[string]interface{}
+	err = table.Get(1).ReadOne(&account, session)
+	c.Assert(err, test.IsNil)
+	c.Assert(account["balance"], test.Equals, float64(90))
+	c.Assert(account["version"], test.Equals, float64(2))
+
+	res, err = table.Get(2).UpdateIfVersion(map[string]interface{}{"balance": 80}, "version", 1).RunWrite(session)
+	c.Assert(err, test.IsNil)
+	outcome, err = res.VersionedUpdate()
+	c.Assert(err, test.IsNil)
+	c.Assert(outcome, test.Equals, r.VersionedUpdateNotFound)
+}
+
 func (s *RethinkSuite) TestQueryProfile(c *test.C) {
 	var response string
 
@@ -77,6 +116,29 @@ func (s *RethinkSuite) TestQueryProfile(c *test.C) {
 	c.Assert(err, test.IsNil)
 	c.Assert(res.Profile(), test.NotNil)
 	c.Assert(response, test.Equals, "Test")
+
+	profile, err := res.ProfileInfo()
+	c.Assert(err, test.IsNil)
+	c.Assert(profile, test.NotNil)
+	c.Assert(profile.Tasks, test.Not(test.HasLen), 0)
+}
+
+func (s *RethinkSuite) TestQueryProfileRunWrite(c *test.C) {
+	r.DB("test").TableDrop("test_profile").Exec(session)
+	r.DB("test").TableCreate("test_profile").Exec(session)
+	r.DB("test").Table("test_profile").Wait().Exec(session)
+
+	res, err := r.DB("test").Table("test_profile").Insert(map[string]interface{}{"num": 1}).RunWrite(session, r.RunOpts{
+		Profile: true,
+	})
+	c.Assert(err, test.IsNil)
+	c.Assert(res.Inserted, test.Equals, 1)
+	c.Assert(res.Profile, test.NotNil)
+	c.Assert(res.Profile.Tasks, test.Not(test.HasLen), 0)
+
+	res, err = r.DB("test").Table("test_profile").Insert(map[string]interface{}{"num": 2}).RunWrite(session)
+	c.Assert(err, test.IsNil)
+	c.Assert(res.Profile, test.IsNil)
 }
 
 func (s *RethinkSuite) TestQueryRunRawTime(c *test.C) {
@@ -737,6 +799,43 @@ func (s *RethinkSuite) TestSelectJSONNumbers(c *test.C) {
 	res.Close()
 }
 
+func (s *RethinkSuite) TestSelectJSONNumbersAll(c *test.C) {
+	session, err := r.Connect(r.ConnectOpts{
+		Address:       url,
+		UseJSONNumber: true,
+	})
+	c.Assert(err, test.IsNil)
+	defer session.Close()
+	// Ensure table + database exist
+	r.DBCreate("test_jnum").Exec(session)
+	r.DB("test_jnum").TableCreate("table_test_query_jsonnum_all").Exec(session)
+	r.DB("test_jnum").Table("table_test_query_jsonnum_all").Wait().Exec(session)
+	r.DB("test_jnum").Table("table_test_query_jsonnum_all").Delete().Exec(session)
+
+	// Insert rows, 2^60 is exactly representable by the server
+	err = r.DB("test_jnum").Table("table_test_query_jsonnum_all").Insert(map[string]interface{}{
+		"id": 1, "num": int64(1)<<53 - 1,
+	}).Exec(session)
+	c.Assert(err, test.IsNil)
+
+	// Test query
+	var response []map[string]interface{}
+	res, err := r.DB("test_jnum").Table("table_test_query_jsonnum_all").Run(session)
+	c.Assert(err, test.IsNil)
+
+	err = res.All(&response)
+	c.Assert(err, test.IsNil)
+	c.Assert(response, test.HasLen, 1)
+
+	// The largest integer stored exactly by the server is read as is
+	num, ok := response[0]["num"].(json.Number)
+	c.Assert(ok, test.Equals, true)
+	c.Assert(num.String(), test.Equals, "9007199254740991")
+	i, err := num.Int64()
+	c.Assert(err, test.IsNil)
+	c.Assert(i, test.Equals, int64(1)<<53-1)
+}
+
 func (s *RethinkSuite) TestSelectManyRows(c *test.C) {
 	// Ensure table + database exist
 	r.DBCreate("test_sm").Exec(session)
@@ -775,3 +874,66 @@ func (s *RethinkSuite) TestSelectManyRows(c *test.C) {
 
 	res.Close()
 }
+
+func (s *RethinkSuite) TestFilterStruct(c *test.C) {
+	type predicate struct {
+		ID  int  `rethinkdb:"id,omitempty"`
+		G1  int  `rethinkdb:"g1,omitempty"`
+		G2  int  `rethinkdb:"g2,omitempty"`
+		Num *int `rethinkdb:"num,omitempty"`
+		Tag int  `rethinkdb:"-"`
+	}
+
+	// Ensure table + database exist
+	r.DBCreate("test").Exec(session)
+	r.DB("test").TableDrop("test_filter_struct").Exec(session)
+	r.DB("test").TableCreate("test_filter_struct").Exec(session)
+	r.DB("test").Table("test_filter_struct").Insert(objList).Exec(session)
+
+	var ids []int
+	res, err := r.DB("test").Table("test_filter_struct").Filter(predicate{G1: 2, Tag: 1}).OrderBy("id").Field("id").Run(session)
+	c.Assert(err, test.IsNil)
+	c.Assert(res.All(&ids), test.IsNil)
+	c.Assert(ids, test.DeepEquals, []int{2, 4, 5, 9})
+
+	zero := 0
+	res, err = r.DB("test").Table("test_filter_struct").Filter(predicate{G1: 1, Num: &zero}).OrderBy("id").Field("id").Run(session)
+	c.Assert(err, test.IsNil)
+	c.Assert(res.All(&ids), test.IsNil)
+	c.Assert(ids, test.DeepEquals, []int{1, 7})
+}
+
+func (s *RethinkSuite) TestWriteUpsertBy(c *test.C) {
+	// Ensure table + database + index exist
+	r.DBCreate("test").Exec(session)
+	r.DB("test").TableDrop("test_upsert_by").Exec(session)
+	r.DB("test").TableCreate("test_upsert_by").Exec(session)
+	r.DB("test").Table("test_upsert_by").IndexCreate("email").Exec(session)
+	r.DB("test").Table("test_upsert_by").IndexWait().Exec(session)
+
+	table := r.DB("test").Table("test_upsert_by")
+
+	// Inserted when no document matches
+	res, err := table.UpsertBy("email", map[string]interface{}{"email": "alice@example.com", "name": "Alice"}).RunWrite(session)
+	c.Assert(err, test.IsNil)
+	c.Assert(res.Inserted, test.Equals, 1)
+	c.Assert(res.GeneratedKeys, test.HasLen, 1)
+	id := res.GeneratedKeys[0]
+
+	// Replaced, keeping the primary key, when one document matches
+	res, err = table.UpsertBy("email", map[string]interface{}{"email": "alice@example.com", "name": "Alice B"}).RunWrite(session)
+	c.Assert(err, test.IsNil)
+	c.Assert(res.Replaced, test.Equals, 1)
+
+	var doc map[string]interface{}
+	cursor, err := table.Get(id).Run(session)
+	c.Assert(err, test.IsNil)
+	c.Assert(cursor.One(&doc), test.IsNil)
+	c.Assert(doc, JsonEquals, map[string]interface{}{"id": id, "email": "alice@example.com", "name": "Alice B"})
+
+	// Fails when more than one document matches
+	_, err = table.Insert(map[string]interface{}{"email": "alice@example.com"}).RunWrite(session)
+	c.Assert(err, test.IsNil)
+	_, err = table.UpsertBy("email", map[string]interface{}{"email": "alice@example.com"}).RunWrite(session)
+	c.Assert(err, test.ErrorMatches, `.*UpsertBy: more than one document matches the index "email".*`)
+}
@@ -1,12 +1,13 @@
 package rethinkdb
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"github.com/segmentio/encoding/json"
-	"gopkg.in/rethinkdb/rethinkdb-go.v6/encoding"
 	"net"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,6 +24,32 @@ type testingT interface {
 	FailNow()
 }
 
+// AssertTermEqual asserts that actual represents the same query as expected,
+// using the same comparison as TermsEqual. It can be used to test code which
+// builds queries without running them:
+//
+//	r.AssertTermEqual(t, r.Table("users").Get("bob"), usersQuery("bob"))
+//
+// On a mismatch both terms are logged along with the path to the first
+// subterm which differs, such as args[0].args[1] for the second argument of
+// the first argument of the terms.
+func AssertTermEqual(t testingT, expected, actual Term) bool {
+	if expected.compare(actual, map[int64]int64{}) {
+		return true
+	}
+
+	path, subExpected, subActual, _ := expected.diff(actual, map[int64]int64{})
+
+	at := "the root term"
+	if len(path) > 0 {
+		at = strings.Join(path, ".")
+	}
+
+	t.Errorf("Terms are not equal:\n\texpected: %s\n\tactual:   %s\nFirst difference at %s:\n\texpected: %s\n\tactual:   %s",
+		expected, actual, at, subExpected, subActual)
+	return false
+}
+
 // MockAnything can be used in place of any term, this is useful when you want
 // mock similar queries or queries that you don't quite know the exact structure
 // of.
@@ -110,7 +137,8 @@ func (mq *MockQuery) unlock() {
 // values of `chan []interface{}` type will turn to delayed data that produce data
 // when there is an elements available on the channel. These elements are chunk of responses.
 // Values of `func() []interface{}` type will produce data by calling the function. E.g.
-// Closing channel or returning nil from func means end of data.
+// Closing channel or returning nil from func means end of data. Values of
+// `<-chan interface{}` type produce a changefeed, see ReturnChangefeed.
 //
 //	f := func() []interface{} { return []interface{}{1, 2} }
 //	mock.On(r.Table("test1")).Return(f)
@@ -129,6 +157,23 @@ func (mq *MockQuery) Return(response interface{}, err error) *MockQuery {
 	return mq
 }
 
+// ReturnChangefeed specifies that the expectation returns an open streaming
+// cursor, like the cursor returned by Changes. Each value received from
+// changes is delivered to the cursor as a separate response as soon as it is
+// sent and the cursor stays open until changes is closed or the cursor is
+// closed.
+//
+//	changes := make(chan interface{})
+//	mock.On(r.Table("test").Changes()).ReturnChangefeed(changes)
+//
+//	go func() {
+//	    changes <- r.ChangeResponse{NewValue: map[string]interface{}{"id": 1}}
+//	    close(changes)
+//	}()
+func (mq *MockQuery) ReturnChangefeed(changes <-chan interface{}) *MockQuery {
+	return mq.Return(changes, nil)
+}
+
 // Once indicates that that the mock should only return the value once.
 //
 //	mock.On(r.Table("test")).Return(result, nil).Once()
@@ -182,6 +227,27 @@ func (mq *MockQuery) On(t Term) *MockQuery {
 	return mq.parent.On(t)
 }
 
+// Calls returns the queries executed by the mock which match the expectation,
+// in the order they were executed, so that tests can check the values they
+// were run with. Like AssertExecuted, queries are matched by comparing their
+// terms with the expectation's, so a query which also matches another
+// expectation is included.
+//
+//	q := mock.On(r.Table("users").Insert(r.MockAnything()))
+//	// run the code under test
+//	calls := q.Calls()
+//	r.TermsEqual(*calls[0].Term, r.Table("users").Insert(user))
+func (mq *MockQuery) Calls() []Query {
+	var calls []Query
+	for _, query := range mq.parent.queries() {
+		if query.Query.Term.compare(*mq.Query.Term, map[int64]int64{}) {
+			calls = append(calls, query.Query)
+		}
+	}
+
+	return calls
+}
+
 // Mock is used to mock query execution and verify that the expected queries are
 // being executed. Mocks are used by creating an instance using NewMock and then
 // passing this when running your queries instead of a session. For example:
@@ -198,6 +264,14 @@ type Mock struct {
 
 	ExpectedQueries []*MockQuery
 	Queries         []MockQuery
+
+	// By default the mock panics when a query without a matching expectation
+	// is executed, both when created with NewMock and as a zero value. If
+	// Lenient is set unexpected queries are added to UnexpectedQueries and
+	// return an empty cursor instead, use AssertNoUnexpectedQueries to check
+	// for them.
+	Lenient           bool
+	UnexpectedQueries []MockQuery
 }
 
 // NewMock creates an instance of Mock, you can optionally pass ConnectOpts to
@@ -236,33 +310,92 @@ func (m *Mock) On(t Term, opts ...map[string]interface{}) *MockQuery {
 // AssertExpectations asserts that everything specified with On and Return was
 // in fact executed as expected. Queries may have been executed in any order.
 func (m *Mock) AssertExpectations(t testingT) bool {
-	var somethingMissing bool
-	var failedExpectations int
-
-	// iterate through each expectation
-	expectedQueries := m.expectedQueries()
-	for _, expectedQuery := range expectedQueries {
-		if !m.queryWasExecuted(expectedQuery) && expectedQuery.executed == 0 {
-			somethingMissing = true
-			failedExpectations++
-			t.Logf("❌\t%s", expectedQuery.Query.Term.String())
-		} else {
-			m.mu.Lock()
-			if expectedQuery.Repeatability > 0 {
-				somethingMissing = true
-				failedExpectations++
-			} else {
-				t.Logf("✅\t%s", expectedQuery.Query.Term.String())
-			}
-			m.mu.Unlock()
+	results := m.expectationResults()
+
+	for _, r := range results {
+		if !r.executed {
+			t.Logf("❌\t%s", r.query.Query.Term.String())
+		} else if r.repeatability <= 0 {
+			t.Logf("✅\t%s", r.query.Query.Term.String())
 		}
 	}
 
-	if somethingMissing {
-		t.Errorf("FAIL: %d out of %d expectation(s) were met.\n\tThe query you are testing needs to be executed %d more times(s).", len(expectedQueries)-failedExpectations, len(expectedQueries), failedExpectations)
+	if err := expectationsError(results); err != nil {
+		t.Errorf("FAIL: %s", err)
+		return false
+	}
+
+	return true
+}
+
+// ExpectationsWereMet returns an error describing the expectations specified
+// with On and Return which were not met, or nil if all queries were executed
+// as expected. Unlike AssertExpectations it does not require a testingT so it
+// can be used outside of tests.
+func (m *Mock) ExpectationsWereMet() error {
+	return expectationsError(m.expectationResults())
+}
+
+type mockExpectationResult struct {
+	query         *MockQuery
+	executed      bool
+	repeatability int
+}
+
+func (r mockExpectationResult) met() bool {
+	return r.executed && r.repeatability <= 0
+}
+
+// expectationResults snapshots the state of each expectation under a single
+// lock so that callers never call back into a testingT while the lock is held
+func (m *Mock) expectationResults() []mockExpectationResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := make([]mockExpectationResult, len(m.ExpectedQueries))
+	for i, expectedQuery := range m.ExpectedQueries {
+		results[i] = mockExpectationResult{
+			query:         expectedQuery,
+			executed:      expectedQuery.executed > 0 || queryWasExecutedIn(m.Queries, expectedQuery),
+			repeatability: expectedQuery.Repeatability,
+		}
 	}
 
-	return !somethingMissing
+	return results
+}
+
+func expectationsError(results []mockExpectationResult) error {
+	var unmet []string
+	for _, r := range results {
+		if !r.met() {
+			unmet = append(unmet, r.query.Query.Term.String())
+		}
+	}
+	if len(unmet) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%d out of %d expectation(s) were met.\n\tThe query you are testing needs to be executed %d more times(s).\n\tUnmet expectations:\n\t\t%s",
+		len(results)-len(unmet), len(results), len(unmet), strings.Join(unmet, "\n\t\t"))
+}
+
+// AssertNoUnexpectedQueries asserts that no queries without a matching
+// expectation were executed, see Mock.Lenient.
+func (m *Mock) AssertNoUnexpectedQueries(t testingT) bool {
+	m.mu.Lock()
+	unexpected := append([]MockQuery{}, m.UnexpectedQueries...)
+	m.mu.Unlock()
+
+	if len(unexpected) == 0 {
+		return true
+	}
+
+	queries := make([]string, len(unexpected))
+	for i, query := range unexpected {
+		queries[i] = query.Query.Term.String()
+	}
+	t.Errorf("FAIL: %d unexpected query(s) were executed:\n\t\t%s", len(unexpected), strings.Join(queries, "\n\t\t"))
+	return false
 }
 
 // AssertNumberOfExecutions asserts that the query was executed expectedExecutions times.
@@ -307,11 +440,29 @@ func (m *Mock) IsConnected() bool {
 	return true
 }
 
+// Query executes the query q, returning the response of the matching expected
+// query. The QueryRecorder of the mock's connect options is called like it
+// would be by a session.
 func (m *Mock) Query(ctx context.Context, q Query) (*Cursor, error) {
+	start := time.Now()
+	cursor, err := m.query(ctx, q)
+	recordQuery(ctx, m.opts.QueryRecorder, q, start, err)
+	return cursor, err
+}
+
+func (m *Mock) query(ctx context.Context, q Query) (*Cursor, error) {
 	found, query := m.findExpectedQuery(q)
 
 	if found < 0 {
-		panic(fmt.Sprintf("rethinkdb: mock: This query was unexpected:\n\t\t%s", q.Term.String()))
+		if !m.Lenient {
+			panic(fmt.Sprintf("rethinkdb: mock: This query was unexpected:\n\t\t%s", q.Term.String()))
+		}
+
+		// Unexpected queries return an empty cursor when lenient
+		query = newMockQuery(m, q)
+		m.mu.Lock()
+		m.UnexpectedQueries = append(m.UnexpectedQueries, *newMockQuery(m, q))
+		m.mu.Unlock()
 	} else {
 		m.mu.Lock()
 		switch {
@@ -326,14 +477,12 @@ func (m *Mock) Query(ctx context.Context, q Query) (*Cursor, error) {
 		case query.Repeatability == 0:
 			query.executed++
 		}
+
+		// add the query
+		m.Queries = append(m.Queries, *newMockQuery(m, q))
 		m.mu.Unlock()
 	}
 
-	// add the query
-	m.mu.Lock()
-	m.Queries = append(m.Queries, *newMockQuery(m, q))
-	m.mu.Unlock()
-
 	// block if specified
 	if query.WaitFor != nil {
 		<-query.WaitFor
@@ -348,7 +497,10 @@ func (m *Mock) Query(ctx context.Context, q Query) (*Cursor, error) {
 		ctx = context.Background()
 	}
 
-	conn := newConnection(newMockConn(query.Response), "mock", &ConnectOpts{})
+	// the mock connect options are used so that cursor options such as
+	// UseJSONNumber are honoured
+	opts := m.opts
+	conn := newConnection(newMockConn(query.Response), "mock", &opts)
 
 	query.Query.Type = p.Query_CONTINUE
 	query.Query.Token = conn.nextToken()
@@ -385,6 +537,10 @@ func (m *Mock) newQuery(t Term, opts map[string]interface{}) (Query, error) {
 	return newQuery(t, opts, &m.opts)
 }
 
+func (m *Mock) defaultRunOpts() RunOpts {
+	return m.opts.DefaultRunOpts
+}
+
 func (m *Mock) findExpectedQuery(q Query) (int, *MockQuery) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -400,7 +556,11 @@ func (m *Mock) findExpectedQuery(q Query) (int, *MockQuery) {
 }
 
 func (m *Mock) queryWasExecuted(expectedQuery *MockQuery) bool {
-	for _, query := range m.queries() {
+	return queryWasExecutedIn(m.queries(), expectedQuery)
+}
+
+func queryWasExecutedIn(queries []MockQuery, expectedQuery *MockQuery) bool {
+	for _, query := range queries {
 		if query.Query.Term.compare(*expectedQuery.Query.Term, map[int64]int64{}) {
 			// if bytes.Equal(query.BuiltQuery, expectedQuery.BuiltQuery) {
 			return true
@@ -411,12 +571,6 @@ func (m *Mock) queryWasExecuted(expectedQuery *MockQuery) bool {
 	return false
 }
 
-func (m *Mock) expectedQueries() []*MockQuery {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	return append([]*MockQuery{}, m.ExpectedQueries...)
-}
-
 func (m *Mock) queries() []MockQuery {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -428,13 +582,19 @@ type mockConn struct {
 	value       []byte
 	tokens      chan int64
 	valueGetter func() []interface{}
+
+	// stopped is closed when a STOP query is written
+	stopped     chan struct{}
+	stoppedOnce sync.Once
 }
 
 func newMockConn(response interface{}) *mockConn {
-	c := &mockConn{tokens: make(chan int64, 1)}
+	c := &mockConn{tokens: make(chan int64, 1), stopped: make(chan struct{})}
 	switch g := response.(type) {
 	case chan []interface{}:
 		c.valueGetter = func() []interface{} { return <-g }
+	case <-chan interface{}:
+		c.valueGetter = changefeedGetter(g, c.stopped)
 	case func() []interface{}:
 		c.valueGetter = g
 	default:
@@ -452,6 +612,29 @@ func newMockConn(response interface{}) *mockConn {
 	return c
 }
 
+// changefeedGetter returns each change as a separate response until changes
+// or stopped is closed. The first response is empty so that running the query
+// does not block until the first change.
+func changefeedGetter(changes <-chan interface{}, stopped <-chan struct{}) func() []interface{} {
+	started := false
+	return func() []interface{} {
+		if !started {
+			started = true
+			return []interface{}{}
+		}
+
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				return nil
+			}
+			return []interface{}{change}
+		case <-stopped:
+			return nil
+		}
+	}
+}
+
 func funcGetter(responses []interface{}) func() []interface{} {
 	done := false
 	return func() []interface{} {
@@ -470,16 +653,9 @@ func (c *mockConn) Read(b []byte) (n int, err error) {
 	if c.value == nil {
 		values := c.valueGetter()
 
-		jresps := make([]json.RawMessage, len(values))
-		for i := range values {
-			coded, err := encoding.Encode(values[i])
-			if err != nil {
-				panic(fmt.Sprintf("failed to encode response: %v", err))
-			}
-			jresps[i], err = json.Marshal(coded)
-			if err != nil {
-				panic(fmt.Sprintf("failed to encode response: %v", err))
-			}
+		jresps, err := encodeResponses(values)
+		if err != nil {
+			panic(fmt.Sprintf("failed to encode response: %v", err))
 		}
 
 		token := <-c.tokens
@@ -515,6 +691,11 @@ func (c *mockConn) Write(b []byte) (n int, err error) {
 		panic("connBad socket write")
 	}
 	token := int64(binary.LittleEndian.Uint64(b[:8]))
+	// STOP queries are sent as [3] or [3,{"noreply":true}]
+	if stop := []byte(fmt.Sprintf("[%d", p.Query_STOP)); bytes.HasPrefix(b[12:], stop) &&
+		len(b) > 12+len(stop) && (b[12+len(stop)] == ']' || b[12+len(stop)] == ',') {
+		c.stoppedOnce.Do(func() { close(c.stopped) })
+	}
 	c.tokens <- token
 	return len(b), nil
 }
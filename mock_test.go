@@ -1,10 +1,19 @@
 package rethinkdb
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"golang.org/x/net/context"
 
 	test "gopkg.in/check.v1"
+	"gopkg.in/rethinkdb/rethinkdb-go.v6/encoding"
 	"gopkg.in/rethinkdb/rethinkdb-go.v6/internal/integration/tests"
 )
 
@@ -75,6 +84,84 @@ func (s *MockSuite) TestMockRunSuccessMultipleResults(c *test.C) {
 	mock.AssertExpectations(c)
 }
 
+func (s *MockSuite) TestMockRunAll_DecodeError(c *test.C) {
+	type document struct {
+		ID   string
+		Tags []string
+	}
+
+	mock := NewMock()
+	mock.On(Table("test")).Return([]interface{}{
+		map[string]interface{}{"ID": "a", "Tags": []interface{}{"x"}},
+		map[string]interface{}{"ID": "b", "Tags": []interface{}{"y", []interface{}{}}},
+		map[string]interface{}{"ID": "c"},
+	}, nil)
+
+	res, err := Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+
+	var response []document
+	err = res.All(&response)
+	c.Assert(err, test.FitsTypeOf, &encoding.DecodeError{})
+	decodeErr := err.(*encoding.DecodeError)
+	c.Assert(decodeErr.DocIndex, test.Equals, 1)
+	c.Assert(decodeErr.FieldPath, test.Equals, "Tags.1")
+	c.Assert(decodeErr.Cause, test.FitsTypeOf, &encoding.DecodeTypeError{})
+	c.Assert(response, test.HasLen, 1)
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockWithTimeout(c *test.C) {
+	recorder := &testQueryRecorder{}
+	mock := NewMock(ConnectOpts{QueryRecorder: recorder})
+	mock.On(Table("test")).Return([]interface{}{1}, nil)
+	mock.On(Table("test").Count()).Return(1, nil)
+
+	start := time.Now()
+	res, err := Table("test").WithTimeout(time.Minute).Run(mock)
+	c.Assert(err, test.IsNil)
+	c.Assert(Table("test").WithTimeout(time.Minute).Exec(mock), test.IsNil)
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = Table("test").WithTimeout(time.Minute).Run(mock, RunOpts{Context: ctx})
+	c.Assert(err, test.IsNil)
+	_, err = Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+	// The timeout is kept by chained terms
+	_, err = Table("test").WithTimeout(time.Minute).Count().Run(mock)
+	c.Assert(err, test.IsNil)
+
+	c.Assert(recorder.queries, test.HasLen, 5)
+	for _, i := range []int{0, 1, 2, 4} {
+		deadline, ok := recorder.queries[i].ctx.Deadline()
+		c.Assert(ok, test.Equals, true, test.Commentf("query %d", i))
+		c.Assert(deadline.After(start) && !deadline.After(time.Now().Add(time.Minute)), test.Equals, true)
+	}
+	// The context is released once Exec returns, or once the cursor is
+	// closed for Run
+	c.Assert(recorder.queries[1].ctx.Err(), test.NotNil)
+	c.Assert(recorder.queries[0].ctx.Err(), test.IsNil)
+	c.Assert(res.Close(), test.IsNil)
+	c.Assert(recorder.queries[0].ctx.Err(), test.NotNil)
+	// A context set in the options is used as the parent of the deadline
+	c.Assert(recorder.queries[2].ctx.Err(), test.IsNil)
+	cancel()
+	c.Assert(recorder.queries[2].ctx.Err(), test.Equals, context.Canceled)
+	_, ok := recorder.queries[3].ctx.Deadline()
+	c.Assert(ok, test.Equals, false)
+
+	// The timeout is also added to a context set by DefaultRunOpts
+	recorder = &testQueryRecorder{}
+	mock = NewMock(ConnectOpts{QueryRecorder: recorder, DefaultRunOpts: RunOpts{Context: context.Background()}})
+	mock.On(Table("test")).Return([]interface{}{1}, nil)
+	c.Assert(Table("test").WithTimeout(time.Minute).Exec(mock), test.IsNil)
+	c.Assert(recorder.queries, test.HasLen, 1)
+	_, ok = recorder.queries[0].ctx.Deadline()
+	c.Assert(ok, test.Equals, true)
+
+	// The timeout doesn't change the query
+	c.Assert(TermsEqual(Table("test").WithTimeout(time.Second), Table("test")), test.Equals, true)
+}
+
 func (s *MockSuite) TestMockRunSuccessChannel(c *test.C) {
 	mock := NewMock()
 	ch := make(chan []interface{})
@@ -241,10 +328,10 @@ func (s *MockSuite) TestMockQueriesWithFuncs(c *test.C) {
 	mock.On(Expr([]int{2}).Map(func(row Term) interface{} {
 		return row.Add(1)
 	})).Return([]int{3}, nil).Times(2)
-	mock.On(Expr([]int{4}).Map(func(row1, row2 Term) interface{} {
+	mock.On(Expr([]int{4}).Map(Expr([]int{0}), func(row1, row2 Term) interface{} {
 		return row1.Add(1)
 	})).Return([]int{5}, nil).Times(1)
-	mock.On(Expr([]int{9}).Map(func(row1, row2 Term) interface{} {
+	mock.On(Expr([]int{9}).Map(Expr([]int{0}), func(row1, row2 Term) interface{} {
 		return row2.Add(1)
 	})).Return([]int{10}, nil).Times(1)
 
@@ -273,7 +360,7 @@ func (s *MockSuite) TestMockQueriesWithFuncs(c *test.C) {
 	c.Assert(response, tests.JsonEquals, []int{3})
 
 	// Query 3
-	res, err = Expr([]int{4}).Map(func(row1, row2 Term) interface{} {
+	res, err = Expr([]int{4}).Map(Expr([]int{0}), func(row1, row2 Term) interface{} {
 		return row1.Add(1)
 	}).Run(mock)
 	c.Assert(err, test.IsNil)
@@ -284,7 +371,7 @@ func (s *MockSuite) TestMockQueriesWithFuncs(c *test.C) {
 	c.Assert(response, tests.JsonEquals, []int{5})
 
 	// Query 5
-	res, err = Expr([]int{9}).Map(func(row1, row2 Term) interface{} {
+	res, err = Expr([]int{9}).Map(Expr([]int{0}), func(row1, row2 Term) interface{} {
 		return row2.Add(1)
 	}).Run(mock)
 	c.Assert(err, test.IsNil)
@@ -297,6 +384,53 @@ func (s *MockSuite) TestMockQueriesWithFuncs(c *test.C) {
 	mock.AssertExpectations(c)
 }
 
+func (s *MockSuite) TestMockFirstChangeField(c *test.C) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	query := Table("posts").Insert(map[string]interface{}{"title": "Hello", "created": Now()}, InsertOpts{ReturnChanges: true})
+	mock := NewMock()
+	mock.On(query).Return(map[string]interface{}{
+		"inserted": 1,
+		"changes": []interface{}{map[string]interface{}{
+			"new_val": map[string]interface{}{"id": "a1", "title": "Hello", "created": created, "tags": []interface{}{"go"}},
+			"old_val": nil,
+		}},
+	}, nil)
+
+	res, err := query.RunWrite(mock)
+	c.Assert(err, test.IsNil)
+
+	var got time.Time
+	c.Assert(res.FirstChangeField("created", &got), test.IsNil)
+	c.Assert(got.Equal(created), test.Equals, true)
+	var tag string
+	c.Assert(res.FirstChangeField("tags.0", &tag), test.IsNil)
+	c.Assert(tag, test.Equals, "go")
+
+	err = WriteResponse{Inserted: 1}.FirstChangeField("created", &got)
+	c.Assert(err, test.ErrorMatches, "rethinkdb: FirstChangeField: the response has no changes, .*")
+}
+
+func (s *MockSuite) TestMockQueryCalls(c *test.C) {
+	mock := NewMock()
+	insert := mock.On(Table("users").Insert(MockAnything())).Return(map[string]interface{}{"inserted": 1}, nil)
+	get := mock.On(Table("users").Get("bob"))
+	mock.On(Table("posts"))
+
+	_, err := Table("users").Insert(map[string]interface{}{"name": "bob"}).RunWrite(mock)
+	c.Assert(err, test.IsNil)
+	_, err = Table("users").Insert(map[string]interface{}{"name": "alice"}).RunWrite(mock, RunOpts{QueryName: "second"})
+	c.Assert(err, test.IsNil)
+	_, err = Table("posts").Run(mock)
+	c.Assert(err, test.IsNil)
+
+	calls := insert.Calls()
+	c.Assert(calls, test.HasLen, 2)
+	c.Assert(TermsEqual(*calls[0].Term, Table("users").Insert(map[string]interface{}{"name": "bob"})), test.Equals, true)
+	c.Assert(TermsEqual(*calls[1].Term, Table("users").Insert(map[string]interface{}{"name": "alice"})), test.Equals, true)
+	c.Assert(calls[1].Name, test.Equals, "second")
+	c.Assert(get.Calls(), test.HasLen, 0)
+}
+
 func (s *MockSuite) TestMockAnything(c *test.C) {
 	mock := NewMock()
 	mock.On(MockAnything()).Return("okay", nil).Times(1)
@@ -418,14 +552,219 @@ func (s *MockSuite) TestMockPointerSliceResultOk(c *test.C) {
 	c.Assert(casted[1].Id, test.Equals, "test2")
 }
 
+func (s *MockSuite) TestMockRecordsQueryName(c *test.C) {
+	mock := NewMock()
+	mock.On(DB("test").Table("test")).Return([]interface{}{}, nil)
+
+	res, err := DB("test").Table("test").Run(mock, RunOpts{QueryName: "list_tests"})
+	c.Assert(err, test.IsNil)
+	c.Assert(res.Close(), test.IsNil)
+
+	c.Assert(mock.Queries, test.HasLen, 1)
+	c.Assert(mock.Queries[0].Query.Name, test.Equals, "list_tests")
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockBetweenIndex(c *test.C) {
+	mock := NewMock()
+	mock.On(DB("test").Table("test").Between(1, 10, BetweenOpts{
+		Index:      "age",
+		LeftBound:  BoundOpen,
+		RightBound: BoundClosed,
+	})).Return([]interface{}{map[string]interface{}{"id": "mocked", "age": 5}}, nil)
+
+	res, err := DB("test").Table("test").BetweenIndex("age", 1, 10, BoundOpen, BoundClosed).Run(mock)
+	c.Assert(err, test.IsNil)
+
+	var response []interface{}
+	err = res.All(&response)
+
+	c.Assert(err, test.IsNil)
+	c.Assert(response, tests.JsonEquals, []interface{}{map[string]interface{}{"id": "mocked", "age": 5}})
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockBetweenIndexDefaultBounds(c *test.C) {
+	mock := NewMock()
+	mock.On(DB("test").Table("test").Between(1, 10, BetweenOpts{Index: "age"})).Return([]interface{}{}, nil)
+
+	res, err := DB("test").Table("test").BetweenIndex("age", 1, 10).Run(mock)
+	c.Assert(err, test.IsNil)
+	c.Assert(res.Close(), test.IsNil)
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockBetweenInvalidBound(c *test.C) {
+	mock := NewMock()
+
+	_, err := DB("test").Table("test").Between(1, 10, BetweenOpts{LeftBound: "closd"}).Run(mock)
+	c.Assert(err, test.FitsTypeOf, RQLDriverError{})
+
+	_, err = DB("test").Table("test").BetweenIndex("age", 1, 10, BoundOpen, BoundOpen, BoundOpen).Run(mock)
+	c.Assert(err, test.FitsTypeOf, RQLDriverError{})
+	c.Assert(mock.Queries, test.HasLen, 0)
+}
+
+func (s *MockSuite) TestMockAssertExpectationsReentrant(c *test.C) {
+	mock := NewMock()
+	mock.On(DB("test").Table("test")).Return([]interface{}{}, nil)
+	mock.On(DB("test").Table("other")).Return([]interface{}{}, nil)
+
+	res, err := DB("test").Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+	c.Assert(res.Close(), test.IsNil)
+
+	t := &reentrantTestingT{mock: mock}
+	c.Assert(mock.AssertExpectations(t), test.Equals, false)
+	c.Assert(t.logs, test.Equals, 2)
+	c.Assert(t.failed, test.Equals, true)
+}
+
+func (s *MockSuite) TestMockExpectationsWereMet(c *test.C) {
+	mock := NewMock()
+	mock.On(DB("test").Table("test")).Return([]interface{}{}, nil)
+	mock.On(DB("test").Table("other")).Return([]interface{}{}, nil)
+
+	res, err := DB("test").Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+	c.Assert(res.Close(), test.IsNil)
+
+	err = mock.ExpectationsWereMet()
+	c.Assert(err, test.ErrorMatches, `(?s)1 out of 2 expectation\(s\) were met.*r.DB\("test"\).Table\("other"\)`)
+
+	res, err = DB("test").Table("other").Run(mock)
+	c.Assert(err, test.IsNil)
+	c.Assert(res.Close(), test.IsNil)
+
+	c.Assert(mock.ExpectationsWereMet(), test.IsNil)
+}
+
+// reentrantTestingT calls back into the mock from every method.
+type reentrantTestingT struct {
+	simpleTestingT
+	mock *Mock
+	logs int
+}
+
+func (t *reentrantTestingT) Logf(format string, args ...interface{}) {
+	t.logs += len(t.mock.queries())
+}
+func (t *reentrantTestingT) Errorf(format string, args ...interface{}) {
+	t.mock.queries()
+	t.failed = true
+}
+
+func (s *MockSuite) TestMockRunInsert(c *test.C) {
+	docs := []map[string]interface{}{{"id": "a"}, {"id": "b"}, {"id": "c"}, {"id": "d"}}
+
+	mock := NewMock()
+	mock.On(DB("test").Table("test").Insert(docs, InsertOpts{Conflict: "update", ReturnChanges: "always"})).Return(map[string]interface{}{
+		"inserted":  1,
+		"replaced":  1,
+		"unchanged": 1,
+		"errors":    1,
+		"changes": []interface{}{
+			map[string]interface{}{"new_val": map[string]interface{}{"id": "a"}, "old_val": nil},
+			map[string]interface{}{"new_val": map[string]interface{}{"id": "b", "v": 2}, "old_val": map[string]interface{}{"id": "b", "v": 1}},
+			map[string]interface{}{"new_val": map[string]interface{}{"id": "c"}, "old_val": map[string]interface{}{"id": "c"}},
+			map[string]interface{}{"new_val": nil, "old_val": nil, "error": "Primary key too long"},
+		},
+	}, nil)
+
+	res, err := DB("test").Table("test").Insert(docs, InsertOpts{Conflict: "update"}).RunInsert(mock)
+	c.Assert(err, test.IsNil)
+	c.Assert(res.Inserted, test.Equals, 1)
+	c.Assert(res.Errors, test.Equals, 1)
+	c.Assert(res.Documents, test.HasLen, 4)
+	c.Assert(res.Documents[0].Outcome, test.Equals, InsertOutcomeInserted)
+	c.Assert(res.Documents[1].Outcome, test.Equals, InsertOutcomeUpdated)
+	c.Assert(res.Documents[2].Outcome, test.Equals, InsertOutcomeUnchanged)
+	c.Assert(res.Documents[3].Outcome, test.Equals, InsertOutcomeErrored)
+	c.Assert(res.Documents[3].Error, test.Equals, "Primary key too long")
+	c.Assert(res.Failed(), test.DeepEquals, []int{3})
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockInsertStreamChan(c *test.C) {
+	docs := strings.NewReader(`{"name": "a"}
+{"id": "b", "name": "b"}
+{"name": "c", "n": 9007199254740993, "f": [1.5]}
+`)
+
+	insertOpts := InsertOpts{Durability: "soft"}
+	mock := NewMock()
+	mock.On(Table("test").Insert([]interface{}{
+		map[string]interface{}{"name": "a"},
+		map[string]interface{}{"id": "b", "name": "b"},
+	}, insertOpts)).Return(map[string]interface{}{"inserted": 2, "generated_keys": []interface{}{"k1"}}, nil)
+	mock.On(Table("test").Insert([]interface{}{
+		map[string]interface{}{"name": "c", "n": int64(9007199254740993), "f": []interface{}{1.5}},
+	}, insertOpts)).Return(map[string]interface{}{"inserted": 1, "generated_keys": []interface{}{"k2"}}, nil)
+
+	keys, errs := Table("test").InsertStreamChan(mock, docs, InsertStreamOpts{BatchSize: 2, InsertOpts: insertOpts})
+	var batches [][]string
+	for batch := range keys {
+		batches = append(batches, batch)
+	}
+	c.Assert(<-errs, test.IsNil)
+	c.Assert(batches, test.DeepEquals, [][]string{{"k1"}, {"k2"}})
+	mock.AssertExpectations(c)
+
+	// Invalid JSON stops inserting after the documents read before it
+	mock = NewMock()
+	mock.On(Table("test").Insert([]interface{}{map[string]interface{}{"name": "a"}})).Return(map[string]interface{}{"inserted": 1}, nil)
+	keys, errs = Table("test").InsertStreamChan(mock, strings.NewReader(`{"name": "a"} {"name":`), InsertStreamOpts{BatchSize: 1})
+	c.Assert(<-keys, test.DeepEquals, []string{})
+	_, ok := <-keys
+	c.Assert(ok, test.Equals, false)
+	c.Assert(<-errs, test.ErrorMatches, "rethinkdb: InsertStreamChan: reading document: .*")
+
+	// The options of the caller are kept and a failed batch stops inserting
+	mock = NewMock()
+	mock.On(Table("test").Insert([]interface{}{map[string]interface{}{"id": "a"}}, InsertOpts{ReturnChanges: true})).Return(map[string]interface{}{
+		"errors":      1,
+		"first_error": "Duplicate primary key `id`",
+	}, nil)
+	keys, errs = Table("test").InsertStreamChan(mock, strings.NewReader(`{"id": "a"} {"id": "b"}`), InsertStreamOpts{BatchSize: 1, InsertOpts: InsertOpts{ReturnChanges: true}})
+	_, ok = <-keys
+	c.Assert(ok, test.Equals, false)
+	err := <-errs
+	c.Assert(err, test.FitsTypeOf, RQLDriverError{})
+	c.Assert(err, test.ErrorMatches, "rethinkdb: InsertStreamChan: inserting documents: Duplicate primary key `id`")
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockInsertStreamChan_ContextDone(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("test").Insert(MockAnything())).Return(map[string]interface{}{"inserted": 1, "generated_keys": []interface{}{"k"}}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	keys, errs := Table("test").InsertStreamChan(mock, strings.NewReader(`{} {} {}`), InsertStreamOpts{BatchSize: 1, RunOpts: RunOpts{Context: ctx}})
+	c.Assert(<-keys, test.DeepEquals, []string{"k"})
+	cancel()
+
+	for range keys {
+	}
+	c.Assert(<-errs, test.Equals, context.Canceled)
+}
+
+func (s *MockSuite) TestMockRunInsertNotInsert(c *test.C) {
+	mock := NewMock()
+
+	_, err := DB("test").Table("test").Update(map[string]int{"val": 1}).RunInsert(mock)
+	c.Assert(err, test.FitsTypeOf, RQLDriverError{})
+}
+
 type simpleTestingT struct {
 	failed bool
+	errors []string
 }
 
 func (t *simpleTestingT) Logf(format string, args ...interface{}) {
 }
 func (t *simpleTestingT) Errorf(format string, args ...interface{}) {
 	t.failed = true
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
 }
 func (t *simpleTestingT) FailNow() {
 	t.failed = true
@@ -433,3 +772,481 @@ func (t *simpleTestingT) FailNow() {
 func (t *simpleTestingT) Failed() bool {
 	return t.failed
 }
+
+func (s *MockSuite) TestMockNumericDatums(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("test").Get(1)).Return(map[string]interface{}{"id": 1}, nil)
+
+	for _, key := range []interface{}{1.0, int64(1), uint8(1), float32(1), json.Number("1"), json.Number("1.0")} {
+		res, err := Table("test").Get(key).Run(mock)
+		c.Assert(err, test.IsNil, test.Commentf("%#v", key))
+		res.Close()
+	}
+
+	built, err := Expr(json.Number("12")).Build()
+	c.Assert(err, test.IsNil)
+	c.Assert(built, test.Equals, json.Number("12"))
+	_, err = Expr(json.Number("abc")).Build()
+	c.Assert(err, test.NotNil)
+
+	c.Assert(TermsEqual(Expr([]interface{}{1, 2.5}), Expr([]float64{1, 2.5})), test.Equals, true)
+	c.Assert(TermsEqual(Expr(1), Expr(1.5)), test.Equals, false)
+	c.Assert(TermsEqual(Expr(1), Expr("1")), test.Equals, false)
+	c.Assert(TermsEqual(Expr(1), Expr(true)), test.Equals, false)
+	c.Assert(TermsEqual(Expr(math.NaN()), Expr(math.NaN())), test.Equals, false)
+	// Large integers are compared exactly
+	c.Assert(TermsEqual(Expr(int64(1<<53+1)), Expr(float64(1<<53))), test.Equals, false)
+	c.Assert(TermsEqual(Expr(uint64(1<<63)), Expr(json.Number("9223372036854775808"))), test.Equals, true)
+	c.Assert(TermsEqual(Expr(1), Expr(json.Number("1"))), test.Equals, true)
+}
+
+func (s *MockSuite) TestAssertTermEqual(c *test.C) {
+	t := &simpleTestingT{}
+	adults := func(age int) Term {
+		return Table("users").Filter(func(user Term) Term { return user.Field("age").Gt(age) })
+	}
+	c.Assert(AssertTermEqual(t, adults(18), adults(18)), test.Equals, true)
+	c.Assert(AssertTermEqual(t, Table("users").Get(MockAnything()), Table("users").Get("bob")), test.Equals, true)
+	c.Assert(t.failed, test.Equals, false)
+
+	c.Assert(AssertTermEqual(t, adults(18), adults(21)), test.Equals, false)
+	c.Assert(t.errors, test.HasLen, 1)
+	c.Assert(t.errors[0], test.Matches, `(?s)Terms are not equal:.*First difference at args\[1\]\.args\[1\]\.args\[1\]:\n\texpected: 18\n\tactual:   21`)
+
+	t = &simpleTestingT{}
+	c.Assert(AssertTermEqual(t, Table("users").OrderBy(OrderByOpts{Index: "age"}), Table("users").OrderBy(OrderByOpts{Index: "name"})), test.Equals, false)
+	c.Assert(t.errors[0], test.Matches, `(?s).*First difference at optArgs\[index\]:\n\texpected: "age"\n\tactual:   "name"`)
+
+	t = &simpleTestingT{}
+	c.Assert(AssertTermEqual(t, Table("users"), DB("test").Table("users")), test.Equals, false)
+	c.Assert(t.errors[0], test.Matches, `(?s).*First difference at the root term:.*`)
+}
+
+func (s *MockSuite) TestMockSyncContext(c *test.C) {
+	mock := NewMock()
+	mock.On(DB("test").Table("test").Sync()).Return(map[string]interface{}{"synced": 1}, nil)
+
+	err := DB("test").Table("test").SyncContext(context.Background(), mock)
+	c.Assert(err, test.IsNil)
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockWaitForReady(c *test.C) {
+	status := func(ready bool) map[string]interface{} {
+		return map[string]interface{}{
+			"id":   "d6e2f4e8",
+			"name": "test",
+			"db":   "test",
+			"shards": []interface{}{map[string]interface{}{
+				"primary_replicas": []interface{}{"server1"},
+				"replicas":         []interface{}{map[string]interface{}{"server": "server1", "state": "ready"}},
+			}},
+			"status": map[string]interface{}{"all_replicas_ready": ready, "ready_for_writes": ready},
+		}
+	}
+
+	mock := NewMock()
+	mock.On(DB("test").Table("test").Status()).Return(status(false), nil).Once()
+	mock.On(DB("test").Table("test").Status()).Return(status(true), nil).Once()
+
+	res, err := waitForReady(context.Background(), mock, "test", "test")
+	c.Assert(err, test.IsNil)
+	c.Assert(res.Name, test.Equals, "test")
+	c.Assert(res.Status.AllReplicasReady, test.Equals, true)
+	c.Assert(res.Status.ReadyForWrites, test.Equals, true)
+	c.Assert(res.Shards, test.HasLen, 1)
+	c.Assert(res.Shards[0].Primaries, test.DeepEquals, []string{"server1"})
+	c.Assert(res.Shards[0].Replicas[0].State, test.Equals, "ready")
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockWaitForReady_ContextDone(c *test.C) {
+	mock := NewMock()
+	mock.On(DB("test").Table("test").Status()).Return(map[string]interface{}{"status": map[string]interface{}{"all_replicas_ready": false}}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := waitForReady(ctx, mock, "test", "test")
+	c.Assert(err, test.Equals, context.DeadlineExceeded)
+}
+
+func (s *MockSuite) TestMockSystemTables(c *test.C) {
+	started := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock := NewMock()
+	mock.On(DB("test").Table("test").Status()).Return(map[string]interface{}{
+		"name": "test", "db": "test", "status": map[string]interface{}{"ready_for_reads": true},
+	}, nil)
+	mock.On(DB("rethinkdb").Table("server_status")).Return([]interface{}{map[string]interface{}{
+		"id":   "a1b2",
+		"name": "server1",
+		"network": map[string]interface{}{
+			"hostname":            "db1",
+			"canonical_addresses": []interface{}{map[string]interface{}{"host": "10.0.0.1", "port": 29015}},
+			"reql_port":           28015,
+			"http_admin_port":     "<no http admin>",
+			"connected_to":        map[string]interface{}{"server2": true},
+		},
+		"process": map[string]interface{}{"pid": 42, "time_started": started, "version": "rethinkdb 2.4.0"},
+	}}, nil)
+	mock.On(DB("rethinkdb").Table("jobs")).Return([]interface{}{map[string]interface{}{
+		"id":           []interface{}{"query", "c3d4"},
+		"type":         "query",
+		"duration_sec": 0.5,
+		"servers":      []interface{}{"server1"},
+		"info":         map[string]interface{}{"client_address": "10.0.0.2"},
+	}}, nil)
+
+	status, err := tableStatus(context.Background(), mock, "test", "test")
+	c.Assert(err, test.IsNil)
+	c.Assert(status.Name, test.Equals, "test")
+	c.Assert(status.Status.ReadyForReads, test.Equals, true)
+
+	servers, err := serverStatus(context.Background(), mock)
+	c.Assert(err, test.IsNil)
+	c.Assert(servers, test.HasLen, 1)
+	c.Assert(servers[0].Name, test.Equals, "server1")
+	c.Assert(servers[0].Network.CanonicalAddresses, test.DeepEquals, []ServerAddress{{Host: "10.0.0.1", Port: 29015}})
+	c.Assert(servers[0].Network.ReqlPort, test.Equals, 28015)
+	c.Assert(servers[0].Network.ConnectedTo, test.DeepEquals, map[string]bool{"server2": true})
+	c.Assert(servers[0].Process.PID, test.Equals, 42)
+	c.Assert(servers[0].Process.TimeStarted.Equal(started), test.Equals, true)
+
+	jobs, err := jobs(context.Background(), mock)
+	c.Assert(err, test.IsNil)
+	c.Assert(jobs, test.HasLen, 1)
+	c.Assert(jobs[0].ID, test.DeepEquals, []string{"query", "c3d4"})
+	c.Assert(jobs[0].DurationSec, test.Equals, 0.5)
+	c.Assert(jobs[0].Info["client_address"], test.Equals, "10.0.0.2")
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockWaitIndex(c *test.C) {
+	mock := NewMock()
+	mock.On(DB("test").Table("test").IndexStatus("name")).Return([]interface{}{map[string]interface{}{
+		"index": "name", "ready": false, "multi": false, "geo": false, "outdated": false,
+		"query": "indexCreate('name', ...)", "progress": 0.5, "blocks_processed": 5, "blocks_total": 10,
+	}}, nil).Once()
+	mock.On(DB("test").Table("test").IndexStatus("name")).Return([]interface{}{map[string]interface{}{
+		"index": "name", "ready": true, "multi": true, "geo": false, "outdated": false,
+		"query": "indexCreate('name', ...)",
+	}}, nil).Once()
+
+	status, err := indexStatus(context.Background(), mock, "test", "test", "name")
+	c.Assert(err, test.IsNil)
+	c.Assert(status.Ready, test.Equals, false)
+	c.Assert(status.Progress, test.Equals, 0.5)
+	c.Assert(status.BlocksProcessed, test.Equals, int64(5))
+	c.Assert(status.BlocksTotal, test.Equals, int64(10))
+
+	res, err := waitIndex(context.Background(), mock, "test", "test", "name")
+	c.Assert(err, test.IsNil)
+	c.Assert(res.Index, test.Equals, "name")
+	c.Assert(res.Ready, test.Equals, true)
+	c.Assert(res.Multi, test.Equals, true)
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockWaitIndex_ContextDone(c *test.C) {
+	mock := NewMock()
+	mock.On(DB("test").Table("test").IndexStatus("name")).Return([]interface{}{map[string]interface{}{"index": "name", "ready": false}}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	res, err := waitIndex(ctx, mock, "test", "test", "name")
+	c.Assert(err, test.Equals, context.DeadlineExceeded)
+	c.Assert(res.Index, test.Equals, "name")
+}
+
+func (s *MockSuite) TestMockWaitIndex_Error(c *test.C) {
+	mock := NewMock()
+	mock.On(DB("test").Table("test").IndexStatus("missing")).Return(nil, errors.New("Index `missing` was not found"))
+
+	_, err := waitIndex(context.Background(), mock, "test", "test", "missing")
+	c.Assert(err, test.ErrorMatches, "Index `missing` was not found")
+}
+
+func (s *MockSuite) TestMockUseJSONNumber(c *test.C) {
+	mock := NewMock(ConnectOpts{UseJSONNumber: true})
+	mock.On(Table("test")).Return([]interface{}{
+		map[string]interface{}{"id": 1, "num": int64(1) << 60},
+	}, nil)
+
+	res, err := Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+
+	var rows []map[string]interface{}
+	c.Assert(res.All(&rows), test.IsNil)
+	c.Assert(rows, test.DeepEquals, []map[string]interface{}{
+		{"id": json.Number("1"), "num": json.Number("1152921504606846976")},
+	})
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockUseJSONNumber_RunOpts(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("test")).Return([]interface{}{
+		map[string]interface{}{"num": int64(1)<<60 + 1},
+	}, nil)
+
+	useNumber := true
+	res, err := Table("test").Run(mock, RunOpts{UseJSONNumber: &useNumber})
+	c.Assert(err, test.IsNil)
+	var rows []map[string]interface{}
+	c.Assert(res.All(&rows), test.IsNil)
+	c.Assert(rows, test.DeepEquals, []map[string]interface{}{{"num": json.Number("1152921504606846977")}})
+
+	res, err = Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+	c.Assert(res.All(&rows), test.IsNil)
+	c.Assert(rows, test.DeepEquals, []map[string]interface{}{{"num": float64(1 << 60)}})
+
+	// The override also turns the connection setting off
+	mock = NewMock(ConnectOpts{UseJSONNumber: true})
+	mock.On(Table("test")).Return([]interface{}{map[string]interface{}{"num": 1}}, nil)
+	useNumber = false
+	res, err = Table("test").Run(mock, RunOpts{UseJSONNumber: &useNumber})
+	c.Assert(err, test.IsNil)
+	c.Assert(res.All(&rows), test.IsNil)
+	c.Assert(rows, test.DeepEquals, []map[string]interface{}{{"num": float64(1)}})
+}
+
+func (s *MockSuite) TestMockReturnChangefeed(c *test.C) {
+	changes := make(chan interface{})
+	mock := NewMock()
+	mock.On(Table("test").Changes()).ReturnChangefeed(changes)
+
+	go func() {
+		changes <- map[string]interface{}{"new_val": map[string]interface{}{"id": 1}}
+		changes <- map[string]interface{}{"new_val": map[string]interface{}{"id": 2}, "old_val": map[string]interface{}{"id": 1}}
+		close(changes)
+	}()
+
+	res, err := Table("test").Changes().Run(mock)
+	c.Assert(err, test.IsNil)
+
+	var change ChangeResponse
+	c.Assert(res.Next(&change), test.Equals, true)
+	c.Assert(change.NewValue, tests.JsonEquals, map[string]interface{}{"id": 1})
+	c.Assert(change.OldValue, test.IsNil)
+
+	c.Assert(res.Next(&change), test.Equals, true)
+	c.Assert(change.NewValue, tests.JsonEquals, map[string]interface{}{"id": 2})
+	c.Assert(change.OldValue, tests.JsonEquals, map[string]interface{}{"id": 1})
+
+	c.Assert(res.Next(&change), test.Equals, false)
+	c.Assert(res.Err(), test.IsNil)
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockReturnChangefeed_ChangeType(c *test.C) {
+	changes := make(chan interface{}, 4)
+	changes <- map[string]interface{}{"new_val": map[string]interface{}{"id": 1}, "type": "initial"}
+	changes <- map[string]interface{}{"state": "ready", "type": "state"}
+	changes <- map[string]interface{}{"old_val": map[string]interface{}{"id": 1}, "type": "remove"}
+	changes <- map[string]interface{}{"new_val": map[string]interface{}{"id": 2}, "type": "moved"}
+	close(changes)
+	mock := NewMock()
+	mock.On(Table("test").Changes(ChangesOpts{IncludeTypes: true})).ReturnChangefeed(changes)
+
+	res, err := Table("test").Changes(ChangesOpts{IncludeTypes: true}).Run(mock)
+	c.Assert(err, test.IsNil)
+
+	var types []ChangeType
+	var change ChangeResponse
+	for res.Next(&change) {
+		types = append(types, change.ChangeType())
+		change = ChangeResponse{}
+	}
+	c.Assert(res.Err(), test.IsNil)
+	c.Assert(types, test.DeepEquals, []ChangeType{ChangeTypeInitial, ChangeTypeState, ChangeTypeRemove, ChangeTypeUnknown})
+	c.Assert(ChangeTypeRemove.String(), test.Equals, "remove")
+	c.Assert(ChangeTypeUnknown.String(), test.Equals, "unknown")
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockReturnChangefeed_StaysOpen(c *test.C) {
+	changes := make(chan interface{})
+	mock := NewMock()
+	mock.On(Table("test").Changes()).ReturnChangefeed(changes)
+
+	res, err := Table("test").Changes().Run(mock)
+	c.Assert(err, test.IsNil)
+
+	received := make(chan interface{})
+	go func() {
+		var change interface{}
+		for res.Next(&change) {
+			received <- change
+		}
+		close(received)
+	}()
+
+	select {
+	case <-received:
+		c.Fatal("received a change before one was sent")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	changes <- 1
+	c.Assert(<-received, tests.JsonEquals, 1)
+
+	close(changes)
+	_, ok := <-received
+	c.Assert(ok, test.Equals, false)
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockReturnChangefeed_Close(c *test.C) {
+	changes := make(chan interface{})
+	mock := NewMock()
+	mock.On(Table("test").Changes()).ReturnChangefeed(changes)
+
+	res, err := Table("test").Changes().Run(mock)
+	c.Assert(err, test.IsNil)
+
+	go func() { changes <- 1 }()
+	var change interface{}
+	c.Assert(res.Next(&change), test.Equals, true)
+	c.Assert(change, tests.JsonEquals, 1)
+
+	c.Assert(res.Close(), test.IsNil)
+	mock.AssertExpectations(c)
+}
+
+func (s *MockSuite) TestMockHTTP(c *test.C) {
+	type post struct {
+		ID    int    `rethinkdb:"id"`
+		Title string `rethinkdb:"title"`
+	}
+
+	mock := NewMock()
+	mock.On(HTTP("example.com/posts/1", HTTPOpts{ResultFormat: "json"})).Return(map[string]interface{}{
+		"id":    1,
+		"title": "hello",
+	}, nil)
+	mock.On(HTTP("example.com/robots.txt", HTTPOpts{ResultFormat: "text"})).Return("User-agent: *", nil)
+
+	res, err := HTTP("example.com/posts/1", HTTPOpts{ResultFormat: "json"}).Run(mock)
+	c.Assert(err, test.IsNil)
+
+	var p post
+	c.Assert(res.One(&p), test.IsNil)
+	c.Assert(p, test.Equals, post{ID: 1, Title: "hello"})
+
+	res, err = HTTP("example.com/robots.txt", HTTPOpts{ResultFormat: "text"}).Run(mock)
+	c.Assert(err, test.IsNil)
+
+	var text string
+	c.Assert(res.One(&text), test.IsNil)
+	c.Assert(text, test.Equals, "User-agent: *")
+
+	mock.AssertExpectations(c)
+}
+
+type recordedQuery struct {
+	ctx       context.Context
+	builtJSON string
+	err       error
+}
+
+type testQueryRecorder struct {
+	mu      sync.Mutex
+	queries []recordedQuery
+}
+
+func (r *testQueryRecorder) Record(ctx context.Context, q Query, builtJSON []byte, dur time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries = append(r.queries, recordedQuery{ctx: ctx, builtJSON: string(builtJSON), err: err})
+}
+
+func (s *MockSuite) TestMockQueryRecorder(c *test.C) {
+	recorder := &testQueryRecorder{}
+	mock := NewMock(ConnectOpts{QueryRecorder: recorder})
+	mock.On(Table("test").Get(1)).Return(map[string]interface{}{"id": 1}, nil)
+	mock.On(Table("test").Delete()).Return(nil, errors.New("failed"))
+
+	_, err := Table("test").Get(1).Run(mock)
+	c.Assert(err, test.IsNil)
+	err = Table("test").Delete().Exec(mock)
+	c.Assert(err, test.ErrorMatches, "failed")
+
+	c.Assert(recorder.queries, test.HasLen, 2)
+	c.Assert(recorder.queries[0].builtJSON, test.Equals, `[1,[16,[[15,["test"]],1]]]`)
+	c.Assert(recorder.queries[0].err, test.IsNil)
+	c.Assert(recorder.queries[1].builtJSON, test.Equals, `[1,[54,[[15,["test"]]]]]`)
+	c.Assert(recorder.queries[1].err, test.ErrorMatches, "failed")
+}
+
+func (s *MockSuite) TestMockPreferReplica(c *test.C) {
+	recorder := &testQueryRecorder{}
+	mock := NewMock(ConnectOpts{QueryRecorder: recorder})
+	mock.On(Table("test")).Return([]interface{}{}, nil)
+
+	res, err := Table("test").Run(mock, RunOpts{PreferReplica: true})
+	c.Assert(err, test.IsNil)
+	c.Assert(res.ServedBy(), test.Equals, ServerResponse{})
+	_, err = Table("test").Run(mock, RunOpts{PreferReplica: true, ReadMode: "single"})
+	c.Assert(err, test.IsNil)
+
+	c.Assert(recorder.queries, test.HasLen, 2)
+	c.Assert(recorder.queries[0].builtJSON, test.Equals, `[1,[15,["test"]],{"read_mode":"outdated"}]`)
+	c.Assert(recorder.queries[1].builtJSON, test.Equals, `[1,[15,["test"]],{"read_mode":"single"}]`)
+}
+
+type requestIDKey struct{}
+
+func (s *MockSuite) TestMockQueryRecorder_Context(c *test.C) {
+	recorder := &testQueryRecorder{}
+	mock := NewMock(ConnectOpts{QueryRecorder: recorder})
+	mock.On(Table("test").Get(1)).Return(map[string]interface{}{"id": 1}, nil)
+	mock.On(Table("test").Get(1).Delete()).Return(nil, nil)
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-1")
+	res, err := Table("test").Get(1).Run(mock, RunOpts{Context: ctx})
+	c.Assert(err, test.IsNil)
+	c.Assert(res.ctx.Value(requestIDKey{}), test.Equals, "req-1")
+	c.Assert(Table("test").Get(1).Delete().Exec(mock, ExecOpts{Context: ctx}), test.IsNil)
+	_, err = Table("test").Get(1).Run(mock)
+	c.Assert(err, test.IsNil)
+
+	c.Assert(recorder.queries, test.HasLen, 3)
+	c.Assert(recorder.queries[0].ctx.Value(requestIDKey{}), test.Equals, "req-1")
+	c.Assert(recorder.queries[1].ctx.Value(requestIDKey{}), test.Equals, "req-1")
+	c.Assert(recorder.queries[2].ctx, test.NotNil)
+	c.Assert(recorder.queries[2].ctx.Value(requestIDKey{}), test.IsNil)
+}
+
+func (s *MockSuite) TestMockLenient(c *test.C) {
+	mock := NewMock()
+	c.Assert(func() { Table("test").Run(mock) }, test.PanicMatches, "(?s)rethinkdb: mock: This query was unexpected.*")
+	c.Assert(func() { Table("test").Run(&Mock{}) }, test.PanicMatches, "(?s)rethinkdb: mock: This query was unexpected.*")
+
+	mock = NewMock()
+	mock.Lenient = true
+	mock.On(Table("test")).Return([]interface{}{1}, nil)
+
+	res, err := Table("test").Run(mock)
+	c.Assert(err, test.IsNil)
+	var rows []int
+	c.Assert(res.All(&rows), test.IsNil)
+	c.Assert(rows, test.DeepEquals, []int{1})
+	c.Assert(mock.AssertNoUnexpectedQueries(c), test.Equals, true)
+
+	res, err = Table("other").Filter(map[string]interface{}{"a": 1}).Run(mock)
+	c.Assert(err, test.IsNil)
+	var row interface{}
+	c.Assert(res.Next(&row), test.Equals, false)
+	c.Assert(res.Err(), test.IsNil)
+
+	c.Assert(mock.Queries, test.HasLen, 1)
+	c.Assert(mock.UnexpectedQueries, test.HasLen, 1)
+	c.Assert(mock.UnexpectedQueries[0].Query.Term.String(), test.Equals, `r.Table("other").Filter({a=1})`)
+
+	t := &simpleTestingT{}
+	c.Assert(mock.AssertNoUnexpectedQueries(t), test.Equals, false)
+	c.Assert(t.Failed(), test.Equals, true)
+	mock.AssertExpectations(c)
+}
@@ -10,6 +10,7 @@ import (
 // Node represents a database server in the cluster
 type Node struct {
 	ID      string
+	Name    string // Server name, as listed in the status of tables.
 	Host    Host
 	aliases []Host
 
@@ -62,6 +63,15 @@ func (n *Node) Close(optArgs ...CloseOpts) error {
 	return nil
 }
 
+// busy returns true if queries on the node's connection pool are waiting for
+// a response or have more results to fetch.
+func (n *Node) busy() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return !n.closed && n.pool != nil && n.pool.busy()
+}
+
 // SetInitialPoolCap sets the initial capacity of the connection pool.
 func (n *Node) SetInitialPoolCap(idleConns int) {
 	n.pool.SetInitialPoolCap(idleConns)
@@ -105,6 +115,16 @@ func (n *Node) Exec(ctx context.Context, q Query) (err error) {
 	return n.pool.Exec(ctx, q)
 }
 
+// query executes a ReQL query like Query, sent reports whether the query may
+// have reached the server.
+func (n *Node) query(ctx context.Context, q Query) (*Cursor, bool, error) {
+	if n.Closed() {
+		return nil, false, ErrInvalidNode
+	}
+
+	return n.pool.query(ctx, q)
+}
+
 // Server returns the server name and server UUID being used by a connection.
 func (n *Node) Server() (ServerResponse, error) {
 	var response ServerResponse
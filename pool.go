@@ -4,6 +4,7 @@ import (
 	"errors"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/context"
 )
@@ -19,7 +20,17 @@ const (
 
 type connFactory func(host string, opts *ConnectOpts) (*Connection, error)
 
-// A Pool is used to store a pool of connections to a single RethinkDB server
+// A Pool is used to store a pool of connections to a single RethinkDB server.
+//
+// Connections are multiplexed so they are never checked out of the pool,
+// instead each query is sent on the next connection in round-robin order. This
+// spreads queries evenly over all connections and a query never waits for
+// another query to release a connection.
+//
+// As there is no queue of idle connections the pool has no option to hand
+// them out FIFO or LIFO: round-robin already uses the connection which has
+// waited longest, as FIFO would. Tail latency under contention comes from the
+// queries sharing a connection, raise ConnectOpts.MaxOpen to reduce it.
 type Pool struct {
 	host Host
 	opts *ConnectOpts
@@ -73,14 +84,23 @@ func newPool(host Host, opts *ConnectOpts, connFactory connFactory) (*Pool, erro
 // Ping verifies a connection to the database is still alive,
 // establishing a connection if necessary.
 func (p *Pool) Ping() error {
-	_, err := p.conn()
-	return err
+	c, err := p.conn()
+	if err != nil {
+		return err
+	}
+	c.release()
+	return nil
 }
 
 // Close closes the database, releasing any open resources.
 //
 // It is rare to Close a Pool, as the Pool handle is meant to be
 // long-lived and shared between many goroutines.
+//
+// If WaitNoReplyOnClose is set then Close waits for the noreply queries sent
+// on each connection to be processed before closing it, this adds a round trip
+// to the server per connection and may take as long as the slowest of the
+// outstanding queries.
 func (p *Pool) Close() error {
 	if atomic.LoadInt32(&p.closed) == poolIsClosed {
 		return nil
@@ -94,8 +114,15 @@ func (p *Pool) Close() error {
 	}
 	p.closed = poolIsClosed
 
+	var waitErr error
 	for _, c := range p.conns {
 		if c != nil {
+			if p.opts.WaitNoReplyOnClose && !c.isBad() {
+				if err := c.waitNoReply(); err != nil && waitErr == nil {
+					waitErr = err
+				}
+			}
+
 			err := c.Close()
 			if err != nil {
 				return err
@@ -103,10 +130,38 @@ func (p *Pool) Close() error {
 		}
 	}
 
-	return nil
+	return waitErr
 }
 
+// busy returns true if any of the pool's connections are busy.
+func (p *Pool) busy() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.conns {
+		if c != nil && !c.isClosed() && c.busy() {
+			return true
+		}
+	}
+	return false
+}
+
+// conn returns the next connection of the pool, release must be called once
+// the query has been sent on it.
 func (p *Pool) conn() (*Connection, error) {
+	for {
+		c, err := p.nextConn()
+		if err != nil {
+			return nil, err
+		}
+		// A connection retired after it was read from the pool is skipped
+		if c.acquire() {
+			return c, nil
+		}
+	}
+}
+
+func (p *Pool) nextConn() (*Connection, error) {
 	if atomic.LoadInt32(&p.closed) == poolIsClosed {
 		return nil, errPoolClosed
 	}
@@ -138,11 +193,45 @@ func (p *Pool) conn() (*Connection, error) {
 		if err != nil {
 			return nil, err
 		}
+	} else if p.conns[pos].expired(time.Now()) {
+		// idle or old connection is replaced, see ConnMaxIdleTime and
+		// ConnMaxLifetime
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if old := p.conns[pos]; old.expired(time.Now()) {
+			c, err := p.connFactory(p.host.String(), p.opts)
+			if err != nil {
+				return nil, err
+			}
+			p.conns[pos] = c
+			go p.retire(old)
+		}
 	}
 
 	return p.conns[pos], nil
 }
 
+// retirePollInterval is how often retire checks whether a replaced connection
+// is still in use.
+const retirePollInterval = 10 * time.Millisecond
+
+// retire closes a connection replaced in the pool once the queries sent on it
+// have completed, including the queries of goroutines which took it from the
+// pool before it was replaced. Like Close it waits for the noreply queries
+// sent on the connection if WaitNoReplyOnClose is set.
+func (p *Pool) retire(c *Connection) {
+	atomic.StoreInt32(&c.retired, 1)
+	for atomic.LoadInt32(&c.users) > 0 || c.busy() {
+		time.Sleep(retirePollInterval)
+	}
+
+	if p.opts.WaitNoReplyOnClose && !c.isBad() {
+		c.waitNoReply()
+	}
+	c.Close()
+}
+
 // SetInitialPoolCap sets the initial capacity of the connection pool.
 //
 // Deprecated: This value should only be set when connecting
@@ -169,24 +258,27 @@ func (p *Pool) SetMaxOpenConns(n int) {
 
 // Exec executes a query without waiting for any response.
 func (p *Pool) Exec(ctx context.Context, q Query) error {
-	c, err := p.conn()
-	if err != nil {
-		return err
-	}
-
-	_, _, err = c.Query(ctx, q)
+	_, _, err := p.query(ctx, q)
 	return err
 }
 
 // Query executes a query and waits for the response
 func (p *Pool) Query(ctx context.Context, q Query) (*Cursor, error) {
+	cursor, _, err := p.query(ctx, q)
+	return cursor, err
+}
+
+// query executes a query like Query, sent reports whether the query may have
+// reached the server.
+func (p *Pool) query(ctx context.Context, q Query) (*Cursor, bool, error) {
 	c, err := p.conn()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
+	defer c.release()
 
-	_, cursor, err := c.Query(ctx, q)
-	return cursor, err
+	_, cursor, sent, err := c.query(ctx, q)
+	return cursor, sent, err
 }
 
 // Server returns the server name and server UUID being used by a connection.
@@ -197,6 +289,7 @@ func (p *Pool) Server() (ServerResponse, error) {
 	if err != nil {
 		return response, err
 	}
+	defer c.release()
 
 	response, err = c.Server()
 	return response, err
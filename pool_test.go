@@ -0,0 +1,152 @@
+package rethinkdb
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	test "gopkg.in/check.v1"
+	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
+)
+
+type PoolSuite struct{}
+
+var _ = test.Suite(&PoolSuite{})
+
+func (s *PoolSuite) TestPool_Close_WaitNoReply(c *test.C) {
+	q := testQuery(Table("table").Insert(map[string]interface{}{"id": 1}))
+	q.Opts["noreply"] = true
+	writeData := serializeQuery(1, q)
+	waitData := serializeQuery(2, Query{Type: p.Query_NOREPLY_WAIT, Opts: map[string]interface{}{}})
+	respData, _ := json.Marshal(map[string]interface{}{"t": p.Response_WAIT_COMPLETE, "r": []interface{}{}})
+	header := respHeader(2, respData)
+
+	conn := &connMock{}
+	conn.On("Write", writeData).Return(len(writeData), nil, nil)
+	conn.On("Write", waitData).Return(len(waitData), nil, nil)
+	conn.On("Read", respHeaderLen).Return(header, respHeaderLen, nil, nil)
+	conn.On("Read", len(respData)).Return(respData, len(respData), nil, nil)
+	conn.On("Close").Return(nil)
+
+	factory := func(host string, opts *ConnectOpts) (*Connection, error) {
+		connection := newConnection(conn, host, opts)
+		runConnection(connection)
+		return connection, nil
+	}
+	pool, err := newPool(NewHost("localhost", 28015), &ConnectOpts{WaitNoReplyOnClose: true}, factory)
+	c.Assert(err, test.IsNil)
+
+	c.Assert(pool.Exec(nil, q), test.IsNil)
+	c.Assert(pool.Close(), test.IsNil)
+	conn.AssertExpectations(c)
+}
+
+func (s *PoolSuite) TestPool_Close_NoWaitWithoutNoReply(c *test.C) {
+	conn := &connMock{}
+	conn.On("Close").Return(nil)
+
+	factory := func(host string, opts *ConnectOpts) (*Connection, error) {
+		return newConnection(conn, host, opts), nil
+	}
+	pool, err := newPool(NewHost("localhost", 28015), &ConnectOpts{WaitNoReplyOnClose: true}, factory)
+	c.Assert(err, test.IsNil)
+
+	c.Assert(pool.Ping(), test.IsNil)
+	c.Assert(pool.Close(), test.IsNil)
+	conn.AssertExpectations(c)
+}
+
+func (s *PoolSuite) TestPool_conn_Recycle(c *test.C) {
+	conn := &connMock{}
+	conn.On("Close").Return(nil)
+
+	var created []*Connection
+	factory := func(host string, opts *ConnectOpts) (*Connection, error) {
+		connection := newConnection(conn, host, opts)
+		created = append(created, connection)
+		return connection, nil
+	}
+	opts := &ConnectOpts{ConnMaxIdleTime: time.Minute, ConnMaxLifetime: time.Hour}
+	pool, err := newPool(NewHost("localhost", 28015), opts, factory)
+	c.Assert(err, test.IsNil)
+
+	first, err := pool.conn()
+	c.Assert(err, test.IsNil)
+	first.release()
+	got, err := pool.conn()
+	c.Assert(err, test.IsNil)
+	c.Assert(got == first, test.Equals, true)
+
+	// An idle connection is replaced, it is only closed once the goroutine
+	// which took it from the pool before it was replaced is done with it
+	first.lastUsed = time.Now().Add(-2 * time.Minute).UnixNano()
+	second, err := pool.conn()
+	c.Assert(err, test.IsNil)
+	second.release()
+	c.Assert(second == first, test.Equals, false)
+	time.Sleep(3 * retirePollInterval)
+	c.Assert(first.isClosed(), test.Equals, false)
+	c.Assert(first.acquire(), test.Equals, false)
+	got.release()
+	for i := 0; i < 100 && !first.isClosed(); i++ {
+		time.Sleep(retirePollInterval)
+	}
+	c.Assert(first.isClosed(), test.Equals, true)
+
+	// A busy connection is kept until it is no longer busy
+	second.createdAt = time.Now().Add(-2 * time.Hour)
+	second.openCursors = 1
+	got, err = pool.conn()
+	c.Assert(err, test.IsNil)
+	got.release()
+	c.Assert(got == second, test.Equals, true)
+
+	second.openCursors = 0
+	got, err = pool.conn()
+	c.Assert(err, test.IsNil)
+	got.release()
+	c.Assert(got == second, test.Equals, false)
+	c.Assert(created, test.HasLen, 3)
+	c.Assert(pool.Close(), test.IsNil)
+}
+
+func BenchmarkPool_conn_Contention(b *testing.B) {
+	factory := func(host string, opts *ConnectOpts) (*Connection, error) {
+		return newConnection(nil, host, opts), nil
+	}
+	pool, err := newPool(NewHost("localhost", 28015), &ConnectOpts{MaxOpen: 8}, factory)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	waits := make([]time.Duration, 0, b.N)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		local := make([]time.Duration, 0, 1024)
+		for pb.Next() {
+			start := time.Now()
+			c, err := pool.conn()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			c.release()
+			local = append(local, time.Since(start))
+		}
+
+		mu.Lock()
+		waits = append(waits, local...)
+		mu.Unlock()
+	})
+	b.StopTimer()
+
+	if len(waits) == 0 {
+		return
+	}
+	sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+	b.Logf("p50: %s, p99: %s, max: %s", waits[len(waits)/2], waits[len(waits)*99/100], waits[len(waits)-1])
+}
@@ -1,6 +1,7 @@
 package rethinkdb
 
 import (
+	"bytes"
 	"encoding/base64"
 	"math"
 	"strconv"
@@ -85,6 +86,12 @@ func convertPseudotype(obj map[string]interface{}, opts map[string]interface{})
 			} else {
 				return nil, fmt.Errorf("Unknown geometry_format run option \"%s\".", reqlType)
 			}
+		} else if reqlType == nonFinitePseudotype {
+			if reject, ok := opts["reject_non_finite"].(bool); ok && reject {
+				return nil, fmt.Errorf("Non-finite number %v in result.", obj["value"])
+			}
+
+			return reqlNonFiniteToFloat(obj)
 		} else {
 			return obj, nil
 		}
@@ -233,3 +240,63 @@ func reqlGeometryToNativeGeometry(obj map[string]interface{}) (interface{}, erro
 		return nil, fmt.Errorf("pseudo-type GEOMETRY object %v field has unknown type %s", obj, typ)
 	}
 }
+
+// nonFinitePseudotype is the type used by replaceNonFiniteNumbers for the
+// objects that replace Infinity, -Infinity and NaN, it is never sent by the
+// server.
+const nonFinitePseudotype = "NON_FINITE"
+
+func reqlNonFiniteToFloat(obj map[string]interface{}) (interface{}, error) {
+	switch obj["value"] {
+	case "Infinity":
+		return math.Inf(1), nil
+	case "-Infinity":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	return nil, fmt.Errorf("pseudo-type %s object %v field \"value\" is not valid", nonFinitePseudotype, obj)
+}
+
+// replaceNonFiniteNumbers replaces the Infinity, -Infinity and NaN tokens,
+// which are not valid JSON, outside of strings in b with pseudo-type objects
+// which are converted back to float64 values by convertPseudotype. It returns
+// false if no tokens were found.
+func replaceNonFiniteNumbers(b []byte) ([]byte, bool) {
+	var out []byte
+	inString, escaped := false, false
+	last := 0
+
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if inString {
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+			continue
+		}
+
+		for _, token := range []string{"-Infinity", "Infinity", "NaN"} {
+			if bytes.HasPrefix(b[i:], []byte(token)) {
+				out = append(out, b[last:i]...)
+				out = append(out, `{"$reql_type$":"`+nonFinitePseudotype+`","value":"`+token+`"}`...)
+				i += len(token) - 1
+				last = i + 1
+				break
+			}
+		}
+	}
+
+	if out == nil {
+		return b, false
+	}
+	return append(out, b[last:]...), true
+}
@@ -2,11 +2,18 @@ package rethinkdb
 
 import (
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/segmentio/encoding/json"
 	"golang.org/x/net/context"
+	"gopkg.in/rethinkdb/rethinkdb-go.v6/encoding"
 	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 )
 
@@ -16,11 +23,20 @@ import (
 // and also allows the driver to identify the response as they can come out of
 // order.
 type Query struct {
-	Type      p.Query_QueryType
-	Token     int64
-	Term      *Term
-	Opts      map[string]interface{}
-	builtTerm interface{}
+	Type  p.Query_QueryType
+	Token int64
+	Term  *Term
+	Opts  map[string]interface{}
+	// Name is an optional label set using RunOpts.QueryName, it is never sent
+	// to the server but is attached to tracing spans and log output.
+	Name string
+	// Idempotent is set using RunOpts.Idempotent, it allows a write query to
+	// be retried after a connection error.
+	Idempotent bool
+	// PreferReplica is set using RunOpts.PreferReplica, the cluster sends the
+	// query to a server which isn't a primary replica of the tables it reads.
+	PreferReplica bool
+	builtTerm     interface{}
 }
 
 func (q *Query) Build() []interface{} {
@@ -34,7 +50,7 @@ func (q *Query) Build() []interface{} {
 		opts := map[string]interface{}{}
 		for k, v := range q.Opts {
 			switch k {
-			case "geometry_format":
+			case "geometry_format", "reject_non_finite", "allow_full_table_write":
 			default:
 				opts[k] = v
 			}
@@ -65,6 +81,8 @@ type Term struct {
 	optArgs        map[string]Term
 	lastErr        error
 	isMockAnything bool
+	// timeout is set by WithTimeout
+	timeout time.Duration
 }
 
 func (t Term) compare(t2 Term, varMap map[int64]int64) bool {
@@ -76,7 +94,7 @@ func (t Term) compare(t2 Term, varMap map[int64]int64) bool {
 		t.rawQuery != t2.rawQuery ||
 		t.rootTerm != t2.rootTerm ||
 		t.termType != t2.termType ||
-		!reflect.DeepEqual(t.data, t2.data) ||
+		!datumEqual(t.data, t2.data) ||
 		len(t.args) != len(t2.args) ||
 		len(t.optArgs) != len(t2.optArgs) {
 		return false
@@ -123,6 +141,121 @@ func (t Term) compare(t2 Term, varMap map[int64]int64) bool {
 	return true
 }
 
+// diff compares t with t2 like compare, if they differ path lists the
+// arguments leading from t to the first subterm which differs, such as
+// "args[1]" or "optArgs[index]", in order, and sub and sub2 are the differing
+// subterms of t and t2. Unlike compare it sorts the optional arguments so the
+// path is stable, it is only used to report terms which are known to differ.
+func (t Term) diff(t2 Term, varMap map[int64]int64) (path []string, sub, sub2 Term, differ bool) {
+	if t.isMockAnything || t2.isMockAnything {
+		return nil, sub, sub2, false
+	}
+
+	if t.name != t2.name ||
+		t.rawQuery != t2.rawQuery ||
+		t.rootTerm != t2.rootTerm ||
+		t.termType != t2.termType ||
+		!datumEqual(t.data, t2.data) ||
+		len(t.args) != len(t2.args) ||
+		len(t.optArgs) != len(t2.optArgs) {
+		return nil, t, t2, true
+	}
+
+	for i, v := range t.args {
+		if t.termType == p.Term_FUNC && t2.termType == p.Term_FUNC && i == 0 {
+			// Functions need to be compared differently as each variable
+			// will have a different var ID so first try to create a mapping
+			// between the two sets of IDs
+			argsArr := t.args[0].args
+			argsArr2 := t2.args[0].args
+
+			if len(argsArr) != len(argsArr2) {
+				return []string{"args[0]"}, t.args[0], t2.args[0], true
+			}
+
+			for j := 0; j < len(argsArr); j++ {
+				varMap[argsArr[j].data.(int64)] = argsArr2[j].data.(int64)
+			}
+		} else if t.termType == p.Term_VAR && t2.termType == p.Term_VAR && i == 0 {
+			// When comparing vars use our var map
+			v1 := t.args[i].data.(int64)
+			v2 := t2.args[i].data.(int64)
+
+			if varMap[v1] != v2 {
+				return nil, t, t2, true
+			}
+		} else if path, sub, sub2, differ := v.diff(t2.args[i], varMap); differ {
+			return append([]string{fmt.Sprintf("args[%d]", i)}, path...), sub, sub2, true
+		}
+	}
+
+	keys := make([]string, 0, len(t.optArgs))
+	for k := range t.optArgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, ok := t2.optArgs[k]; !ok {
+			return nil, t, t2, true
+		}
+
+		if path, sub, sub2, differ := t.optArgs[k].diff(t2.optArgs[k], varMap); differ {
+			return append([]string{fmt.Sprintf("optArgs[%s]", k)}, path...), sub, sub2, true
+		}
+	}
+
+	return nil, sub, sub2, false
+}
+
+// datumEqual returns true if the data of two terms are equal, numbers are equal
+// if they have the same value regardless of their type, so 1, 1.0 and
+// json.Number("1") are equal.
+func datumEqual(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+
+	ra, ok := numberRat(a)
+	if !ok {
+		return false
+	}
+	rb, ok := numberRat(b)
+	return ok && ra.Cmp(rb) == 0
+}
+
+// numberRat returns the exact value of v if it is a number.
+func numberRat(v interface{}) (*big.Rat, bool) {
+	if n, ok := v.(json.Number); ok {
+		return new(big.Rat).SetString(n.String())
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Rat).SetUint64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, false
+		}
+		return new(big.Rat).SetFloat64(f), true
+	}
+	return nil, false
+}
+
+// TermsEqual returns true if the two terms represent the same query, it uses
+// the same comparison as Mock. A term created with MockAnything is equal to any
+// other term. Functions are equal if their bodies are equal once variables are
+// renamed, so they compare equal regardless of the variable IDs assigned when
+// the terms were built. r.Row is only equal to r.Row, not to the argument of a
+// function. Numbers are equal if they have the same value, so Get(1) is equal
+// to Get(1.0).
+func TermsEqual(a, b Term) bool {
+	return a.compare(b, map[int64]int64{})
+}
+
 // build takes the query tree and prepares it to be sent as a JSON
 // expression
 func (t Term) Build() (interface{}, error) {
@@ -237,6 +370,224 @@ func (t Term) String() string {
 	return fmt.Sprintf("%s.%s(%s)", t.args[0].String(), t.name, strings.Join(allArgsToStringSlice(t.args[1:], t.optArgs), ", "))
 }
 
+// UsesIndex returns true if the query tree contains a term which reads from
+// the named secondary index, such as GetAllByIndex, Between, OrderBy, EqJoin,
+// GetIntersecting, GetNearest or Distinct with the index option set. This can
+// be used in tests to catch queries which fall back to a full table scan.
+func (t Term) UsesIndex(index string) bool {
+	switch t.termType {
+	case p.Term_GET_ALL, p.Term_BETWEEN, p.Term_ORDER_BY, p.Term_EQ_JOIN,
+		p.Term_GET_INTERSECTING, p.Term_GET_NEAREST, p.Term_DISTINCT:
+		if opt, ok := t.optArgs["index"]; ok && indexName(opt) == index {
+			return true
+		}
+	}
+
+	for _, arg := range t.args {
+		if arg.UsesIndex(index) {
+			return true
+		}
+	}
+	for _, opt := range t.optArgs {
+		if opt.UsesIndex(index) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Depth returns the nesting depth of the query tree, a term without arguments
+// such as a string has a depth of 1. See ConnectOpts.MaxQueryDepth.
+func (t Term) Depth() int {
+	depth := 0
+	for _, arg := range t.args {
+		if d := arg.Depth(); d > depth {
+			depth = d
+		}
+	}
+	for _, opt := range t.optArgs {
+		if d := opt.Depth(); d > depth {
+			depth = d
+		}
+	}
+
+	return depth + 1
+}
+
+// QueryComplexity is a rough, client side estimate of the cost of a query,
+// see Term.Complexity.
+type QueryComplexity struct {
+	// Terms is the number of terms in the query tree, including values.
+	Terms int
+	// Depth is the nesting depth of the query tree, see Term.Depth.
+	Depth int
+	// FullTable is true if the query reads a whole table, that is if a table
+	// is used other than to get documents by key or index, insert documents
+	// or administer the table.
+	FullTable bool
+}
+
+// tableAccessTerms are the terms which take a table without reading all of
+// its documents.
+var tableAccessTerms = map[p.Term_TermType]bool{
+	p.Term_GET:              true,
+	p.Term_GET_ALL:          true,
+	p.Term_BETWEEN:          true,
+	p.Term_GET_INTERSECTING: true,
+	p.Term_GET_NEAREST:      true,
+	p.Term_INSERT:           true,
+	p.Term_CONFIG:           true,
+	p.Term_STATUS:           true,
+	p.Term_WAIT:             true,
+	p.Term_RECONFIGURE:      true,
+	p.Term_REBALANCE:        true,
+	p.Term_SYNC:             true,
+	p.Term_INDEX_CREATE:     true,
+	p.Term_INDEX_DROP:       true,
+	p.Term_INDEX_LIST:       true,
+	p.Term_INDEX_STATUS:     true,
+	p.Term_INDEX_WAIT:       true,
+	p.Term_INDEX_RENAME:     true,
+}
+
+// Complexity returns a static estimate of the cost of the query, computed
+// from the query tree without contacting the server. It can be used to flag
+// expensive queries, for example in tests:
+//
+//	if c := query.Complexity(); c.FullTable {
+//	    t.Errorf("query reads a whole table: %s", query)
+//	}
+//
+// The estimate doesn't know about the data or indexes, a Filter on a table
+// is reported as reading the whole table even if few documents match.
+func (t Term) Complexity() QueryComplexity {
+	c := QueryComplexity{Depth: t.Depth()}
+	t.addComplexity(&c, false)
+	return c
+}
+
+// addComplexity adds the terms of t to c, keyed is true if t is the table
+// argument of a term in tableAccessTerms.
+func (t Term) addComplexity(c *QueryComplexity, keyed bool) {
+	c.Terms++
+	if t.termType == p.Term_TABLE && !keyed {
+		c.FullTable = true
+	}
+
+	for i, arg := range t.args {
+		arg.addComplexity(c, i == 0 && tableAccessTerms[t.termType])
+	}
+	for _, opt := range t.optArgs {
+		opt.addComplexity(c, false)
+	}
+}
+
+// maxDepthTermLength is the length at which the term included in the error
+// returned by checkDepth is truncated.
+const maxDepthTermLength = 100
+
+// checkDepth returns an error describing the term nested too deeply if the
+// depth of t is greater than max.
+func checkDepth(t Term, max int) error {
+	// Find the outermost term below the limit, the query is only walked down
+	// to the limit
+	deep, ok := termAtLevel(t, max)
+	if !ok {
+		return nil
+	}
+
+	s := deep.String()
+	if len(s) > maxDepthTermLength {
+		s = s[:maxDepthTermLength] + "..."
+	}
+	return RQLDriverError{rqlError(fmt.Sprintf("query depth %d exceeds MaxQueryDepth %d, nested too deeply: %s", t.Depth(), max, s))}
+}
+
+// termAtLevel returns the first term found nested level terms below t, if
+// any, without walking the query any deeper.
+func termAtLevel(t Term, level int) (Term, bool) {
+	if level == 0 {
+		return t, true
+	}
+	for _, arg := range t.args {
+		if sub, ok := termAtLevel(arg, level-1); ok {
+			return sub, true
+		}
+	}
+	for _, opt := range t.optArgs {
+		if sub, ok := termAtLevel(opt, level-1); ok {
+			return sub, true
+		}
+	}
+
+	return Term{}, false
+}
+
+// checkFullTableWrites returns an error if t deletes, updates or replaces
+// every document of a table, that is if the selection written to is a table
+// which has not been narrowed down by terms such as Get, Filter or Between.
+func checkFullTableWrites(t Term) error {
+	switch t.termType {
+	case p.Term_DELETE, p.Term_UPDATE, p.Term_REPLACE:
+		if len(t.args) > 0 && isFullTable(t.args[0]) {
+			s := t.String()
+			if len(s) > maxDepthTermLength {
+				s = s[:maxDepthTermLength] + "..."
+			}
+			return RQLDriverError{rqlError(fmt.Sprintf("%s of a whole table is not allowed by GuardFullTableWrites, set AllowFullTableWrite to run it: %s", t.name, s))}
+		}
+	}
+
+	for _, sub := range append(append([]Term{}, t.args...), optArgsTerms(t.optArgs)...) {
+		if err := checkFullTableWrites(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isFullTable returns true if the selection t is a whole table, terms which
+// only reorder or skip documents are looked through.
+func isFullTable(t Term) bool {
+	for {
+		switch t.termType {
+		case p.Term_TABLE:
+			return true
+		case p.Term_ORDER_BY, p.Term_SKIP:
+			if len(t.args) == 0 {
+				return false
+			}
+			t = t.args[0]
+		default:
+			return false
+		}
+	}
+}
+
+func optArgsTerms(optArgs map[string]Term) []Term {
+	terms := make([]Term, 0, len(optArgs))
+	for _, t := range optArgs {
+		terms = append(terms, t)
+	}
+	return terms
+}
+
+// indexName returns the name of the index from the value of an index optional
+// argument, which may be wrapped in Asc or Desc when ordering.
+func indexName(t Term) string {
+	if (t.termType == p.Term_ASC || t.termType == p.Term_DESC) && len(t.args) == 1 {
+		t = t.args[0]
+	}
+	if t.termType == p.Term_DATUM {
+		if name, ok := t.data.(string); ok {
+			return name
+		}
+	}
+
+	return ""
+}
+
 // OptArgs is an interface used to represent a terms optional arguments. All
 // optional argument types have a toMap function, the returned map can be encoded
 // and sent as part of the query.
@@ -261,10 +612,17 @@ type QueryExecutor interface {
 	Exec(context.Context, Query) error
 
 	newQuery(t Term, opts map[string]interface{}) (Query, error)
+	defaultRunOpts() RunOpts
 }
 
 // WriteResponse is a helper type used when dealing with the response of a
 // write query. It is also returned by the RunWrite function.
+//
+// No version of RethinkDB reports how many replicas acknowledged a write, so
+// there is no field for it. Instead a write only succeeds once it has been
+// acknowledged by the replicas required by the write_acks setting of the table
+// ("majority" by default, or "single"), see Config, and written to disk unless
+// the soft durability was used.
 type WriteResponse struct {
 	Errors        int              `rethinkdb:"errors"`
 	Inserted      int              `rethinkdb:"inserted"`
@@ -282,12 +640,60 @@ type WriteResponse struct {
 	TablesDropped int              `rethinkdb:"tables_dropped"`
 	GeneratedKeys []string         `rethinkdb:"generated_keys"`
 	FirstError    string           `rethinkdb:"first_error"` // populated if Errors > 0
+	Warnings      []string         `rethinkdb:"warnings"`
 	ConfigChanges []ChangeResponse `rethinkdb:"config_changes"`
 	Changes       []ChangeResponse
+	// Profile is set by RunWrite if the query was run with RunOpts.Profile
+	Profile *Profile `rethinkdb:"-"`
+}
+
+// AssignGeneratedKeys sets the primary key of each document in docs which does
+// not have a primary key to the keys generated by the server, docs should be
+// the slice of structs passed to Insert. The primary key field is the one
+// tagged with the "primary" option or the "id" field. Only fields tagged with
+// omitempty are left empty when inserting so that the server generates a key,
+// for example `rethinkdb:"id,omitempty"`.
+func (r WriteResponse) AssignGeneratedKeys(docs interface{}) error {
+	return encoding.AssignGeneratedKeys(docs, r.GeneratedKeys)
+}
+
+// AllKeys returns the primary key of each document in docs, the slice of
+// structs passed to Insert, in the order of docs. Keys set on the documents
+// are read from their primary key field (see AssignGeneratedKeys) and the
+// keys generated by the server are used for the documents without one, so
+// the result does not depend on who assigned the keys.
+func (r WriteResponse) AllKeys(docs interface{}) ([]interface{}, error) {
+	return encoding.DocumentKeys(docs, r.GeneratedKeys)
+}
+
+// FirstChangeField decodes the value at field in the new value of the first
+// change into dest, for example to read a value computed by the server such as
+// a timestamp set with r.Now(). The query must be run with ReturnChanges set.
+// Like Cursor.NextPath, field is a dotted list of object keys or array indexes
+// such as "author.name" and dest is decoded from null if the field doesn't
+// exist.
+//
+//	res, err := r.Table("posts").Insert(
+//		map[string]interface{}{"title": "Hello", "created": r.Now()},
+//		r.InsertOpts{ReturnChanges: true},
+//	).RunWrite(session)
+//	var created time.Time
+//	err = res.FirstChangeField("created", &created)
+func (r WriteResponse) FirstChangeField(field string, dest interface{}) error {
+	if len(r.Changes) == 0 {
+		return RQLDriverError{rqlError("FirstChangeField: the response has no changes, run the query with ReturnChanges set")}
+	}
+
+	return encoding.Decode(dest, valueAtPath(r.Changes[0].NewValue, strings.Split(field, ".")))
 }
 
 // ChangeResponse is a helper type used when dealing with changefeeds. The type
 // contains both the value before the query and the new value.
+//
+// OldOffset and NewOffset are set when the changefeed is run with
+// ChangesOpts.IncludeOffsets, they are 0 both for the first position and for
+// a document entering or leaving the results, set ChangesOpts.IncludeTypes
+// and use ChangeType to tell them apart.
 type ChangeResponse struct {
 	NewValue  interface{} `rethinkdb:"new_val,omitempty"`
 	OldValue  interface{} `rethinkdb:"old_val,omitempty"`
@@ -298,6 +704,60 @@ type ChangeResponse struct {
 	NewOffset int         `rethinkdb:"new_offset,omitempty"`
 }
 
+// ChangeType is the kind of change reported by a changefeed run with
+// ChangesOpts.IncludeTypes, see ChangeResponse.ChangeType.
+type ChangeType int
+
+const (
+	// ChangeTypeUnknown is returned when the change has no type, because
+	// IncludeTypes was not set, or a type unknown to the driver.
+	ChangeTypeUnknown ChangeType = iota
+	// ChangeTypeAdd is a document added to the results.
+	ChangeTypeAdd
+	// ChangeTypeRemove is a document removed from the results.
+	ChangeTypeRemove
+	// ChangeTypeChange is a document of the results which changed.
+	ChangeTypeChange
+	// ChangeTypeInitial is a document of the initial results, sent when the
+	// changefeed is run with ChangesOpts.IncludeInitial.
+	ChangeTypeInitial
+	// ChangeTypeUninitial is a document removed from the initial results
+	// before they were all sent.
+	ChangeTypeUninitial
+	// ChangeTypeState is a change of the state of the changefeed, sent when
+	// the changefeed is run with ChangesOpts.IncludeStates.
+	ChangeTypeState
+)
+
+var changeTypeNames = map[ChangeType]string{
+	ChangeTypeAdd:       "add",
+	ChangeTypeRemove:    "remove",
+	ChangeTypeChange:    "change",
+	ChangeTypeInitial:   "initial",
+	ChangeTypeUninitial: "uninitial",
+	ChangeTypeState:     "state",
+}
+
+// String returns the name of the type as sent by the server, or "unknown".
+func (t ChangeType) String() string {
+	if name, ok := changeTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ChangeType returns the type of the change, it is ChangeTypeUnknown if the
+// changefeed was not run with ChangesOpts.IncludeTypes or the server sent a
+// type unknown to the driver.
+func (r ChangeResponse) ChangeType() ChangeType {
+	for t, name := range changeTypeNames {
+		if r.Type == name {
+			return t
+		}
+	}
+	return ChangeTypeUnknown
+}
+
 // RunOpts contains the optional arguments for the Run function.
 type RunOpts struct {
 	DB                  interface{} `rethinkdb:"db,omitempty"`
@@ -313,19 +773,209 @@ type RunOpts struct {
 	ReadMode            interface{} `rethinkdb:"read_mode,omitempty"`
 	ChangefeedQueueSize interface{} `rethinkdb:"changefeed_queue_size,omitempty"`
 
+	// The batch options set how many documents the server returns in each
+	// batch fetched by the cursor, smaller batches suit large documents and
+	// larger batches small ones. MinBatchRows (at least 0) and MaxBatchRows
+	// (at least 1) bound the number of documents of a batch,
+	// FirstBatchScaledownFactor (at least 1) divides the size of the first
+	// batch so that the first documents are returned sooner. They are checked
+	// by Run and Exec, which fail if MinBatchRows is greater than MaxBatchRows.
 	MinBatchRows              interface{} `rethinkdb:"min_batch_rows,omitempty"`
 	MaxBatchRows              interface{} `rethinkdb:"max_batch_rows,omitempty"`
 	MaxBatchBytes             interface{} `rethinkdb:"max_batch_bytes,omitempty"`
 	MaxBatchSeconds           interface{} `rethinkdb:"max_batch_seconds,omitempty"`
 	FirstBatchScaledownFactor interface{} `rethinkdb:"first_batch_scaledown_factor,omitempty"`
 
+	// UseJSONNumber overrides ConnectOpts.UseJSONNumber for the cursor
+	// returned by the query when it is not nil, numbers are then decoded as
+	// json.Number into interface{} values to keep integers beyond 2^53 exact.
+	UseJSONNumber *bool `rethinkdb:"-"`
+	// RejectNonFinite causes reading a result containing Infinity, -Infinity or
+	// NaN to fail instead of decoding them as math.Inf and math.NaN.
+	RejectNonFinite bool `rethinkdb:"reject_non_finite,omitempty"`
+	// StaleFallbackAfter is the number of times the query is retried when it
+	// fails with an availability error, such as when a primary replica is
+	// unavailable during an election, before it is run with the "outdated"
+	// read mode. The retries are sent straight away using the ReadMode set
+	// above. Cursor.IsStale reports whether the result may be out of date, only
+	// set it for read queries which can tolerate stale results.
+	StaleFallbackAfter int `rethinkdb:"-"`
+	// AllowFullTableWrite allows the query to delete, update or replace every
+	// document of a table when ConnectOpts.GuardFullTableWrites is set.
+	AllowFullTableWrite bool `rethinkdb:"allow_full_table_write,omitempty"`
+	// ResolveType is called with the JSON of each row read by Cursor.Next,
+	// All and One to pick the Go type it is decoded into, for tables holding
+	// documents of several shapes. It returns a pointer to a new value of the
+	// type, the row is decoded into it and the pointer, or the value it points
+	// to, is stored in the destination. Returning nil decodes the row as
+	// usual.
+	//
+	//	ResolveType: func(raw json.RawMessage) (interface{}, error) {
+	//	    var row struct{ Kind string `json:"kind"` }
+	//	    if err := json.Unmarshal(raw, &row); err != nil {
+	//	        return nil, err
+	//	    }
+	//	    switch row.Kind {
+	//	    case "created":
+	//	        return &OrderCreated{}, nil
+	//	    case "shipped":
+	//	        return &OrderShipped{}, nil
+	//	    }
+	//	    return nil, fmt.Errorf("unknown kind %q", row.Kind)
+	//	}
+	ResolveType func(raw json.RawMessage) (interface{}, error) `rethinkdb:"-"`
+	// Idempotent marks the query as safe to run more than once. A query which
+	// fails with a connection error is retried, up to ConnectOpts.NumRetries
+	// times, but a query which writes is only retried if it failed before
+	// being sent, as the server may have applied a write whose response was
+	// lost. Setting Idempotent retries such a query whenever it fails with a
+	// connection error.
+	//
+	// Only set it if running the query twice has the same effect as running it
+	// once, for example inserting a document with a given primary key and
+	// Conflict set to "replace", or updating fields to fixed values. Queries
+	// which increment or append to fields, or insert documents without a
+	// primary key, are not idempotent and may be applied twice if retried.
+	Idempotent bool `rethinkdb:"-"`
+	// PreferReplica sends a read query to a server which isn't the primary
+	// replica of the tables it reads, to spread reads across the cluster. The
+	// query is run with the "outdated" read mode unless ReadMode is set, as
+	// only primary replicas can serve up-to-date reads. The primary replicas
+	// are looked up using the status of each table and cached for a minute,
+	// when they can't be found, or every server is a primary, the query is
+	// sent to any server. Cursor.ServedBy reports which server ran the query.
+	PreferReplica bool `rethinkdb:"-"`
+
+	// Context bounds the query and the fetching of its results. When it is
+	// cancelled or its deadline passes while waiting for the server, a STOP
+	// query is sent so that the server stops running the query, and
+	// ErrQueryTimeout is returned.
 	Context context.Context `rethinkdb:"-"`
+	// QueryName is a stable label for the query, it is not sent to the server
+	// but is attached to tracing spans and log output and recorded by Mock.
+	// This is useful for aggregating metrics by query kind.
+	QueryName string `rethinkdb:"-"`
 }
 
 func (o RunOpts) toMap() map[string]interface{} {
 	return optArgsToMap(o)
 }
 
+func (o RunOpts) validate() error {
+	if err := validateArrayLimit(o.ArrayLimit); err != nil {
+		return err
+	}
+	return validateBatchOpts(o.MinBatchRows, o.MaxBatchRows, o.FirstBatchScaledownFactor)
+}
+
+// withDefaults returns o with each field which is not set, meaning it holds
+// its zero value, taken from defaults.
+func (o RunOpts) withDefaults(defaults RunOpts) RunOpts {
+	setUnsetFields(reflect.ValueOf(&o).Elem(), reflect.ValueOf(defaults))
+	return o
+}
+
+// MaxArrayLimit is the largest value accepted for the ArrayLimit option of
+// RunOpts and ExecOpts, the largest integer stored exactly by the server.
+// ArrayLimit sets the maximum size of the arrays created by a query, by default
+// the server allows 100,000 elements, it must be at least 1.
+const MaxArrayLimit = 1 << 53
+
+// validateArrayLimit checks that the array_limit optional argument v is an
+// integer between 1 and MaxArrayLimit, or a Term.
+func validateArrayLimit(v interface{}) error {
+	return validateIntegerOpt("ArrayLimit", v, 1)
+}
+
+// validateBatchOpts checks the optional arguments which set the size of the
+// batches of documents returned by the server. MinBatchRows must be an integer
+// of at least 0, MaxBatchRows and FirstBatchScaledownFactor integers of at
+// least 1, and MinBatchRows must not be greater than MaxBatchRows.
+func validateBatchOpts(minRows, maxRows, scaledownFactor interface{}) error {
+	if err := validateIntegerOpt("MinBatchRows", minRows, 0); err != nil {
+		return err
+	}
+	if err := validateIntegerOpt("MaxBatchRows", maxRows, 1); err != nil {
+		return err
+	}
+	if err := validateIntegerOpt("FirstBatchScaledownFactor", scaledownFactor, 1); err != nil {
+		return err
+	}
+
+	min, minOk := integerOptValue(minRows)
+	max, maxOk := integerOptValue(maxRows)
+	if minOk && maxOk && min > max {
+		return RQLDriverError{rqlError(fmt.Sprintf("MinBatchRows must not be greater than MaxBatchRows, got %v and %v", minRows, maxRows))}
+	}
+	return nil
+}
+
+// validateIntegerOpt checks that the optional argument v, named name, is an
+// integer between min and MaxArrayLimit, or a Term.
+func validateIntegerOpt(name string, v interface{}, min int64) error {
+	if v == nil {
+		return nil
+	}
+	if _, ok := v.(Term); ok {
+		return nil
+	}
+
+	var valid bool
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		valid = rv.Int() >= min && rv.Int() <= MaxArrayLimit
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		valid = rv.Uint() >= uint64(min) && rv.Uint() <= MaxArrayLimit
+	case reflect.Float32, reflect.Float64:
+		valid = rv.Float() >= float64(min) && rv.Float() <= MaxArrayLimit && rv.Float() == math.Trunc(rv.Float())
+	default:
+		return RQLDriverError{rqlError(fmt.Sprintf("%s must be a number, got %T", name, v))}
+	}
+	if !valid {
+		return RQLDriverError{rqlError(fmt.Sprintf("%s must be an integer between %d and %d, got %v", name, min, int64(MaxArrayLimit), v))}
+	}
+	return nil
+}
+
+// integerOptValue returns the value of an optional argument which passed
+// validateIntegerOpt, ok is false if it is not set or is a Term.
+func integerOptValue(v interface{}) (n float64, ok bool) {
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// WithTimeout returns the term with a timeout used when it is run, Run and Exec
+// use a context with a deadline d after they are called. If a Context is set
+// in RunOpts or ExecOpts, including by ConnectOpts.DefaultRunOpts, the deadline
+// is added to it, so the query stops at whichever comes first. The deadline
+// also applies to reading the cursor returned by Run, so it should be long
+// enough to read all of the documents, the context is released when the cursor
+// is closed.
+//
+// The timeout is kept by terms chained after WithTimeout.
+//
+//	res, err := r.Table("table").WithTimeout(5 * time.Second).Filter(filter).Run(sess)
+func (t Term) WithTimeout(d time.Duration) Term {
+	t.timeout = d
+	return t
+}
+
+// withTimeout returns a context with the timeout of WithTimeout, derived from
+// ctx if it is set.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithTimeout(ctx, d)
+}
+
 // Run runs a query using the given connection.
 //
 //	rows, err := query.Run(sess)
@@ -338,11 +988,24 @@ func (o RunOpts) toMap() map[string]interface{} {
 //      // Do something with document
 //	}
 func (t Term) Run(s QueryExecutor, optArgs ...RunOpts) (*Cursor, error) {
-	opts := map[string]interface{}{}
-	var ctx context.Context = nil // if it's nil connection will form context from connection opts
+	var runOpts RunOpts
 	if len(optArgs) >= 1 {
-		opts = optArgs[0].toMap()
-		ctx = optArgs[0].Context
+		runOpts = optArgs[0]
+	}
+	if s != nil {
+		runOpts = runOpts.withDefaults(s.defaultRunOpts())
+	}
+	if err := runOpts.validate(); err != nil {
+		return nil, err
+	}
+
+	opts := runOpts.toMap()
+	ctx := runOpts.Context // if it's nil connection will form context from connection opts
+	name := runOpts.QueryName
+	idempotent := runOpts.Idempotent
+	preferReplica := runOpts.PreferReplica
+	if _, ok := opts["read_mode"]; preferReplica && !ok {
+		opts["read_mode"] = "outdated"
 	}
 
 	if s == nil || !s.IsConnected() {
@@ -354,7 +1017,75 @@ func (t Term) Run(s QueryExecutor, optArgs ...RunOpts) (*Cursor, error) {
 		return nil, err
 	}
 
-	return s.Query(ctx, q)
+	// The cursor keeps using ctx once the query is sent so the timeout is
+	// only canceled when the cursor is closed
+	var cancel context.CancelFunc
+	if t.timeout > 0 {
+		ctx, cancel = withTimeout(ctx, t.timeout)
+	}
+	q.Name = name
+	q.Idempotent = idempotent
+	q.PreferReplica = preferReplica
+
+	var cursor *Cursor
+	if runOpts.StaleFallbackAfter > 0 {
+		cursor, err = runWithStaleFallback(ctx, s, t, q, opts, runOpts.StaleFallbackAfter)
+	} else {
+		cursor, err = s.Query(ctx, q)
+	}
+	if cursor != nil {
+		cursor.mu.Lock()
+		if runOpts.ResolveType != nil {
+			cursor.resolveType = runOpts.ResolveType
+		}
+		if runOpts.UseJSONNumber != nil {
+			cursor.useJSONNumber = *runOpts.UseJSONNumber
+		}
+		if cancel != nil && err == nil && !cursor.closed {
+			cursor.cancel = cancel
+			cancel = nil
+		}
+		cursor.mu.Unlock()
+	}
+	if cancel != nil {
+		cancel()
+	}
+	return cursor, err
+}
+
+// runWithStaleFallback runs q up to failures times while it fails with an
+// availability error, then runs it again using the outdated read mode.
+func runWithStaleFallback(ctx context.Context, s QueryExecutor, t Term, q Query, opts map[string]interface{}, failures int) (*Cursor, error) {
+	var cursor *Cursor
+	var err error
+	for i := 0; i < failures; i++ {
+		cursor, err = s.Query(ctx, q)
+		if !isAvailabilityErr(err) {
+			return cursor, err
+		}
+	}
+
+	staleOpts := make(map[string]interface{}, len(opts)+1)
+	for k, v := range opts {
+		staleOpts[k] = v
+	}
+	staleOpts["read_mode"] = "outdated"
+
+	staleQuery, err := s.newQuery(t, staleOpts)
+	if err != nil {
+		return nil, err
+	}
+	staleQuery.Name = q.Name
+	staleQuery.Idempotent = q.Idempotent
+	staleQuery.PreferReplica = q.PreferReplica
+
+	cursor, err = s.Query(ctx, staleQuery)
+	if cursor != nil {
+		cursor.mu.Lock()
+		cursor.stale = true
+		cursor.mu.Unlock()
+	}
+	return cursor, err
 }
 
 // RunWrite runs a query using the given connection but unlike Run automatically
@@ -362,6 +1093,8 @@ func (t Term) Run(s QueryExecutor, optArgs ...RunOpts) (*Cursor, error) {
 // if you are running a write query (such as Insert,  Update, TableCreate, etc...).
 //
 // If an error occurs when running the write query the first error is returned.
+// If the query is run with RunOpts.Profile set to true then the output of the
+// query profiler is returned in the Profile field of the response.
 //
 //	res, err := r.DB("database").Table("table").Insert(doc).RunWrite(sess)
 func (t Term) RunWrite(s QueryExecutor, optArgs ...RunOpts) (WriteResponse, error) {
@@ -376,6 +1109,9 @@ func (t Term) RunWrite(s QueryExecutor, optArgs ...RunOpts) (WriteResponse, erro
 	if err = res.One(&response); err != nil {
 		return response, err
 	}
+	if response.Profile, err = res.ProfileInfo(); err != nil {
+		return response, err
+	}
 
 	if response.Errors > 0 {
 		return response, fmt.Errorf("%s", response.FirstError)
@@ -408,6 +1144,61 @@ func (t Term) ReadAll(dest interface{}, s QueryExecutor, optArgs ...RunOpts) err
 	return res.All(dest)
 }
 
+// GetAllParallel gets the documents of the table t matching keys, like GetAll,
+// and reads them into dest like ReadAll. The keys are split into shards
+// groups, each of which is fetched by a separate query and the queries are run
+// concurrently, using up to shards connections of the session's pool. This can
+// be faster than a single GetAll query when reading a large number of keys.
+//
+// The documents are not returned in any particular order, as for GetAll. If
+// any of the queries fail dest is left unchanged and the error of the first
+// failed query, in the order of the keys, is returned.
+//
+//	var users []User
+//	err := r.Table("users").GetAllParallel(&users, session, ids, 4)
+func (t Term) GetAllParallel(dest interface{}, s QueryExecutor, keys []interface{}, shards int, optArgs ...RunOpts) error {
+	destv := reflect.ValueOf(dest)
+	if destv.Kind() != reflect.Ptr || destv.Elem().Kind() != reflect.Slice {
+		panic("dest argument must be a slice address")
+	}
+	if shards < 1 {
+		return RQLDriverError{rqlError(fmt.Sprintf("GetAllParallel: shards must be at least 1, got %d", shards))}
+	}
+	if shards > len(keys) {
+		shards = len(keys)
+	}
+
+	results := make([]reflect.Value, shards)
+	errs := make([]error, shards)
+	var wg sync.WaitGroup
+	for i := 0; i < shards; i++ {
+		// Split the keys into contiguous groups of about the same size
+		shardKeys := keys[i*len(keys)/shards : (i+1)*len(keys)/shards]
+		results[i] = reflect.New(destv.Elem().Type())
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = t.GetAll(shardKeys...).ReadAll(results[i].Interface(), s, optArgs...)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	slicev := reflect.MakeSlice(destv.Elem().Type(), 0, 0)
+	for _, result := range results {
+		slicev = reflect.AppendSlice(slicev, result.Elem())
+	}
+	destv.Elem().Set(slicev)
+
+	return nil
+}
+
 // ExecOpts contains the optional arguments for the Exec function and  inherits
 // its options from RunOpts, the only difference is the addition of the NoReply
 // field.
@@ -433,14 +1224,37 @@ type ExecOpts struct {
 	FirstBatchScaledownFactor interface{} `rethinkdb:"first_batch_scaledown_factor,omitempty"`
 
 	NoReply interface{} `rethinkdb:"noreply,omitempty"`
-
+	// AllowFullTableWrite allows the query to delete, update or replace every
+	// document of a table, see RunOpts.AllowFullTableWrite.
+	AllowFullTableWrite bool `rethinkdb:"allow_full_table_write,omitempty"`
+	// Idempotent allows the query to be retried after a connection error even
+	// if it writes, see RunOpts.Idempotent.
+	Idempotent bool `rethinkdb:"-"`
+
+	// Context bounds the query, see RunOpts.Context.
 	Context context.Context `rethinkdb:"-"`
+	// QueryName is a stable label for the query, see RunOpts.QueryName.
+	QueryName string `rethinkdb:"-"`
 }
 
 func (o ExecOpts) toMap() map[string]interface{} {
 	return optArgsToMap(o)
 }
 
+func (o ExecOpts) validate() error {
+	if err := validateArrayLimit(o.ArrayLimit); err != nil {
+		return err
+	}
+	return validateBatchOpts(o.MinBatchRows, o.MaxBatchRows, o.FirstBatchScaledownFactor)
+}
+
+// withDefaults returns o with each field which is not set taken from the field
+// of the same name of defaults, see RunOpts.withDefaults.
+func (o ExecOpts) withDefaults(defaults RunOpts) ExecOpts {
+	setUnsetFields(reflect.ValueOf(&o).Elem(), reflect.ValueOf(defaults))
+	return o
+}
+
 // Exec runs the query but does not return the result. Exec will still wait for
 // the response to be received unless the NoReply field is true.
 //
@@ -448,11 +1262,25 @@ func (o ExecOpts) toMap() map[string]interface{} {
 //		NoReply: true,
 //	})
 func (t Term) Exec(s QueryExecutor, optArgs ...ExecOpts) error {
-	opts := map[string]interface{}{}
-	var ctx context.Context = nil // if it's nil connection will form context from connection opts
+	var execOpts ExecOpts
 	if len(optArgs) >= 1 {
-		opts = optArgs[0].toMap()
-		ctx = optArgs[0].Context
+		execOpts = optArgs[0]
+	}
+	if s != nil {
+		execOpts = execOpts.withDefaults(s.defaultRunOpts())
+	}
+	if err := execOpts.validate(); err != nil {
+		return err
+	}
+
+	opts := execOpts.toMap()
+	ctx := execOpts.Context // if it's nil connection will form context from connection opts
+	name := execOpts.QueryName
+	idempotent := execOpts.Idempotent
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = withTimeout(ctx, t.timeout)
+		defer cancel()
 	}
 
 	if s == nil || !s.IsConnected() {
@@ -463,6 +1291,8 @@ func (t Term) Exec(s QueryExecutor, optArgs ...ExecOpts) error {
 	if err != nil {
 		return err
 	}
+	q.Name = name
+	q.Idempotent = idempotent
 
 	return s.Exec(ctx, q)
 }
@@ -1,6 +1,9 @@
 package rethinkdb
 
 import (
+	"time"
+
+	"golang.org/x/net/context"
 	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 )
 
@@ -44,6 +47,139 @@ func (t Term) Status() Term {
 	return constructMethodTerm(t, "Status", p.Term_STATUS, []interface{}{}, map[string]interface{}{})
 }
 
+// TableStatus is the result of the Status term, it contains the availability
+// of a table and the state of each of its shards.
+type TableStatus struct {
+	ID         string             `rethinkdb:"id"`
+	Name       string             `rethinkdb:"name"`
+	DB         string             `rethinkdb:"db"`
+	RaftLeader string             `rethinkdb:"raft_leader"`
+	Shards     []TableShardStatus `rethinkdb:"shards"`
+	Status     struct {
+		AllReplicasReady      bool `rethinkdb:"all_replicas_ready"`
+		ReadyForOutdatedReads bool `rethinkdb:"ready_for_outdated_reads"`
+		ReadyForReads         bool `rethinkdb:"ready_for_reads"`
+		ReadyForWrites        bool `rethinkdb:"ready_for_writes"`
+	} `rethinkdb:"status"`
+}
+
+// TableShardStatus contains the state of a single shard of a table.
+type TableShardStatus struct {
+	Primaries []string `rethinkdb:"primary_replicas"`
+	Replicas  []struct {
+		Server string `rethinkdb:"server"`
+		State  string `rethinkdb:"state"`
+	} `rethinkdb:"replicas"`
+}
+
+// ServerStatus is a document of the server_status system table, it contains
+// the network and process information of a server of the cluster.
+type ServerStatus struct {
+	ID      string              `rethinkdb:"id"`
+	Name    string              `rethinkdb:"name"`
+	Network ServerStatusNetwork `rethinkdb:"network"`
+	Process ServerStatusProcess `rethinkdb:"process"`
+}
+
+// ServerStatusNetwork contains the addresses and ports a server listens on
+// and the servers it is connected to.
+type ServerStatusNetwork struct {
+	Hostname           string          `rethinkdb:"hostname"`
+	CanonicalAddresses []ServerAddress `rethinkdb:"canonical_addresses"`
+	ClusterPort        int             `rethinkdb:"cluster_port"`
+	ReqlPort           int             `rethinkdb:"reql_port"`
+	HTTPAdminPort      interface{}     `rethinkdb:"http_admin_port"` // a port or "<no http admin>"
+	ConnectedTo        map[string]bool `rethinkdb:"connected_to"`
+	TimeConnected      time.Time       `rethinkdb:"time_connected"`
+}
+
+// ServerAddress is an address a server can be reached at.
+type ServerAddress struct {
+	Host string `rethinkdb:"host"`
+	Port int    `rethinkdb:"port"`
+}
+
+// ServerStatusProcess contains information about the process of a server.
+type ServerStatusProcess struct {
+	Argv        []string  `rethinkdb:"argv"`
+	CacheSizeMB float64   `rethinkdb:"cache_size_mb"`
+	PID         int       `rethinkdb:"pid"`
+	TimeStarted time.Time `rethinkdb:"time_started"`
+	Version     string    `rethinkdb:"version"`
+}
+
+// Job is a document of the jobs system table, it describes a task running on
+// the cluster such as a query, an index construction or a backfill.
+type Job struct {
+	// ID is the type of the job followed by its UUID.
+	ID          []string `rethinkdb:"id"`
+	Type        string   `rethinkdb:"type"`
+	DurationSec float64  `rethinkdb:"duration_sec"`
+	// Servers lists the names of the servers running the job.
+	Servers []string `rethinkdb:"servers"`
+	// Info depends on the type of the job, for example it contains the
+	// client address of a query or the progress of an index construction.
+	Info map[string]interface{} `rethinkdb:"info"`
+}
+
+// tableStatus returns the status of a table.
+func tableStatus(ctx context.Context, s QueryExecutor, db, table string) (TableStatus, error) {
+	var status TableStatus
+	res, err := DB(db).Table(table).Status().Run(s, RunOpts{Context: ctx})
+	if err != nil {
+		return status, err
+	}
+	defer res.Close()
+
+	err = res.One(&status)
+	return status, err
+}
+
+// serverStatus returns the status of every server of the cluster.
+func serverStatus(ctx context.Context, s QueryExecutor) ([]ServerStatus, error) {
+	var servers []ServerStatus
+	res, err := DB(SystemDatabase).Table(ServerStatusSystemTable).Run(s, RunOpts{Context: ctx})
+	if err != nil {
+		return nil, err
+	}
+
+	err = res.All(&servers)
+	return servers, err
+}
+
+// jobs returns the jobs running on the cluster.
+func jobs(ctx context.Context, s QueryExecutor) ([]Job, error) {
+	var jobs []Job
+	res, err := DB(SystemDatabase).Table(JobsSystemTable).Run(s, RunOpts{Context: ctx})
+	if err != nil {
+		return nil, err
+	}
+
+	err = res.All(&jobs)
+	return jobs, err
+}
+
+// tableStatusPollInterval is the time between the Status queries sent by
+// WaitForReady
+const tableStatusPollInterval = 100 * time.Millisecond
+
+// waitForReady polls the status of a table until all of its replicas are
+// ready or ctx is done.
+func waitForReady(ctx context.Context, s QueryExecutor, db, table string) (TableStatus, error) {
+	for {
+		status, err := tableStatus(ctx, s, db, table)
+		if err != nil || status.Status.AllReplicasReady {
+			return status, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-time.After(tableStatusPollInterval):
+		}
+	}
+}
+
 // WaitOpts contains the optional arguments for the Wait term.
 type WaitOpts struct {
 	WaitFor interface{} `rethinkdb:"wait_for,omitempty"`
@@ -15,6 +15,13 @@ import p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 //         return left.Add(right)
 //     })
 func (t Term) Reduce(args ...interface{}) Term {
+	if len(args) > 0 {
+		if err := validateFunc("Reduce", "function", args[len(args)-1], 2); err != nil {
+			term := constructMethodTerm(t, "Reduce", p.Term_REDUCE, args[:len(args)-1], map[string]interface{}{})
+			term.lastErr = err
+			return term
+		}
+	}
 	return constructMethodTerm(t, "Reduce", p.Term_REDUCE, funcWrapArgs(args), map[string]interface{}{})
 }
 
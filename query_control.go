@@ -2,6 +2,7 @@ package rethinkdb
 
 import (
 	"encoding/base64"
+	"fmt"
 	"github.com/segmentio/encoding/json"
 
 	"reflect"
@@ -12,6 +13,7 @@ import (
 // Expr converts any value to an expression and is also used by many other terms
 // such as Insert and Update. This function can convert the following basic Go
 // types (bool, int, uint, string, float) and even pointers, maps and structs.
+// json.Number values are sent as numbers.
 //
 // When evaluating structs they are encoded into a map before being sent to the
 // server. Each exported field is added to the map unless
@@ -95,6 +97,14 @@ func Expr(val interface{}) Term {
 			termType: p.Term_DATUM,
 			data:     val,
 		}
+	case json.Number:
+		// Numbers are checked and sent as numbers rather than strings
+		data, err := encode(val)
+		return Term{
+			termType: p.Term_DATUM,
+			data:     data,
+			lastErr:  err,
+		}
 	default:
 		// Use reflection to check for other types
 		valType := reflect.TypeOf(val)
@@ -179,14 +189,23 @@ func JS(jssrc interface{}, optArgs ...JSOpts) Term {
 // HTTPOpts contains the optional arguments for the HTTP term
 type HTTPOpts struct {
 	// General Options
-	Timeout      interface{} `rethinkdb:"timeout,omitempty"`
-	Reattempts   interface{} `rethinkdb:"reattempts,omitempty"`
-	Redirects    interface{} `rethinkdb:"redirect,omitempty"`
-	Verify       interface{} `rethinkdb:"verify,omitempty"`
+
+	// Timeout is the number of seconds before the request times out
+	Timeout interface{} `rethinkdb:"timeout,omitempty"`
+	// Reattempts is the number of retries on a connection error
+	Reattempts interface{} `rethinkdb:"reattempts,omitempty"`
+	// Redirects is the number of redirects to follow
+	Redirects interface{} `rethinkdb:"redirects,omitempty"`
+	// Verify verifies the SSL certificate of the server, defaults to true
+	Verify interface{} `rethinkdb:"verify,omitempty"`
+	// ResultFormat is one of "text", "json", "jsonp", "binary" or "auto"
 	ResultFormat interface{} `rethinkdb:"result_format,omitempty"`
 
 	// Request Options
+
+	// Method is one of "GET", "POST", "PUT", "PATCH", "DELETE" or "HEAD"
 	Method interface{} `rethinkdb:"method,omitempty"`
+	// Auth holds the credentials, usually a HTTPAuth
 	Auth   interface{} `rethinkdb:"auth,omitempty"`
 	Params interface{} `rethinkdb:"params,omitempty"`
 	Header interface{} `rethinkdb:"header,omitempty"`
@@ -197,16 +216,72 @@ type HTTPOpts struct {
 	PageLimit interface{} `rethinkdb:"page_limit,omitempty"`
 }
 
+// HTTPAuth contains the credentials used by the HTTP term, Type is "basic"
+// (the default) or "digest".
+type HTTPAuth struct {
+	Type string `rethinkdb:"type,omitempty"`
+	User string `rethinkdb:"user"`
+	Pass string `rethinkdb:"pass"`
+}
+
 func (o HTTPOpts) toMap() map[string]interface{} {
 	return optArgsToMap(o)
 }
 
+func (o HTTPOpts) validate() error {
+	if err := validateHTTPString("Method", o.Method, "GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"); err != nil {
+		return err
+	}
+	if err := validateHTTPString("ResultFormat", o.ResultFormat, "text", "json", "jsonp", "binary", "auto"); err != nil {
+		return err
+	}
+	numbers := []struct {
+		name  string
+		value interface{}
+	}{
+		{"Timeout", o.Timeout},
+		{"Reattempts", o.Reattempts},
+		{"Redirects", o.Redirects},
+		{"PageLimit", o.PageLimit},
+	}
+	for _, n := range numbers {
+		if _, ok := n.value.(Term); n.value != nil && !ok && !isNonNegativeNumber(n.value) {
+			return RQLDriverError{rqlError(fmt.Sprintf("HTTP: %s must be a non-negative number, got %v", n.name, n.value))}
+		}
+	}
+	if auth, ok := o.Auth.(HTTPAuth); ok {
+		if err := validateHTTPString("Auth.Type", auth.Type, "", "basic", "digest"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateHTTPString(name string, v interface{}, values ...string) error {
+	if _, ok := v.(Term); v == nil || ok {
+		return nil
+	}
+	for _, value := range values {
+		if v == value {
+			return nil
+		}
+	}
+
+	return RQLDriverError{rqlError(fmt.Sprintf("HTTP: %s must be one of %q, got %v", name, values, v))}
+}
+
 // HTTP retrieves data from the specified URL over HTTP. The return type depends
 // on the resultFormat option, which checks the Content-Type of the response by
 // default.
 func HTTP(url interface{}, optArgs ...HTTPOpts) Term {
 	opts := map[string]interface{}{}
 	if len(optArgs) >= 1 {
+		if err := optArgs[0].validate(); err != nil {
+			term := constructRootTerm("Http", p.Term_HTTP, []interface{}{url}, opts)
+			term.lastErr = err
+			return term
+		}
 		opts = optArgs[0].toMap()
 	}
 	return constructRootTerm("Http", p.Term_HTTP, []interface{}{url}, opts)
@@ -226,8 +301,36 @@ func Error(args ...interface{}) Term {
 // Args is a special term used to splice an array of arguments into another term.
 // This is useful when you want to call a variadic term such as GetAll with a set
 // of arguments provided at runtime.
+//
+// Args expects a single argument which must be a slice, an array or a term
+// which evaluates to an array, the elements are encoded in the same way as
+// any other value passed to Expr.
+//
+//	r.Table("users").GetAll(r.Args([]string{"alice", "bob"}))
 func Args(args ...interface{}) Term {
-	return constructRootTerm("Args", p.Term_ARGS, args, map[string]interface{}{})
+	term := constructRootTerm("Args", p.Term_ARGS, args, map[string]interface{}{})
+	if err := validateArgs(args); err != nil {
+		term.lastErr = err
+	}
+	return term
+}
+
+func validateArgs(args []interface{}) error {
+	if len(args) != 1 {
+		return RQLDriverError{rqlError(fmt.Sprintf("Args: expected 1 argument, got %d", len(args)))}
+	}
+	if _, ok := args[0].(Term); ok {
+		return nil
+	}
+
+	if args[0] != nil {
+		typ := reflect.TypeOf(args[0])
+		if (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) && typ.Elem().Kind() != reflect.Uint8 {
+			return nil
+		}
+	}
+
+	return RQLDriverError{rqlError(fmt.Sprintf("Args: expected a slice or array, got %T", args[0]))}
 }
 
 // Binary encapsulates binary data within a query.
@@ -282,7 +385,9 @@ func (t Term) Do(args ...interface{}) Term {
 	newArgs = append(newArgs, t)
 	newArgs = append(newArgs, args[:len(args)-1]...)
 
-	return constructRootTerm("Do", p.Term_FUNCALL, newArgs, map[string]interface{}{})
+	term := constructRootTerm("Do", p.Term_FUNCALL, newArgs, map[string]interface{}{})
+	term.timeout = t.timeout
+	return term
 }
 
 // Do evaluates the expr in the context of one or more value bindings. The type of
@@ -295,6 +400,48 @@ func Do(args ...interface{}) Term {
 	return constructRootTerm("Do", p.Term_FUNCALL, newArgs, map[string]interface{}{})
 }
 
+// Var returns a new variable which can be bound as a parameter of Func, each
+// call returns a different variable.
+func Var() Term {
+	return makeVar()
+}
+
+// Func creates a function which binds params, each of which must have been
+// returned by Var, in body. Passing a Go function to terms such as Map or
+// Update creates the same function, for example these two terms are equal:
+//
+//	row := r.Var()
+//	r.Table("users").Update(r.Func([]r.Term{row}, map[string]interface{}{"visits": row.Field("visits").Add(1)}))
+//
+//	r.Table("users").Update(func(row r.Term) interface{} {
+//		return map[string]interface{}{"visits": row.Field("visits").Add(1)}
+//	})
+func Func(params []Term, body interface{}) Term {
+	if err := validateFuncParams(params); err != nil {
+		term := makeFuncTerm(nil, body)
+		term.lastErr = err
+		return term
+	}
+
+	return makeFuncTerm(params, body)
+}
+
+func validateFuncParams(params []Term) error {
+	seen := map[int64]bool{}
+	for i, param := range params {
+		if param.termType != p.Term_VAR || len(param.args) != 1 {
+			return RQLDriverError{rqlError(fmt.Sprintf("Func: parameter %d is not a variable returned by Var", i))}
+		}
+		varID, _ := param.args[0].data.(int64)
+		if seen[varID] {
+			return RQLDriverError{rqlError(fmt.Sprintf("Func: parameter %d is bound more than once", i))}
+		}
+		seen[varID] = true
+	}
+
+	return nil
+}
+
 // Branch evaluates one of two control paths based on the value of an expression.
 // branch is effectively an if renamed due to language constraints.
 //
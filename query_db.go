@@ -13,6 +13,12 @@ func DBCreate(args ...interface{}) Term {
 	return constructRootTerm("DBCreate", p.Term_DB_CREATE, args, map[string]interface{}{})
 }
 
+// EnsureDB creates a database unless it already exists, other errors are
+// returned.
+func EnsureDB(s QueryExecutor, name string) error {
+	return ensure(s, DBCreate(name))
+}
+
 // DBDrop drops a database. The database, all its tables, and corresponding data
 // will be deleted.
 func DBDrop(args ...interface{}) Term {
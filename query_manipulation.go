@@ -1,6 +1,9 @@
 package rethinkdb
 
 import (
+	"fmt"
+	"regexp"
+
 	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 )
 
@@ -12,11 +15,49 @@ import (
 var Row = constructRootTerm("Doc", p.Term_IMPLICIT_VAR, []interface{}{}, map[string]interface{}{})
 
 // Literal replaces an object in a field instead of merging it with an existing
-// object in a merge or update operation.
+// object in a merge or update operation. Its argument is encoded like any other
+// value so it may be a struct, and a Literal term may itself be stored in a
+// struct field of type Term or interface{}:
+//
+//	type profileUpdate struct {
+//		Profile interface{} `rethinkdb:"profile"`
+//	}
+//
+//	// Replaces the whole profile instead of only setting its name
+//	r.Table("users").Get(id).Update(profileUpdate{
+//		Profile: r.Literal(Profile{Name: "Alice"}),
+//	})
+//
+// Calling Literal without an argument removes the field.
 func Literal(args ...interface{}) Term {
 	return constructRootTerm("Literal", p.Term_LITERAL, args, map[string]interface{}{})
 }
 
+// fieldNameRegexp matches the field names accepted by Field
+var fieldNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Field gets a single field from the currently visited document, it is
+// equivalent to r.Row.Field(name) but only accepts a plain identifier made of
+// letters, digits and underscores which does not start with a digit. If name is
+// not a plain identifier the query returns an error when it is run.
+//
+// Field should be used when the field name comes from an untrusted source such
+// as a request parameter. Term.Field and the other terms which select fields
+// accept any value, a value decoded from attacker-controlled JSON may be an
+// object or array which these terms interpret as a nested field selection, or
+// a Term which changes the query itself. Field only accepts a string and
+// rejects names which could refer to something other than a single top level
+// field.
+//
+//	r.Table("users").Filter(r.Field(sortField).Eq(value))
+func Field(name string) Term {
+	term := Row.Field(name)
+	if !fieldNameRegexp.MatchString(name) {
+		term.lastErr = RQLDriverError{rqlError(fmt.Sprintf("Field: %q is not a valid field name", name))}
+	}
+	return term
+}
+
 // Field gets a single field from an object. If called on a sequence, gets that field
 // from every object in the sequence, skipping objects that lack it.
 func (t Term) Field(args ...interface{}) Term {
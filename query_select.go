@@ -1,6 +1,8 @@
 package rethinkdb
 
 import (
+	"fmt"
+
 	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 )
 
@@ -84,6 +86,12 @@ func (t Term) GetAllByIndex(index interface{}, keys ...interface{}) Term {
 	return constructMethodTerm(t, "GetAll", p.Term_GET_ALL, keys, map[string]interface{}{"index": index})
 }
 
+// Bound values used by the LeftBound and RightBound options of Between
+const (
+	BoundClosed = "closed"
+	BoundOpen   = "open"
+)
+
 // BetweenOpts contains the optional arguments for the Between term
 type BetweenOpts struct {
 	Index      interface{} `rethinkdb:"index,omitempty"`
@@ -95,12 +103,32 @@ func (o BetweenOpts) toMap() map[string]interface{} {
 	return optArgsToMap(o)
 }
 
+func (o BetweenOpts) validate() error {
+	if err := validateBound("LeftBound", o.LeftBound); err != nil {
+		return err
+	}
+	return validateBound("RightBound", o.RightBound)
+}
+
+func validateBound(name string, bound interface{}) error {
+	switch bound {
+	case nil, BoundClosed, BoundOpen:
+		return nil
+	}
+	if _, ok := bound.(Term); ok {
+		return nil
+	}
+
+	return RQLDriverError{rqlError(fmt.Sprintf("Between: %s must be %q or %q, got %v", name, BoundClosed, BoundOpen, bound))}
+}
+
 // Between gets all documents between two keys. Accepts three optional arguments:
 // index, leftBound, and rightBound. If index is set to the name of a secondary
 // index, between will return all documents where that index’s value is in the
 // specified range (it uses the primary key by default). leftBound or rightBound
-// may be set to open or closed to indicate whether or not to include that endpoint
-// of the range (by default, leftBound is closed and rightBound is open).
+// may be set to BoundOpen or BoundClosed to indicate whether or not to include
+// that endpoint of the range (by default, leftBound is closed and rightBound is
+// open).
 //
 // You may also use the special constants r.minval and r.maxval for boundaries,
 // which represent “less than any index key” and “more than any index key”
@@ -110,11 +138,38 @@ func (o BetweenOpts) toMap() map[string]interface{} {
 func (t Term) Between(lowerKey, upperKey interface{}, optArgs ...BetweenOpts) Term {
 	opts := map[string]interface{}{}
 	if len(optArgs) >= 1 {
+		if err := optArgs[0].validate(); err != nil {
+			term := constructMethodTerm(t, "Between", p.Term_BETWEEN, []interface{}{lowerKey, upperKey}, opts)
+			term.lastErr = err
+			return term
+		}
 		opts = optArgs[0].toMap()
 	}
 	return constructMethodTerm(t, "Between", p.Term_BETWEEN, []interface{}{lowerKey, upperKey}, opts)
 }
 
+// BetweenIndex gets all documents where the value of the given secondary index
+// is between two keys. The optional bounds are the left bound followed by the
+// right bound and must be BoundOpen or BoundClosed.
+//
+//	r.Table("users").BetweenIndex("age", 18, 65, r.BoundClosed, r.BoundClosed)
+func (t Term) BetweenIndex(index string, lowerKey, upperKey interface{}, bounds ...string) Term {
+	opts := BetweenOpts{Index: index}
+	if len(bounds) > 2 {
+		term := t.Between(lowerKey, upperKey, opts)
+		term.lastErr = RQLDriverError{rqlError(fmt.Sprintf("BetweenIndex: expected at most 2 bounds, got %d", len(bounds)))}
+		return term
+	}
+	if len(bounds) >= 1 {
+		opts.LeftBound = bounds[0]
+	}
+	if len(bounds) >= 2 {
+		opts.RightBound = bounds[1]
+	}
+
+	return t.Between(lowerKey, upperKey, opts)
+}
+
 // FilterOpts contains the optional arguments for the Filter term
 type FilterOpts struct {
 	Default interface{} `rethinkdb:"default,omitempty"`
@@ -132,10 +187,27 @@ func (o FilterOpts) toMap() map[string]interface{} {
 // and the default value can be changed by passing the optional argument `default`.
 // Setting this optional argument to `r.error()` will cause any non-existence
 // errors to abort the filter.
+//
+// If the predicate is a struct (or map) then the documents are matched exactly
+// against the encoded object. Structs are encoded like in Insert, so fields
+// tagged with "-" are never part of the match and fields tagged with
+// "omitempty" are only matched if they are not empty:
+//
+//	type hero struct {
+//		Name string `rethinkdb:"name,omitempty"`
+//		Age  int    `rethinkdb:"age,omitempty"`
+//	}
+//	// Matches documents where name is "Superman", regardless of the age
+//	r.Table("heroes").Filter(hero{Name: "Superman"})
 func (t Term) Filter(f interface{}, optArgs ...FilterOpts) Term {
 	opts := map[string]interface{}{}
 	if len(optArgs) >= 1 {
 		opts = optArgs[0].toMap()
 	}
+	if err := validateFunc("Filter", "predicate", f, 1); err != nil {
+		term := constructMethodTerm(t, "Filter", p.Term_FILTER, []interface{}{}, opts)
+		term.lastErr = err
+		return term
+	}
 	return constructMethodTerm(t, "Filter", p.Term_FILTER, []interface{}{funcWrap(f)}, opts)
 }
@@ -1,11 +1,19 @@
 package rethinkdb
 
 import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"golang.org/x/net/context"
+	"gopkg.in/rethinkdb/rethinkdb-go.v6/encoding"
 	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 )
 
 // TableCreateOpts contains the optional arguments for the TableCreate term
 type TableCreateOpts struct {
+	// PrimaryKey is the name of the primary key, use PrimaryKeyOf to get it
+	// from a struct with a field tagged with the "primary" option.
 	PrimaryKey           interface{} `rethinkdb:"primary_key,omitempty"`
 	Durability           interface{} `rethinkdb:"durability,omitempty"`
 	Shards               interface{} `rethinkdb:"shards,omitempty"`
@@ -18,6 +26,19 @@ func (o TableCreateOpts) toMap() map[string]interface{} {
 	return optArgsToMap(o)
 }
 
+// PrimaryKeyOf returns the name of the primary key of doc, a struct or a
+// pointer to a struct. The primary key is the field tagged with the "primary"
+// option, or "id" if there is no such field.
+//
+//	type User struct {
+//	    Email string `rethinkdb:"email,primary"`
+//	}
+//
+//	r.TableCreate("users", r.TableCreateOpts{PrimaryKey: r.PrimaryKeyOf(User{})})
+func PrimaryKeyOf(doc interface{}) string {
+	return encoding.PrimaryKey(reflect.TypeOf(doc))
+}
+
 // TableCreate creates a table. A RethinkDB table is a collection of JSON
 // documents.
 //
@@ -62,6 +83,12 @@ func (t Term) TableList(args ...interface{}) Term {
 	return constructMethodTerm(t, "TableList", p.Term_TABLE_LIST, args, map[string]interface{}{})
 }
 
+// EnsureTable creates a table in the database db unless it already exists,
+// other errors are returned.
+func EnsureTable(s QueryExecutor, db, name string, optArgs ...TableCreateOpts) error {
+	return ensure(s, DB(db).TableCreate(name, optArgs...))
+}
+
 // IndexCreateOpts contains the optional arguments for the IndexCreate term
 type IndexCreateOpts struct {
 	Multi interface{} `rethinkdb:"multi,omitempty"`
@@ -100,14 +127,69 @@ func (t Term) IndexCreate(name interface{}, optArgs ...IndexCreateOpts) Term {
 //     different name to the field.
 //   - Compound indexes based on multiple fields.
 //   - Multi indexes based on arrays of values, created when the multi optional argument is true.
+//
+// When indexFunction is a Go function it must take a single Term, the row, and
+// return a single value:
+//
+//	r.Table("users").IndexCreateFunc("full_name", func(row r.Term) interface{} {
+//		return []interface{}{row.Field("last_name"), row.Field("first_name")}
+//	})
 func (t Term) IndexCreateFunc(name, indexFunction interface{}, optArgs ...IndexCreateOpts) Term {
 	opts := map[string]interface{}{}
 	if len(optArgs) >= 1 {
 		opts = optArgs[0].toMap()
 	}
+	if err := validateIndexFunc(indexFunction); err != nil {
+		term := constructMethodTerm(t, "IndexCreate", p.Term_INDEX_CREATE, []interface{}{name}, opts)
+		term.lastErr = err
+		return term
+	}
 	return constructMethodTerm(t, "IndexCreate", p.Term_INDEX_CREATE, []interface{}{name, funcWrap(indexFunction)}, opts)
 }
 
+// validateIndexFunc returns an error if indexFunction is a Go function which
+// does not take a single Term and return a single value.
+func validateIndexFunc(indexFunction interface{}) error {
+	return validateFunc("IndexCreateFunc", "index function", indexFunction, 1)
+}
+
+// IndexCreateMulti creates a multi index, which indexes each element of the
+// array stored in the field name, for example to query documents by tag.
+func (t Term) IndexCreateMulti(name interface{}, optArgs ...IndexCreateOpts) Term {
+	opts := IndexCreateOpts{}
+	if len(optArgs) >= 1 {
+		opts = optArgs[0]
+	}
+	opts.Multi = true
+	return t.IndexCreate(name, opts)
+}
+
+// IndexCreateGeo creates a geospatial index on the geometry objects stored in
+// the field name, which can be queried using GetIntersecting and GetNearest.
+func (t Term) IndexCreateGeo(name interface{}, optArgs ...IndexCreateOpts) Term {
+	opts := IndexCreateOpts{}
+	if len(optArgs) >= 1 {
+		opts = optArgs[0]
+	}
+	opts.Geo = true
+	return t.IndexCreate(name, opts)
+}
+
+// EnsureIndex creates a simple secondary index on the table db.table unless
+// an index with the same name already exists, other errors are returned. The
+// index may still be building when EnsureIndex returns, use IndexWait to wait
+// for it.
+func EnsureIndex(s QueryExecutor, db, table, name string, optArgs ...IndexCreateOpts) error {
+	return ensure(s, DB(db).Table(table).IndexCreate(name, optArgs...))
+}
+
+// EnsureIndexFunc is like EnsureIndex but creates the index using
+// IndexCreateFunc. An existing index with the same name is left unchanged even
+// if its function differs.
+func EnsureIndexFunc(s QueryExecutor, db, table, name string, indexFunction interface{}, optArgs ...IndexCreateOpts) error {
+	return ensure(s, DB(db).Table(table).IndexCreateFunc(name, indexFunction, optArgs...))
+}
+
 // IndexDrop deletes a previously created secondary index of a table.
 func (t Term) IndexDrop(args ...interface{}) Term {
 	return constructMethodTerm(t, "IndexDrop", p.Term_INDEX_DROP, args, map[string]interface{}{})
@@ -148,13 +230,76 @@ func (t Term) IndexWait(args ...interface{}) Term {
 	return constructMethodTerm(t, "IndexWait", p.Term_INDEX_WAIT, args, map[string]interface{}{})
 }
 
+// IndexStatus is a single result of the IndexStatus term, it contains the
+// definition of a secondary index and the progress of its construction.
+type IndexStatus struct {
+	Index    string `rethinkdb:"index"`
+	Ready    bool   `rethinkdb:"ready"`
+	Function []byte `rethinkdb:"function"`
+	Query    string `rethinkdb:"query"`
+	Multi    bool   `rethinkdb:"multi"`
+	Geo      bool   `rethinkdb:"geo"`
+	Outdated bool   `rethinkdb:"outdated"`
+	// Progress is the fraction of the index which has been built, it is only
+	// set while the index is being constructed.
+	Progress        float64 `rethinkdb:"progress"`
+	BlocksProcessed int64   `rethinkdb:"blocks_processed"`
+	BlocksTotal     int64   `rethinkdb:"blocks_total"`
+}
+
+// indexStatusPollInterval is the time between the IndexStatus queries sent by
+// WaitIndex
+const indexStatusPollInterval = 100 * time.Millisecond
+
+// indexStatus returns the status of a single secondary index.
+func indexStatus(ctx context.Context, s QueryExecutor, db, table, index string) (IndexStatus, error) {
+	var status IndexStatus
+	res, err := DB(db).Table(table).IndexStatus(index).Run(s, RunOpts{Context: ctx})
+	if err != nil {
+		return status, err
+	}
+	defer res.Close()
+
+	err = res.One(&status)
+	return status, err
+}
+
+// waitIndex polls the status of a secondary index until it is ready or ctx is
+// done.
+func waitIndex(ctx context.Context, s QueryExecutor, db, table, index string) (IndexStatus, error) {
+	for {
+		status, err := indexStatus(ctx, s, db, table, index)
+		if err != nil || status.Ready {
+			return status, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-time.After(indexStatusPollInterval):
+		}
+	}
+}
+
 // ChangesOpts contains the optional arguments for the Changes term
 type ChangesOpts struct {
-	Squash              interface{} `rethinkdb:"squash,omitempty"`
-	IncludeInitial      interface{} `rethinkdb:"include_initial,omitempty"`
-	IncludeStates       interface{} `rethinkdb:"include_states,omitempty"`
-	IncludeOffsets      interface{} `rethinkdb:"include_offsets,omitempty"`
-	IncludeTypes        interface{} `rethinkdb:"include_types,omitempty"`
+	// Squash is either a bool or the number of seconds changes are squashed
+	// for, use SquashEvery to set it from a time.Duration.
+	Squash         interface{} `rethinkdb:"squash,omitempty"`
+	IncludeInitial bool        `rethinkdb:"include_initial,omitempty"`
+	IncludeStates  bool        `rethinkdb:"include_states,omitempty"`
+	// IncludeOffsets adds the position of each document in the results of an
+	// OrderBy().Limit() changefeed, the only kind of changefeed which supports
+	// it. Offsets are indexes into the ordered results, not positions in a
+	// log of changes: RethinkDB doesn't keep changes once they are sent, so a
+	// changefeed can't be resumed from an offset after a restart. Consumers
+	// which need to survive restarts should set IncludeInitial and reconcile
+	// the initial values with their own state. Cursor.Offset returns the
+	// new_offset of the last change read.
+	IncludeOffsets bool `rethinkdb:"include_offsets,omitempty"`
+	// IncludeTypes adds the type of each change, read it with
+	// ChangeResponse.ChangeType.
+	IncludeTypes        bool        `rethinkdb:"include_types,omitempty"`
 	ChangefeedQueueSize interface{} `rethinkdb:"changefeed_queue_size,omitempty"`
 }
 
@@ -163,10 +308,48 @@ func (o ChangesOpts) toMap() map[string]interface{} {
 	return optArgsToMap(o)
 }
 
+func (o ChangesOpts) validate() error {
+	switch v := o.Squash.(type) {
+	case nil, bool, Term:
+		return nil
+	case time.Duration:
+		return RQLDriverError{rqlError("Changes: Squash must be a number of seconds, use SquashEvery to convert a time.Duration")}
+	default:
+		if isNonNegativeNumber(v) {
+			return nil
+		}
+		return RQLDriverError{rqlError(fmt.Sprintf("Changes: Squash must be a bool or a non-negative number, got %v", v))}
+	}
+}
+
+// isNonNegativeNumber returns true if v is a Go number which is not negative
+func isNonNegativeNumber(v interface{}) bool {
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() >= 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() >= 0
+	}
+	return false
+}
+
+// SquashEvery returns the value of ChangesOpts.Squash that squashes changes
+// for the duration d.
+func SquashEvery(d time.Duration) float64 {
+	return d.Seconds()
+}
+
 // Changes returns an infinite stream of objects representing changes to a query.
 func (t Term) Changes(optArgs ...ChangesOpts) Term {
 	opts := map[string]interface{}{}
 	if len(optArgs) >= 1 {
+		if err := optArgs[0].validate(); err != nil {
+			term := constructMethodTerm(t, "Changes", p.Term_CHANGES, []interface{}{}, opts)
+			term.lastErr = err
+			return term
+		}
 		opts = optArgs[0].toMap()
 	}
 	return constructMethodTerm(t, "Changes", p.Term_CHANGES, []interface{}{}, opts)
@@ -0,0 +1,125 @@
+package rethinkdb
+
+import (
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	test "gopkg.in/check.v1"
+	"gopkg.in/rethinkdb/rethinkdb-go.v6/internal/integration/tests"
+	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
+)
+
+type QueryTableSuite struct{}
+
+var _ = test.Suite(&QueryTableSuite{})
+
+func (s *QueryTableSuite) TestChanges_OptArgs(c *test.C) {
+	q, err := Table("test").Changes(ChangesOpts{
+		Squash:         SquashEvery(1500 * time.Millisecond),
+		IncludeInitial: true,
+		IncludeStates:  true,
+		IncludeTypes:   true,
+	}).Build()
+	c.Assert(err, test.IsNil)
+
+	optArgs := q.([]interface{})[2]
+	c.Assert(optArgs, tests.JsonEquals, map[string]interface{}{
+		"squash":          1.5,
+		"include_initial": true,
+		"include_states":  true,
+		"include_types":   true,
+	})
+}
+
+func (s *QueryTableSuite) TestChanges_SquashBool(c *test.C) {
+	q, err := Table("test").Changes(ChangesOpts{Squash: false}).Build()
+	c.Assert(err, test.IsNil)
+	c.Assert(q.([]interface{})[2], tests.JsonEquals, map[string]interface{}{"squash": false})
+}
+
+func (s *QueryTableSuite) TestChanges_SquashInvalid(c *test.C) {
+	_, err := Table("test").Changes(ChangesOpts{Squash: time.Second}).Build()
+	c.Assert(err, test.FitsTypeOf, RQLDriverError{})
+
+	_, err = Table("test").Changes(ChangesOpts{Squash: -1}).Build()
+	c.Assert(err, test.FitsTypeOf, RQLDriverError{})
+
+	_, err = Table("test").Changes(ChangesOpts{Squash: "1s"}).Build()
+	c.Assert(err, test.FitsTypeOf, RQLDriverError{})
+}
+
+func opFailedError(msg string) error {
+	b, _ := json.Marshal(msg)
+	return createRuntimeError(p.Response_OP_FAILED, &Response{Responses: []json.RawMessage{b}}, nil)
+}
+
+func (s *QueryTableSuite) TestEnsure_Twice(c *test.C) {
+	mock := NewMock()
+	mock.On(DBCreate("app")).Return(map[string]interface{}{"dbs_created": 1}, nil).Once()
+	mock.On(DBCreate("app")).Return(nil, opFailedError("Database `app` already exists.")).Once()
+	mock.On(DB("app").TableCreate("users")).Return(map[string]interface{}{"tables_created": 1}, nil).Once()
+	mock.On(DB("app").TableCreate("users")).Return(nil, opFailedError("Table `app.users` already exists.")).Once()
+	mock.On(DB("app").Table("users").IndexCreate("email")).Return(map[string]interface{}{"created": 1}, nil).Once()
+	mock.On(DB("app").Table("users").IndexCreate("email")).Return(nil, opFailedError("Index `email` already exists on table `app.users`.")).Once()
+
+	for i := 0; i < 2; i++ {
+		c.Assert(EnsureDB(mock, "app"), test.IsNil)
+		c.Assert(EnsureTable(mock, "app", "users"), test.IsNil)
+		c.Assert(EnsureIndex(mock, "app", "users", "email"), test.IsNil)
+	}
+	mock.AssertExpectations(c)
+}
+
+func (s *QueryTableSuite) TestEnsure_OtherErrors(c *test.C) {
+	mock := NewMock()
+	mock.On(DB("app").TableCreate("users", TableCreateOpts{Replicas: 3})).Return(nil, opFailedError("Can't put 3 replicas on servers with the tag `default` because there are only 1 servers with the tag `default`."))
+	mock.On(DB("missing").TableCreate("users")).Return(nil, opFailedError("Database `missing` does not exist."))
+
+	err := EnsureTable(mock, "app", "users", TableCreateOpts{Replicas: 3})
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Can't put 3 replicas .*")
+	err = EnsureTable(mock, "missing", "users")
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Database `missing` does not exist.")
+	mock.AssertExpectations(c)
+}
+
+func (s *QueryTableSuite) TestIndexCreateFunc(c *test.C) {
+	got := Table("users").IndexCreateFunc("full_name", func(row Term) interface{} {
+		return []interface{}{row.Field("last_name"), row.Field("first_name")}
+	})
+	expected := constructMethodTerm(Table("users"), "IndexCreate", p.Term_INDEX_CREATE, []interface{}{"full_name", makeFunc(func(x Term) Term {
+		return Expr([]interface{}{x.Field("last_name"), x.Field("first_name")})
+	})}, map[string]interface{}{})
+	c.Assert(TermsEqual(got, expected), test.Equals, true, test.Commentf("%s", got))
+
+	_, err := got.Build()
+	c.Assert(err, test.IsNil)
+}
+
+func (s *QueryTableSuite) TestIndexCreateFunc_Arity(c *test.C) {
+	_, err := Table("users").IndexCreateFunc("a", func(a, b Term) Term { return a }).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: IndexCreateFunc: index function must take 1 argument, got 2")
+
+	_, err = Table("users").IndexCreateFunc("a", func() Term { return Expr(1) }).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: IndexCreateFunc: index function must take 1 argument, got 0")
+
+	_, err = Table("users").IndexCreateFunc("a", func(row string) Term { return Expr(row) }).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: IndexCreateFunc: index function argument must be a Term, got string")
+
+	_, err = Table("users").IndexCreateFunc("a", func(row Term) {}).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: IndexCreateFunc: index function must return 1 value, got 0")
+}
+
+func (s *QueryTableSuite) TestIndexCreateMultiGeo(c *test.C) {
+	c.Assert(TermsEqual(
+		Table("posts").IndexCreateMulti("tags"),
+		Table("posts").IndexCreate("tags", IndexCreateOpts{Multi: true}),
+	), test.Equals, true)
+	c.Assert(TermsEqual(
+		Table("places").IndexCreateGeo("location"),
+		Table("places").IndexCreate("location", IndexCreateOpts{Geo: true}),
+	), test.Equals, true)
+	c.Assert(TermsEqual(
+		Table("places").IndexCreateGeo("areas", IndexCreateOpts{Multi: true}),
+		Table("places").IndexCreate("areas", IndexCreateOpts{Multi: true, Geo: true}),
+	), test.Equals, true)
+}
@@ -0,0 +1,711 @@
+package rethinkdb
+
+import (
+	"errors"
+
+	"github.com/segmentio/encoding/json"
+	test "gopkg.in/check.v1"
+	"gopkg.in/rethinkdb/rethinkdb-go.v6/internal/integration/tests"
+)
+
+type QuerySuite struct{}
+
+var _ = test.Suite(&QuerySuite{})
+
+func (s *QuerySuite) TestTerm_UsesIndex(c *test.C) {
+	c.Assert(Table("test").GetAllByIndex("name", "a").UsesIndex("name"), test.Equals, true)
+	c.Assert(Table("test").GetAllByIndex("name", "a").UsesIndex("age"), test.Equals, false)
+	c.Assert(Table("test").BetweenIndex("age", 1, 10).Count().UsesIndex("age"), test.Equals, true)
+	c.Assert(Table("test").OrderBy(OrderByOpts{Index: Desc("age")}).UsesIndex("age"), test.Equals, true)
+	c.Assert(Table("test").Filter(map[string]interface{}{"age": 1}).UsesIndex("age"), test.Equals, false)
+	c.Assert(Table("test").GetAll("a").UsesIndex("id"), test.Equals, false)
+}
+
+func (s *QuerySuite) TestTermsEqual(c *test.C) {
+	c.Assert(TermsEqual(Table("test").Get(1), Table("test").Get(1)), test.Equals, true)
+	c.Assert(TermsEqual(Table("test").Get(1), Table("test").Get(2)), test.Equals, false)
+	c.Assert(TermsEqual(Table("test").Get(1), MockAnything()), test.Equals, true)
+	c.Assert(TermsEqual(Table("test").Get(MockAnything()), Table("test").Get(1)), test.Equals, true)
+
+	f1 := Table("test").Filter(func(row Term) Term { return row.Field("age").Gt(1) })
+	f2 := Table("test").Filter(func(row Term) Term { return row.Field("age").Gt(1) })
+	f3 := Table("test").Filter(func(row Term) Term { return row.Field("age").Lt(1) })
+	c.Assert(TermsEqual(f1, f2), test.Equals, true)
+	c.Assert(TermsEqual(f1, f3), test.Equals, false)
+}
+
+func (s *QuerySuite) TestEncodeToMap(c *test.C) {
+	type address struct {
+		City string `rethinkdb:"city"`
+		Zip  string `rethinkdb:"zip,omitempty"`
+	}
+	type user struct {
+		ID       string  `rethinkdb:"id,omitempty"`
+		Name     string  `rethinkdb:"name"`
+		Password string  `rethinkdb:"-"`
+		Address  address `rethinkdb:"address"`
+		Seen     Term    `rethinkdb:"seen"`
+	}
+	doc := user{Name: "ann", Password: "secret", Address: address{City: "Paris"}, Seen: Now()}
+
+	m, err := EncodeToMap(doc)
+	c.Assert(err, test.IsNil)
+	c.Assert(m, test.DeepEquals, map[string]interface{}{
+		"name":    "ann",
+		"address": map[string]interface{}{"city": "Paris"},
+		"seen":    Now(),
+	})
+	c.Assert(TermsEqual(Table("users").Insert(m), Table("users").Insert(doc)), test.Equals, true)
+
+	m, err = EncodeToMap(&doc)
+	c.Assert(err, test.IsNil)
+	c.Assert(m["name"], test.Equals, "ann")
+
+	_, err = EncodeToMap([]int{1})
+	c.Assert(err, test.ErrorMatches, `rethinkdb: EncodeToMap: \[\]int is not encoded to an object`)
+	_, err = EncodeToMap(Now())
+	c.Assert(err, test.FitsTypeOf, RQLDriverError{})
+}
+
+func (s *QuerySuite) TestReplaceNonFiniteNumbers(c *test.C) {
+	b, ok := replaceNonFiniteNumbers([]byte(`[1,"a \"NaN\" b",NaN,{"Infinity":-Infinity}]`))
+	c.Assert(ok, test.Equals, true)
+	c.Assert(string(b), test.Equals, `[1,"a \"NaN\" b",{"$reql_type$":"NON_FINITE","value":"NaN"},{"Infinity":{"$reql_type$":"NON_FINITE","value":"-Infinity"}}]`)
+
+	b, ok = replaceNonFiniteNumbers([]byte(`[1,"NaN"]`))
+	c.Assert(ok, test.Equals, false)
+	c.Assert(string(b), test.Equals, `[1,"NaN"]`)
+}
+
+func (s *QuerySuite) TestRunOptsRejectNonFiniteNotSent(c *test.C) {
+	q, err := newQuery(Expr(1), RunOpts{RejectNonFinite: true}.toMap(), &ConnectOpts{})
+	c.Assert(err, test.IsNil)
+	c.Assert(q.Opts["reject_non_finite"], test.Equals, true)
+	c.Assert(q.Build()[2], test.DeepEquals, map[string]interface{}{})
+}
+
+func (s *QuerySuite) TestArgs_GetAll(c *test.C) {
+	type user struct {
+		Name string `rethinkdb:"name"`
+	}
+
+	built, err := Table("users").GetAll(Args([]user{{Name: "alice"}})).Build()
+	c.Assert(err, test.IsNil)
+	c.Assert(built, tests.JsonEquals, []interface{}{78, []interface{}{
+		[]interface{}{15, []interface{}{"users"}},
+		[]interface{}{154, []interface{}{[]interface{}{2, []interface{}{map[string]interface{}{"name": "alice"}}}}},
+	}})
+}
+
+func (s *QuerySuite) TestArgs_Do(c *test.C) {
+	built, err := Do(Args([2]int{1, 2}), func(a, b Term) Term { return a.Add(b) }).Build()
+	c.Assert(err, test.IsNil)
+	c.Assert(built.([]interface{})[1].([]interface{})[1], tests.JsonEquals, []interface{}{154, []interface{}{[]interface{}{2, []interface{}{1, 2}}}})
+}
+
+func (s *QuerySuite) TestArgs_Invalid(c *test.C) {
+	_, err := Table("users").GetAll(Args("alice")).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Args: expected a slice or array, got string")
+
+	_, err = Table("users").GetAll(Args([]byte("alice"))).Build()
+	c.Assert(err, test.ErrorMatches, `rethinkdb: Args: expected a slice or array, got \[\]uint8`)
+
+	_, err = Table("users").GetAll(Args([]string{"a"}, []string{"b"})).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Args: expected 1 argument, got 2")
+
+	_, err = Table("users").GetAll(Args(Expr([]string{"a"}))).Build()
+	c.Assert(err, test.IsNil)
+}
+
+func (s *QuerySuite) TestField(c *test.C) {
+	c.Assert(TermsEqual(Field("name"), Row.Field("name")), test.Equals, true)
+
+	_, err := Table("users").Filter(Field("first_name2").Eq("a")).Build()
+	c.Assert(err, test.IsNil)
+
+	for _, name := range []string{"", "1a", "a.b", "a b", `a"`, "a-b"} {
+		_, err = Table("users").Filter(Field(name).Eq("a")).Build()
+		c.Assert(err, test.NotNil, test.Commentf("name %q", name))
+	}
+}
+
+func (s *QuerySuite) TestHTTP_Opts(c *test.C) {
+	built, err := HTTP("example.com", HTTPOpts{
+		Method:    "POST",
+		Redirects: 2,
+		Auth:      HTTPAuth{User: "user", Pass: "pass"},
+	}).Build()
+	c.Assert(err, test.IsNil)
+	c.Assert(built, tests.JsonEquals, []interface{}{153, []interface{}{"example.com"}, map[string]interface{}{
+		"method":    "POST",
+		"redirects": 2,
+		"auth":      map[string]interface{}{"user": "user", "pass": "pass"},
+	}})
+
+	_, err = HTTP("example.com", HTTPOpts{Method: Expr("GET"), Timeout: 0.5}).Build()
+	c.Assert(err, test.IsNil)
+}
+
+func (s *QuerySuite) TestHTTP_InvalidOpts(c *test.C) {
+	_, err := HTTP("example.com", HTTPOpts{Method: "get"}).Build()
+	c.Assert(err, test.ErrorMatches, `rethinkdb: HTTP: Method must be one of .*, got get`)
+
+	_, err = HTTP("example.com", HTTPOpts{ResultFormat: "xml"}).Build()
+	c.Assert(err, test.ErrorMatches, `rethinkdb: HTTP: ResultFormat must be one of .*, got xml`)
+
+	_, err = HTTP("example.com", HTTPOpts{Timeout: -1}).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: HTTP: Timeout must be a non-negative number, got -1")
+
+	_, err = HTTP("example.com", HTTPOpts{Redirects: "2"}).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: HTTP: Redirects must be a non-negative number, got 2")
+
+	_, err = HTTP("example.com", HTTPOpts{Auth: HTTPAuth{Type: "oauth"}}).Build()
+	c.Assert(err, test.ErrorMatches, `rethinkdb: HTTP: Auth.Type must be one of .*, got oauth`)
+}
+
+func (s *QuerySuite) TestWriteOpts_Durability(c *test.C) {
+	_, err := Table("users").Insert(map[string]interface{}{}, InsertOpts{Durability: DurabilitySoft}).Build()
+	c.Assert(err, test.IsNil)
+	_, err = Table("users").Update(map[string]interface{}{}, UpdateOpts{Durability: DurabilityHard}).Build()
+	c.Assert(err, test.IsNil)
+	_, err = Table("users").Delete(DeleteOpts{Durability: Expr("soft")}).Build()
+	c.Assert(err, test.IsNil)
+
+	_, err = Table("users").Insert(map[string]interface{}{}, InsertOpts{Durability: "sfot"}).Build()
+	c.Assert(err, test.ErrorMatches, `rethinkdb: Insert: Durability must be "hard" or "soft", got sfot`)
+	_, err = Table("users").Update(map[string]interface{}{}, UpdateOpts{Durability: "HARD"}).Build()
+	c.Assert(err, test.ErrorMatches, `rethinkdb: Update: Durability must be "hard" or "soft", got HARD`)
+	_, err = Table("users").Replace(map[string]interface{}{}, ReplaceOpts{Durability: true}).Build()
+	c.Assert(err, test.ErrorMatches, `rethinkdb: Replace: Durability must be "hard" or "soft", got true`)
+	_, err = Table("users").Delete(DeleteOpts{Durability: "wrong"}).Build()
+	c.Assert(err, test.ErrorMatches, `rethinkdb: Delete: Durability must be "hard" or "soft", got wrong`)
+}
+
+func (s *QuerySuite) TestRun_StaleFallbackAfter(c *test.C) {
+	query := Table("stats").Get("daily")
+	unavailable := opFailedError("Cannot perform read: primary replica for shard [\"\", +inf) not available")
+
+	mock := NewMock()
+	mock.On(query).Return(nil, unavailable).Twice()
+	mock.On(query).Return(map[string]interface{}{"views": 10}, nil).Once()
+
+	res, err := query.Run(mock, RunOpts{ReadMode: "majority", StaleFallbackAfter: 2})
+	c.Assert(err, test.IsNil)
+	c.Assert(res.IsStale(), test.Equals, true)
+
+	var stats map[string]interface{}
+	c.Assert(res.One(&stats), test.IsNil)
+	c.Assert(stats, tests.JsonEquals, map[string]interface{}{"views": 10})
+
+	c.Assert(mock.Queries, test.HasLen, 3)
+	c.Assert(mock.Queries[1].Query.Opts["read_mode"], tests.JsonEquals, "majority")
+	c.Assert(mock.Queries[2].Query.Opts["read_mode"], tests.JsonEquals, "outdated")
+	mock.AssertExpectations(c)
+}
+
+func (s *QuerySuite) TestRun_StaleFallbackAfter_NotNeeded(c *test.C) {
+	query := Table("stats").Get("daily")
+
+	mock := NewMock()
+	mock.On(query).Return(nil, opFailedError("Cannot perform read: primary replica not available")).Once()
+	mock.On(query).Return(map[string]interface{}{"views": 10}, nil).Once()
+	mock.On(Table("stats").Get("weekly")).Return(nil, RQLNonExistenceError{}).Once()
+
+	res, err := query.Run(mock, RunOpts{StaleFallbackAfter: 2})
+	c.Assert(err, test.IsNil)
+	c.Assert(res.IsStale(), test.Equals, false)
+
+	_, err = Table("stats").Get("weekly").Run(mock, RunOpts{StaleFallbackAfter: 2})
+	c.Assert(err, test.FitsTypeOf, RQLNonExistenceError{})
+	mock.AssertExpectations(c)
+}
+
+func (s *QuerySuite) TestTerm_Depth(c *test.C) {
+	c.Assert(Expr("a").Depth(), test.Equals, 1)
+	c.Assert(Table("users").Depth(), test.Equals, 2)
+	c.Assert(Table("users").Get("a").Depth(), test.Equals, 3)
+	c.Assert(Table("users").Filter(Row.Field("age").Gt(18)).Depth(), test.Equals, 5)
+	c.Assert(Table("users").OrderBy(OrderByOpts{Index: Desc("age")}).Depth(), test.Equals, 3)
+}
+
+func (s *QuerySuite) TestTerm_Complexity(c *test.C) {
+	c.Assert(Expr("a").Complexity(), test.Equals, QueryComplexity{Terms: 1, Depth: 1})
+	c.Assert(Table("users").Complexity(), test.Equals, QueryComplexity{Terms: 2, Depth: 2, FullTable: true})
+	c.Assert(Table("users").Get("a").Complexity(), test.Equals, QueryComplexity{Terms: 4, Depth: 3})
+	c.Assert(Table("users").GetAll("a", "b").Field("name").Complexity(), test.Equals, QueryComplexity{Terms: 7, Depth: 4})
+	c.Assert(Table("users").Insert(map[string]interface{}{"id": "a"}).Complexity().FullTable, test.Equals, false)
+	c.Assert(Table("users").IndexCreate("age").Complexity().FullTable, test.Equals, false)
+
+	c.Assert(Table("users").Filter(Row.Field("age").Gt(18)).Complexity().FullTable, test.Equals, true)
+	c.Assert(Table("users").Count().Complexity().FullTable, test.Equals, true)
+	c.Assert(Table("users").OrderBy(OrderByOpts{Index: Desc("age")}).Limit(10).Complexity(), test.Equals, QueryComplexity{Terms: 7, Depth: 4, FullTable: true})
+
+	// A table read in a subquery is found
+	query := Table("posts").GetAll("a").Merge(func(post Term) Term {
+		return Expr(map[string]interface{}{"comments": Table("comments").Filter(map[string]interface{}{"post": post.Field("id")}).CoerceTo("array")})
+	})
+	c.Assert(query.Complexity().FullTable, test.Equals, true)
+}
+
+func (s *QuerySuite) TestMaxQueryDepth(c *test.C) {
+	query := Expr(1)
+	for i := 0; i < 10; i++ {
+		query = query.Add(1)
+	}
+	c.Assert(query.Depth(), test.Equals, 11)
+
+	_, err := newQuery(query, map[string]interface{}{}, &ConnectOpts{MaxQueryDepth: 11})
+	c.Assert(err, test.IsNil)
+	_, err = newQuery(query, map[string]interface{}{}, &ConnectOpts{})
+	c.Assert(err, test.IsNil)
+
+	_, err = newQuery(query, map[string]interface{}{}, &ConnectOpts{MaxQueryDepth: 9})
+	c.Assert(err, test.ErrorMatches, `rethinkdb: query depth 11 exceeds MaxQueryDepth 9, nested too deeply: 1\.Add\(1\)$`)
+
+	mock := NewMock(ConnectOpts{MaxQueryDepth: 5})
+	_, err = query.Run(mock)
+	c.Assert(err, test.ErrorMatches, "rethinkdb: query depth 11 exceeds MaxQueryDepth 5, .*")
+}
+
+func (s *QuerySuite) TestWriteResponse_Decode(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("users").Insert(MockAnything())).Return(map[string]interface{}{
+		"deleted":        0,
+		"errors":         1,
+		"first_error":    "Duplicate primary key `id`:\n{\n\t\"id\":\t1\n}\n{\n\t\"id\":\t1\n}",
+		"generated_keys": []interface{}{"8d3a3c6a-6b0e-4a8b-9d6a-3f3b2c1d0e9f"},
+		"inserted":       1,
+		"replaced":       0,
+		"skipped":        0,
+		"unchanged":      0,
+		"warnings":       []interface{}{"Too many changes, array truncated to 100000."},
+	}, nil)
+
+	res, err := Table("users").Insert([]interface{}{map[string]interface{}{"id": 1}, map[string]interface{}{}}).RunWrite(mock)
+	c.Assert(err, test.ErrorMatches, "(?s)Duplicate primary key .*")
+	c.Assert(res.Inserted, test.Equals, 1)
+	c.Assert(res.Errors, test.Equals, 1)
+	c.Assert(res.GeneratedKeys, test.DeepEquals, []string{"8d3a3c6a-6b0e-4a8b-9d6a-3f3b2c1d0e9f"})
+	c.Assert(res.Warnings, test.DeepEquals, []string{"Too many changes, array truncated to 100000."})
+	mock.AssertExpectations(c)
+}
+
+func (s *QuerySuite) TestFilter_Struct(c *test.C) {
+	type predicate struct {
+		ID     int    `rethinkdb:"id,omitempty"`
+		G1     int    `rethinkdb:"g1"`
+		G2     int    `rethinkdb:"g2,omitempty"`
+		Num    *int   `rethinkdb:"num,omitempty"`
+		Ignore string `rethinkdb:"-"`
+	}
+
+	zero := 0
+	filter := Table("test").Filter(predicate{G1: 2, Num: &zero, Ignore: "x"})
+	expected := Table("test").Filter(map[string]interface{}{"g1": int64(2), "num": int64(0)})
+	c.Assert(TermsEqual(filter, expected), test.Equals, true, test.Commentf("%s", filter))
+
+	// The predicate is encoded like an inserted document
+	insert := Table("test").Insert(predicate{G1: 2, Num: &zero, Ignore: "x"})
+	c.Assert(TermsEqual(filter.args[1], insert.args[1]), test.Equals, true)
+
+	filter = Table("test").Filter(&predicate{ID: 4, G1: 2, G2: 3})
+	expected = Table("test").Filter(map[string]interface{}{"id": int64(4), "g1": int64(2), "g2": int64(3)})
+	c.Assert(TermsEqual(filter, expected), test.Equals, true, test.Commentf("%s", filter))
+}
+
+func (s *QuerySuite) TestRunOpts_ArrayLimit(c *test.C) {
+	query := Table("test").CoerceTo("array")
+
+	mock := NewMock()
+	mock.On(query, map[string]interface{}{"array_limit": 500000}).Return([]interface{}{}, nil)
+	_, err := query.Run(mock, RunOpts{ArrayLimit: 500000})
+	c.Assert(err, test.IsNil)
+	c.Assert(mock.Queries[0].Query.Opts["array_limit"], tests.JsonEquals, 500000)
+	mock.AssertExpectations(c)
+
+	for _, limit := range []interface{}{0, -1, 1.5, uint64(MaxArrayLimit + 1), "100"} {
+		_, err = query.Run(mock, RunOpts{ArrayLimit: limit})
+		c.Assert(err, test.FitsTypeOf, RQLDriverError{}, test.Commentf("%v", limit))
+		err = query.Exec(mock, ExecOpts{ArrayLimit: limit})
+		c.Assert(err, test.FitsTypeOf, RQLDriverError{}, test.Commentf("%v", limit))
+	}
+	_, err = query.Run(mock, RunOpts{ArrayLimit: 0})
+	c.Assert(err, test.ErrorMatches, "rethinkdb: ArrayLimit must be an integer between 1 and 9007199254740992, got 0")
+	c.Assert(mock.Queries, test.HasLen, 1)
+}
+
+func (s *QuerySuite) TestRunOpts_BatchSize(c *test.C) {
+	query := Table("test")
+
+	mock := NewMock()
+	mock.On(query).Return([]interface{}{}, nil)
+	_, err := query.Run(mock, RunOpts{MinBatchRows: 2, MaxBatchRows: 50, FirstBatchScaledownFactor: 4})
+	c.Assert(err, test.IsNil)
+	c.Assert(mock.Queries[0].Query.Opts["min_batch_rows"], tests.JsonEquals, 2)
+	c.Assert(mock.Queries[0].Query.Opts["max_batch_rows"], tests.JsonEquals, 50)
+	c.Assert(mock.Queries[0].Query.Opts["first_batch_scaledown_factor"], tests.JsonEquals, 4)
+	c.Assert(mock.Queries[0].Query.Opts["array_limit"], test.IsNil)
+	mock.AssertExpectations(c)
+
+	for _, opts := range []RunOpts{
+		{MinBatchRows: -1},
+		{MinBatchRows: 1.5},
+		{MaxBatchRows: 0},
+		{MaxBatchRows: "50"},
+		{FirstBatchScaledownFactor: 0},
+		{MinBatchRows: 10, MaxBatchRows: 5},
+	} {
+		_, err = query.Run(mock, opts)
+		c.Assert(err, test.FitsTypeOf, RQLDriverError{}, test.Commentf("%+v", opts))
+		err = query.Exec(mock, ExecOpts{
+			MinBatchRows:              opts.MinBatchRows,
+			MaxBatchRows:              opts.MaxBatchRows,
+			FirstBatchScaledownFactor: opts.FirstBatchScaledownFactor,
+		})
+		c.Assert(err, test.FitsTypeOf, RQLDriverError{}, test.Commentf("%+v", opts))
+	}
+	c.Assert(mock.Queries, test.HasLen, 1)
+
+	_, err = query.Run(mock, RunOpts{MaxBatchRows: 0})
+	c.Assert(err, test.ErrorMatches, "rethinkdb: MaxBatchRows must be an integer between 1 and 9007199254740992, got 0")
+	_, err = query.Run(mock, RunOpts{MinBatchRows: 10, MaxBatchRows: 5})
+	c.Assert(err, test.ErrorMatches, "rethinkdb: MinBatchRows must not be greater than MaxBatchRows, got 10 and 5")
+}
+
+func (s *QuerySuite) TestRunOpts_Defaults(c *test.C) {
+	query := Table("test").Get(1)
+	write := Table("test").Get(1).Delete()
+
+	mock := NewMock(ConnectOpts{DefaultRunOpts: RunOpts{
+		Durability: "soft",
+		ReadMode:   "outdated",
+		QueryName:  "default",
+	}})
+	mock.On(query).Return(nil, nil).Twice()
+	mock.On(write).Return(nil, nil)
+
+	_, err := query.Run(mock)
+	c.Assert(err, test.IsNil)
+	_, err = query.Run(mock, RunOpts{ReadMode: "majority", QueryName: "get"})
+	c.Assert(err, test.IsNil)
+	c.Assert(write.Exec(mock, ExecOpts{Durability: "hard"}), test.IsNil)
+
+	c.Assert(mock.Queries, test.HasLen, 3)
+	c.Assert(mock.Queries[0].Query.Opts["durability"], tests.JsonEquals, "soft")
+	c.Assert(mock.Queries[0].Query.Opts["read_mode"], tests.JsonEquals, "outdated")
+	c.Assert(mock.Queries[0].Query.Name, test.Equals, "default")
+	c.Assert(mock.Queries[1].Query.Opts["durability"], tests.JsonEquals, "soft")
+	c.Assert(mock.Queries[1].Query.Opts["read_mode"], tests.JsonEquals, "majority")
+	c.Assert(mock.Queries[1].Query.Name, test.Equals, "get")
+	c.Assert(mock.Queries[2].Query.Opts["durability"], tests.JsonEquals, "hard")
+	c.Assert(mock.Queries[2].Query.Opts["read_mode"], test.IsNil)
+	c.Assert(mock.Queries[2].Query.Name, test.Equals, "default")
+	mock.AssertExpectations(c)
+}
+
+func (s *QuerySuite) TestUpsertBy(c *test.C) {
+	doc := map[string]interface{}{"email": "alice@example.com", "name": "Alice"}
+	upsert := Table("users").UpsertBy("email", doc)
+
+	expected := Do(Expr(doc), Table("users").Info().Field("primary_key"), func(doc, primaryKey Term) Term {
+		matches := Table("users").GetAll(doc.Field("email")).OptArgs(GetAllOpts{Index: "email"})
+		return matches.Count().Do(func(count Term) Term {
+			return Branch(
+				count.Eq(0), Table("users").Insert(doc),
+				count.Eq(1), matches.Replace(func(row Term) Term {
+					return doc.Merge(Object(primaryKey, row.Field(primaryKey)))
+				}),
+				Error(`UpsertBy: more than one document matches the index "email"`),
+			)
+		})
+	})
+	c.Assert(TermsEqual(upsert, expected), test.Equals, true, test.Commentf("%s", upsert))
+
+	_, err := Table("users").UpsertBy("", doc).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: UpsertBy: index and doc must be set")
+	_, err = Table("users").UpsertBy("email", nil).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: UpsertBy: index and doc must be set")
+}
+
+func (s *QuerySuite) TestFunc(c *test.C) {
+	row := Var()
+	manual := Table("users").Update(Func([]Term{row}, Object("visits", row.Field("visits").Add(1))))
+	expected := Table("users").Update(func(row Term) Term {
+		return Object("visits", row.Field("visits").Add(1))
+	})
+	c.Assert(TermsEqual(manual, expected), test.Equals, true, test.Commentf("%s", manual))
+	c.Assert(TermsEqual(Var(), Var()), test.Equals, false)
+
+	_, err := Func([]Term{Expr(1)}, 1).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Func: parameter 0 is not a variable returned by Var")
+	_, err = Func([]Term{row, row}, row).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Func: parameter 1 is bound more than once")
+}
+
+func (s *QuerySuite) TestUpdateFunc(c *test.C) {
+	update := Table("users").Get(1).UpdateFunc(func(row Term) Term {
+		return Object("visits", row.Field("visits").Add(1))
+	}, UpdateOpts{ReturnChanges: true})
+
+	row := Var()
+	expected := Table("users").Get(1).Update(Func([]Term{row}, Object("visits", row.Field("visits").Add(1))), UpdateOpts{ReturnChanges: true})
+	c.Assert(TermsEqual(update, expected), test.Equals, true, test.Commentf("%s", update))
+	_, err := update.Build()
+	c.Assert(err, test.IsNil)
+
+	_, err = Table("users").UpdateFunc(nil).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: UpdateFunc: f must not be nil")
+	_, err = Table("users").UpdateFunc(func(row Term) Term {
+		return Object("visits", Row.Field("visits").Add(1))
+	}).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: UpdateFunc: r.Row can't be used in f, use its row argument instead")
+}
+
+func (s *QuerySuite) TestUpdateIfVersion(c *test.C) {
+	update := Table("accounts").Get(1).UpdateIfVersion(map[string]interface{}{"balance": 90}, "version", 3, UpdateOpts{Durability: "soft"})
+
+	row := Var()
+	version := row.Field("version")
+	expected := Table("accounts").Get(1).Update(Func([]Term{row}, Branch(
+		row.HasFields("version").And(version.Eq(3)),
+		Expr(map[string]interface{}{"balance": 90}).Merge(Object("version", version.Add(1))),
+		Object(),
+	)), UpdateOpts{Durability: "soft"})
+	c.Assert(TermsEqual(update, expected), test.Equals, true, test.Commentf("%s", update))
+	_, err := update.Build()
+	c.Assert(err, test.IsNil)
+
+	_, err = Table("accounts").Get(1).UpdateIfVersion(map[string]interface{}{}, "", 3).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: UpdateIfVersion: versionField must not be empty")
+
+	for _, tc := range []struct {
+		res     WriteResponse
+		outcome VersionedUpdate
+	}{
+		{WriteResponse{Replaced: 1}, VersionedUpdateApplied},
+		{WriteResponse{Unchanged: 1}, VersionedUpdateStale},
+		{WriteResponse{Skipped: 1}, VersionedUpdateNotFound},
+	} {
+		outcome, err := tc.res.VersionedUpdate()
+		c.Assert(err, test.IsNil)
+		c.Assert(outcome, test.Equals, tc.outcome)
+	}
+
+	// A failed write isn't a version conflict
+	outcome, err := WriteResponse{Errors: 1, FirstError: "Table `test.accounts` does not exist."}.VersionedUpdate()
+	c.Assert(err, test.ErrorMatches, "Table `test.accounts` does not exist.")
+	c.Assert(outcome, test.Equals, VersionedUpdateFailed)
+}
+
+func (s *QuerySuite) TestGuardFullTableWrites(c *test.C) {
+	opts := &ConnectOpts{GuardFullTableWrites: true}
+
+	for _, t := range []Term{
+		Table("users").Delete(),
+		DB("app").Table("users").Update(map[string]interface{}{"active": false}),
+		Table("users").OrderBy("name").Replace(func(row Term) Term { return row }),
+		Expr([]int{1, 2}).ForEach(func(i Term) Term { return Table("users").Delete() }),
+	} {
+		_, err := newQuery(t, map[string]interface{}{}, opts)
+		c.Assert(err, test.ErrorMatches, "rethinkdb: (Delete|Update|Replace) of a whole table is not allowed by GuardFullTableWrites.*", test.Commentf("%s", t))
+
+		_, err = newQuery(t, RunOpts{AllowFullTableWrite: true}.toMap(), opts)
+		c.Assert(err, test.IsNil)
+		_, err = newQuery(t, map[string]interface{}{}, &ConnectOpts{})
+		c.Assert(err, test.IsNil)
+	}
+
+	for _, t := range []Term{
+		Table("users").Get(1).Delete(),
+		Table("users").GetAllByIndex("email", "a@example.com").Update(map[string]interface{}{"active": false}),
+		Table("users").Filter(map[string]interface{}{"active": false}).Delete(),
+		Table("users").Between(1, 10).OrderBy("name").Delete(),
+		Table("users").OrderBy(OrderByOpts{Index: "name"}).Limit(10).Delete(),
+		Table("users").Insert(map[string]interface{}{"id": 1}),
+	} {
+		_, err := newQuery(t, map[string]interface{}{}, opts)
+		c.Assert(err, test.IsNil, test.Commentf("%s", t))
+	}
+
+	q, err := newQuery(Table("users").Delete(), ExecOpts{AllowFullTableWrite: true}.toMap(), opts)
+	c.Assert(err, test.IsNil)
+	c.Assert(q.Build()[2], test.DeepEquals, map[string]interface{}{})
+}
+
+func (s *QuerySuite) TestGetAllParallel(c *test.C) {
+	mock := NewMock()
+	mock.On(Table("users").GetAll(1, 2)).Return([]interface{}{map[string]interface{}{"id": 1}, map[string]interface{}{"id": 2}}, nil)
+	mock.On(Table("users").GetAll(3, 4, 5)).Return([]interface{}{map[string]interface{}{"id": 5}}, nil)
+
+	var users []map[string]interface{}
+	err := Table("users").GetAllParallel(&users, mock, []interface{}{1, 2, 3, 4, 5}, 2)
+	c.Assert(err, test.IsNil)
+	c.Assert(users, tests.JsonEquals, []interface{}{
+		map[string]interface{}{"id": 1}, map[string]interface{}{"id": 2}, map[string]interface{}{"id": 5},
+	})
+	mock.AssertExpectations(c)
+
+	mock = NewMock()
+	mock.On(Table("users").GetAll(1)).Return([]interface{}{map[string]interface{}{"id": 1}}, nil)
+	mock.On(Table("users").GetAll(2)).Return(nil, errors.New("failed"))
+	users = nil
+	err = Table("users").GetAllParallel(&users, mock, []interface{}{1, 2}, 10)
+	c.Assert(err, test.ErrorMatches, "failed")
+	c.Assert(users, test.IsNil)
+
+	err = Table("users").GetAllParallel(&users, mock, []interface{}{1}, 0)
+	c.Assert(err, test.ErrorMatches, "rethinkdb: GetAllParallel: shards must be at least 1, got 0")
+	c.Assert(Table("users").GetAllParallel(&users, mock, nil, 4), test.IsNil)
+	c.Assert(users, test.HasLen, 0)
+}
+
+func (s *QuerySuite) TestFirstLast(c *test.C) {
+	c.Assert(TermsEqual(Table("users").First(), Table("users").Nth(0)), test.Equals, true)
+	c.Assert(TermsEqual(Table("users").Last(), Table("users").Nth(-1)), test.Equals, true)
+
+	_, err := Table("users").Last().Build()
+	c.Assert(err, test.IsNil)
+}
+
+func (s *QuerySuite) TestNth_Validation(c *test.C) {
+	valid := []Term{
+		Expr([]int{1, 2, 3}).Nth(-3),
+		Table("users").Nth(-1),
+		Table("users").OrderBy("name").Nth(-2),
+		Table("users").Nth(Row.Field("n")),
+		Table("users").Nth(2.0),
+	}
+	for _, t := range valid {
+		_, err := t.Build()
+		c.Assert(err, test.IsNil, test.Commentf("%s", t))
+	}
+
+	_, err := Table("users").Nth(-2).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Nth: can't use an index less than -1 on a stream, got -2")
+	_, err = Table("users").Filter(map[string]interface{}{"active": true}).Limit(10).Nth(-3).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Nth: can't use an index less than -1 on a stream, got -3")
+	_, err = Table("users").OrderBy(OrderByOpts{Index: "name"}).Nth(-2).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Nth: can't use an index less than -1 on a stream, got -2")
+	_, err = Expr([]int{1, 2, 3}).Nth(1.5).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Nth: index must be an integer, got 1.5")
+	_, err = Expr([]int{1, 2, 3}).Nth().Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Nth: expected 1 index, got 0")
+}
+
+func (s *QuerySuite) TestSlice_Validation(c *test.C) {
+	valid := []Term{
+		Expr([]int{1, 2, 3}).Slice(-2, -1),
+		Table("users").Slice(1, -1, SliceOpts{RightBound: "closed"}),
+		Table("users").OrderBy("name").Slice(-2),
+		Table("users").Slice(Row.Field("n"), 10),
+	}
+	for _, t := range valid {
+		_, err := t.Build()
+		c.Assert(err, test.IsNil, test.Commentf("%s", t))
+	}
+
+	_, err := Table("users").Slice(-1).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Slice: can't use a negative start index on a stream, got -1")
+	_, err = Table("users").GetAll(1, 2, 3).Slice(0, -2).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Slice: can't use an end index less than -1 on a stream, got -2")
+	_, err = Expr([]int{1, 2, 3}).Slice(0.5).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Slice: index must be an integer, got 0.5")
+	_, err = Expr([]int{1, 2, 3}).Slice(1, 2, 3).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Slice: expected 1 or 2 bounds, got 3")
+}
+
+func (s *QuerySuite) TestFunc_Arity(c *test.C) {
+	_, err := Expr([]int{1, 2}).Map(func(a, b Term) interface{} { return a }).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Map: function must take 1 argument, got 2")
+
+	_, err = Expr([]int{1, 2}).Map(Expr([]int{3, 4}), func(a Term) interface{} { return a }).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Map: function must take 2 arguments, got 1")
+
+	_, err = Map(Expr([]int{1, 2}), func(a Term, b Term) interface{} { return a }).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Map: function must take 1 argument, got 2")
+
+	_, err = Table("test").ConcatMap(func() interface{} { return nil }).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: ConcatMap: function must take 1 argument, got 0")
+
+	_, err = Table("test").Filter(func(row string) interface{} { return row }).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Filter: predicate argument must be a Term, got string")
+
+	_, err = Expr([]int{1, 2}).Reduce(func(acc Term) interface{} { return acc }).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Reduce: function must take 2 arguments, got 1")
+
+	_, err = Expr([]int{1, 2}).Reduce(func(acc, row Term) {}).Build()
+	c.Assert(err, test.ErrorMatches, "rethinkdb: Reduce: function must return 1 value, got 0")
+
+	// Valid functions and non-function arguments are unchanged
+	_, err = Expr([]int{1, 2}).Map(Expr([]int{3, 4}), func(a, b Term) interface{} { return a.Add(b) }).Build()
+	c.Assert(err, test.IsNil)
+	_, err = Table("test").Filter(map[string]interface{}{"a": 1}).Build()
+	c.Assert(err, test.IsNil)
+	_, err = Table("test").Filter(Row.Field("a").Eq(1)).Build()
+	c.Assert(err, test.IsNil)
+	_, err = Expr([]int{1, 2}).Reduce(func(acc, row interface{}) interface{} { return 1 }).Build()
+	c.Assert(err, test.IsNil)
+}
+
+func (s *QuerySuite) TestLiteral_Struct(c *test.C) {
+	type profile struct {
+		Name string `rethinkdb:"name"`
+		Age  int    `rethinkdb:"age,omitempty"`
+	}
+	type update struct {
+		Profile interface{} `rethinkdb:"profile"`
+		Tags    Term        `rethinkdb:"tags"`
+	}
+
+	got := Table("users").Get(1).Update(update{
+		Profile: Literal(profile{Name: "Alice"}),
+		Tags:    Literal(),
+	})
+	expected := Table("users").Get(1).Update(map[string]interface{}{
+		"profile": Literal(map[string]interface{}{"name": "Alice"}),
+		"tags":    Literal(),
+	})
+	c.Assert(TermsEqual(got, expected), test.Equals, true, test.Commentf("%s", got))
+
+	built, err := got.Build()
+	c.Assert(err, test.IsNil)
+	b, err := json.Marshal(built)
+	c.Assert(err, test.IsNil)
+	c.Assert(string(b), test.Equals, `[53,[[16,[[15,["users"]],1]],{"profile":[137,[{"name":"Alice"}]],"tags":[137]}]]`)
+
+	type merge struct {
+		Profile interface{} `rethinkdb:"profile"`
+	}
+	got = Expr(map[string]interface{}{"id": 1}).Merge(merge{Profile: Literal(&profile{Name: "Bob", Age: 3})})
+	expected = Expr(map[string]interface{}{"id": 1}).Merge(map[string]interface{}{
+		"profile": Literal(map[string]interface{}{"name": "Bob", "age": int64(3)}),
+	})
+	c.Assert(TermsEqual(got, expected), test.Equals, true, test.Commentf("%s", got))
+}
+
+func (s *QuerySuite) TestDeleteKeys(c *test.C) {
+	got := Table("test").DeleteKeys([]interface{}{1, "a"})
+	expected := Table("test").GetAll(1, "a").Delete()
+	c.Assert(TermsEqual(got, expected), test.Equals, true, test.Commentf("%s", got))
+
+	got = Table("test").DeleteKeys([]interface{}{[]interface{}{"a", 1}}, DeleteOpts{Durability: "soft"})
+	expected = Table("test").GetAll([]interface{}{"a", 1}).Delete(DeleteOpts{Durability: "soft"})
+	c.Assert(TermsEqual(got, expected), test.Equals, true, test.Commentf("%s", got))
+
+	// Keys are encoded like documents
+	type key struct {
+		Region string `rethinkdb:"region"`
+		ID     int    `rethinkdb:"id"`
+	}
+	got = Table("test").DeleteKeys([]interface{}{key{Region: "eu", ID: 1}})
+	expected = Table("test").GetAll(map[string]interface{}{"region": "eu", "id": int64(1)}).Delete()
+	c.Assert(TermsEqual(got, expected), test.Equals, true, test.Commentf("%s", got))
+
+	got = Table("test").DeleteKeys(nil)
+	_, err := got.Build()
+	c.Assert(err, test.IsNil)
+	c.Assert(got.String(), test.Equals, `r.Table("test").GetAll(r.Args([])).Delete()`)
+
+	_, err = Table("test").DeleteKeys([]interface{}{1}, DeleteOpts{Durability: "fast"}).Build()
+	c.Assert(err, test.NotNil)
+}
@@ -1,6 +1,14 @@
 package rethinkdb
 
-import p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/segmentio/encoding/json"
+	"gopkg.in/rethinkdb/rethinkdb-go.v6/encoding"
+	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
+)
 
 // Map transform each element of the sequence by applying the given mapping
 // function. It takes two arguments, a sequence and a function of type
@@ -13,6 +21,12 @@ import p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 //     })
 func Map(args ...interface{}) Term {
 	if len(args) > 0 {
+		// The function takes an element of each sequence
+		if err := validateFunc("Map", "function", args[len(args)-1], len(args)-1); err != nil {
+			term := constructRootTerm("Map", p.Term_MAP, args[:len(args)-1], map[string]interface{}{})
+			term.lastErr = err
+			return term
+		}
 		args = append(args[:len(args)-1], funcWrap(args[len(args)-1]))
 	}
 
@@ -29,6 +43,12 @@ func Map(args ...interface{}) Term {
 //     })
 func (t Term) Map(args ...interface{}) Term {
 	if len(args) > 0 {
+		// The function takes an element of t and of each other sequence
+		if err := validateFunc("Map", "function", args[len(args)-1], len(args)); err != nil {
+			term := constructMethodTerm(t, "Map", p.Term_MAP, args[:len(args)-1], map[string]interface{}{})
+			term.lastErr = err
+			return term
+		}
 		args = append(args[:len(args)-1], funcWrap(args[len(args)-1]))
 	}
 
@@ -48,6 +68,13 @@ func (t Term) WithFields(args ...interface{}) Term {
 // given function to each element in a sequence, but it will always return a
 // single sequence.
 func (t Term) ConcatMap(args ...interface{}) Term {
+	if len(args) > 0 {
+		if err := validateFunc("ConcatMap", "function", args[len(args)-1], len(args)); err != nil {
+			term := constructMethodTerm(t, "ConcatMap", p.Term_CONCAT_MAP, args[:len(args)-1], map[string]interface{}{})
+			term.lastErr = err
+			return term
+		}
+	}
 	return constructMethodTerm(t, "ConcatMap", p.Term_CONCAT_MAP, funcWrapArgs(args), map[string]interface{}{})
 }
 
@@ -99,6 +126,118 @@ func Asc(args ...interface{}) Term {
 	return constructRootTerm("Asc", p.Term_ASC, funcWrapArgs(args), map[string]interface{}{})
 }
 
+// resumeToken is the state encoded by Cursor.ResumeToken.
+type resumeToken struct {
+	Index string      `json:"index"`
+	Desc  bool        `json:"desc,omitempty"`
+	Key   interface{} `json:"key"`
+}
+
+// orderByIndex returns the index and direction of the first OrderBy term using
+// an index in the chain of terms ending with t.
+func orderByIndex(t Term) (index string, desc bool, ok bool) {
+	for {
+		if t.termType == p.Term_ORDER_BY {
+			if indexTerm, found := t.optArgs["index"]; found {
+				if indexTerm.termType == p.Term_DESC || indexTerm.termType == p.Term_ASC {
+					desc = indexTerm.termType == p.Term_DESC
+					if len(indexTerm.args) != 1 {
+						return "", false, false
+					}
+					indexTerm = indexTerm.args[0]
+				}
+				index, ok = indexTerm.data.(string)
+				return index, desc, ok && indexTerm.termType == p.Term_DATUM
+			}
+		}
+		if len(t.args) == 0 {
+			return "", false, false
+		}
+		t = t.args[0]
+	}
+}
+
+func newResumeToken(t Term, row interface{}) ([]byte, error) {
+	index, desc, ok := orderByIndex(t)
+	if !ok {
+		return nil, RQLDriverError{rqlError("ResumeToken: query must be ordered using OrderBy with an index")}
+	}
+	doc, ok := row.(map[string]interface{})
+	if !ok {
+		return nil, RQLDriverError{rqlError(fmt.Sprintf("ResumeToken: expected the last row to be an object, got %T", row))}
+	}
+	key, ok := doc[index]
+	if !ok {
+		return nil, RQLDriverError{rqlError(fmt.Sprintf("ResumeToken: the last row has no field %q", index))}
+	}
+	key, err := encoding.Encode(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(resumeToken{Index: index, Desc: desc, Key: key})
+}
+
+// resumeOrderBy replaces the input of the OrderBy term found by orderByIndex
+// with the rows after key.
+func resumeOrderBy(t Term, token resumeToken) Term {
+	if t.termType == p.Term_ORDER_BY {
+		if _, ok := t.optArgs["index"]; ok {
+			bounds := BetweenOpts{Index: token.Index, LeftBound: "open"}
+			lower, upper := Expr(token.Key), MaxVal
+			if token.Desc {
+				bounds = BetweenOpts{Index: token.Index, RightBound: "open"}
+				lower, upper = MinVal, Expr(token.Key)
+			}
+
+			args := append([]Term{}, t.args...)
+			args[0] = args[0].Between(lower, upper, bounds)
+			t.args = args
+			return t
+		}
+	}
+
+	args := append([]Term{}, t.args...)
+	args[0] = resumeOrderBy(args[0], token)
+	t.args = args
+	return t
+}
+
+// ResumeFrom restarts the query from the position recorded by
+// Cursor.ResumeToken, returning the rows after the last row read from the
+// cursor. This allows stateless pagination, the token can be returned to a
+// client and the query resumed by a later request:
+//
+//	query := r.Table("posts").OrderBy(r.OrderByOpts{Index: r.Desc("created_at")}).Limit(20)
+//	if token != nil {
+//		query = query.ResumeFrom(token)
+//	}
+//
+// The query must be ordered using OrderBy with an index named after the field
+// it indexes (such as the primary key) and must be the query the token was
+// created from. The rows are resumed using Between on that index with an open
+// bound, so rows sharing the key of the last row are skipped, the index should
+// therefore be unique.
+func (t Term) ResumeFrom(token []byte) Term {
+	var rt resumeToken
+	if err := json.Unmarshal(token, &rt); err != nil {
+		t.lastErr = RQLDriverError{rqlError(fmt.Sprintf("ResumeFrom: invalid token: %v", err))}
+		return t
+	}
+
+	index, desc, ok := orderByIndex(t)
+	if !ok {
+		t.lastErr = RQLDriverError{rqlError("ResumeFrom: query must be ordered using OrderBy with an index")}
+		return t
+	}
+	if index != rt.Index || desc != rt.Desc {
+		t.lastErr = RQLDriverError{rqlError(fmt.Sprintf("ResumeFrom: token was created for the index %q, not %q", rt.Index, index))}
+		return t
+	}
+
+	return resumeOrderBy(t, rt)
+}
+
 // Skip skips a number of elements from the head of the sequence.
 func (t Term) Skip(args ...interface{}) Term {
 	return constructMethodTerm(t, "Skip", p.Term_SKIP, args, map[string]interface{}{})
@@ -119,7 +258,9 @@ func (o SliceOpts) toMap() map[string]interface{} {
 	return optArgsToMap(o)
 }
 
-// Slice trims the sequence to within the bounds provided.
+// Slice trims the sequence to within the bounds provided. Negative bounds
+// count from the end of the sequence, on a stream such as a table the start
+// index can't be negative and the end index can't be less than -1.
 func (t Term) Slice(args ...interface{}) Term {
 	var opts = map[string]interface{}{}
 
@@ -131,7 +272,33 @@ func (t Term) Slice(args ...interface{}) Term {
 		}
 	}
 
-	return constructMethodTerm(t, "Slice", p.Term_SLICE, args, opts)
+	term := constructMethodTerm(t, "Slice", p.Term_SLICE, args, opts)
+	if len(args) < 1 || len(args) > 2 {
+		term.lastErr = RQLDriverError{rqlError(fmt.Sprintf("Slice: expected 1 or 2 bounds, got %d", len(args)))}
+		return term
+	}
+
+	stream := isStream(t)
+	for i, bound := range term.args[1:] {
+		n, ok, err := datumIndex(bound)
+		if err != nil {
+			term.lastErr = RQLDriverError{rqlError(fmt.Sprintf("Slice: %v", err))}
+			return term
+		}
+		if !ok || !stream {
+			continue
+		}
+		if i == 0 && n < 0 {
+			term.lastErr = RQLDriverError{rqlError(fmt.Sprintf("Slice: can't use a negative start index on a stream, got %d", n))}
+			return term
+		}
+		if i == 1 && n < -1 {
+			term.lastErr = RQLDriverError{rqlError(fmt.Sprintf("Slice: can't use an end index less than -1 on a stream, got %d", n))}
+			return term
+		}
+	}
+
+	return term
 }
 
 // AtIndex gets a single field from an object or the nth element from a sequence.
@@ -139,9 +306,89 @@ func (t Term) AtIndex(args ...interface{}) Term {
 	return constructMethodTerm(t, "AtIndex", p.Term_BRACKET, args, map[string]interface{}{})
 }
 
-// Nth gets the nth element from a sequence.
+// Nth gets the nth element from a sequence. Negative indexes count from the
+// end of the sequence, on a stream such as a table the index can't be less
+// than -1.
 func (t Term) Nth(args ...interface{}) Term {
-	return constructMethodTerm(t, "Nth", p.Term_NTH, args, map[string]interface{}{})
+	term := constructMethodTerm(t, "Nth", p.Term_NTH, args, map[string]interface{}{})
+	if len(args) != 1 {
+		term.lastErr = RQLDriverError{rqlError(fmt.Sprintf("Nth: expected 1 index, got %d", len(args)))}
+		return term
+	}
+
+	n, ok, err := datumIndex(term.args[1])
+	if err != nil {
+		term.lastErr = RQLDriverError{rqlError(fmt.Sprintf("Nth: %v", err))}
+	} else if ok && n < -1 && isStream(t) {
+		term.lastErr = RQLDriverError{rqlError(fmt.Sprintf("Nth: can't use an index less than -1 on a stream, got %d", n))}
+	}
+
+	return term
+}
+
+// First gets the first element of a sequence, it is equivalent to Nth(0).
+func (t Term) First() Term {
+	return t.Nth(0)
+}
+
+// Last gets the last element of a sequence, it is equivalent to Nth(-1).
+func (t Term) Last() Term {
+	return t.Nth(-1)
+}
+
+// datumIndex returns the value of the index t if it is a literal number, ok is
+// false if the index is only known by the server or isn't a number, which the
+// server reports. An error is returned if t is a number which isn't an integer.
+func datumIndex(t Term) (n int64, ok bool, err error) {
+	if t.termType != p.Term_DATUM || t.data == nil {
+		return 0, false, nil
+	}
+
+	v := reflect.ValueOf(t.data)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return 0, false, nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(v.Uint()), true, nil
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); f != math.Trunc(f) {
+			return 0, false, fmt.Errorf("index must be an integer, got %v", f)
+		}
+		return int64(v.Float()), true, nil
+	}
+
+	return 0, false, nil
+}
+
+// isStream returns true if t is known to evaluate to a stream, such as a table,
+// rather than an array. Terms which keep a stream a stream are looked through.
+func isStream(t Term) bool {
+	for {
+		switch t.termType {
+		case p.Term_TABLE, p.Term_GET_ALL, p.Term_BETWEEN, p.Term_GET_INTERSECTING:
+			return true
+		case p.Term_ORDER_BY:
+			// Only ordering using an index keeps a stream, otherwise the
+			// documents are ordered in an array
+			if _, ok := t.optArgs["index"]; !ok {
+				return false
+			}
+		case p.Term_FILTER, p.Term_MAP, p.Term_CONCAT_MAP, p.Term_PLUCK, p.Term_WITHOUT,
+			p.Term_WITH_FIELDS, p.Term_HAS_FIELDS, p.Term_SKIP, p.Term_LIMIT, p.Term_SLICE:
+		default:
+			return false
+		}
+		if len(t.args) == 0 {
+			return false
+		}
+		t = t.args[0]
+	}
 }
 
 // OffsetsOf gets the indexes of an element in a sequence. If the argument is a
@@ -1,9 +1,37 @@
 package rethinkdb
 
 import (
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/segmentio/encoding/json"
+	"golang.org/x/net/context"
 	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 )
 
+// Possible values of the Durability write option
+const (
+	// DurabilityHard acknowledges writes once they are written to disk
+	DurabilityHard = "hard"
+	// DurabilitySoft acknowledges writes once they are in memory
+	DurabilitySoft = "soft"
+)
+
+// validateDurability returns an error if durability is not nil, a Term or
+// one of the durability constants.
+func validateDurability(termName string, durability interface{}) error {
+	switch durability {
+	case nil, DurabilityHard, DurabilitySoft:
+		return nil
+	}
+	if _, ok := durability.(Term); ok {
+		return nil
+	}
+
+	return RQLDriverError{rqlError(fmt.Sprintf("%s: Durability must be %q or %q, got %v", termName, DurabilityHard, DurabilitySoft, durability))}
+}
+
 // InsertOpts contains the optional arguments for the Insert term
 type InsertOpts struct {
 	Durability      interface{} `gorethink:"durability,omitempty"`
@@ -16,16 +44,276 @@ func (o InsertOpts) toMap() map[string]interface{} {
 	return optArgsToMap(o)
 }
 
+func (o InsertOpts) validate() error {
+	return validateDurability("Insert", o.Durability)
+}
+
 // Insert documents into a table. Accepts a single document or an array
 // of documents.
 func (t Term) Insert(arg interface{}, optArgs ...InsertOpts) Term {
 	opts := map[string]interface{}{}
 	if len(optArgs) >= 1 {
+		if err := optArgs[0].validate(); err != nil {
+			term := constructMethodTerm(t, "Insert", p.Term_INSERT, []interface{}{Expr(arg)}, opts)
+			term.lastErr = err
+			return term
+		}
 		opts = optArgs[0].toMap()
 	}
 	return constructMethodTerm(t, "Insert", p.Term_INSERT, []interface{}{Expr(arg)}, opts)
 }
 
+// InsertOutcome is the outcome of inserting a single document, see
+// InsertStreamResult.
+type InsertOutcome string
+
+// Possible values of InsertOutcome
+const (
+	InsertOutcomeInserted  InsertOutcome = "inserted"
+	InsertOutcomeUpdated   InsertOutcome = "updated"
+	InsertOutcomeUnchanged InsertOutcome = "unchanged"
+	InsertOutcomeErrored   InsertOutcome = "errored"
+)
+
+// InsertDocumentResult contains the outcome of inserting the document at
+// Index in the inserted array.
+type InsertDocumentResult struct {
+	Index    int
+	Outcome  InsertOutcome
+	Error    string
+	NewValue interface{}
+	OldValue interface{}
+}
+
+// InsertStreamResult is returned by RunInsert, alongside the counts from the
+// WriteResponse it contains the outcome of each inserted document.
+type InsertStreamResult struct {
+	WriteResponse
+	Documents []InsertDocumentResult
+}
+
+// Failed returns the indexes of the documents which could not be inserted,
+// these can be used to retry only the failed documents.
+func (r InsertStreamResult) Failed() []int {
+	var failed []int
+	for _, doc := range r.Documents {
+		if doc.Outcome == InsertOutcomeErrored {
+			failed = append(failed, doc.Index)
+		}
+	}
+	return failed
+}
+
+// RunInsert runs an Insert query and returns the outcome of each document. The
+// return_changes option is set to "always" so that the server returns a change
+// for every document in the order they were inserted, including the documents
+// which failed. Unlike RunWrite no error is returned if some documents failed,
+// use InsertStreamResult.Failed to find them.
+//
+//	res, err := r.Table("table").Insert(docs, r.InsertOpts{Conflict: "update"}).RunInsert(sess)
+func (t Term) RunInsert(s QueryExecutor, optArgs ...RunOpts) (InsertStreamResult, error) {
+	var result InsertStreamResult
+
+	if t.termType != p.Term_INSERT {
+		return result, RQLDriverError{rqlError("RunInsert can only be used with Insert")}
+	}
+
+	optArgsCopy := make(map[string]Term, len(t.optArgs)+1)
+	for k, v := range t.optArgs {
+		optArgsCopy[k] = v
+	}
+	optArgsCopy["return_changes"] = Expr("always")
+	t.optArgs = optArgsCopy
+
+	res, err := t.Run(s, optArgs...)
+	if err != nil {
+		return result, err
+	}
+	defer res.Close()
+
+	if err = res.One(&result.WriteResponse); err != nil {
+		return result, err
+	}
+
+	result.Documents = make([]InsertDocumentResult, len(result.Changes))
+	for i, change := range result.Changes {
+		doc := InsertDocumentResult{
+			Index:    i,
+			Error:    change.Error,
+			NewValue: change.NewValue,
+			OldValue: change.OldValue,
+		}
+		switch {
+		case change.Error != "":
+			doc.Outcome = InsertOutcomeErrored
+		case change.OldValue == nil:
+			doc.Outcome = InsertOutcomeInserted
+		case reflect.DeepEqual(change.OldValue, change.NewValue):
+			doc.Outcome = InsertOutcomeUnchanged
+		default:
+			doc.Outcome = InsertOutcomeUpdated
+		}
+		result.Documents[i] = doc
+	}
+
+	return result, nil
+}
+
+// defaultInsertStreamBatchSize is the number of documents inserted per query
+// by InsertStreamChan when InsertStreamOpts.BatchSize is not set.
+const defaultInsertStreamBatchSize = 200
+
+// InsertStreamOpts contains the optional arguments for InsertStreamChan.
+type InsertStreamOpts struct {
+	// BatchSize is the number of documents inserted by each query, 200 by
+	// default.
+	BatchSize int
+	// InsertOpts are the options of each Insert query.
+	InsertOpts InsertOpts
+	// RunOpts are the options used to run each Insert query, inserting stops
+	// when RunOpts.Context is done.
+	RunOpts RunOpts
+}
+
+// InsertStreamChan reads documents from r, a stream of JSON values such as
+// newline delimited JSON, and inserts them into the table t in batches of
+// InsertStreamOpts.BatchSize documents. Integers which fit in an int64 are
+// inserted exactly and other numbers as float64. The keys generated by the server for
+// each batch are sent on the keys channel as soon as the batch is inserted, so
+// they can be processed while the next batches are inserted, the slice is
+// empty if all the documents of a batch have a primary key.
+//
+// Both channels are closed once r is read to the end, after an error has been
+// sent on the errs channel or when RunOpts.Context is done. At most one error
+// is sent, it is the error of a failed batch, an error reading r or the error
+// of the context. The keys channel must be read for inserting to continue,
+// cancel the context to stop reading it early.
+//
+//	keys, errs := r.Table("events").InsertStreamChan(session, file)
+//	for batch := range keys {
+//	    // process the keys of the batch
+//	}
+//	if err := <-errs; err != nil {
+//	    // the documents after the last batch received were not inserted
+//	}
+func (t Term) InsertStreamChan(s QueryExecutor, r io.Reader, optArgs ...InsertStreamOpts) (<-chan []string, <-chan error) {
+	opts := InsertStreamOpts{}
+	if len(optArgs) >= 1 {
+		opts = optArgs[0]
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultInsertStreamBatchSize
+	}
+	ctx := opts.RunOpts.Context
+	if ctx == nil {
+		ctx = context.Background()
+		opts.RunOpts.Context = ctx
+	}
+
+	keys := make(chan []string)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(keys)
+
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		if err := t.insertStream(ctx, s, dec, opts, keys); err != nil {
+			errs <- err
+		}
+	}()
+
+	return keys, errs
+}
+
+// insertStream inserts the documents decoded by dec in batches, sending the
+// generated keys of each batch on keys.
+func (t Term) insertStream(ctx context.Context, s QueryExecutor, dec *json.Decoder, opts InsertStreamOpts, keys chan<- []string) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch := make([]interface{}, 0, opts.BatchSize)
+		var readErr error
+		for len(batch) < opts.BatchSize {
+			var doc interface{}
+			if readErr = dec.Decode(&doc); readErr != nil {
+				break
+			}
+			batch = append(batch, insertStreamNumbers(doc))
+		}
+		if readErr != nil && readErr != io.EOF {
+			return RQLDriverError{rqlError(fmt.Sprintf("InsertStreamChan: reading document: %s", readErr))}
+		}
+
+		if len(batch) > 0 {
+			res, err := t.insertBatch(s, batch, opts)
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return ctxErr
+				}
+				return err
+			}
+			if res.Errors > 0 {
+				return RQLDriverError{rqlError(fmt.Sprintf("InsertStreamChan: inserting documents: %s", res.FirstError))}
+			}
+
+			generated := res.GeneratedKeys
+			if generated == nil {
+				generated = []string{}
+			}
+			select {
+			case keys <- generated:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// insertBatch inserts a batch of InsertStreamChan and returns the counts of the
+// write, the options of the caller, such as ReturnChanges, are used as is.
+func (t Term) insertBatch(s QueryExecutor, batch []interface{}, opts InsertStreamOpts) (WriteResponse, error) {
+	var response WriteResponse
+
+	res, err := t.Insert(batch, opts.InsertOpts).Run(s, opts.RunOpts)
+	if err != nil {
+		return response, err
+	}
+	defer res.Close()
+
+	err = res.One(&response)
+	return response, err
+}
+
+// insertStreamNumbers replaces the json.Number values of a document decoded by
+// InsertStreamChan with int64 values if they are integers which fit, so that
+// they are inserted exactly, or float64 values otherwise.
+func insertStreamNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = insertStreamNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = insertStreamNumbers(e)
+		}
+	}
+	return v
+}
+
 // UpdateOpts contains the optional arguments for the Update term
 type UpdateOpts struct {
 	Durability      interface{} `gorethink:"durability,omitempty"`
@@ -33,23 +321,148 @@ type UpdateOpts struct {
 	NonAtomic       interface{} `gorethink:"non_atomic,omitempty"`
 	Conflict        interface{} `gorethink:"conflict,omitempty"`
 	IgnoreWriteHook interface{} `gorethink:"ignore_write_hook,omitempty"`
+
+	// OmitZero excludes zero-valued fields from the update object when the
+	// update argument is a struct. This uses the same zero-value heuristic as
+	// the omitempty tag option, so a field explicitly set to its zero value is
+	// treated as unset. Use a pointer field to write a zero value.
+	OmitZero bool `gorethink:"-"`
 }
 
 func (o UpdateOpts) toMap() map[string]interface{} {
 	return optArgsToMap(o)
 }
 
+func (o UpdateOpts) validate() error {
+	return validateDurability("Update", o.Durability)
+}
+
 // Update JSON documents in a table. Accepts a JSON document, a ReQL expression,
 // or a combination of the two. You can pass options like returnChanges that will
 // return the old and new values of the row you have modified.
+//
+// When updating with a struct, zero-valued fields overwrite the existing values
+// unless OmitZero is set:
+//
+//	r.Table("users").Get(id).Update(User{Name: "new"}, r.UpdateOpts{OmitZero: true})
 func (t Term) Update(arg interface{}, optArgs ...UpdateOpts) Term {
 	opts := map[string]interface{}{}
 	if len(optArgs) >= 1 {
+		if err := optArgs[0].validate(); err != nil {
+			term := constructMethodTerm(t, "Update", p.Term_UPDATE, []interface{}{funcWrap(arg)}, opts)
+			term.lastErr = err
+			return term
+		}
 		opts = optArgs[0].toMap()
+		if optArgs[0].OmitZero {
+			arg = exprOmitEmpty(arg)
+		}
 	}
 	return constructMethodTerm(t, "Update", p.Term_UPDATE, []interface{}{funcWrap(arg)}, opts)
 }
 
+// UpdateFunc updates the documents with the object returned by f, which is
+// called once to build the query with a variable bound to the document being
+// updated. The object must be built from row, r.Row can't be used as it would
+// refer to the function itself. It is equivalent to passing f to Update:
+//
+//	r.Table("users").Get(id).UpdateFunc(func(row r.Term) r.Term {
+//		return r.Object("visits", row.Field("visits").Add(1))
+//	})
+func (t Term) UpdateFunc(f func(row Term) Term, optArgs ...UpdateOpts) Term {
+	if f == nil {
+		term := t.Update(nil, optArgs...)
+		term.lastErr = RQLDriverError{rqlError("UpdateFunc: f must not be nil")}
+		return term
+	}
+
+	row := makeVar()
+	body := f(row)
+	fn := makeFuncTerm([]Term{row}, body)
+	if implVarScan(body) {
+		term := t.Update(fn, optArgs...)
+		term.lastErr = RQLDriverError{rqlError("UpdateFunc: r.Row can't be used in f, use its row argument instead")}
+		return term
+	}
+
+	return t.Update(fn, optArgs...)
+}
+
+// UpdateIfVersion updates a document with doc only if its versionField is
+// equal to expected, incrementing versionField at the same time. The check
+// and the update are done atomically by the server, so this can be used for
+// optimistic concurrency control: read a document with its version, then
+// write it back with UpdateIfVersion and retry if another client updated it
+// in the meantime. t should select a single document, for example with Get,
+// use WriteResponse.VersionedUpdate to find out whether it was updated:
+//
+//	res, err := r.Table("accounts").Get(id).UpdateIfVersion(
+//		map[string]interface{}{"balance": 90}, "version", 3,
+//	).RunWrite(session)
+//	if err != nil {
+//		return err
+//	}
+//	if outcome, _ := res.VersionedUpdate(); outcome == r.VersionedUpdateStale {
+//		// reread the account and try again
+//	}
+//
+// The version field must hold a number, a document whose version field is
+// missing is never updated.
+func (t Term) UpdateIfVersion(doc interface{}, versionField string, expected interface{}, optArgs ...UpdateOpts) Term {
+	if versionField == "" {
+		term := t.Update(doc, optArgs...)
+		term.lastErr = RQLDriverError{rqlError("UpdateIfVersion: versionField must not be empty")}
+		return term
+	}
+	if len(optArgs) >= 1 && optArgs[0].OmitZero {
+		doc = exprOmitEmpty(doc)
+	}
+
+	return t.UpdateFunc(func(row Term) Term {
+		version := row.Field(versionField)
+		return Branch(
+			row.HasFields(versionField).And(version.Eq(expected)),
+			Expr(doc).Merge(Object(versionField, version.Add(1))),
+			Object(),
+		)
+	}, optArgs...)
+}
+
+// VersionedUpdate is the outcome of an update built with UpdateIfVersion.
+type VersionedUpdate int
+
+const (
+	// VersionedUpdateApplied means the version matched, the document was
+	// updated and its version incremented.
+	VersionedUpdateApplied VersionedUpdate = iota
+	// VersionedUpdateStale means the version didn't match, the document was
+	// updated by someone else and was left unchanged.
+	VersionedUpdateStale
+	// VersionedUpdateNotFound means the document doesn't exist.
+	VersionedUpdateNotFound
+	// VersionedUpdateFailed means the server failed to write the document,
+	// for example because the table doesn't exist.
+	VersionedUpdateFailed
+)
+
+// VersionedUpdate returns the outcome of an update built with
+// UpdateIfVersion from the counts of the response. If the write failed it
+// returns VersionedUpdateFailed and an error holding FirstError, a failed
+// write is never reported as VersionedUpdateStale.
+func (r WriteResponse) VersionedUpdate() (VersionedUpdate, error) {
+	switch {
+	case r.Errors > 0:
+		return VersionedUpdateFailed, fmt.Errorf("%s", r.FirstError)
+	case r.Replaced > 0:
+		return VersionedUpdateApplied, nil
+	case r.Skipped > 0:
+		return VersionedUpdateNotFound, nil
+	default:
+		// The version didn't match so the document was left unchanged
+		return VersionedUpdateStale, nil
+	}
+}
+
 // ReplaceOpts contains the optional arguments for the Replace term
 type ReplaceOpts struct {
 	Durability      interface{} `gorethink:"durability,omitempty"`
@@ -62,17 +475,60 @@ func (o ReplaceOpts) toMap() map[string]interface{} {
 	return optArgsToMap(o)
 }
 
+func (o ReplaceOpts) validate() error {
+	return validateDurability("Replace", o.Durability)
+}
+
 // Replace documents in a table. Accepts a JSON document or a ReQL expression,
 // and replaces the original document with the new one. The new document must
 // have the same primary key as the original document.
 func (t Term) Replace(arg interface{}, optArgs ...ReplaceOpts) Term {
 	opts := map[string]interface{}{}
 	if len(optArgs) >= 1 {
+		if err := optArgs[0].validate(); err != nil {
+			term := constructMethodTerm(t, "Replace", p.Term_REPLACE, []interface{}{funcWrap(arg)}, opts)
+			term.lastErr = err
+			return term
+		}
 		opts = optArgs[0].toMap()
 	}
 	return constructMethodTerm(t, "Replace", p.Term_REPLACE, []interface{}{funcWrap(arg)}, opts)
 }
 
+// UpsertBy inserts doc into the table unless a document with the same value
+// for the secondary index exists, in which case that document is replaced by
+// doc while keeping its primary key. The index must be a simple index on the
+// field of doc with the same name. The query fails if more than one document
+// matches.
+//
+//	r.Table("users").UpsertBy("email", map[string]interface{}{
+//		"email": "alice@example.com",
+//		"name":  "Alice",
+//	}).RunWrite(session)
+//
+// The query returns the write response of the insert or replace, it is
+// evaluated in a single query but the lookup and the write are not atomic.
+func (t Term) UpsertBy(index string, doc interface{}) Term {
+	if index == "" || doc == nil {
+		term := constructMethodTerm(t, "UpsertBy", p.Term_INSERT, []interface{}{Expr(doc)}, map[string]interface{}{})
+		term.lastErr = RQLDriverError{rqlError("UpsertBy: index and doc must be set")}
+		return term
+	}
+
+	return Do(Expr(doc), t.Info().Field("primary_key"), func(doc, primaryKey Term) Term {
+		matches := t.GetAllByIndex(index, doc.Field(index))
+		return matches.Count().Do(func(count Term) Term {
+			return Branch(
+				count.Eq(0), t.Insert(doc),
+				count.Eq(1), matches.Replace(func(row Term) Term {
+					return doc.Merge(Object(primaryKey, row.Field(primaryKey)))
+				}),
+				Error(fmt.Sprintf("UpsertBy: more than one document matches the index %q", index)),
+			)
+		})
+	})
+}
+
 // DeleteOpts contains the optional arguments for the Delete term
 type DeleteOpts struct {
 	Durability      interface{} `gorethink:"durability,omitempty"`
@@ -84,15 +540,41 @@ func (o DeleteOpts) toMap() map[string]interface{} {
 	return optArgsToMap(o)
 }
 
+func (o DeleteOpts) validate() error {
+	return validateDurability("Delete", o.Durability)
+}
+
 // Delete one or more documents from a table.
 func (t Term) Delete(optArgs ...DeleteOpts) Term {
 	opts := map[string]interface{}{}
 	if len(optArgs) >= 1 {
+		if err := optArgs[0].validate(); err != nil {
+			term := constructMethodTerm(t, "Delete", p.Term_DELETE, []interface{}{}, opts)
+			term.lastErr = err
+			return term
+		}
 		opts = optArgs[0].toMap()
 	}
 	return constructMethodTerm(t, "Delete", p.Term_DELETE, []interface{}{}, opts)
 }
 
+// DeleteKeys deletes the documents of the table with the given primary keys in
+// a single query, it is equivalent to t.GetAll(keys...).Delete(optArgs...).
+// Keys are encoded like inserted documents, so a time.Time or a struct is
+// matched against the value stored for it, and run with RunWrite the query
+// returns the usual WriteResponse:
+//
+//	res, err := r.Table("sessions").DeleteKeys(expiredIDs).RunWrite(session)
+//
+// No documents are deleted if keys is empty.
+func (t Term) DeleteKeys(keys []interface{}, optArgs ...DeleteOpts) Term {
+	if len(keys) == 0 {
+		// GetAll requires at least one key unless they are passed using Args
+		return t.GetAll(Args([]interface{}{})).Delete(optArgs...)
+	}
+	return t.GetAll(keys...).Delete(optArgs...)
+}
+
 // Sync ensures that writes on a given table are written to permanent storage.
 // Queries that specify soft durability do not give such guarantees, so Sync
 // can be used to ensure the state of these queries. A call to Sync does not
@@ -100,3 +582,27 @@ func (t Term) Delete(optArgs ...DeleteOpts) Term {
 func (t Term) Sync(args ...interface{}) Term {
 	return constructMethodTerm(t, "Sync", p.Term_SYNC, args, map[string]interface{}{})
 }
+
+// SyncContext runs Sync on the table t and waits until its writes have been
+// persisted or ctx is done.
+//
+//	err := r.DB("db").Table("table").SyncContext(ctx, session)
+func (t Term) SyncContext(ctx context.Context, s QueryExecutor) error {
+	res, err := t.Sync().Run(s, RunOpts{Context: ctx})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	var response struct {
+		Synced int `rethinkdb:"synced"`
+	}
+	if err = res.One(&response); err != nil {
+		return err
+	}
+	if response.Synced != 1 {
+		return RQLDriverError{rqlError("Sync: table was not synced")}
+	}
+
+	return nil
+}
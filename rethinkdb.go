@@ -1,6 +1,7 @@
 package rethinkdb
 
 import (
+	"fmt"
 	"reflect"
 
 	"github.com/sirupsen/logrus"
@@ -56,6 +57,41 @@ func SetVerbose(verbose bool) {
 // passed into this function. If no parameters are passed then the driver will
 // default to checking for the rethinkdb tag (the rethinkdb tag is always included)
 // Old-style gorethink tag is also supported but deprecated
+//
+// For example SetTags("json", "rethinkdb") prefers json tags over rethinkdb
+// tags. The tags apply to every session of the process, call SetTags once
+// before connecting, for example in an init function.
 func SetTags(tags ...string) {
-	encoding.Tags = append(tags, encoding.TagName, encoding.OldTagName)
+	tags = append([]string(nil), tags...)
+	for _, tag := range []string{encoding.TagName, encoding.OldTagName} {
+		if !containsString(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	encoding.SetTags(tags)
+}
+
+// EncodeToMap encodes the struct or map v to the map used when it is passed to
+// a query, for example as the document of an Insert. The fields are named
+// and omitted according to their tags in the same way, nested structs are
+// encoded to maps and Term values are kept as is. This is useful for
+// inspecting or changing the document before building a query from it.
+//
+//	doc, err := r.EncodeToMap(user)
+//	if err != nil {
+//		// error
+//	}
+//	doc["updated_at"] = r.Now()
+//	err = r.Table("users").Insert(doc).Exec(session)
+func EncodeToMap(v interface{}) (map[string]interface{}, error) {
+	data, err := encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, RQLDriverError{rqlError(fmt.Sprintf("EncodeToMap: %T is not encoded to an object", v))}
+	}
+	return m, nil
 }
@@ -2,9 +2,14 @@ package rethinkdb
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/segmentio/encoding/json"
 	"golang.org/x/net/context"
 	p "gopkg.in/rethinkdb/rethinkdb-go.v6/ql2"
 )
@@ -18,12 +23,19 @@ type Session struct {
 	mu      sync.RWMutex
 	cluster *Cluster
 	closed  bool
+
+	// querySlots limits the number of concurrent queries if
+	// MaxConcurrentQueries is set
+	querySlots    chan struct{}
+	activeQueries int32
+	queuedQueries int32
 }
 
 // ConnectOpts is used to specify optional arguments when connecting to a cluster.
 type ConnectOpts struct {
 	// Address holds the address of the server initially used when creating the
-	// session. Only used if Addresses is empty
+	// session. Only used if Addresses is empty. Addresses of the form
+	// unix:///var/run/rethinkdb.sock connect to a unix domain socket.
 	Address string `rethinkdb:"address,omitempty" json:"address,omitempty"`
 	// Addresses holds the addresses of the servers initially used when creating
 	// the session.
@@ -37,6 +49,13 @@ type ConnectOpts struct {
 	// Password holds the password used for authentication (only used when using
 	// the v1 handshake protocol)
 	Password string `rethinkdb:"password,omitempty" json:"password,omitempty"`
+	// CredentialProvider, if set, is called during the handshake of each new
+	// connection to get the username and password used for authentication,
+	// Username and Password are ignored. This allows credentials to be rotated,
+	// existing connections keep the credentials they were created with until
+	// they are closed and replaced by the pool. Only used with the v1 handshake
+	// protocol.
+	CredentialProvider func(ctx context.Context) (username, password string, err error) `rethinkdb:"-" json:"-"`
 	// AuthKey is used for authentication when using the v0.4 handshake protocol
 	// This field is no deprecated
 	AuthKey string `rethinkdb:"authkey,omitempty" json:"authkey,omitempty"`
@@ -55,9 +74,23 @@ type ConnectOpts struct {
 	// KeepAlivePeriod is the keep alive period used by the connection, by default
 	// this is 30s. It is not possible to disable keep alive messages
 	KeepAlivePeriod time.Duration `rethinkdb:"keep_alive_timeout,omitempty" json:"keep_alive_timeout,omitempty"`
+	// TCPNoDelay controls whether Nagle's algorithm is disabled on the TCP
+	// connection, by default this is true which reduces the latency of small
+	// queries. Setting it to false may reduce the number of packets sent by
+	// workloads sending many queries at once.
+	TCPNoDelay *bool `rethinkdb:"tcp_no_delay,omitempty" json:"tcp_no_delay,omitempty"`
 	// TLSConfig holds the TLS configuration and can be used when connecting
-	// to a RethinkDB server protected by SSL
+	// to a RethinkDB server protected by SSL. Connecting to a unix domain
+	// socket fails if TLSConfig or the certificate files are set.
 	TLSConfig *tls.Config `rethinkdb:"tlsconfig,omitempty" json:"tlsconfig,omitempty"`
+	// ClientCertFile and ClientKeyFile are the paths of a PEM encoded client
+	// certificate and its private key, used to authenticate the client with
+	// mutual TLS. CAFile is the path of the PEM encoded CA certificates used to
+	// verify the server. The files are loaded when connecting and added to a
+	// copy of TLSConfig, or to a new TLS configuration if TLSConfig is nil.
+	ClientCertFile string `rethinkdb:"client_cert_file,omitempty" json:"client_cert_file,omitempty"`
+	ClientKeyFile  string `rethinkdb:"client_key_file,omitempty" json:"client_key_file,omitempty"`
+	CAFile         string `rethinkdb:"ca_file,omitempty" json:"ca_file,omitempty"`
 	// HandshakeVersion is used to specify which handshake version should be
 	// used, this currently defaults to v1 which is used by RethinkDB 2.3 and
 	// later. If you are using an older version then you can set the handshake
@@ -65,13 +98,82 @@ type ConnectOpts struct {
 	HandshakeVersion HandshakeVersion `rethinkdb:"handshake_version,omitempty" json:"handshake_version,omitempty"`
 	// UseJSONNumber indicates whether the cursors running in this session should
 	// use json.Number instead of float64 while unmarshaling documents with
-	// interface{}. The default is `false`.
+	// interface{}. The default is `false`, it can be overridden for a single
+	// query using RunOpts.UseJSONNumber.
 	UseJSONNumber bool `json:"use_json_number,omitempty"`
+	// TagPriority sets the struct tags checked when encoding or decoding
+	// structs in order of priority, for example []string{"json", "rethinkdb"}
+	// prefers json tags over rethinkdb tags. The rethinkdb tag is always
+	// checked last if it is not included. Tags are configured for the whole
+	// process, not per session, so this is equivalent to calling SetTags
+	// before connecting and affects all sessions.
+	TagPriority []string `rethinkdb:"tag_priority,omitempty" json:"tag_priority,omitempty"`
 	// NumRetries is the number of times a query is retried if a connection
 	// error is detected, queries are not retried if RethinkDB returns a
-	// runtime error.
+	// runtime error. Queries which write are only retried if they failed
+	// before being sent, as the server may already have applied them. Earlier
+	// versions also retried writes which were sent, set RunOpts.Idempotent or
+	// ExecOpts.Idempotent to keep doing so for a query. Reads are also
+	// retried on a new connection if the connection is closed before their
+	// first response, errors returned while iterating a cursor are not
+	// retried.
 	// Default is 3.
 	NumRetries int `json:"num_retries,omitempty"`
+	// MaxQueryDepth limits the nesting depth of queries, as returned by
+	// Term.Depth, queries nested deeper return an error when run instead of
+	// being sent to the server. There is no limit if zero.
+	MaxQueryDepth int `json:"max_query_depth,omitempty"`
+	// GuardFullTableWrites causes queries which delete, update or replace every
+	// document of a table, such as r.Table("users").Delete(), to return an
+	// error instead of being sent to the server. Writes to a selection narrowed
+	// down by terms such as Get, GetAll, Filter, Between or Limit are allowed,
+	// as are queries run with RunOpts.AllowFullTableWrite set.
+	GuardFullTableWrites bool `json:"guard_full_table_writes,omitempty"`
+	// MaxConcurrentQueries limits the number of queries the session executes
+	// at once, regardless of the number of connections. Queries above the
+	// limit wait for a running query to finish, or return ErrQueryTimeout if
+	// the query context is done first. A query is running until Run returns,
+	// fetching further batches of a cursor is not limited. There is no limit
+	// if zero.
+	MaxConcurrentQueries int `json:"max_concurrent_queries,omitempty"`
+	// QueryRecorder, if set, is called after each query is executed by the
+	// session with the JSON sent to the server, for example for audit logging.
+	// Unlike opentracing spans it is called for every query, including
+	// noreply queries and queries which failed.
+	QueryRecorder QueryRecorder `rethinkdb:"-" json:"-"`
+	// ReuseBuffers enables reusing the buffers responses are read into across
+	// the connections of the session, which reduces garbage collection for
+	// sessions reading many documents. The documents of a response are not
+	// copied out of the buffer, it is reused once the cursor has read all of
+	// them or is closed. The default is `false`.
+	ReuseBuffers bool `json:"reuse_buffers,omitempty"`
+	// MaxResponseBytes limits the size of a single response read from the
+	// server, queries returning a larger response (or batch of documents)
+	// fail with ResponseTooLargeError. The response is discarded without
+	// being buffered, the query is stopped so that the server doesn't send
+	// any more batches and the connection can still be used. The default is
+	// 0, meaning no limit.
+	MaxResponseBytes int `json:"max_response_bytes,omitempty"`
+	// DefaultRunOpts holds the options used by every query run by the session
+	// with Run, or Exec for the fields which ExecOpts shares with RunOpts.
+	// Each field of the options passed to the query which is set takes
+	// precedence, the fields which hold their zero value are taken from
+	// DefaultRunOpts:
+	//
+	//  - the interface{} fields, such as Durability and ReadMode, are used
+	//    when the query's are nil.
+	//  - UseJSONNumber, ResolveType and Context are used when the query's are
+	//    nil.
+	//  - StaleFallbackAfter is used when the query's is 0.
+	//  - QueryName is used when the query's is empty.
+	//  - the bool fields, such as Idempotent and PreferReplica, are used when
+	//    the query's are false, so a query can't turn off an option set to
+	//    true in DefaultRunOpts.
+	//
+	// Database takes precedence over DefaultRunOpts.DB, as it does over the DB
+	// of a query. Mock applies the DefaultRunOpts of its options in the same
+	// way.
+	DefaultRunOpts RunOpts `rethinkdb:"-" json:"-"`
 
 	// InitialCap is used by the internal connection pool and is used to
 	// configure how many connections are created for each host when the
@@ -80,8 +182,28 @@ type ConnectOpts struct {
 	InitialCap int `rethinkdb:"initial_cap,omitempty" json:"initial_cap,omitempty"`
 	// MaxOpen is used by the internal connection pool and is used to configure
 	// the maximum number of connections held in the pool. By default the
-	// maximum number of connections is 1
+	// maximum number of connections is 1. Queries are spread over the
+	// connections in round-robin order, see Pool.
 	MaxOpen int `rethinkdb:"max_open,omitempty" json:"max_open,omitempty"`
+	// WaitNoReplyOnClose is used by the internal connection pool, when true a
+	// NOREPLY_WAIT query is sent before closing a connection which has sent
+	// noreply queries so that these queries are not dropped. Closing the pool
+	// is delayed until the server has processed the outstanding queries.
+	WaitNoReplyOnClose bool `rethinkdb:"wait_noreply_on_close,omitempty" json:"wait_noreply_on_close,omitempty"`
+	// ConnMaxIdleTime is used by the internal connection pool, a connection
+	// which has not sent a query for longer than this is replaced by a new
+	// connection the next time it is picked for a query. This avoids errors
+	// when idle connections are silently dropped by firewalls or NAT. By
+	// default idle connections are kept.
+	ConnMaxIdleTime time.Duration `rethinkdb:"conn_max_idle_time,omitempty" json:"conn_max_idle_time,omitempty"`
+	// ConnMaxLifetime is used by the internal connection pool, a connection
+	// opened for longer than this is replaced by a new connection the next
+	// time it is picked for a query. By default connections are kept.
+	//
+	// Connections are only replaced when they have no queries waiting for a
+	// response and no cursors with more results to fetch, so a connection
+	// used by a long running changefeed is kept until the changefeed closes.
+	ConnMaxLifetime time.Duration `rethinkdb:"conn_max_lifetime,omitempty" json:"conn_max_lifetime,omitempty"`
 
 	// Below options are for cluster discovery, please note there is a high
 	// probability of these changing as the API is still being worked on.
@@ -93,12 +215,24 @@ type ConnectOpts struct {
 	// HostDecayDuration is used by the go-hostpool package to calculate a weighted
 	// score when selecting a host. By default a value of 5 minutes is used.
 	HostDecayDuration time.Duration `json:"host_decay_duration,omitempty"`
+	// ReconnectBackoff configures the delays between attempts to reconnect
+	// to the cluster and to connect to discovered nodes.
+	ReconnectBackoff ReconnectBackoffOpts `json:"reconnect_backoff,omitempty"`
 
 	// UseOpentracing is used to enable creating opentracing-go spans for queries.
 	// Each span is created as child of span from the context in `RunOpts`.
 	// This span lasts from point the query created to the point when cursor closed.
 	UseOpentracing bool `json:"use_opentracing,omitempty"`
 
+	// DebugTrackCursors records the stack trace of the code which ran the
+	// query of each cursor and logs it with a warning using Log if the cursor
+	// is garbage collected without Close being called or all of its results
+	// being read. A cursor still waiting for results is referenced by its
+	// connection so it is only reported once the connection is closed. This
+	// is meant for finding leaked cursors during development, recording the
+	// stack traces slows down every query.
+	DebugTrackCursors bool `json:"debug_track_cursors,omitempty"`
+
 	// Deprecated: This function is no longer used due to changes in the
 	// way hosts are selected.
 	NodeRefreshInterval time.Duration `rethinkdb:"node_refresh_interval,omitempty" json:"node_refresh_interval,omitempty"`
@@ -106,6 +240,35 @@ type ConnectOpts struct {
 	MaxIdle int `rethinkdb:"max_idle,omitempty" json:"max_idle,omitempty"`
 }
 
+// QueryRecorder records the queries executed by a session, see
+// ConnectOpts.QueryRecorder. Record is called after the query was executed,
+// for queries returning a cursor dur is the time taken to receive the first
+// batch of results and err is the error returned by Run. builtJSON is nil if
+// the query could not be encoded. ctx is the context the query was run with,
+// set using RunOpts.Context or ExecOpts.Context, so request scoped values such
+// as request IDs can be recorded with the query, it is context.Background()
+// if no context was set. Record may be called concurrently.
+type QueryRecorder interface {
+	Record(ctx context.Context, q Query, builtJSON []byte, dur time.Duration, err error)
+}
+
+// recordQuery calls the QueryRecorder r, if set, for the query q which was
+// executed at start with ctx.
+func recordQuery(ctx context.Context, r QueryRecorder, q Query, start time.Time, err error) {
+	if r == nil {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	builtJSON, jsonErr := json.Marshal(q.Build())
+	if jsonErr != nil {
+		builtJSON = nil
+	}
+	r.Record(ctx, q, builtJSON, time.Since(start), err)
+}
+
 func (o ConnectOpts) toMap() map[string]interface{} {
 	return optArgsToMap(o)
 }
@@ -133,29 +296,36 @@ func (o ConnectOpts) toMap() map[string]interface{} {
 // 		AuthKey:  "14daak1cad13dj",
 // 	})
 func Connect(opts ConnectOpts) (*Session, error) {
-	var addresses = opts.Addresses
-	if len(addresses) == 0 {
-		addresses = []string{opts.Address}
-	}
+	return ConnectContext(context.Background(), opts)
+}
 
-	hosts := make([]Host, len(addresses))
-	for i, address := range addresses {
-		hostname, port := splitAddress(address)
-		hosts[i] = NewHost(hostname, port)
+// ConnectContext creates a new database session like Connect, the deadline and
+// cancellation of ctx apply to dialing the hosts and to the handshake, in
+// addition to ConnectOpts.Timeout. Connections which are opened later by the
+// connection pool are not affected by ctx.
+func ConnectContext(ctx context.Context, opts ConnectOpts) (*Session, error) {
+	hosts, err := hostsFromOpts(opts)
+	if err != nil {
+		return nil, err
 	}
-	if len(hosts) <= 0 {
-		return nil, ErrNoHosts
+	if opts.TLSConfig, err = tlsConfigFromOpts(opts); err != nil {
+		return nil, err
+	}
+
+	if len(opts.TagPriority) > 0 {
+		SetTags(opts.TagPriority...)
 	}
 
 	// Connect
 	s := &Session{
-		hosts: hosts,
-		opts:  &opts,
+		hosts:      hosts,
+		opts:       &opts,
+		querySlots: newQuerySlots(opts.MaxConcurrentQueries),
 	}
 
-	err := s.Reconnect()
+	err = s.reconnectContext(ctx)
 	if err != nil {
-		// note: s.Reconnect() will initialize cluster information which
+		// note: s.reconnectContext() will initialize cluster information which
 		// will cause the .IsConnected() method to be caught in a loop
 		return &Session{
 			hosts: hosts,
@@ -166,6 +336,64 @@ func Connect(opts ConnectOpts) (*Session, error) {
 	return s, nil
 }
 
+func hostsFromOpts(opts ConnectOpts) ([]Host, error) {
+	var addresses = opts.Addresses
+	if len(addresses) == 0 {
+		addresses = []string{opts.Address}
+	}
+
+	hosts := make([]Host, len(addresses))
+	for i, address := range addresses {
+		hosts[i] = hostFromAddress(address)
+	}
+	if len(hosts) <= 0 {
+		return nil, ErrNoHosts
+	}
+
+	return hosts, nil
+}
+
+// tlsConfigFromOpts returns the TLS configuration used to connect, which is
+// opts.TLSConfig with the certificates from ClientCertFile, ClientKeyFile and
+// CAFile added.
+func tlsConfigFromOpts(opts ConnectOpts) (*tls.Config, error) {
+	if opts.ClientCertFile == "" && opts.ClientKeyFile == "" && opts.CAFile == "" {
+		return opts.TLSConfig, nil
+	}
+
+	config := &tls.Config{}
+	if opts.TLSConfig != nil {
+		config = opts.TLSConfig.Clone()
+	}
+
+	if opts.ClientCertFile != "" || opts.ClientKeyFile != "" {
+		if opts.ClientCertFile == "" || opts.ClientKeyFile == "" {
+			return nil, RQLDriverError{rqlError("ClientCertFile and ClientKeyFile must be set together")}
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, RQLDriverError{rqlError(fmt.Sprintf("Failed to load client certificate: %s", err))}
+		}
+		config.Certificates = append(append([]tls.Certificate(nil), config.Certificates...), cert)
+	}
+
+	if opts.CAFile != "" {
+		if config.RootCAs != nil {
+			return nil, RQLDriverError{rqlError("CAFile can't be set when TLSConfig.RootCAs is set")}
+		}
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, RQLDriverError{rqlError(fmt.Sprintf("Failed to load CA certificates: %s", err))}
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, RQLDriverError{rqlError(fmt.Sprintf("Failed to load CA certificates: no certificates found in %s", opts.CAFile))}
+		}
+	}
+
+	return config, nil
+}
+
 // CloseOpts allows calls to the Close function to be configured.
 type CloseOpts struct {
 	NoReplyWait bool `rethinkdb:"noreplyWait,omitempty"`
@@ -188,6 +416,10 @@ func (s *Session) IsConnected() bool {
 
 // Reconnect closes and re-opens a session.
 func (s *Session) Reconnect(optArgs ...CloseOpts) error {
+	return s.reconnectContext(context.Background(), optArgs...)
+}
+
+func (s *Session) reconnectContext(ctx context.Context, optArgs ...CloseOpts) error {
 	var err error
 
 	if err = s.Close(optArgs...); err != nil {
@@ -195,7 +427,7 @@ func (s *Session) Reconnect(optArgs ...CloseOpts) error {
 	}
 
 	s.mu.Lock()
-	s.cluster, err = NewCluster(s.hosts, s.opts)
+	s.cluster, err = newClusterContext(ctx, s.hosts, s.opts)
 	if err != nil {
 		s.mu.Unlock()
 		return err
@@ -207,6 +439,83 @@ func (s *Session) Reconnect(optArgs ...CloseOpts) error {
 	return nil
 }
 
+// ReconnectOpts allows calls to the ReconnectWith function to be configured.
+type ReconnectOpts struct {
+	// ConnectOpts replaces the options used by the session, including the
+	// addresses and credentials, if set. The session's options are left
+	// unchanged if nil.
+	ConnectOpts *ConnectOpts
+	// NoReplyWait waits for the noreply queries sent using the old connections
+	// to be processed before they are closed.
+	NoReplyWait bool
+	// DrainTimeout is how long to wait for the queries and cursors using the
+	// old connections to finish before they are closed. Cursors which are
+	// still open when the timeout expires return an error once they need to
+	// fetch more results. The old connections are closed straight away if
+	// zero.
+	DrainTimeout time.Duration
+}
+
+// ReconnectWith opens a new connection pool, optionally using new options, and
+// swaps it with the session's current pool. Unlike Reconnect the current pool
+// is kept until the new one is connected, so queries can be run on the
+// session throughout, which allows credentials to be rotated without
+// restarting the process:
+//
+//	opts.Password = newPassword
+//	err := session.ReconnectWith(r.ReconnectOpts{ConnectOpts: &opts, DrainTimeout: time.Minute})
+//
+// If the new pool can not be connected the error is returned and the session
+// keeps using its current pool and options. Once swapped, new queries use the
+// new pool while the old pool is drained as configured by opts and closed, the
+// call returns once the old pool is closed.
+func (s *Session) ReconnectWith(opts ReconnectOpts) error {
+	s.mu.RLock()
+	hosts, connectOpts := s.hosts, s.opts
+	s.mu.RUnlock()
+
+	if opts.ConnectOpts != nil {
+		var err error
+		if hosts, err = hostsFromOpts(*opts.ConnectOpts); err != nil {
+			return err
+		}
+		o := *opts.ConnectOpts
+		if o.TLSConfig, err = tlsConfigFromOpts(o); err != nil {
+			return err
+		}
+		connectOpts = &o
+	}
+
+	cluster, err := NewCluster(hosts, connectOpts)
+	if err != nil {
+		return err
+	}
+
+	if opts.ConnectOpts != nil && len(connectOpts.TagPriority) > 0 {
+		SetTags(connectOpts.TagPriority...)
+	}
+
+	s.mu.Lock()
+	old := s.cluster
+	s.cluster = cluster
+	s.hosts = hosts
+	if connectOpts.MaxConcurrentQueries != s.opts.MaxConcurrentQueries {
+		s.querySlots = newQuerySlots(connectOpts.MaxConcurrentQueries)
+	}
+	s.opts = connectOpts
+	s.closed = false
+	s.mu.Unlock()
+
+	if old == nil {
+		return nil
+	}
+	if opts.DrainTimeout > 0 {
+		old.drain(opts.DrainTimeout)
+	}
+
+	return old.Close(CloseOpts{NoReplyWait: opts.NoReplyWait})
+}
+
 // Close closes the session
 func (s *Session) Close(optArgs ...CloseOpts) error {
 	s.mu.Lock()
@@ -277,6 +586,45 @@ func (s *Session) NoReplyWait() error {
 	})
 }
 
+// WaitForReady blocks until all the replicas of the given table are ready,
+// polling the status of the table. It returns the last status of the table or
+// an error if ctx is done first.
+func (s *Session) WaitForReady(ctx context.Context, db, table string) (TableStatus, error) {
+	return waitForReady(ctx, s, db, table)
+}
+
+// IndexReady reports whether the given secondary index has finished building.
+// An error is returned if the index doesn't exist.
+func (s *Session) IndexReady(ctx context.Context, db, table, index string) (bool, error) {
+	status, err := indexStatus(ctx, s, db, table, index)
+	return status.Ready, err
+}
+
+// WaitIndex blocks until the given secondary index has finished building,
+// polling the status of the index. It returns the last status of the index or
+// an error if ctx is done first.
+func (s *Session) WaitIndex(ctx context.Context, db, table, index string) (IndexStatus, error) {
+	return waitIndex(ctx, s, db, table, index)
+}
+
+// TableStatus returns the status of the given table, read from the
+// table_status system table.
+func (s *Session) TableStatus(ctx context.Context, db, table string) (TableStatus, error) {
+	return tableStatus(ctx, s, db, table)
+}
+
+// ServerStatus returns the status of every server of the cluster, read from
+// the server_status system table.
+func (s *Session) ServerStatus(ctx context.Context) ([]ServerStatus, error) {
+	return serverStatus(ctx, s)
+}
+
+// Jobs returns the jobs running on the cluster, read from the jobs system
+// table.
+func (s *Session) Jobs(ctx context.Context) ([]Job, error) {
+	return jobs(ctx, s)
+}
+
 // Use changes the default database used
 func (s *Session) Use(database string) {
 	s.mu.Lock()
@@ -295,6 +643,12 @@ func (s *Session) Database() string {
 
 // Query executes a ReQL query using the session to connect to the database
 func (s *Session) Query(ctx context.Context, q Query) (*Cursor, error) {
+	release, err := s.acquireQuerySlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -302,11 +656,20 @@ func (s *Session) Query(ctx context.Context, q Query) (*Cursor, error) {
 		return nil, ErrConnectionClosed
 	}
 
-	return s.cluster.Query(ctx, q)
+	start := time.Now()
+	cursor, err := s.cluster.Query(ctx, q)
+	recordQuery(ctx, s.opts.QueryRecorder, q, start, err)
+	return cursor, err
 }
 
 // Exec executes a ReQL query using the session to connect to the database
 func (s *Session) Exec(ctx context.Context, q Query) error {
+	release, err := s.acquireQuerySlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -314,7 +677,160 @@ func (s *Session) Exec(ctx context.Context, q Query) error {
 		return ErrConnectionClosed
 	}
 
-	return s.cluster.Exec(ctx, q)
+	start := time.Now()
+	err = s.cluster.Exec(ctx, q)
+	recordQuery(ctx, s.opts.QueryRecorder, q, start, err)
+	return err
+}
+
+// SessionStats holds the statistics returned by Session.Stats.
+type SessionStats struct {
+	// ConcurrentQueries is the number of queries being executed.
+	ConcurrentQueries int
+	// QueuedQueries is the number of queries waiting to be executed because
+	// of ConnectOpts.MaxConcurrentQueries.
+	QueuedQueries int
+	// MaxConcurrentQueries is the limit set by ConnectOpts.MaxConcurrentQueries,
+	// zero if there is no limit.
+	MaxConcurrentQueries int
+}
+
+// Stats returns the current query statistics of the session.
+func (s *Session) Stats() SessionStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return SessionStats{
+		ConcurrentQueries:    int(atomic.LoadInt32(&s.activeQueries)),
+		QueuedQueries:        int(atomic.LoadInt32(&s.queuedQueries)),
+		MaxConcurrentQueries: cap(s.querySlots),
+	}
+}
+
+func newQuerySlots(max int) chan struct{} {
+	if max <= 0 {
+		return nil
+	}
+	return make(chan struct{}, max)
+}
+
+// acquireQuerySlot waits until the session can execute another query, the
+// returned function must be called once the query has finished.
+func (s *Session) acquireQuerySlot(ctx context.Context) (func(), error) {
+	s.mu.RLock()
+	slots := s.querySlots
+	s.mu.RUnlock()
+
+	if slots != nil {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		atomic.AddInt32(&s.queuedQueries, 1)
+		select {
+		case slots <- struct{}{}:
+			atomic.AddInt32(&s.queuedQueries, -1)
+		case <-ctx.Done():
+			atomic.AddInt32(&s.queuedQueries, -1)
+			return nil, ErrQueryTimeout
+		}
+	}
+
+	atomic.AddInt32(&s.activeQueries, 1)
+	return func() {
+		atomic.AddInt32(&s.activeQueries, -1)
+		if slots != nil {
+			<-slots
+		}
+	}, nil
+}
+
+// OnNode returns a QueryExecutor which runs queries on the connections to the
+// node with the given address instead of letting the cluster choose a node.
+// This is useful for benchmarking a single node or checking the state of a
+// replica. Queries return ErrNodeNotFound if the node is not in the cluster
+// and are not retried on another node.
+//
+//	res, err := r.Table("table").Run(session.OnNode("10.0.0.2:28015"))
+func (s *Session) OnNode(address string) QueryExecutor {
+	return &nodeExecutor{session: s, host: hostFromAddress(address)}
+}
+
+// nodeExecutor is the QueryExecutor returned by Session.OnNode
+type nodeExecutor struct {
+	session *Session
+	host    Host
+}
+
+func (e *nodeExecutor) node() (*Node, error) {
+	if e.session.closed || e.session.cluster == nil {
+		return nil, ErrConnectionClosed
+	}
+	if node := e.session.cluster.nodeByAddress(e.host); node != nil {
+		return node, nil
+	}
+	return nil, ErrNodeNotFound
+}
+
+// IsConnected returns true if the session is connected, queries return
+// ErrNodeNotFound if the node is not in the cluster
+func (e *nodeExecutor) IsConnected() bool {
+	return e.session.IsConnected()
+}
+
+func (e *nodeExecutor) Query(ctx context.Context, q Query) (*Cursor, error) {
+	release, err := e.session.acquireQuerySlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	e.session.mu.RLock()
+	defer e.session.mu.RUnlock()
+
+	node, err := e.node()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	cursor, err := node.Query(ctx, q)
+	recordQuery(ctx, e.session.opts.QueryRecorder, q, start, err)
+	if cursor != nil {
+		cursor.mu.Lock()
+		cursor.servedBy = ServerResponse{ID: node.ID, Name: node.Name}
+		cursor.mu.Unlock()
+	}
+	return cursor, err
+}
+
+func (e *nodeExecutor) Exec(ctx context.Context, q Query) error {
+	release, err := e.session.acquireQuerySlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	e.session.mu.RLock()
+	defer e.session.mu.RUnlock()
+
+	node, err := e.node()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = node.Exec(ctx, q)
+	recordQuery(ctx, e.session.opts.QueryRecorder, q, start, err)
+	return err
+}
+
+func (e *nodeExecutor) newQuery(t Term, opts map[string]interface{}) (Query, error) {
+	return e.session.newQuery(t, opts)
+}
+
+func (e *nodeExecutor) defaultRunOpts() RunOpts {
+	return e.session.defaultRunOpts()
 }
 
 // Server returns the server name and server UUID being used by a connection.
@@ -333,3 +849,7 @@ func (s *Session) SetHosts(hosts []Host) {
 func (s *Session) newQuery(t Term, opts map[string]interface{}) (Query, error) {
 	return newQuery(t, opts, s.opts)
 }
+
+func (s *Session) defaultRunOpts() RunOpts {
+	return s.opts.DefaultRunOpts
+}
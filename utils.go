@@ -1,6 +1,7 @@
 package rethinkdb
 
 import (
+	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
@@ -35,6 +36,7 @@ func constructMethodTerm(prevVal Term, name string, termType p.Term_TermType, ar
 		termType: termType,
 		args:     convertTermList(args),
 		optArgs:  convertTermObj(optArgs),
+		timeout:  prevVal.timeout,
 	}
 }
 
@@ -55,6 +57,17 @@ func newQuery(t Term, qopts map[string]interface{}, copts *ConnectOpts) (q Query
 		}
 	}
 
+	if copts.MaxQueryDepth > 0 {
+		if err = checkDepth(t, copts.MaxQueryDepth); err != nil {
+			return q, err
+		}
+	}
+	if allow, _ := qopts["allow_full_table_write"].(bool); copts.GuardFullTableWrites && !allow {
+		if err = checkFullTableWrites(t); err != nil {
+			return q, err
+		}
+	}
+
 	builtTerm, err := t.Build()
 	if err != nil {
 		return q, err
@@ -93,13 +106,12 @@ func makeFunc(f interface{}) Term {
 	value := reflect.ValueOf(f)
 	valueType := value.Type()
 
-	var argNums = make([]interface{}, valueType.NumIn())
+	var params = make([]Term, valueType.NumIn())
 	var args = make([]reflect.Value, valueType.NumIn())
 	for i := 0; i < valueType.NumIn(); i++ {
 		// Get a slice of the VARs to use as the function arguments
-		varID := atomic.AddInt64(&nextVarID, 1)
-		args[i] = reflect.ValueOf(constructRootTerm("var", p.Term_VAR, []interface{}{varID}, map[string]interface{}{}))
-		argNums[i] = varID
+		params[i] = makeVar()
+		args[i] = reflect.ValueOf(params[i])
 
 		// make sure all input arguments are of type Term
 		argValueTypeName := valueType.In(i).String()
@@ -113,6 +125,49 @@ func makeFunc(f interface{}) Term {
 	}
 
 	body := value.Call(args)[0].Interface()
+
+	return makeFuncTerm(params, body)
+}
+
+// validateFunc returns an error naming term if fn is a Go function which does
+// not take arity Terms and return a single value, so that the mistake is
+// reported when the query is run instead of makeFunc panicking. desc describes
+// fn in the error, such as "function" or "index function".
+func validateFunc(term, desc string, fn interface{}, arity int) error {
+	ft := reflect.TypeOf(fn)
+	if ft == nil || ft.Kind() != reflect.Func {
+		return nil
+	}
+	if ft.NumIn() != arity || ft.IsVariadic() {
+		plural := "s"
+		if arity == 1 {
+			plural = ""
+		}
+		return RQLDriverError{rqlError(fmt.Sprintf("%s: %s must take %d argument%s, got %d", term, desc, arity, plural, ft.NumIn()))}
+	}
+	for i := 0; i < ft.NumIn(); i++ {
+		if in := ft.In(i); in != reflect.TypeOf(Term{}) && in != reflect.TypeOf((*interface{})(nil)).Elem() {
+			return RQLDriverError{rqlError(fmt.Sprintf("%s: %s argument must be a Term, got %s", term, desc, in))}
+		}
+	}
+	if ft.NumOut() != 1 {
+		return RQLDriverError{rqlError(fmt.Sprintf("%s: %s must return 1 value, got %d", term, desc, ft.NumOut()))}
+	}
+	return nil
+}
+
+// makeVar returns a VAR term with a new variable ID.
+func makeVar() Term {
+	varID := atomic.AddInt64(&nextVarID, 1)
+	return constructRootTerm("var", p.Term_VAR, []interface{}{varID}, map[string]interface{}{})
+}
+
+// makeFuncTerm returns a FUNC term binding the VAR terms params in body.
+func makeFuncTerm(params []Term, body interface{}) Term {
+	var argNums = make([]interface{}, len(params))
+	for i, param := range params {
+		argNums[i] = param.args[0].data
+	}
 	argsArr := makeArray(convertTermList(argNums))
 
 	return constructRootTerm("func", p.Term_FUNC, []interface{}{argsArr, body}, map[string]interface{}{})
@@ -171,6 +226,24 @@ func optArgsToMap(optArgs OptArgs) map[string]interface{} {
 	return map[string]interface{}{}
 }
 
+// setUnsetFields sets each field of the struct dst which holds its zero value
+// to the field of the same name and type of the struct src.
+func setUnsetFields(dst, src reflect.Value) {
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Field(i)
+		if !isZeroValue(field) {
+			continue
+		}
+		if v := src.FieldByName(dst.Type().Field(i).Name); v.IsValid() && v.Type() == field.Type() {
+			field.Set(v)
+		}
+	}
+}
+
+func isZeroValue(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
 // Convert a list into a slice of terms
 func convertTermList(l []interface{}) termsList {
 	if len(l) == 0 {
@@ -268,16 +341,140 @@ func encode(data interface{}) (interface{}, error) {
 	return v, nil
 }
 
+// exprOmitEmpty converts a struct to a term omitting any empty fields, other
+// values are converted using Expr.
+func exprOmitEmpty(val interface{}) Term {
+	if t, ok := val.(Term); ok {
+		return t
+	}
+
+	data, err := encoding.EncodeOmitEmpty(val)
+	if err != nil {
+		return Term{
+			termType: p.Term_DATUM,
+			data:     nil,
+			lastErr:  err,
+		}
+	}
+
+	return Expr(data)
+}
+
 // shouldRetryQuery checks the result of a query and returns true if the query
-// should be retried
-func shouldRetryQuery(q Query, err error) bool {
+// should be retried. Queries which write and may have reached the server are
+// only retried if they are idempotent, as the write may have been applied.
+func shouldRetryQuery(q Query, sent bool, err error) bool {
 	if err == nil {
 		return false
 	}
 
-	if _, ok := err.(RQLConnectionError); ok {
+	if _, ok := err.(RQLConnectionError); !ok && err != ErrConnectionClosed {
+		return false
+	}
+
+	return !sent || q.Idempotent || q.Term == nil || !isWriteTerm(*q.Term)
+}
+
+// isWriteTerm returns true if t, or any of its arguments, writes documents or
+// changes the configuration of the server.
+func isWriteTerm(t Term) bool {
+	switch t.termType {
+	case p.Term_INSERT, p.Term_UPDATE, p.Term_REPLACE, p.Term_DELETE,
+		p.Term_DB_CREATE, p.Term_DB_DROP, p.Term_TABLE_CREATE, p.Term_TABLE_DROP,
+		p.Term_INDEX_CREATE, p.Term_INDEX_DROP, p.Term_INDEX_RENAME,
+		p.Term_RECONFIGURE, p.Term_REBALANCE, p.Term_GRANT:
 		return true
 	}
 
-	return err == ErrConnectionClosed
+	for _, arg := range t.args {
+		if isWriteTerm(arg) {
+			return true
+		}
+	}
+	for _, arg := range t.optArgs {
+		if isWriteTerm(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// readTables returns the tables read by t, TABLE terms without a database use
+// defaultDB. ok is false if the database or name of a table isn't a literal,
+// as the tables are then only known once the query has run.
+func readTables(t Term, defaultDB string) (tables []tableRef, ok bool) {
+	if t.termType == p.Term_TABLE {
+		table, ok := tableRefOf(t, defaultDB)
+		if !ok {
+			return nil, false
+		}
+		tables = append(tables, table)
+	}
+
+	for _, arg := range t.args {
+		argTables, ok := readTables(arg, defaultDB)
+		if !ok {
+			return nil, false
+		}
+		tables = append(tables, argTables...)
+	}
+	for _, arg := range t.optArgs {
+		argTables, ok := readTables(arg, defaultDB)
+		if !ok {
+			return nil, false
+		}
+		tables = append(tables, argTables...)
+	}
+	return tables, true
+}
+
+func tableRefOf(t Term, defaultDB string) (tableRef, bool) {
+	table := tableRef{db: defaultDB}
+	args := t.args
+	if len(args) == 2 {
+		if args[0].termType != p.Term_DB || len(args[0].args) != 1 {
+			return table, false
+		}
+		db, ok := args[0].args[0].data.(string)
+		if !ok || args[0].args[0].termType != p.Term_DATUM {
+			return table, false
+		}
+		table.db = db
+		args = args[1:]
+	}
+	if len(args) != 1 || args[0].termType != p.Term_DATUM {
+		return table, false
+	}
+
+	name, ok := args[0].data.(string)
+	table.table = name
+	return table, ok
+}
+
+// queryDB returns the name of the default database of q, which is "test" when
+// the query doesn't set one.
+func queryDB(q Query) string {
+	// The db option is built as [DB, ["name"]], or as "name" when RunOpts.DB
+	// is set to a string
+	if name, ok := q.Opts["db"].(string); ok {
+		return name
+	}
+	if built, ok := q.Opts["db"].([]interface{}); ok && len(built) == 2 {
+		if args, ok := built[1].([]interface{}); ok && len(args) == 1 {
+			if name, ok := args[0].(string); ok {
+				return name
+			}
+		}
+	}
+	return "test"
+}
+
+// containsString returns true if s is in list.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }